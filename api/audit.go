@@ -0,0 +1,48 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+)
+
+// ActorHeader names who performs an administrative action in the audit log.
+// It is not authenticated: any caller can claim any name, so audit entries
+// also record the client address the request came from.
+const ActorHeader = "X-Actor-ID"
+
+type AuditHandler struct {
+	log *audit.Log
+}
+
+func NewAuditHandler(log *audit.Log) *AuditHandler {
+	return &AuditHandler{log: log}
+}
+
+// GetAuditLog writes the complete audit log including its checkpoints and the
+// service public key, so that it can be verified offline.
+func (h *AuditHandler) GetAuditLog(w http.ResponseWriter, r *http.Request) {
+	export, err := h.log.Export(r.Context())
+	if err != nil {
+		WriteErrorResponse(w, r, http.StatusInternalServerError, []string{fmt.Sprintf("Failed to read audit log: %v", err)})
+		return
+	}
+
+	WriteAPIResponse(w, http.StatusOK, export)
+}
+
+// recordClientAddress makes audit entries of the request record its remote address.
+func recordClientAddress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(audit.WithClientAddress(r.Context(), r.RemoteAddr)))
+	})
+}
+
+// actor returns the caller recorded in audit entries.
+func actor(r *http.Request) string {
+	if actor := r.Header.Get(ActorHeader); actor != "" {
+		return actor
+	}
+	return "anonymous"
+}
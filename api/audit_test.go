@@ -0,0 +1,73 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestAuditLog(t *testing.T) {
+	key, err := audit.LoadServiceKey("")
+	if err != nil {
+		t.Fatalf("Failed to generate service key: %v", err)
+	}
+	auditLog, err := audit.NewLog(audit.NewMemoryStore(), key, 1)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+
+	repo := persistence.NewInMemoryDeviceRepository()
+	handler := NewServer(":0", NewDeviceHandler(service.NewDeviceService(repo, service.WithAuditLog(auditLog))), WithAuditHandler(NewAuditHandler(auditLog))).Handler()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v0/devices", bytes.NewBufferString(`{"algorithm": "ECC", "label": "Audited"}`))
+	req.Header.Set(ActorHeader, "alice")
+	req.RemoteAddr = "203.0.113.7:4321"
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v0/audit", nil)
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	var response struct {
+		Data audit.Export `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(response.Data.Entries) != 1 {
+		t.Fatalf("Expected 1 audit entry, got %d", len(response.Data.Entries))
+	}
+	entry := response.Data.Entries[0]
+	if entry.Actor != "alice" || entry.ClientAddress != "203.0.113.7:4321" || entry.Action != audit.ActionCreateDevice || entry.Details["label"] != "Audited" {
+		t.Errorf("Unexpected audit entry: %+v", entry)
+	}
+	if _, err := audit.Verify(&response.Data, key.Public); err != nil {
+		t.Errorf("Expected served audit log to verify: %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v0/audit", nil)
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/backup"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+// maxRestoreRequestBytes limits the size of restore requests, which only carry a time.
+const maxRestoreRequestBytes = 4 << 10
+
+// BackupResponse describes a snapshot of all devices and their transactions.
+type BackupResponse struct {
+	Name    string    `json:"name"`
+	TakenAt time.Time `json:"taken_at"`
+	// Devices is the number of devices in the snapshot. It is only set for
+	// created and restored snapshots.
+	Devices int `json:"devices,omitempty"`
+}
+
+// RestoreBackupRequest selects the snapshot to restore: the latest one taken
+// at or before At, or the latest one if At is omitted.
+type RestoreBackupRequest struct {
+	At time.Time `json:"at"`
+}
+
+type BackupHandler struct {
+	backups *backup.Manager
+}
+
+func NewBackupHandler(backups *backup.Manager) *BackupHandler {
+	return &BackupHandler{backups: backups}
+}
+
+// ListBackups writes the stored snapshots, oldest first.
+func (h *BackupHandler) ListBackups(w http.ResponseWriter, r *http.Request) {
+	infos, err := h.backups.List(r.Context())
+	if err != nil {
+		writeBackupError(w, r, err)
+		return
+	}
+
+	response := make([]BackupResponse, 0, len(infos))
+	for _, info := range infos {
+		response = append(response, newBackupResponse(info))
+	}
+	writeResponse(w, r, http.StatusOK, response)
+}
+
+// CreateBackup takes a snapshot of all devices and their transactions.
+func (h *BackupHandler) CreateBackup(w http.ResponseWriter, r *http.Request) {
+	info, err := h.backups.Create(r.Context())
+	if err != nil {
+		writeBackupError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, http.StatusCreated, newBackupResponse(info))
+}
+
+// RestoreBackup replaces all devices with a snapshot after verifying its
+// signature chains. Devices created after the snapshot are deleted.
+func (h *BackupHandler) RestoreBackup(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRestoreRequestBytes)
+
+	var request RestoreBackupRequest
+	if err := decodeRequest(r, &request); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, r, err, "Invalid request body")
+		return
+	}
+
+	info, err := h.backups.Restore(r.Context(), request.At, actor(r))
+	if err != nil {
+		writeBackupError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, newBackupResponse(info))
+}
+
+// writeBackupError translates errors of the backup.Manager to status codes.
+func writeBackupError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, backup.ErrNoBackup):
+		WriteErrorResponse(w, r, http.StatusNotFound, []string{"No backup found"})
+	case errors.Is(err, backup.ErrInvalidSnapshot), errors.Is(err, service.ErrBrokenChain):
+		WriteErrorResponse(w, r, http.StatusUnprocessableEntity, []string{err.Error()})
+	default:
+		writeServiceError(w, r, err)
+	}
+}
+
+func newBackupResponse(info backup.Info) BackupResponse {
+	return BackupResponse{Name: info.Name, TakenAt: info.TakenAt, Devices: info.Devices}
+}
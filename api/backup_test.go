@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/backup"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/bundle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestBackupRestore(t *testing.T) {
+	key, err := bundle.NewKey(bytes.Repeat([]byte("b"), bundle.MinSecretSize))
+	if err != nil {
+		t.Fatalf("Failed to create backup key: %v", err)
+	}
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	backups := backup.NewManager(devices, backup.NewDirStore(t.TempDir()), key)
+	handler := NewServer(":0", NewDeviceHandler(devices), WithBackupHandler(NewBackupHandler(backups))).Handler()
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rr
+	}
+
+	if rr := call(http.MethodPost, "/api/v0/backups/restore", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without backups, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	device, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	rr := call(http.MethodPost, "/api/v0/backups", "")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201 for a backup, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var created struct {
+		Data BackupResponse `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &created)
+	if created.Data.Devices != 1 || created.Data.Name == "" {
+		t.Errorf("Unexpected backup %+v", created.Data)
+	}
+
+	if rr := call(http.MethodPost, "/api/v0/devices/"+device.ID+"/sign", `{"data": "after backup"}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for sign, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var listed struct {
+		Data []BackupResponse `json:"data"`
+	}
+	json.Unmarshal(call(http.MethodGet, "/api/v0/backups", "").Body.Bytes(), &listed)
+	if len(listed.Data) != 1 || listed.Data[0].Name != created.Data.Name {
+		t.Errorf("Unexpected backups %+v", listed.Data)
+	}
+
+	before, _ := json.Marshal(RestoreBackupRequest{At: created.Data.TakenAt.Add(-time.Second)})
+	if rr := call(http.MethodPost, "/api/v0/backups/restore", string(before)); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 before the first backup, got %d", rr.Code)
+	}
+	if rr := call(http.MethodPost, "/api/v0/backups/restore", `{"at": "yesterday"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid time, got %d", rr.Code)
+	}
+	at, _ := json.Marshal(RestoreBackupRequest{At: created.Data.TakenAt})
+	if rr := call(http.MethodPost, "/api/v0/backups/restore", string(at)); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for restore, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if restored, _ := devices.GetDevice(context.Background(), device.ID); restored.SignatureCounter != 0 {
+		t.Errorf("Expected the counter of the backup, got %d", restored.SignatureCounter)
+	}
+}
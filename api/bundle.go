@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+)
+
+// DeviceBundleResponse holds an encrypted, signed device bundle for import
+// into another instance that shares the migration key and trusts this one.
+type DeviceBundleResponse struct {
+	ID               string `json:"id"`
+	SignatureCounter int    `json:"signature_counter"`
+	// Bundle is the opaque bundle, base64-encoded in JSON and a byte string in CBOR.
+	Bundle []byte `json:"bundle"`
+}
+
+// ImportDeviceRequest holds a bundle returned by the export endpoint.
+type ImportDeviceRequest struct {
+	Bundle []byte `json:"bundle"`
+}
+
+// ExportDevice writes an encrypted bundle of a device, including its
+// signature counter and wrapped private key.
+func (h *DeviceHandler) ExportDevice(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	annotateDevice(r, id)
+
+	device, sealed, err := h.devices.ExportDevice(r.Context(), id, actor(r))
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, DeviceBundleResponse{ID: id, SignatureCounter: device.SignatureCounter, Bundle: sealed})
+}
+
+// ImportDevice restores the device of a bundle created by ExportDevice on
+// this or another instance. Devices that exist with a higher signature
+// counter are not replaced.
+func (h *DeviceHandler) ImportDevice(w http.ResponseWriter, r *http.Request) {
+	h.limitBody(w, r)
+
+	var request ImportDeviceRequest
+	if err := decodeRequest(r, &request); err != nil {
+		writeBodyError(w, r, err, "Invalid request body")
+		return
+	}
+	if len(request.Bundle) == 0 {
+		WriteErrorResponse(w, r, http.StatusBadRequest, []string{"Invalid request body"})
+		return
+	}
+
+	device, err := h.devices.ImportDevice(r.Context(), request.Bundle, actor(r))
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	annotateDevice(r, device.ID)
+	response, err := newDeviceResponse(device)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, response)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/bundle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestExportImportDevice(t *testing.T) {
+	key, err := bundle.NewKey(bytes.Repeat([]byte("m"), bundle.MinSecretSize))
+	if err != nil {
+		t.Fatalf("Failed to create migration key: %v", err)
+	}
+	sourceDevices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithMigrationKey(key))
+	source := routes(NewDeviceHandler(sourceDevices))
+	target := routes(NewDeviceHandler(service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithMigrationKey(key))))
+
+	device, err := sourceDevices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: "RSA", Label: "Till 1"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := sourceDevices.SignTransaction(context.Background(), device.ID, "receipt"); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+
+	post := func(handler http.Handler, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return rr
+	}
+
+	rr := post(source, "/api/v0/devices/"+device.ID+"/export", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for export, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var exported struct {
+		Data DeviceBundleResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &exported); err != nil {
+		t.Fatalf("Failed to unmarshal export response: %v", err)
+	}
+	if exported.Data.ID != device.ID || exported.Data.SignatureCounter != 1 || len(exported.Data.Bundle) == 0 {
+		t.Fatalf("Unexpected export response %+v", exported.Data)
+	}
+	request, _ := json.Marshal(ImportDeviceRequest{Bundle: exported.Data.Bundle})
+
+	rr = post(target, "/api/v0/devices/import", string(request))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for import, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var imported struct {
+		Data CreateDeviceResponse `json:"data"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &imported)
+	if imported.Data.ID != device.ID || imported.Data.SignatureCounter != 1 || imported.Data.Label != "Till 1" {
+		t.Errorf("Unexpected imported device %+v", imported.Data)
+	}
+
+	if rr := post(target, "/api/v0/devices/"+device.ID+"/sign", `{"data": "next"}`); rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for sign, got %d: %s", rr.Code, rr.Body.String())
+	}
+	for _, tt := range []struct {
+		name    string
+		handler http.Handler
+		path    string
+		body    string
+		status  int
+	}{
+		{"stale bundle", target, "/api/v0/devices/import", string(request), http.StatusConflict},
+		{"exported device", source, "/api/v0/devices/" + device.ID + "/sign", `{"data": "fork"}`, http.StatusConflict},
+		{"invalid bundle", target, "/api/v0/devices/import", `{"bundle": "bm90IGEgYnVuZGxl"}`, http.StatusUnprocessableEntity},
+		{"empty body", target, "/api/v0/devices/import", `{}`, http.StatusBadRequest},
+		{"unknown device", source, "/api/v0/devices/" + device.ID + "0/export", "", http.StatusNotFound},
+		{"no migration key", routes(NewDeviceHandler(service.NewDeviceService(persistence.NewInMemoryDeviceRepository()))), "/api/v0/devices/import", string(request), http.StatusNotFound},
+	} {
+		if rr := post(tt.handler, tt.path, tt.body); rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CBORContentType is the media type of CBOR (RFC 8949) request and response bodies.
+const CBORContentType = "application/cbor"
+
+// cborEncoding writes times as RFC 3339 strings, like the JSON encoding.
+var cborEncoding, _ = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+
+// decodeRequest decodes the body of r as CBOR if its Content-Type says so, and
+// as JSON otherwise. CBOR maps use the same keys as the JSON objects.
+func decodeRequest(r *http.Request, v interface{}) error {
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == CBORContentType {
+		return cbor.NewDecoder(r.Body).Decode(v)
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// writeResponse writes data in the Response envelope, encoded as CBOR if the
+// client prefers it and as JSON otherwise.
+func writeResponse(w http.ResponseWriter, r *http.Request, code int, data interface{}) {
+	if !acceptsCBOR(r) {
+		WriteAPIResponse(w, code, data)
+		return
+	}
+	writeCBOR(w, code, Response{Data: data})
+}
+
+func writeCBOR(w http.ResponseWriter, code int, body interface{}) {
+	content, err := cborEncoding.Marshal(body)
+	if err != nil {
+		WriteInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", CBORContentType)
+	w.WriteHeader(code)
+	w.Write(content)
+}
+
+// acceptsCBOR reports whether the Accept header of r lists application/cbor
+// before application/json.
+func acceptsCBOR(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case CBORContentType:
+			return true
+		case "application/json":
+			return false
+		}
+	}
+	return false
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+	"github.com/fxamacker/cbor/v2"
+)
+
+func TestCBOREncoding(t *testing.T) {
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	handler := routes(NewDeviceHandler(devices))
+
+	body, _ := cbor.Marshal(map[string]string{"algorithm": "ECC", "label": "CBOR"})
+	req := httptest.NewRequest(http.MethodPost, "/api/v0/devices", bytes.NewReader(body))
+	req.Header.Set("Content-Type", CBORContentType)
+	req.Header.Set("Accept", CBORContentType+", application/json;q=0.5")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if contentType := rr.Header().Get("Content-Type"); contentType != CBORContentType {
+		t.Fatalf("Expected Content-Type %s, got %q", CBORContentType, contentType)
+	}
+	var created struct {
+		Data CreateDeviceResponse `cbor:"data"`
+	}
+	if err := cbor.Unmarshal(rr.Body.Bytes(), &created); err != nil {
+		t.Fatalf("Failed to decode CBOR response: %v", err)
+	}
+	if created.Data.Algorithm != "ECC" || created.Data.Label != "CBOR" {
+		t.Errorf("Unexpected device %+v", created.Data)
+	}
+
+	body, _ = cbor.Marshal(map[string]string{"data": "receipt"})
+	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+created.Data.ID+"/sign?format=cose", bytes.NewReader(body))
+	req.Header.Set("Content-Type", CBORContentType)
+	req.Header.Set("Accept", CBORContentType)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var signed struct {
+		Data SignTransactionResponse `cbor:"data"`
+	}
+	if err := cbor.Unmarshal(rr.Body.Bytes(), &signed); err != nil {
+		t.Fatalf("Failed to decode CBOR response: %v", err)
+	}
+	if signed.Data.JWS != "" {
+		t.Errorf("Expected no JWS without format=jws, got %q", signed.Data.JWS)
+	}
+	var message cbor.RawTag
+	if err := cbor.Unmarshal(signed.Data.COSE, &message); err != nil || message.Number != 18 {
+		t.Fatalf("Expected a tagged COSE_Sign1, got tag %d: %v", message.Number, err)
+	}
+	var fields []interface{}
+	if err := cbor.Unmarshal(message.Content, &fields); err != nil || len(fields) != 4 {
+		t.Fatalf("Expected a four element COSE_Sign1, got %v: %v", fields, err)
+	}
+	var header map[interface{}]interface{}
+	if err := cbor.Unmarshal(fields[0].([]byte), &header); err != nil {
+		t.Fatalf("Invalid protected header: %v", err)
+	}
+	if header[uint64(1)] != int64(-35) || header[domain.COSEHeaderCounter] != uint64(0) {
+		t.Errorf("Unexpected protected header %v", header)
+	}
+	if string(fields[2].([]byte)) != signed.Data.SignedData {
+		t.Errorf("Expected the COSE payload to be the signed data, got %q", fields[2])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v0/devices/unknown", nil)
+	req.Header.Set("Accept", CBORContentType)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound || rr.Header().Get("Content-Type") != CBORContentType {
+		t.Fatalf("Expected a CBOR 404, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var errorResponse ErrorResponse
+	if err := cbor.Unmarshal(rr.Body.Bytes(), &errorResponse); err != nil || len(errorResponse.Errors) == 0 {
+		t.Errorf("Expected a CBOR error envelope, got %v: %v", errorResponse, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v0/devices/"+created.Data.ID, nil)
+	req.Header.Set("Accept", "application/json, "+CBORContentType)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("Expected JSON when preferred over CBOR, got %q", contentType)
+	}
+
+	if stored, _ := devices.GetDevice(context.Background(), created.Data.ID); stored.SignatureCounter != 1 {
+		t.Errorf("Expected one signature, got counter %d", stored.SignatureCounter)
+	}
+}
@@ -0,0 +1,287 @@
+package api
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CertificateResponse holds the X.509 certificate of a device and its chain.
+type CertificateResponse struct {
+	ID string `json:"id"`
+	// Certificate is the PEM-encoded device certificate.
+	Certificate string `json:"certificate"`
+	// Chain holds the PEM-encoded certificates from the device up to the CA.
+	Chain        []string  `json:"chain"`
+	SerialNumber string    `json:"serial_number"`
+	NotBefore    time.Time `json:"not_before"`
+	NotAfter     time.Time `json:"not_after"`
+}
+
+// CSRRequest holds optional subject attributes of a certificate signing
+// request. The common name defaults to the device label or ID.
+type CSRRequest struct {
+	CommonName         string `json:"common_name,omitempty"`
+	Organization       string `json:"organization,omitempty"`
+	OrganizationalUnit string `json:"organizational_unit,omitempty"`
+	Locality           string `json:"locality,omitempty"`
+	Province           string `json:"province,omitempty"`
+	Country            string `json:"country,omitempty"`
+}
+
+// CSRResponse holds a PKCS#10 certificate signing request for a device key.
+type CSRResponse struct {
+	ID string `json:"id"`
+	// CSR is the PEM-encoded certificate signing request.
+	CSR string `json:"csr"`
+}
+
+// ImportCertificateRequest holds a certificate issued by an external CA.
+type ImportCertificateRequest struct {
+	// Certificate is the PEM-encoded device certificate, optionally followed
+	// by its issuer certificates.
+	Certificate string `json:"certificate"`
+}
+
+// Media types of the raw certificate formats.
+const (
+	pemCertificateChainContentType = "application/pem-certificate-chain"
+	derCertificateContentType      = "application/pkix-cert"
+	derCSRContentType              = "application/pkcs10"
+)
+
+// Certificate writes the certificate chain of a device. The format is selected
+// by the format query parameter or, without it, by the Accept header: pem
+// returns the PEM chain, der the DER device certificate, and by default the
+// chain is returned in the usual JSON envelope.
+func (h *DeviceHandler) Certificate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	annotateDevice(r, id)
+
+	format, ok := negotiateCertificateFormat(r)
+	if !ok {
+		WriteErrorResponse(w, r, http.StatusBadRequest, []string{"Unsupported format. Supported formats: json, pem, der"})
+		return
+	}
+
+	chain, err := h.devices.CertificateChain(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	switch format {
+	case "pem":
+		w.Header().Set("Content-Type", pemCertificateChainContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(strings.Join(encodeCertificates(chain), "")))
+	case "der":
+		w.Header().Set("Content-Type", derCertificateContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(chain[0].Raw)
+	default:
+		writeResponse(w, r, http.StatusOK, newCertificateResponse(id, chain))
+	}
+}
+
+func newCertificateResponse(id string, chain []*x509.Certificate) CertificateResponse {
+	encoded := encodeCertificates(chain)
+	return CertificateResponse{
+		ID:           id,
+		Certificate:  encoded[0],
+		Chain:        encoded,
+		SerialNumber: chain[0].SerialNumber.Text(16),
+		NotBefore:    chain[0].NotBefore.UTC(),
+		NotAfter:     chain[0].NotAfter.UTC(),
+	}
+}
+
+func encodeCertificates(chain []*x509.Certificate) []string {
+	encoded := make([]string, len(chain))
+	for i, certificate := range chain {
+		encoded[i] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}))
+	}
+	return encoded
+}
+
+// negotiateCertificateFormat returns "pem", "der" or "" for the JSON envelope.
+// It reports false for an unknown format parameter.
+func negotiateCertificateFormat(r *http.Request) (string, bool) {
+	if name := strings.ToLower(r.URL.Query().Get("format")); name != "" {
+		switch name {
+		case "json":
+			return "", true
+		case "pem", "der":
+			return name, true
+		default:
+			return "", false
+		}
+	}
+
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json", CBORContentType:
+			return "", true
+		case pemCertificateChainContentType, "application/x-pem-file":
+			return "pem", true
+		case derCertificateContentType, derCSRContentType:
+			return "der", true
+		}
+	}
+	return "", true
+}
+
+// CertificateRequest writes a PKCS#10 certificate signing request for the key
+// of a device, signed with that key. The optional body sets subject
+// attributes. The format is selected like for Certificate: pem returns the
+// PEM request, der (application/pkcs10) the DER request, and by default it is
+// returned in the usual JSON envelope.
+func (h *DeviceHandler) CertificateRequest(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	annotateDevice(r, id)
+
+	format, ok := negotiateCertificateFormat(r)
+	if !ok {
+		WriteErrorResponse(w, r, http.StatusBadRequest, []string{"Unsupported format. Supported formats: json, pem, der"})
+		return
+	}
+
+	h.limitBody(w, r)
+
+	var request CSRRequest
+	if err := decodeRequest(r, &request); err != nil && !errors.Is(err, io.EOF) {
+		writeBodyError(w, r, err, "Invalid request body")
+		return
+	}
+
+	csr, err := h.devices.CertificateRequest(r.Context(), id, request.subject())
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	switch format {
+	case "pem":
+		w.Header().Set("Content-Type", "application/x-pem-file")
+		w.WriteHeader(http.StatusOK)
+		w.Write(encoded)
+	case "der":
+		w.Header().Set("Content-Type", derCSRContentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(csr)
+	default:
+		writeResponse(w, r, http.StatusOK, CSRResponse{ID: id, CSR: string(encoded)})
+	}
+}
+
+func (request CSRRequest) subject() pkix.Name {
+	var subject pkix.Name
+	subject.CommonName = request.CommonName
+	for _, attribute := range []struct {
+		value  string
+		target *[]string
+	}{
+		{request.Organization, &subject.Organization},
+		{request.OrganizationalUnit, &subject.OrganizationalUnit},
+		{request.Locality, &subject.Locality},
+		{request.Province, &subject.Province},
+		{request.Country, &subject.Country},
+	} {
+		if attribute.value != "" {
+			*attribute.target = []string{attribute.value}
+		}
+	}
+	return subject
+}
+
+// ImportCertificate replaces the certificate of a device with one issued by an
+// external CA. The body is a PEM chain (application/pem-certificate-chain), a
+// DER certificate (application/pkix-cert) or an ImportCertificateRequest. The
+// certificate must certify the current device key.
+func (h *DeviceHandler) ImportCertificate(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	annotateDevice(r, id)
+
+	h.limitBody(w, r)
+
+	chain, err := readCertificates(r)
+	if err != nil {
+		writeBodyError(w, r, err, "Invalid request body: "+err.Error())
+		return
+	}
+
+	if err := h.devices.ImportCertificate(r.Context(), id, chain, actor(r)); err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	chain, err = h.devices.CertificateChain(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, newCertificateResponse(id, chain))
+}
+
+// readCertificates parses the certificate chain in the body of r.
+func readCertificates(r *http.Request) ([]*x509.Certificate, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case pemCertificateChainContentType, "application/x-pem-file":
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		return parsePEMCertificates(content)
+	case derCertificateContentType:
+		content, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		certificate, err := x509.ParseCertificate(content)
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.Certificate{certificate}, nil
+	default:
+		var request ImportCertificateRequest
+		if err := decodeRequest(r, &request); err != nil {
+			return nil, err
+		}
+		return parsePEMCertificates([]byte(request.Certificate))
+	}
+}
+
+func parsePEMCertificates(content []byte) ([]*x509.Certificate, error) {
+	var chain []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, content = pem.Decode(content)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, certificate)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no PEM certificate found")
+	}
+	return chain, nil
+}
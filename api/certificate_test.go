@@ -0,0 +1,202 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestCertificate(t *testing.T) {
+	authority, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithCertificateAuthority(authority))
+	handler := routes(NewDeviceHandler(devices))
+	device, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: "ECC", Label: "Till 1"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	path := "/api/v0/devices/" + device.ID + "/certificate"
+
+	get := func(path, query, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get(path, "", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Data CertificateResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if len(response.Data.Chain) != 2 || response.Data.Chain[0] != response.Data.Certificate {
+		t.Fatalf("Expected the device certificate followed by the CA, got %d certificates", len(response.Data.Chain))
+	}
+	block, _ := pem.Decode([]byte(response.Data.Certificate))
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Invalid device certificate: %v", err)
+	}
+	if id, _ := ca.DeviceID(certificate); id != device.ID || certificate.Subject.CommonName != "Till 1" {
+		t.Errorf("Unexpected certificate subject %v", certificate.Subject)
+	}
+	if response.Data.SerialNumber != certificate.SerialNumber.Text(16) {
+		t.Errorf("Expected serial number %x, got %s", certificate.SerialNumber, response.Data.SerialNumber)
+	}
+
+	rr = get(path, "?format=pem", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/pem-certificate-chain" {
+		t.Fatalf("Expected a PEM chain, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	intermediates := x509.NewCertPool()
+	if !intermediates.AppendCertsFromPEM(rr.Body.Bytes()) {
+		t.Errorf("Failed to parse the PEM chain")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.Certificate())
+	if _, err := certificate.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+		t.Errorf("Certificate does not chain to the CA: %v", err)
+	}
+
+	rr = get(path, "", "application/pkix-cert")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/pkix-cert" || string(rr.Body.Bytes()) != string(certificate.Raw) {
+		t.Errorf("Expected the DER certificate, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+
+	if rr := get(path, "?format=p7b", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unsupported format, got %d", rr.Code)
+	}
+	if rr := get("/api/v0/devices/unknown/certificate", "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown device, got %d", rr.Code)
+	}
+
+	handler = routes(NewDeviceHandler(service.NewDeviceService(persistence.NewInMemoryDeviceRepository())))
+	if rr := get(path, "", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a CA, got %d", rr.Code)
+	}
+}
+
+func TestCertificateRequestAndImport(t *testing.T) {
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	handler := routes(NewDeviceHandler(devices))
+	device, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: "RSA", Label: "Till 2"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+
+	send := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send(http.MethodPost, "/api/v0/devices/"+device.ID+"/csr", "", `{"organization": "ACME", "country": "DE"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Data CSRResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	block, _ := pem.Decode([]byte(response.Data.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		t.Fatalf("Expected a PEM certificate request, got %q", response.Data.CSR)
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatalf("Invalid certificate request: %v", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		t.Errorf("Certificate request signature does not verify: %v", err)
+	}
+	spki, _ := device.ExportPublicKey("der")
+	if string(csr.RawSubjectPublicKeyInfo) != string(spki) {
+		t.Errorf("Expected the certificate request to be for the device key")
+	}
+	if csr.Subject.CommonName != "Till 2" || csr.Subject.SerialNumber != device.ID || csr.Subject.Organization[0] != "ACME" || csr.Subject.Country[0] != "DE" {
+		t.Errorf("Unexpected subject %v", csr.Subject)
+	}
+
+	rr = send(http.MethodPost, "/api/v0/devices/"+device.ID+"/csr?format=der", "", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/pkcs10" {
+		t.Fatalf("Expected a DER certificate request, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	if _, err := x509.ParseCertificateRequest(rr.Body.Bytes()); err != nil {
+		t.Errorf("Invalid DER certificate request: %v", err)
+	}
+
+	external, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	issued, err := external.Issue(device)
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	chain := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: external.Certificate().Raw}))
+
+	path := "/api/v0/devices/" + device.ID + "/certificate"
+	rr = send(http.MethodPut, path, "application/pem-certificate-chain", chain)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = send(http.MethodGet, path+"?format=pem", "", "")
+	if rr.Body.String() != chain {
+		t.Errorf("Expected the imported chain to be served, got:\n%s", rr.Body.String())
+	}
+
+	other, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: "RSA"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	body, _ := json.Marshal(ImportCertificateRequest{Certificate: chain})
+	if rr := send(http.MethodPut, "/api/v0/devices/"+other.ID+"/certificate", "", string(body)); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a certificate of another key, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	unrelated, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	broken := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued})) +
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: unrelated.Certificate().Raw}))
+	if rr := send(http.MethodPut, path, "application/x-pem-file", broken); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a broken chain, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := send(http.MethodPut, path, "application/pkix-cert", "not a certificate"); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an invalid certificate, got %d", rr.Code)
+	}
+	if rr := send(http.MethodPut, path, "application/pkix-cert", string(issued)); rr.Code != http.StatusOK {
+		t.Errorf("Expected a DER certificate to be accepted, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+// CounterStatusResponse compares the signature counter of a device with the
+// highest counter it ever reached. Devices that are rolled back refuse to sign.
+type CounterStatusResponse struct {
+	ID               string `json:"id"`
+	SignatureCounter int    `json:"signature_counter"`
+	HighWaterMark    int    `json:"high_water_mark"`
+	RolledBack       bool   `json:"rolled_back"`
+}
+
+// OverrideHighWaterMarkRequest gives the reason that is recorded in the audit log.
+type OverrideHighWaterMarkRequest struct {
+	Reason string `json:"reason"`
+}
+
+// CounterStatus writes the signature counter and high-water mark of a device.
+func (h *DeviceHandler) CounterStatus(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	annotateDevice(r, id)
+
+	status, err := h.devices.CounterStatus(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, newCounterStatusResponse(id, status))
+}
+
+// OverrideHighWaterMark lets a rolled back device sign again from its current
+// counter, re-issuing the counters up to its high-water mark.
+func (h *DeviceHandler) OverrideHighWaterMark(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	annotateDevice(r, id)
+	h.limitBody(w, r)
+
+	var request OverrideHighWaterMarkRequest
+	if err := decodeRequest(r, &request); err != nil {
+		writeBodyError(w, r, err, "Invalid request body")
+		return
+	}
+
+	status, err := h.devices.OverrideHighWaterMark(r.Context(), id, actor(r), request.Reason)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	writeResponse(w, r, http.StatusOK, newCounterStatusResponse(id, status))
+}
+
+func newCounterStatusResponse(id string, status service.CounterStatus) CounterStatusResponse {
+	return CounterStatusResponse{
+		ID:               id,
+		SignatureCounter: status.SignatureCounter,
+		HighWaterMark:    status.HighWaterMark,
+		RolledBack:       status.RolledBack(),
+	}
+}
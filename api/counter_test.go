@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestCounterOverride(t *testing.T) {
+	ctx := context.Background()
+	repository := persistence.NewInMemoryDeviceRepository()
+	devices := service.NewDeviceService(repository)
+	handler := routes(NewDeviceHandler(devices))
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(ActorHeader, "operator")
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	device, err := devices.CreateDevice(ctx, service.CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	backup, _ := repository.Get(ctx, device.ID)
+	if _, err := devices.SignTransaction(ctx, device.ID, "voided"); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	repository.Update(ctx, backup)
+
+	path := "/api/v0/devices/" + device.ID
+	if rr := call(http.MethodPost, path+"/sign", `{"data": "reissued"}`); rr.Code != http.StatusConflict {
+		t.Errorf("Expected status 409 for a rolled back device, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var status struct {
+		Data CounterStatusResponse `json:"data"`
+	}
+	json.Unmarshal(call(http.MethodGet, path+"/counter", "").Body.Bytes(), &status)
+	if status.Data.SignatureCounter != 0 || status.Data.HighWaterMark != 1 || !status.Data.RolledBack {
+		t.Errorf("Unexpected counter status %+v", status.Data)
+	}
+
+	if rr := call(http.MethodPost, path+"/counter/override", `{}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 without a reason, got %d", rr.Code)
+	}
+	if rr := call(http.MethodPost, path+"/counter/override", `{`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a malformed body, got %d", rr.Code)
+	}
+	rr := call(http.MethodPost, path+"/counter/override", `{"reason": "receipt was voided"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for the override, got %d: %s", rr.Code, rr.Body.String())
+	}
+	json.Unmarshal(rr.Body.Bytes(), &status)
+	if status.Data.HighWaterMark != 0 || status.Data.RolledBack {
+		t.Errorf("Unexpected counter status after the override %+v", status.Data)
+	}
+	if rr := call(http.MethodPost, path+"/sign", `{"data": "reissued"}`); rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after the override, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
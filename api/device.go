@@ -1,20 +1,26 @@
 package api
 
 import (
-	"encoding/json"
+	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"mime"
 	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
-	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
-	"github.com/google/uuid"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
 )
 
 type CreateDeviceRequest struct {
-	ID        string `json:"id"`
-	Algorithm string `json:"algorithm"`
-	Label     string `json:"label"`
+	ID        string `json:"id,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Label     string `json:"label,omitempty"`
 }
 
 type CreateDeviceResponse struct {
@@ -22,195 +28,362 @@ type CreateDeviceResponse struct {
 	Label            string `json:"label"`
 	Algorithm        string `json:"algorithm"`
 	SignatureCounter int    `json:"signature_counter"`
-	PublicKey        []byte `json:"public_key"`
+	// PublicKey is the standard PKIX PEM encoding of the key.
+	PublicKey string `json:"public_key"`
 }
 
+// SignTransactionRequest carries either the transaction data or a digest of
+// it. A digest is signed as "<hash algorithm>:<hex digest>" in place of the data.
 type SignTransactionRequest struct {
-	Data string `json:"data"`
+	Data string `json:"data,omitempty"`
+	// DataEncoding is the encoding of Data: utf8 (the default), base64 or hex.
+	// Binary data is signed as "<encoding>:<canonical encoding>".
+	DataEncoding string `json:"data_encoding,omitempty"`
+	// Digest is the hex-encoded hash of the transaction data.
+	Digest string `json:"digest,omitempty"`
+	// HashAlgorithm names the hash function of Digest: sha256, sha384 or sha512.
+	HashAlgorithm string `json:"hash_algorithm,omitempty"`
 }
 
+// LogValue implements slog.LogValuer so that transaction data never reaches the logs.
+func (r SignTransactionRequest) LogValue() slog.Value {
+	if r.Digest != "" {
+		return slog.GroupValue(slog.String("hash_algorithm", r.HashAlgorithm))
+	}
+	return slog.GroupValue(slog.Int("data_length", len(r.Data)), slog.String("data_encoding", r.DataEncoding))
+}
+
+// StreamContentType marks sign requests whose body is the raw transaction
+// data. The body is hashed while it is read and the digest is signed.
+const StreamContentType = "application/octet-stream"
+
 type SignTransactionResponse struct {
 	Signature  string `json:"signature"`
 	SignedData string `json:"signed_data"`
+	// SignedAt is the signing time included in the signed data.
+	SignedAt time.Time `json:"signed_at"`
+	// JWS is the signed data as a compact JWS, returned for format=jws.
+	JWS string `json:"jws,omitempty"`
+	// COSE is the signed data as a tagged COSE_Sign1 structure, returned for
+	// format=cose. It is a byte string in CBOR and base64 in JSON.
+	COSE []byte `json:"cose,omitempty"`
+	// TimestampToken is a DER-encoded RFC 3161 token for the signature,
+	// returned if a time-stamping authority is configured.
+	TimestampToken []byte `json:"timestamp_token,omitempty"`
+	// DataEncoding is the encoding of the data in SignedData; it is omitted
+	// for signed digests.
+	DataEncoding string `json:"data_encoding,omitempty"`
 }
 
-type DeviceHandler struct {
-	repository persistence.DeviceRepository
+type TransactionResponse struct {
+	Counter        int       `json:"counter"`
+	Signature      string    `json:"signature"`
+	SignedData     string    `json:"signed_data"`
+	SignedAt       time.Time `json:"signed_at"`
+	TimestampToken []byte    `json:"timestamp_token,omitempty"`
+	DataEncoding   string    `json:"data_encoding,omitempty"`
 }
 
-func NewDeviceHandler(repository persistence.DeviceRepository) *DeviceHandler {
-	return &DeviceHandler{repository: repository}
+// DeviceSettings holds the DeviceHandler settings that can be changed at runtime.
+// Zero values disable the respective limit.
+type DeviceSettings struct {
+	MaxRequestBodyBytes int64
+	// MaxStreamBytes limits transaction data streamed to the sign endpoint.
+	MaxStreamBytes int64
 }
 
-func (h *DeviceHandler) CreateDevice(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		WriteErrorResponse(w, http.StatusMethodNotAllowed, []string{http.StatusText(http.StatusMethodNotAllowed)})
-		return
-	}
+// DeviceHandler serves the device endpoints of the REST API on top of a service.DeviceService.
+type DeviceHandler struct {
+	devices  *service.DeviceService
+	settings atomic.Pointer[DeviceSettings]
+}
 
-	var request CreateDeviceRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		WriteErrorResponse(w, http.StatusBadRequest, []string{"Invalid request body"})
-		return
-	}
+func NewDeviceHandler(devices *service.DeviceService) *DeviceHandler {
+	h := &DeviceHandler{devices: devices}
+	h.settings.Store(&DeviceSettings{})
+	return h
+}
 
-	if request.ID == "" {
-		request.ID = uuid.New().String()
-	} else {
-		if err := domain.ValidateID(request.ID); err != nil {
-			WriteErrorResponse(w, http.StatusBadRequest, []string{err.Error()})
-			return
-		}
-	}
+// ApplySettings replaces the runtime settings of the handler. It is safe to call concurrently with requests.
+func (h *DeviceHandler) ApplySettings(settings DeviceSettings) {
+	h.settings.Store(&settings)
+}
 
-	algorithm := domain.SignatureAlgorithm(strings.ToUpper(request.Algorithm))
-	if algorithm != domain.RSA && algorithm != domain.ECC {
-		WriteErrorResponse(w, http.StatusBadRequest, []string{"Invalid algorithm. Supported algorithms: RSA, ECC"})
-		return
+func (h *DeviceHandler) limitBody(w http.ResponseWriter, r *http.Request) {
+	if limit := h.settings.Load().MaxRequestBodyBytes; limit > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, limit)
 	}
+}
 
-	device, err := domain.NewSignatureDevice(request.ID, algorithm, request.Label)
-	if err != nil {
-		WriteErrorResponse(w, http.StatusInternalServerError, []string{fmt.Sprintf("Failed to create signature device: %v", err)})
+// writeBodyError rejects a request whose body could not be read: with 413 if
+// it exceeds the limit set by limitBody, and with 400 and message otherwise.
+func writeBodyError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		WriteErrorResponse(w, r, http.StatusRequestEntityTooLarge, []string{"Request body too large"})
 		return
 	}
+	WriteErrorResponse(w, r, http.StatusBadRequest, []string{message})
+}
 
-	if err := h.repository.Create(r.Context(), device); err != nil {
-		WriteErrorResponse(w, http.StatusInternalServerError, []string{fmt.Sprintf("Failed to store signature device: %v", err)})
-		return
+// writeServiceError translates errors of the DeviceService to status codes.
+// Well-formed requests with invalid values are 422; malformed bodies are
+// rejected with 400 before the service is called.
+func writeServiceError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		WriteErrorResponse(w, r, http.StatusNotFound, []string{"Device not found"})
+	case errors.Is(err, service.ErrConflict):
+		WriteErrorResponse(w, r, http.StatusConflict, []string{"Device with this ID already exists"})
+	case errors.Is(err, service.ErrInvalidAlgorithm):
+		WriteErrorResponse(w, r, http.StatusUnprocessableEntity, []string{"Invalid algorithm. Supported algorithms: RSA, ECC"})
+	case errors.Is(err, service.ErrInvalidID), errors.Is(err, service.ErrInvalidHashAlgorithm), errors.Is(err, service.ErrInvalidDigest),
+		errors.Is(err, service.ErrInvalidDataEncoding), errors.Is(err, service.ErrInvalidData):
+		WriteErrorResponse(w, r, http.StatusUnprocessableEntity, []string{err.Error()})
+	case errors.Is(err, service.ErrDeviceLimitReached):
+		WriteErrorResponse(w, r, http.StatusForbidden, []string{err.Error()})
+	case errors.Is(err, service.ErrCertificateMismatch), errors.Is(err, service.ErrInvalidCertificate):
+		WriteErrorResponse(w, r, http.StatusUnprocessableEntity, []string{err.Error()})
+	case errors.Is(err, service.ErrNoCertificateAuthority):
+		WriteErrorResponse(w, r, http.StatusNotFound, []string{"No certificate authority configured"})
+	case errors.Is(err, service.ErrNoMigrationKey):
+		WriteErrorResponse(w, r, http.StatusNotFound, []string{"No migration key configured"})
+	case errors.Is(err, service.ErrInvalidBundle):
+		WriteErrorResponse(w, r, http.StatusUnprocessableEntity, []string{err.Error()})
+	case errors.Is(err, service.ErrStaleBundle), errors.Is(err, service.ErrCounterRollback), errors.Is(err, service.ErrDeviceExported):
+		WriteErrorResponse(w, r, http.StatusConflict, []string{err.Error()})
+	case errors.Is(err, service.ErrMissingReason):
+		WriteErrorResponse(w, r, http.StatusUnprocessableEntity, []string{err.Error()})
+	case errors.Is(err, service.ErrUnavailable):
+		WriteErrorResponse(w, r, http.StatusServiceUnavailable, []string{"Storage is temporarily unavailable"})
+	case errors.Is(err, service.ErrTimestampUnavailable):
+		WriteErrorResponse(w, r, http.StatusServiceUnavailable, []string{"Timestamp authority is temporarily unavailable"})
+	default:
+		WriteErrorResponse(w, r, http.StatusInternalServerError, []string{err.Error()})
 	}
+}
 
-	response := CreateDeviceResponse{
+func newDeviceResponse(device *domain.SignatureDevice) (CreateDeviceResponse, error) {
+	publicKey, err := device.ExportPublicKey(domain.PublicKeyPEM)
+	if err != nil {
+		return CreateDeviceResponse{}, err
+	}
+	return CreateDeviceResponse{
 		ID:               device.ID,
 		Label:            device.Label,
 		Algorithm:        string(device.Algorithm),
 		SignatureCounter: device.SignatureCounter,
-		PublicKey:        device.PublicKey,
-	}
-
-	WriteAPIResponse(w, http.StatusCreated, response)
+		PublicKey:        string(publicKey),
+	}, nil
 }
 
-func (h *DeviceHandler) GetDevice(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		WriteErrorResponse(w, http.StatusMethodNotAllowed, []string{http.StatusText(http.StatusMethodNotAllowed)})
+func (h *DeviceHandler) CreateDevice(w http.ResponseWriter, r *http.Request) {
+	h.limitBody(w, r)
+
+	var request CreateDeviceRequest
+	if err := decodeRequest(r, &request); err != nil {
+		writeBodyError(w, r, err, "Invalid request body")
 		return
 	}
 
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 {
-		WriteErrorResponse(w, http.StatusBadRequest, []string{"Invalid URL path"})
+	device, err := h.devices.CreateDevice(r.Context(), service.CreateDeviceParams{
+		ID:        request.ID,
+		Algorithm: request.Algorithm,
+		Label:     request.Label,
+		Actor:     actor(r),
+	})
+	if err != nil {
+		writeServiceError(w, r, err)
 		return
 	}
-	id := parts[len(parts)-1]
 
-	device, err := h.repository.Get(r.Context(), id)
+	annotateDevice(r, device.ID)
+	response, err := newDeviceResponse(device)
 	if err != nil {
-		WriteErrorResponse(w, http.StatusNotFound, []string{"Device not found"})
+		writeServiceError(w, r, err)
 		return
 	}
+	writeResponse(w, r, http.StatusCreated, response)
+}
 
-	response := CreateDeviceResponse{
-		ID:               device.ID,
-		Label:            device.Label,
-		Algorithm:        string(device.Algorithm),
-		SignatureCounter: device.SignatureCounter,
-		PublicKey:        device.PublicKey,
-	}
+func (h *DeviceHandler) GetDevice(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	annotateDevice(r, id)
 
-	WriteAPIResponse(w, http.StatusOK, response)
-}
+	device, err := h.devices.GetDevice(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
 
-func (h *DeviceHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		WriteErrorResponse(w, http.StatusMethodNotAllowed, []string{http.StatusText(http.StatusMethodNotAllowed)})
+	response, err := newDeviceResponse(device)
+	if err != nil {
+		writeServiceError(w, r, err)
 		return
 	}
+	writeResponse(w, r, http.StatusOK, response)
+}
 
-	devices, err := h.repository.List(r.Context())
+func (h *DeviceHandler) ListDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := h.devices.ListDevices(r.Context())
 	if err != nil {
-		WriteErrorResponse(w, http.StatusInternalServerError, []string{fmt.Sprintf("Failed to retrieve devices: %v", err)})
+		writeServiceError(w, r, err)
 		return
 	}
 
 	response := make([]CreateDeviceResponse, 0, len(devices))
 	for _, device := range devices {
-		response = append(response, CreateDeviceResponse{
-			ID:               device.ID,
-			Label:            device.Label,
-			Algorithm:        string(device.Algorithm),
-			SignatureCounter: device.SignatureCounter,
-			PublicKey:        device.PublicKey,
-		})
+		item, err := newDeviceResponse(device)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		response = append(response, item)
 	}
 
-	WriteAPIResponse(w, http.StatusOK, response)
+	writeResponse(w, r, http.StatusOK, response)
 }
 
 func (h *DeviceHandler) SignTransaction(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		WriteErrorResponse(w, http.StatusMethodNotAllowed, []string{http.StatusText(http.StatusMethodNotAllowed)})
+	id := r.PathValue("id")
+	annotateDevice(r, id)
+
+	if _, err := h.devices.GetDevice(r.Context(), id); err != nil {
+		writeServiceError(w, r, err)
 		return
 	}
 
-	parts := strings.Split(r.URL.Path, "/")
-	var filteredParts []string
-	for _, part := range parts {
-		if part != "" {
-			filteredParts = append(filteredParts, part)
+	var options []service.SignOption
+	if formats := r.URL.Query().Get("format"); formats != "" {
+		for _, format := range strings.Split(formats, ",") {
+			switch format {
+			case "jws":
+				options = append(options, service.WithJWS())
+			case "cose":
+				options = append(options, service.WithCOSE())
+			default:
+				WriteErrorResponse(w, r, http.StatusBadRequest, []string{"Unsupported format. Supported formats: jws, cose"})
+				return
+			}
 		}
 	}
 
-	if len(filteredParts) < 5 || filteredParts[len(filteredParts)-1] != "sign" {
-		WriteErrorResponse(w, http.StatusBadRequest, []string{"Invalid URL path"})
-		return
-	}
-	id := filteredParts[len(filteredParts)-2]
+	var transaction *domain.Transaction
+	var err error
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == StreamContentType {
+		algorithm, digest, ok := h.hashStream(w, r)
+		if !ok {
+			return
+		}
+		transaction, err = h.devices.SignDigest(r.Context(), id, algorithm, digest, options...)
+	} else {
+		h.limitBody(w, r)
 
-	device, err := h.repository.Get(r.Context(), id)
+		var request SignTransactionRequest
+		if err := decodeRequest(r, &request); err != nil {
+			writeBodyError(w, r, err, "Invalid request body")
+			return
+		}
+		if request.Data != "" && request.Digest != "" {
+			WriteErrorResponse(w, r, http.StatusBadRequest, []string{"Send either data or a digest, not both"})
+			return
+		}
+		if request.DataEncoding != "" && request.Digest != "" {
+			WriteErrorResponse(w, r, http.StatusBadRequest, []string{"data_encoding does not apply to digests"})
+			return
+		}
+		transaction, err = h.signRequest(r.Context(), id, request, options)
+	}
 	if err != nil {
-		WriteErrorResponse(w, http.StatusNotFound, []string{"Device not found"})
+		writeServiceError(w, r, err)
 		return
 	}
 
-	var request SignTransactionRequest
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		WriteErrorResponse(w, http.StatusBadRequest, []string{"Invalid request body"})
-		return
+	response := SignTransactionResponse{
+		Signature:      transaction.Signature,
+		SignedData:     transaction.SignedData,
+		SignedAt:       transaction.SignedAt,
+		JWS:            transaction.JWS,
+		COSE:           transaction.COSE,
+		TimestampToken: transaction.TimestampToken,
+		DataEncoding:   string(transaction.DataEncoding),
 	}
 
-	signature, signedData, err := device.SignTransaction(request.Data)
+	writeResponse(w, r, http.StatusOK, response)
+}
+
+// signRequest signs the data or, if given, the digest of request.
+func (h *DeviceHandler) signRequest(ctx context.Context, id string, request SignTransactionRequest, options []service.SignOption) (*domain.Transaction, error) {
+	if request.Digest == "" && request.HashAlgorithm == "" {
+		encoding, err := domain.ParseDataEncoding(request.DataEncoding)
+		if err != nil {
+			return nil, err
+		}
+		if encoding == domain.DataUTF8 {
+			return h.devices.SignTransaction(ctx, id, request.Data, options...)
+		}
+		data, err := encoding.Decode(request.Data)
+		if err != nil {
+			return nil, err
+		}
+		return h.devices.SignBytes(ctx, id, encoding, data, options...)
+	}
+	algorithm, err := domain.ParseHashAlgorithm(request.HashAlgorithm)
 	if err != nil {
-		WriteErrorResponse(w, http.StatusInternalServerError, []string{fmt.Sprintf("Failed to sign transaction: %v", err)})
-		return
+		return nil, err
 	}
-
-	if err := h.repository.Update(r.Context(), device); err != nil {
-		WriteErrorResponse(w, http.StatusInternalServerError, []string{fmt.Sprintf("Failed to update device: %v", err)})
-		return
+	digest, err := hex.DecodeString(request.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: digest must be hex-encoded", service.ErrInvalidDigest)
 	}
+	return h.devices.SignDigest(ctx, id, algorithm, digest, options...)
+}
 
-	response := SignTransactionResponse{
-		Signature:  signature,
-		SignedData: signedData,
+// hashStream hashes the request body with the algorithm of the hash query
+// parameter, SHA-256 by default, without holding the body in memory.
+func (h *DeviceHandler) hashStream(w http.ResponseWriter, r *http.Request) (domain.HashAlgorithm, []byte, bool) {
+	algorithm := domain.SHA256
+	if name := r.URL.Query().Get("hash"); name != "" {
+		var err error
+		if algorithm, err = domain.ParseHashAlgorithm(name); err != nil {
+			WriteErrorResponse(w, r, http.StatusBadRequest, []string{"Unsupported hash. Supported hashes: sha256, sha384, sha512"})
+			return "", nil, false
+		}
 	}
 
-	WriteAPIResponse(w, http.StatusOK, response)
+	body := r.Body
+	if limit := h.settings.Load().MaxStreamBytes; limit > 0 {
+		body = http.MaxBytesReader(w, r.Body, limit)
+	}
+	hash := algorithm.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		writeBodyError(w, r, err, "Invalid request body")
+		return "", nil, false
+	}
+	return algorithm, hash.Sum(nil), true
 }
 
-func (h *DeviceHandler) HandleDeviceRequests(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Path
-	w.Header().Set("Content-Type", "application/json")
+func (h *DeviceHandler) ListTransactions(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	annotateDevice(r, id)
 
-	if path == "/api/v0/devices" && r.Method == http.MethodPost {
-		h.CreateDevice(w, r)
-	} else if path == "/api/v0/devices" && r.Method == http.MethodGet {
-		h.ListDevices(w, r)
-	} else if strings.HasPrefix(path, "/api/v0/devices/") && !strings.Contains(path, "/sign") && r.Method == http.MethodGet {
-		h.GetDevice(w, r)
-	} else if (strings.HasSuffix(path, "/sign") || strings.HasSuffix(path, "/sign/")) && r.Method == http.MethodPost {
-		h.SignTransaction(w, r)
-	} else {
-		WriteErrorResponse(w, http.StatusNotFound, []string{"Endpoint not found"})
+	transactions, err := h.devices.ListTransactions(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
 	}
+
+	response := make([]TransactionResponse, 0, len(transactions))
+	for _, transaction := range transactions {
+		response = append(response, TransactionResponse{
+			Counter:        transaction.Counter,
+			Signature:      transaction.Signature,
+			SignedData:     transaction.SignedData,
+			SignedAt:       transaction.SignedAt,
+			TimestampToken: transaction.TimestampToken,
+			DataEncoding:   string(transaction.DataEncoding),
+		})
+	}
+
+	writeResponse(w, r, http.StatusOK, response)
 }
@@ -3,19 +3,32 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
 	"github.com/google/uuid"
 )
 
+// routes returns the complete routing table serving handler.
+func routes(handler *DeviceHandler) http.Handler {
+	return NewServer(":0", handler).Handler()
+}
+
 func TestCreateDevice(t *testing.T) {
 	repo := persistence.NewInMemoryDeviceRepository()
-	handler := NewDeviceHandler(repo)
+	handler := NewDeviceHandler(service.NewDeviceService(repo))
 
 	validID := uuid.New().String()
 	validRequest := CreateDeviceRequest{
@@ -56,6 +69,13 @@ func TestCreateDevice(t *testing.T) {
 		t.Errorf("Expected device algorithm to be 'RSA', got %s", responseData["algorithm"])
 	}
 
+	publicKey, _ := responseData["public_key"].(string)
+	if block, _ := pem.Decode([]byte(publicKey)); block == nil || block.Type != "PUBLIC KEY" {
+		t.Errorf("Expected public_key to be a standard PEM string, got %q", publicKey)
+	} else if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		t.Errorf("Expected public_key to hold a PKIX key: %v", err)
+	}
+
 	invalidRequest := CreateDeviceRequest{
 		ID:        uuid.New().String(),
 		Algorithm: "INVALID",
@@ -67,15 +87,26 @@ func TestCreateDevice(t *testing.T) {
 
 	handler.CreateDevice(rr, req)
 
-	if status := rr.Code; status != http.StatusBadRequest {
-		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	if status := rr.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusUnprocessableEntity)
 	}
 
-	req = httptest.NewRequest(http.MethodGet, "/api/v0/devices", nil)
+	duplicateRequest := CreateDeviceRequest{ID: validID, Algorithm: "ECC"}
+	requestBody, _ = json.Marshal(duplicateRequest)
+	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices", bytes.NewBuffer(requestBody))
 	rr = httptest.NewRecorder()
 
 	handler.CreateDevice(rr, req)
 
+	if status := rr.Code; status != http.StatusConflict {
+		t.Errorf("Handler returned wrong status code for duplicate ID: got %v want %v", status, http.StatusConflict)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/api/v0/devices", nil)
+	rr = httptest.NewRecorder()
+
+	routes(handler).ServeHTTP(rr, req)
+
 	if status := rr.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
 	}
@@ -83,7 +114,7 @@ func TestCreateDevice(t *testing.T) {
 
 func TestGetDevice(t *testing.T) {
 	repo := persistence.NewInMemoryDeviceRepository()
-	handler := NewDeviceHandler(repo)
+	handler := NewDeviceHandler(service.NewDeviceService(repo))
 
 	id := uuid.New().String()
 	device, err := domain.NewSignatureDevice(id, domain.RSA, "Test Device")
@@ -97,7 +128,7 @@ func TestGetDevice(t *testing.T) {
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v0/devices/"+id, nil)
 	rr := httptest.NewRecorder()
-	req.URL.Path = "/api/v0/devices/" + id
+	req.SetPathValue("id", id)
 
 	handler.GetDevice(rr, req)
 
@@ -122,7 +153,7 @@ func TestGetDevice(t *testing.T) {
 
 	req = httptest.NewRequest(http.MethodGet, "/api/v0/devices/non-existent-id", nil)
 	rr = httptest.NewRecorder()
-	req.URL.Path = "/api/v0/devices/non-existent-id"
+	req.SetPathValue("id", "non-existent-id")
 
 	handler.GetDevice(rr, req)
 
@@ -133,7 +164,7 @@ func TestGetDevice(t *testing.T) {
 	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+id, nil)
 	rr = httptest.NewRecorder()
 
-	handler.GetDevice(rr, req)
+	routes(handler).ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
@@ -142,7 +173,7 @@ func TestGetDevice(t *testing.T) {
 
 func TestListDevices(t *testing.T) {
 	repo := persistence.NewInMemoryDeviceRepository()
-	handler := NewDeviceHandler(repo)
+	handler := NewDeviceHandler(service.NewDeviceService(repo))
 
 	for i := 0; i < 3; i++ {
 		id := uuid.New().String()
@@ -180,10 +211,10 @@ func TestListDevices(t *testing.T) {
 		t.Errorf("Expected 3 devices, got %d", len(responseData))
 	}
 
-	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices", nil)
+	req = httptest.NewRequest(http.MethodDelete, "/api/v0/devices", nil)
 	rr = httptest.NewRecorder()
 
-	handler.ListDevices(rr, req)
+	routes(handler).ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
@@ -192,7 +223,7 @@ func TestListDevices(t *testing.T) {
 
 func TestSignTransaction(t *testing.T) {
 	repo := persistence.NewInMemoryDeviceRepository()
-	handler := NewDeviceHandler(repo)
+	handler := NewDeviceHandler(service.NewDeviceService(repo))
 
 	id := uuid.New().String()
 	device, err := domain.NewSignatureDevice(id, domain.RSA, "Test Device")
@@ -208,7 +239,7 @@ func TestSignTransaction(t *testing.T) {
 	requestBody, _ := json.Marshal(validRequest)
 	req := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+id+"/sign", bytes.NewBuffer(requestBody))
 	rr := httptest.NewRecorder()
-	req.URL.Path = "/api/v0/devices/" + id + "/sign"
+	req.SetPathValue("id", id)
 
 	handler.SignTransaction(rr, req)
 
@@ -237,7 +268,7 @@ func TestSignTransaction(t *testing.T) {
 
 	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices/non-existent-id/sign", bytes.NewBuffer(requestBody))
 	rr = httptest.NewRecorder()
-	req.URL.Path = "/api/v0/devices/non-existent-id/sign"
+	req.SetPathValue("id", "non-existent-id")
 
 	handler.SignTransaction(rr, req)
 
@@ -249,7 +280,7 @@ func TestSignTransaction(t *testing.T) {
 	trailingSlashRequestBody, _ := json.Marshal(trailingSlashRequest)
 	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+id+"/sign/", bytes.NewBuffer(trailingSlashRequestBody))
 	rr = httptest.NewRecorder()
-	req.URL.Path = "/api/v0/devices/" + id + "/sign/"
+	req.SetPathValue("id", id)
 
 	handler.SignTransaction(rr, req)
 
@@ -260,31 +291,92 @@ func TestSignTransaction(t *testing.T) {
 	req = httptest.NewRequest(http.MethodGet, "/api/v0/devices/"+id+"/sign", nil)
 	rr = httptest.NewRecorder()
 
-	handler.SignTransaction(rr, req)
+	routes(handler).ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusMethodNotAllowed {
 		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusMethodNotAllowed)
 	}
 }
 
-func TestHandleDeviceRequests(t *testing.T) {
+func TestSignTransactionJWS(t *testing.T) {
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	handler := routes(NewDeviceHandler(devices))
+
+	for _, test := range []struct {
+		algorithm string
+		jwsAlg    string
+	}{
+		{"RSA", "RS256"},
+		{"ECC", "ES384"},
+	} {
+		device, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: test.algorithm})
+		if err != nil {
+			t.Fatalf("CreateDevice failed: %v", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+device.ID+"/sign?format=jws", bytes.NewBufferString(`{"data": "receipt"}`))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s: expected status 200, got %d: %s", test.algorithm, rr.Code, rr.Body.String())
+		}
+
+		var response struct {
+			Data SignTransactionResponse `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: failed to unmarshal response: %v", test.algorithm, err)
+		}
+		parts := strings.Split(response.Data.JWS, ".")
+		if len(parts) != 3 {
+			t.Fatalf("%s: expected a compact JWS, got %q", test.algorithm, response.Data.JWS)
+		}
+		var header domain.JWSHeader
+		headerJSON, _ := base64.RawURLEncoding.DecodeString(parts[0])
+		if err := json.Unmarshal(headerJSON, &header); err != nil {
+			t.Fatalf("%s: invalid JWS header: %v", test.algorithm, err)
+		}
+		if header.Algorithm != test.jwsAlg || header.KeyID != device.KeyID() || header.Counter != 0 {
+			t.Errorf("%s: unexpected JWS header %+v", test.algorithm, header)
+		}
+		if payload, _ := base64.RawURLEncoding.DecodeString(parts[1]); string(payload) != response.Data.SignedData {
+			t.Errorf("%s: expected the JWS payload to be the signed data, got %q", test.algorithm, payload)
+		}
+	}
+
+	device, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+device.ID+"/sign?format=xml", bytes.NewBufferString(`{"data": "receipt"}`))
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unsupported format, got %d", rr.Code)
+	}
+	if stored, _ := devices.GetDevice(context.Background(), device.ID); stored.SignatureCounter != 0 {
+		t.Errorf("Expected a rejected format not to consume the counter, got %d", stored.SignatureCounter)
+	}
+}
+
+func TestDeviceRoutesContentType(t *testing.T) {
 	repo := persistence.NewInMemoryDeviceRepository()
-	handler := NewDeviceHandler(repo)
+	handler := NewDeviceHandler(service.NewDeviceService(repo))
 
 	req := httptest.NewRequest(http.MethodPost, "/api/v0/devices", nil)
 	rr := httptest.NewRecorder()
 
-	handler.HandleDeviceRequests(rr, req)
+	routes(handler).ServeHTTP(rr, req)
 
 	contentType := rr.Header().Get("Content-Type")
 	if contentType != "application/json" {
 		t.Errorf("Expected Content-Type to be 'application/json', got %s", contentType)
 	}
 
-	req = httptest.NewRequest(http.MethodGet, "/api/v0/devices", nil)
+	req = httptest.NewRequest(http.MethodPut, "/api/v0/devices", nil)
 	rr = httptest.NewRecorder()
 
-	handler.HandleDeviceRequests(rr, req)
+	routes(handler).ServeHTTP(rr, req)
 
 	contentType = rr.Header().Get("Content-Type")
 	if contentType != "application/json" {
@@ -294,7 +386,7 @@ func TestHandleDeviceRequests(t *testing.T) {
 	req = httptest.NewRequest(http.MethodGet, "/api/v0/devices/some-id", nil)
 	rr = httptest.NewRecorder()
 
-	handler.HandleDeviceRequests(rr, req)
+	routes(handler).ServeHTTP(rr, req)
 
 	contentType = rr.Header().Get("Content-Type")
 	if contentType != "application/json" {
@@ -304,7 +396,7 @@ func TestHandleDeviceRequests(t *testing.T) {
 	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices/some-id/sign", nil)
 	rr = httptest.NewRecorder()
 
-	handler.HandleDeviceRequests(rr, req)
+	routes(handler).ServeHTTP(rr, req)
 
 	contentType = rr.Header().Get("Content-Type")
 	if contentType != "application/json" {
@@ -314,7 +406,7 @@ func TestHandleDeviceRequests(t *testing.T) {
 	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices/some-id/sign/", nil)
 	rr = httptest.NewRecorder()
 
-	handler.HandleDeviceRequests(rr, req)
+	routes(handler).ServeHTTP(rr, req)
 
 	contentType = rr.Header().Get("Content-Type")
 	if contentType != "application/json" {
@@ -324,9 +416,190 @@ func TestHandleDeviceRequests(t *testing.T) {
 	req = httptest.NewRequest(http.MethodGet, "/api/v0/non-existent", nil)
 	rr = httptest.NewRecorder()
 
-	handler.HandleDeviceRequests(rr, req)
+	routes(handler).ServeHTTP(rr, req)
 
 	if status := rr.Code; status != http.StatusNotFound {
 		t.Errorf("Handler returned wrong status code: got %v want %v", status, http.StatusNotFound)
 	}
 }
+
+func TestDeviceSettings(t *testing.T) {
+	repo := persistence.NewInMemoryDeviceRepository()
+	devices := service.NewDeviceService(repo)
+	devices.ApplySettings(service.Settings{
+		DefaultAlgorithm: "ECC",
+		MaxDevices:       1,
+	})
+	handler := NewDeviceHandler(devices)
+	handler.ApplySettings(DeviceSettings{
+		MaxRequestBodyBytes: 256,
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v0/devices", bytes.NewBufferString(`{"label": "Default Algorithm"}`))
+	rr := httptest.NewRecorder()
+
+	handler.CreateDevice(rr, req)
+
+	if status := rr.Code; status != http.StatusCreated {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusCreated)
+	}
+
+	var response Response
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if algorithm := response.Data.(map[string]interface{})["algorithm"]; algorithm != "ECC" {
+		t.Errorf("Expected default algorithm 'ECC', got %v", algorithm)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices", bytes.NewBufferString(`{"algorithm": "RSA"}`))
+	rr = httptest.NewRecorder()
+
+	handler.CreateDevice(rr, req)
+
+	if status := rr.Code; status != http.StatusForbidden {
+		t.Errorf("Handler returned wrong status code when device limit reached: got %v want %v", status, http.StatusForbidden)
+	}
+
+	largeBody := `{"label": "` + string(bytes.Repeat([]byte("x"), 512)) + `"}`
+	req = httptest.NewRequest(http.MethodPost, "/api/v0/devices", bytes.NewBufferString(largeBody))
+	rr = httptest.NewRecorder()
+
+	handler.CreateDevice(rr, req)
+
+	if status := rr.Code; status != http.StatusRequestEntityTooLarge {
+		t.Errorf("Handler returned wrong status code for oversized body: got %v want %v", status, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestSignDigest(t *testing.T) {
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	device, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	handler := NewDeviceHandler(devices)
+	handler.ApplySettings(DeviceSettings{MaxRequestBodyBytes: 1024, MaxStreamBytes: 4096})
+	server := routes(handler)
+	signPath := "/api/v0/devices/" + device.ID + "/sign"
+
+	sign := func(path, contentType, body string) (*httptest.ResponseRecorder, SignTransactionResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		var response struct {
+			Data SignTransactionResponse `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response.Data
+	}
+
+	// A streamed body larger than the request body limit is hashed, not buffered.
+	payload := strings.Repeat("receipt line\n", 200)
+	digest := sha256.Sum256([]byte(payload))
+	rr, streamed := sign(signPath, StreamContentType, payload)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for a streamed body, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, data, _, _ := domain.ParseSecuredData(streamed.SignedData); data != "sha256:"+hex.EncodeToString(digest[:]) {
+		t.Errorf("Expected the SHA-256 digest of the body to be signed, got %q", streamed.SignedData)
+	}
+
+	digest512 := sha512.Sum512([]byte(payload))
+	rr, precomputed := sign(signPath, "application/json", `{"digest": "`+hex.EncodeToString(digest512[:])+`", "hash_algorithm": "SHA-512"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for a digest, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if _, data, _, _ := domain.ParseSecuredData(precomputed.SignedData); data != "sha512:"+hex.EncodeToString(digest512[:]) {
+		t.Errorf("Expected the SHA-512 digest to be signed, got %q", precomputed.SignedData)
+	}
+
+	for _, tt := range []struct {
+		name        string
+		path        string
+		contentType string
+		body        string
+		status      int
+	}{
+		{"stream over limit", signPath, StreamContentType, strings.Repeat("x", 4097), http.StatusRequestEntityTooLarge},
+		{"JSON over limit", signPath, "application/json", `{"data": "` + strings.Repeat("x", 1024) + `"}`, http.StatusRequestEntityTooLarge},
+		{"unsupported stream hash", signPath + "?hash=md5", StreamContentType, payload, http.StatusBadRequest},
+		{"data and digest", signPath, "application/json", `{"data": "x", "digest": "00", "hash_algorithm": "sha256"}`, http.StatusBadRequest},
+		{"unsupported algorithm", signPath, "application/json", `{"digest": "00", "hash_algorithm": "md5"}`, http.StatusUnprocessableEntity},
+		{"missing algorithm", signPath, "application/json", `{"digest": "` + hex.EncodeToString(digest[:]) + `"}`, http.StatusUnprocessableEntity},
+		{"short digest", signPath, "application/json", `{"digest": "abcd", "hash_algorithm": "sha256"}`, http.StatusUnprocessableEntity},
+		{"digest not hex", signPath, "application/json", `{"digest": "xyz", "hash_algorithm": "sha256"}`, http.StatusUnprocessableEntity},
+	} {
+		if rr, _ := sign(tt.path, tt.contentType, tt.body); rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+
+	stored, err := devices.GetDevice(context.Background(), device.ID)
+	if err != nil {
+		t.Fatalf("Failed to get device: %v", err)
+	}
+	if stored.SignatureCounter != 2 {
+		t.Errorf("Expected rejected requests not to sign, got counter %d", stored.SignatureCounter)
+	}
+}
+
+func TestSignDataEncoding(t *testing.T) {
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	device, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	server := routes(NewDeviceHandler(devices))
+	signPath := "/api/v0/devices/" + device.ID + "/sign"
+
+	sign := func(body string) (*httptest.ResponseRecorder, SignTransactionResponse) {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, signPath, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.ServeHTTP(rr, req)
+		var response struct {
+			Data SignTransactionResponse `json:"data"`
+		}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr, response.Data
+	}
+
+	// Both encodings of the same bytes produce the same canonical data.
+	for _, body := range []string{
+		`{"data": "AP_7X4A", "data_encoding": "base64"}`,
+		`{"data": "00FFFB5F80", "data_encoding": "hex"}`,
+	} {
+		rr, response := sign(body)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %s, got %d: %s", body, rr.Code, rr.Body.String())
+		}
+		_, data, _, _ := domain.ParseSecuredData(response.SignedData)
+		decoded, err := domain.DecodeData(domain.DataEncoding(response.DataEncoding), data)
+		if err != nil || !bytes.Equal(decoded, []byte{0x00, 0xff, 0xfb, '_', 0x80}) {
+			t.Errorf("Unexpected signed data %q with encoding %q for %s", response.SignedData, response.DataEncoding, body)
+		}
+	}
+
+	if rr, response := sign(`{"data": "plain"}`); rr.Code != http.StatusOK || response.DataEncoding != "utf8" {
+		t.Errorf("Expected utf8 to be echoed for plain data, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	for _, tt := range []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"unsupported encoding", `{"data": "x", "data_encoding": "ebcdic"}`, http.StatusUnprocessableEntity},
+		{"invalid base64", `{"data": "!!", "data_encoding": "base64"}`, http.StatusUnprocessableEntity},
+		{"invalid hex", `{"data": "abc", "data_encoding": "hex"}`, http.StatusUnprocessableEntity},
+		{"encoding of a digest", `{"digest": "00", "hash_algorithm": "sha256", "data_encoding": "hex"}`, http.StatusBadRequest},
+	} {
+		if rr, _ := sign(tt.body); rr.Code != tt.status {
+			t.Errorf("%s: expected status %d, got %d: %s", tt.name, tt.status, rr.Code, rr.Body.String())
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package api
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// IdempotencyKeyHeader lets clients safely retry POST requests: a repeated
+	// request with the same key receives the stored response of the first one.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	idempotencyTTL          = 24 * time.Hour
+	maxIdempotencyKeyLength = 255
+	maxIdempotencyEntries   = 10000
+
+	// idempotencyRetryAfter is the Retry-After value, in seconds, of the 409
+	// returned while the first request with a key is still in progress. It
+	// distinguishes that conflict, which clients may retry, from permanent ones.
+	idempotencyRetryAfter = "1"
+)
+
+// idempotencyCache stores the responses of requests that carried an idempotency
+// key. It holds at most maxEntries completed responses and evicts the oldest
+// ones first.
+type idempotencyCache struct {
+	mu         sync.Mutex
+	entries    map[string]*idempotentResponse
+	order      *list.List
+	maxEntries int
+	now        func() time.Time
+}
+
+type idempotentResponse struct {
+	// element is the position of the entry in the insertion order.
+	element *list.Element
+	// fingerprint is the hash of the request as far as the handler read its
+	// body: bodySize bytes, which were the whole body if wholeBody is set.
+	fingerprint [sha256.Size]byte
+	bodySize    int64
+	wholeBody   bool
+	completed   bool
+	expires     time.Time
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+func newIdempotencyCache() *idempotencyCache {
+	return &idempotencyCache{
+		entries:    make(map[string]*idempotentResponse),
+		order:      list.New(),
+		maxEntries: maxIdempotencyEntries,
+		now:        time.Now,
+	}
+}
+
+// wrap replays stored responses for repeated idempotency keys. A key reused for a
+// different request is rejected, as is a repetition while the first request is
+// still in progress. Server errors are not stored so that they can be retried.
+//
+// The body is not buffered: it is hashed while the handler reads it, within the
+// handler's own limits. A repeated request matches if it has the same body as
+// far as the first request's handler read it.
+func (c *idempotencyCache) wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			WriteErrorResponse(w, r, http.StatusBadRequest, []string{"Idempotency key is too long"})
+			return
+		}
+
+		scope := r.Method + " " + r.URL.Path + " " + key
+
+		c.mu.Lock()
+		c.evict()
+		entry, exists := c.entries[scope]
+		switch {
+		case exists && !entry.completed:
+			c.mu.Unlock()
+			w.Header().Set("Retry-After", idempotencyRetryAfter)
+			WriteErrorResponse(w, r, http.StatusConflict, []string{"A request with this idempotency key is still in progress"})
+			return
+		case exists:
+			c.mu.Unlock()
+			matches, err := entry.matches(r)
+			if err != nil {
+				writeBodyError(w, r, err, "Invalid request body")
+				return
+			}
+			if !matches {
+				WriteErrorResponse(w, r, http.StatusUnprocessableEntity, []string{"Idempotency key was already used for a different request"})
+				return
+			}
+			for name, values := range entry.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(entry.status)
+			w.Write(entry.body)
+			return
+		}
+		entry = &idempotentResponse{expires: c.now().Add(idempotencyTTL)}
+		entry.element = c.order.PushBack(scope)
+		c.entries[scope] = entry
+		c.mu.Unlock()
+
+		body := &hashingReader{ReadCloser: r.Body, hash: newFingerprint(r)}
+		r.Body = body
+		recorder := &responseCapture{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if recorder.status >= http.StatusInternalServerError {
+			c.order.Remove(entry.element)
+			delete(c.entries, scope)
+			return
+		}
+		copy(entry.fingerprint[:], body.hash.Sum(nil))
+		entry.bodySize = body.size
+		entry.wholeBody = body.eof
+		entry.completed = true
+		entry.status = recorder.status
+		entry.header = w.Header().Clone()
+		entry.header.Del(RequestIDHeader)
+		entry.body = recorder.body.Bytes()
+	})
+}
+
+// newFingerprint starts the hash of a request. The query and Accept header
+// select the response format, so they are part of the request.
+func newFingerprint(r *http.Request) hash.Hash {
+	fingerprint := sha256.New()
+	io.WriteString(fingerprint, r.URL.RawQuery+"\n"+r.Header.Get("Accept")+"\n")
+	return fingerprint
+}
+
+// matches reports whether r repeats the stored request. It reads no more of
+// the body than the stored request's handler did, plus one byte to check that
+// the body ends there too, if the stored body did.
+func (e *idempotentResponse) matches(r *http.Request) (bool, error) {
+	fingerprint := newFingerprint(r)
+	if _, err := io.CopyN(fingerprint, r.Body, e.bodySize); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	if e.wholeBody {
+		if n, err := io.CopyN(io.Discard, r.Body, 1); err != nil && err != io.EOF {
+			return false, err
+		} else if n > 0 {
+			return false, nil
+		}
+	}
+	return bytes.Equal(fingerprint.Sum(nil), e.fingerprint[:]), nil
+}
+
+// hashingReader hashes a request body as it is read.
+type hashingReader struct {
+	io.ReadCloser
+	hash hash.Hash
+	size int64
+	eof  bool
+}
+
+func (h *hashingReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	h.size += int64(n)
+	if err == io.EOF {
+		h.eof = true
+	}
+	return n, err
+}
+
+// evict removes expired responses and, while the cache is full, the oldest
+// completed ones. Entries expire in insertion order, so it stops at the first
+// entry that is neither expired nor needed to make room. Requests in progress
+// are kept.
+func (c *idempotencyCache) evict() {
+	now := c.now()
+	for element := c.order.Front(); element != nil; {
+		next := element.Next()
+		scope := element.Value.(string)
+		entry := c.entries[scope]
+		if !now.After(entry.expires) && len(c.entries) < c.maxEntries {
+			return
+		}
+		if entry.completed {
+			c.order.Remove(element)
+			delete(c.entries, scope)
+		}
+		element = next
+	}
+}
+
+// responseCapture writes through to the client while keeping a copy of the response.
+type responseCapture struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (c *responseCapture) WriteHeader(status int) {
+	c.status = status
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *responseCapture) Write(p []byte) (int, error) {
+	c.body.Write(p)
+	return c.ResponseWriter.Write(p)
+}
@@ -0,0 +1,148 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+	"github.com/google/uuid"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	handler := routes(NewDeviceHandler(service.NewDeviceService(persistence.NewInMemoryDeviceRepository())))
+	post := func(path, key, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	id := uuid.New().String()
+	create := `{"id": "` + id + `", "algorithm": "ECC"}`
+	if rr := post("/api/v0/devices", "create-1", create); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	replayed := post("/api/v0/devices", "create-1", create)
+	if replayed.Code != http.StatusCreated || replayed.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("Expected replayed 201, got %d with headers %v", replayed.Code, replayed.Header())
+	}
+
+	signPath := "/api/v0/devices/" + id + "/sign"
+	first := post(signPath, "sign-1", `{"data": "a"}`)
+	second := post(signPath, "sign-1", `{"data": "a"}`)
+	if first.Code != http.StatusOK || second.Body.String() != first.Body.String() {
+		t.Errorf("Expected identical responses, got %q and %q", first.Body.String(), second.Body.String())
+	}
+
+	if rr := post(signPath, "sign-1", `{"data": "b"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a reused key, got %d", rr.Code)
+	}
+	if rr := post(signPath+"?format=jws", "sign-1", `{"data": "a"}`); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a reused key with another format, got %d", rr.Code)
+	}
+	if rr := post(signPath, strings.Repeat("k", maxIdempotencyKeyLength+1), `{"data": "a"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a long key, got %d", rr.Code)
+	}
+
+	var third struct {
+		Data SignTransactionResponse `json:"data"`
+	}
+	if err := json.Unmarshal(post(signPath, "", `{"data": "a"}`).Body.Bytes(), &third); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	if counter, data, _, err := domain.ParseSecuredData(third.Data.SignedData); err != nil || counter != 1 || data != "a" {
+		t.Errorf("Expected the replay not to sign again, got %q", third.Data.SignedData)
+	}
+
+	// Streamed bodies are hashed as they are read, not buffered, so they are
+	// only limited by the handler.
+	stream := func(key string, body []byte) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, signPath, bytes.NewReader(body))
+		req.Header.Set("Content-Type", StreamContentType)
+		req.Header.Set(IdempotencyKeyHeader, key)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	large := bytes.Repeat([]byte("s"), 11<<20)
+	streamed := stream("stream-1", large)
+	if streamed.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 for a large streamed body, got %d: %s", streamed.Code, streamed.Body.String())
+	}
+	if rr := stream("stream-1", large); rr.Header().Get("Idempotent-Replayed") != "true" || rr.Body.String() != streamed.Body.String() {
+		t.Errorf("Expected the streamed request to be replayed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := stream("stream-1", append(large, 's')); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a longer body, got %d", rr.Code)
+	}
+	if rr := stream("stream-1", large[1:]); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status 422 for a shorter body, got %d", rr.Code)
+	}
+}
+
+func TestIdempotencyCacheBounds(t *testing.T) {
+	cache := newIdempotencyCache()
+	cache.maxEntries = 2
+	release := make(chan struct{})
+	handler := cache.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	post := func(path, key string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.Header.Set(IdempotencyKeyHeader, key)
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	done := make(chan struct{})
+	go func() {
+		post("/slow", "slow")
+		close(done)
+	}()
+	for {
+		cache.mu.Lock()
+		_, started := cache.entries["POST /slow slow"]
+		cache.mu.Unlock()
+		if started {
+			break
+		}
+	}
+	rr := post("/slow", "slow")
+	if rr.Code != http.StatusConflict || rr.Header().Get("Retry-After") != idempotencyRetryAfter {
+		t.Errorf("Expected a retryable 409 while the request is in progress, got %d with headers %v", rr.Code, rr.Header())
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		post("/fast", key)
+	}
+	if len(cache.entries) != 2 {
+		t.Errorf("Expected the cache to hold 2 entries, got %d", len(cache.entries))
+	}
+	if _, ok := cache.entries["POST /slow slow"]; !ok {
+		t.Errorf("Expected the request in progress to be kept")
+	}
+	if _, ok := cache.entries["POST /fast c"]; !ok {
+		t.Errorf("Expected the newest response to be kept")
+	}
+	if rr := post("/fast", "a"); rr.Header().Get("Idempotent-Replayed") == "true" {
+		t.Errorf("Expected the oldest response to be evicted")
+	}
+
+	close(release)
+	<-done
+}
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+// JWKSet is a JSON Web Key Set (RFC 7517, section 5).
+type JWKSet struct {
+	Keys []crypto.JWK `json:"keys"`
+}
+
+// jwksMaxAge is how long verifiers may cache the key set without revalidating it.
+const jwksMaxAge = "300"
+
+// JWKS writes the public keys of all devices as a JSON Web Key Set. It is
+// served without the Response envelope, so that JOSE libraries can fetch it
+// directly, and supports conditional requests with If-None-Match.
+func (h *DeviceHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	devices, err := h.devices.ListDevices(r.Context())
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	set := JWKSet{Keys: make([]crypto.JWK, 0, len(devices))}
+	for _, device := range devices {
+		jwk, err := device.JWK()
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		set.Keys = append(set.Keys, *jwk)
+	}
+	// A stable order keeps the ETag unchanged while the set of keys is.
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+
+	content, err := json.Marshal(set)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	hash := sha256.Sum256(content)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(hash[:16]) + `"`
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age="+jwksMaxAge)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// etagMatches reports whether an If-None-Match header matches etag, using the
+// weak comparison of RFC 9110.
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
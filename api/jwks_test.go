@@ -0,0 +1,82 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestJWKS(t *testing.T) {
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	handler := routes(NewDeviceHandler(devices))
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	empty := get("")
+	if empty.Code != http.StatusOK || empty.Body.String() != `{"keys":[]}` {
+		t.Fatalf("Expected an empty key set, got %d %s", empty.Code, empty.Body.String())
+	}
+
+	kids := map[string]string{}
+	for _, algorithm := range []string{"RSA", "ECC"} {
+		device, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: algorithm})
+		if err != nil {
+			t.Fatalf("CreateDevice failed: %v", err)
+		}
+		kids[device.KeyID()] = algorithm
+	}
+
+	rr := get(empty.Header().Get("ETag"))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the stale ETag to return the new key set, got %d", rr.Code)
+	}
+	etag := rr.Header().Get("ETag")
+	if etag == "" || etag == empty.Header().Get("ETag") {
+		t.Errorf("Expected a new ETag after creating devices, got %q", etag)
+	}
+	if rr.Header().Get("Cache-Control") == "" {
+		t.Errorf("Expected a Cache-Control header")
+	}
+
+	var set JWKSet
+	if err := json.Unmarshal(rr.Body.Bytes(), &set); err != nil {
+		t.Fatalf("Failed to unmarshal key set: %v", err)
+	}
+	if len(set.Keys) != 2 {
+		t.Fatalf("Expected 2 keys, got %d", len(set.Keys))
+	}
+	for _, key := range set.Keys {
+		algorithm, ok := kids[key.KeyID]
+		if !ok || key.Use != "sig" {
+			t.Errorf("Unexpected key %+v", key)
+		}
+		if algorithm == "RSA" && (key.KeyType != "RSA" || key.Algorithm != "RS256") {
+			t.Errorf("Unexpected RSA key %+v", key)
+		}
+		if algorithm == "ECC" && (key.KeyType != "EC" || key.Curve != "P-384") {
+			t.Errorf("Unexpected ECC key %+v", key)
+		}
+	}
+
+	if again := get(""); again.Header().Get("ETag") != etag || again.Body.String() != rr.Body.String() {
+		t.Errorf("Expected an unchanged key set to have a stable ETag and body")
+	}
+	for _, ifNoneMatch := range []string{etag, "W/" + etag, `"other", ` + etag, "*"} {
+		if rr := get(ifNoneMatch); rr.Code != http.StatusNotModified || rr.Body.Len() != 0 {
+			t.Errorf("If-None-Match %s: expected an empty 304 response, got %d", ifNoneMatch, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// RequestIDHeader carries the request ID in both directions.
+	RequestIDHeader = "X-Request-ID"
+	// TenantHeader identifies the calling tenant in access logs.
+	TenantHeader = "X-Tenant-ID"
+
+	maxRequestIDLength = 128
+)
+
+type requestInfoKey struct{}
+
+// requestInfo collects per-request values that handlers contribute to the access log.
+type requestInfo struct {
+	requestID string
+	deviceID  string
+}
+
+// RequestIDFromContext returns the ID of the request being served, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.requestID
+	}
+	return ""
+}
+
+// annotateDevice records the device a request operates on for the access log.
+func annotateDevice(r *http.Request, deviceID string) {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		info.deviceID = deviceID
+	}
+}
+
+// LogRequests assigns every request an ID, echoes it in the X-Request-ID response
+// header and writes one structured access log entry per request. Only request
+// metadata is logged; bodies, and with them transaction data and keys, never are.
+func LogRequests(logger *slog.Logger, routeOf func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		info := &requestInfo{requestID: requestID(r)}
+		w.Header().Set(RequestIDHeader, info.requestID)
+		r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
+
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		attributes := []slog.Attr{
+			slog.String("request_id", info.requestID),
+			slog.String("method", r.Method),
+			slog.String("route", routeOf(r)),
+			slog.Int("status", recorder.status),
+			slog.Duration("latency", time.Since(start)),
+		}
+		if info.deviceID != "" {
+			attributes = append(attributes, slog.String("device_id", info.deviceID))
+		}
+		if tenant := r.Header.Get(TenantHeader); tenant != "" {
+			attributes = append(attributes, slog.String("tenant", tenant))
+		}
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(r.Context(), level, "HTTP request", attributes...)
+	})
+}
+
+// requestID propagates a well-formed incoming X-Request-ID or generates a new one.
+func requestID(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength || strings.IndexFunc(id, func(c rune) bool {
+		return c < '!' || c > '~'
+	}) >= 0 {
+		return uuid.New().String()
+	}
+	return id
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
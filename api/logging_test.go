@@ -0,0 +1,110 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+	"github.com/google/uuid"
+)
+
+func TestLogRequests(t *testing.T) {
+	repo := persistence.NewInMemoryDeviceRepository()
+	id := uuid.New().String()
+	device, err := domain.NewSignatureDevice(id, domain.ECC, "Test Device")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	if err := repo.Create(context.Background(), device); err != nil {
+		t.Fatalf("Failed to create device in repository: %v", err)
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+	handler := NewServer(":0", NewDeviceHandler(service.NewDeviceService(repo)), WithLogger(logger)).Handler()
+
+	const secret = "very secret transaction data"
+	req := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+id+"/sign", bytes.NewBufferString(`{"data": "`+secret+`"}`))
+	req.Header.Set(RequestIDHeader, "incoming-request-id")
+	req.Header.Set(TenantHeader, "tenant-a")
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if requestID := rr.Header().Get(RequestIDHeader); requestID != "incoming-request-id" {
+		t.Errorf("Expected incoming request ID to be echoed, got %q", requestID)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("Failed to parse access log entry: %v", err)
+	}
+	expected := map[string]interface{}{
+		"request_id": "incoming-request-id",
+		"method":     http.MethodPost,
+		"route":      "/api/v0/devices/{id}/sign",
+		"status":     float64(http.StatusOK),
+		"device_id":  id,
+		"tenant":     "tenant-a",
+	}
+	for key, value := range expected {
+		if entry[key] != value {
+			t.Errorf("Expected access log %s to be %v, got %v", key, value, entry[key])
+		}
+	}
+	if _, ok := entry["latency"]; !ok {
+		t.Errorf("Expected access log to contain latency")
+	}
+	if strings.Contains(logs.String(), secret) {
+		t.Errorf("Access log must not contain transaction data")
+	}
+
+	logs.Reset()
+	req = httptest.NewRequest(http.MethodGet, "/api/v0/devices/non-existent-id", nil)
+	req.Header.Set(RequestIDHeader, "invalid request id")
+	rr = httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	requestID := rr.Header().Get(RequestIDHeader)
+	if _, err := uuid.Parse(requestID); err != nil {
+		t.Errorf("Expected malformed request ID to be replaced by a UUID, got %q", requestID)
+	}
+
+	var errorResponse ErrorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &errorResponse); err != nil {
+		t.Fatalf("Failed to unmarshal error response: %v", err)
+	}
+	if errorResponse.RequestID != requestID {
+		t.Errorf("Expected error response to carry request ID %s, got %s", requestID, errorResponse.RequestID)
+	}
+}
+
+func TestSensitiveValuesAreNotLogged(t *testing.T) {
+	device, err := domain.NewSignatureDevice(uuid.New().String(), domain.RSA, "Test Device")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, nil))
+	logger.Info("debugging", "device", device, "request", SignTransactionRequest{Data: "very secret transaction data"})
+
+	if strings.Contains(logs.String(), "PRIVATE") || strings.Contains(logs.String(), "very secret") {
+		t.Errorf("Expected keys and transaction data to be redacted, got %s", logs.String())
+	}
+	if !strings.Contains(logs.String(), device.ID) {
+		t.Errorf("Expected device ID to be logged")
+	}
+}
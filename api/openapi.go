@@ -0,0 +1,611 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+)
+
+//go:generate go test -run TestOpenAPIDocumentIsUpToDate -update
+
+// openAPIDocument is the generated specification served at /api/v0/openapi.json.
+// It is kept in sync with the Go types by TestOpenAPIDocumentIsUpToDate.
+//
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// apiOperation documents one route of the routing table.
+type apiOperation struct {
+	pattern     string
+	operationID string
+	summary     string
+	tag         string
+	request     interface{}
+	// optionalBody marks request bodies that may be omitted.
+	optionalBody bool
+	// requestAlternatives lists further media types accepted as request body.
+	requestAlternatives []string
+	// responses maps status codes to the type wrapped in the Response envelope.
+	// Error statuses are documented with ErrorResponse and ProblemDetails and need no type.
+	responses map[int]interface{}
+	// contentType overrides the JSON media type of the success response.
+	contentType string
+	// alternatives lists further media types of the success response that
+	// clients can request with the Accept header.
+	alternatives []string
+	// query documents the query parameters of the route.
+	query []apiQueryParameter
+	// unwrapped marks success responses written without the Response envelope.
+	unwrapped bool
+	// cbor marks routes that also accept and return application/cbor bodies.
+	cbor bool
+	// idempotent marks routes that honour the Idempotency-Key header.
+	idempotent bool
+}
+
+// apiQueryParameter documents an optional query parameter with a fixed set of values.
+type apiQueryParameter struct {
+	name        string
+	description string
+	values      []string
+}
+
+var apiOperations = []apiOperation{
+	{
+		pattern:     "GET /api/v0/health",
+		operationID: "getHealth",
+		summary:     "Report the health of the service",
+		tag:         "health",
+		responses:   map[int]interface{}{http.StatusOK: HealthResponse{}},
+	},
+	{
+		pattern:     "GET /api/v0/devices",
+		operationID: "listDevices",
+		summary:     "List all signature devices",
+		tag:         "devices",
+		cbor:        true,
+		responses: map[int]interface{}{
+			http.StatusOK:                  []CreateDeviceResponse{},
+			http.StatusInternalServerError: nil,
+			http.StatusServiceUnavailable:  nil,
+		},
+	},
+	{
+		pattern:     "POST /api/v0/devices",
+		operationID: "createDevice",
+		summary:     "Create a signature device",
+		tag:         "devices",
+		cbor:        true,
+		request:     CreateDeviceRequest{},
+		idempotent:  true,
+		responses: map[int]interface{}{
+			http.StatusCreated:               CreateDeviceResponse{},
+			http.StatusBadRequest:            nil,
+			http.StatusRequestEntityTooLarge: nil,
+			http.StatusForbidden:             nil,
+			http.StatusConflict:              nil,
+			http.StatusUnprocessableEntity:   nil,
+			http.StatusInternalServerError:   nil,
+			http.StatusServiceUnavailable:    nil,
+		},
+	},
+	{
+		pattern:     "GET /api/v0/devices/{id}",
+		operationID: "getDevice",
+		summary:     "Get a signature device",
+		tag:         "devices",
+		cbor:        true,
+		responses: map[int]interface{}{
+			http.StatusOK:                 CreateDeviceResponse{},
+			http.StatusNotFound:           nil,
+			http.StatusServiceUnavailable: nil,
+		},
+	},
+	{
+		pattern:     "POST /api/v0/devices/{id}/sign",
+		operationID: "signTransaction",
+		summary:     "Sign transaction data with a signature device",
+		tag:         "devices",
+		cbor:        true,
+		request:     SignTransactionRequest{},
+		// The raw transaction data, hashed while it is read.
+		requestAlternatives: []string{StreamContentType},
+		idempotent:          true,
+		query: []apiQueryParameter{{
+			name:        "format",
+			description: "Comma-separated additional representations of the signed data: a compact JWS and/or a COSE_Sign1 structure (RS256 for RSA, ES384 for ECC devices).",
+			values:      []string{"jws", "cose", "jws,cose"},
+		}, {
+			name:        "hash",
+			description: "Hash algorithm for application/octet-stream bodies, SHA-256 by default.",
+			values:      []string{"sha256", "sha384", "sha512"},
+		}},
+		responses: map[int]interface{}{
+			http.StatusOK:                    SignTransactionResponse{},
+			http.StatusBadRequest:            nil,
+			http.StatusNotFound:              nil,
+			http.StatusConflict:              nil,
+			http.StatusRequestEntityTooLarge: nil,
+			http.StatusUnprocessableEntity:   nil,
+			http.StatusInternalServerError:   nil,
+			http.StatusServiceUnavailable:    nil,
+		},
+	},
+	{
+		pattern:     "GET /api/v0/devices/{id}/transactions",
+		operationID: "listTransactions",
+		summary:     "List the transactions signed by a signature device",
+		tag:         "devices",
+		cbor:        true,
+		responses: map[int]interface{}{
+			http.StatusOK:                  []TransactionResponse{},
+			http.StatusNotFound:            nil,
+			http.StatusInternalServerError: nil,
+			http.StatusServiceUnavailable:  nil,
+		},
+	},
+	{
+		pattern:     "GET /api/v0/devices/{id}/public-key",
+		operationID: "getPublicKey",
+		summary:     "Export the public key of a signature device",
+		tag:         "devices",
+		cbor:        true,
+		query: []apiQueryParameter{{
+			name:        "format",
+			description: "Raw format of the key; without it the Accept header selects the format and defaults to JSON.",
+			values:      []string{"json", "pem", "der", "jwk", "openssh"},
+		}},
+		alternatives: []string{"application/x-pem-file", "application/octet-stream", "application/jwk+json", "text/plain"},
+		responses: map[int]interface{}{
+			http.StatusOK:                  PublicKeyResponse{},
+			http.StatusBadRequest:          nil,
+			http.StatusNotFound:            nil,
+			http.StatusInternalServerError: nil,
+			http.StatusServiceUnavailable:  nil,
+		},
+	},
+	{
+		pattern:     "GET /api/v0/devices/{id}/certificate",
+		operationID: "getCertificate",
+		summary:     "Get the X.509 certificate chain of a signature device",
+		tag:         "devices",
+		cbor:        true,
+		query: []apiQueryParameter{{
+			name:        "format",
+			description: "Raw format: pem returns the PEM chain, der the DER device certificate; without it the Accept header selects the format and defaults to JSON.",
+			values:      []string{"json", "pem", "der"},
+		}},
+		alternatives: []string{"application/pem-certificate-chain", "application/pkix-cert"},
+		responses: map[int]interface{}{
+			http.StatusOK:                  CertificateResponse{},
+			http.StatusBadRequest:          nil,
+			http.StatusNotFound:            nil,
+			http.StatusInternalServerError: nil,
+			http.StatusServiceUnavailable:  nil,
+		},
+	},
+	{
+		pattern:             "PUT /api/v0/devices/{id}/certificate",
+		operationID:         "importCertificate",
+		summary:             "Import a device certificate issued by an external CA",
+		tag:                 "devices",
+		cbor:                true,
+		request:             ImportCertificateRequest{},
+		requestAlternatives: []string{"application/pem-certificate-chain", "application/pkix-cert"},
+		responses: map[int]interface{}{
+			http.StatusOK:                    CertificateResponse{},
+			http.StatusBadRequest:            nil,
+			http.StatusRequestEntityTooLarge: nil,
+			http.StatusNotFound:              nil,
+			http.StatusUnprocessableEntity:   nil,
+			http.StatusInternalServerError:   nil,
+			http.StatusServiceUnavailable:    nil,
+		},
+	},
+	{
+		pattern:      "POST /api/v0/devices/{id}/csr",
+		operationID:  "createCertificateRequest",
+		summary:      "Create a PKCS#10 certificate signing request for the key of a signature device",
+		tag:          "devices",
+		cbor:         true,
+		request:      CSRRequest{},
+		optionalBody: true,
+		query: []apiQueryParameter{{
+			name:        "format",
+			description: "Raw format: pem returns the PEM request, der the DER request; without it the Accept header selects the format and defaults to JSON.",
+			values:      []string{"json", "pem", "der"},
+		}},
+		alternatives: []string{"application/x-pem-file", "application/pkcs10"},
+		responses: map[int]interface{}{
+			http.StatusOK:                    CSRResponse{},
+			http.StatusBadRequest:            nil,
+			http.StatusRequestEntityTooLarge: nil,
+			http.StatusNotFound:              nil,
+			http.StatusInternalServerError:   nil,
+			http.StatusServiceUnavailable:    nil,
+		},
+	},
+	{
+		pattern:     "POST /api/v0/devices/{id}/export",
+		operationID: "exportDevice",
+		summary:     "Export a signature device as an encrypted, signed bundle for migration",
+		tag:         "devices",
+		cbor:        true,
+		responses: map[int]interface{}{
+			http.StatusOK:                  DeviceBundleResponse{},
+			http.StatusNotFound:            nil,
+			http.StatusInternalServerError: nil,
+			http.StatusServiceUnavailable:  nil,
+		},
+	},
+	{
+		pattern:     "POST /api/v0/devices/import",
+		operationID: "importDevice",
+		summary:     "Import a signature device from a bundle, unless it exists with a higher counter",
+		tag:         "devices",
+		cbor:        true,
+		request:     ImportDeviceRequest{},
+		responses: map[int]interface{}{
+			http.StatusOK:                    CreateDeviceResponse{},
+			http.StatusBadRequest:            nil,
+			http.StatusRequestEntityTooLarge: nil,
+			http.StatusNotFound:              nil,
+			http.StatusConflict:              nil,
+			http.StatusForbidden:             nil,
+			http.StatusUnprocessableEntity:   nil,
+			http.StatusInternalServerError:   nil,
+			http.StatusServiceUnavailable:    nil,
+		},
+	},
+	{
+		pattern:     "GET /api/v0/devices/{id}/counter",
+		operationID: "getCounterStatus",
+		summary:     "Compare the signature counter of a device with its high-water mark",
+		tag:         "devices",
+		cbor:        true,
+		responses: map[int]interface{}{
+			http.StatusOK:                  CounterStatusResponse{},
+			http.StatusNotFound:            nil,
+			http.StatusInternalServerError: nil,
+			http.StatusServiceUnavailable:  nil,
+		},
+	},
+	{
+		pattern:     "POST /api/v0/devices/{id}/counter/override",
+		operationID: "overrideHighWaterMark",
+		summary:     "Let a rolled back signature device sign again from its current counter",
+		tag:         "devices",
+		cbor:        true,
+		request:     OverrideHighWaterMarkRequest{},
+		responses: map[int]interface{}{
+			http.StatusOK:                    CounterStatusResponse{},
+			http.StatusBadRequest:            nil,
+			http.StatusRequestEntityTooLarge: nil,
+			http.StatusNotFound:              nil,
+			http.StatusUnprocessableEntity:   nil,
+			http.StatusInternalServerError:   nil,
+			http.StatusServiceUnavailable:    nil,
+		},
+	},
+	{
+		pattern:     "GET /.well-known/jwks.json",
+		operationID: "getJWKS",
+		summary:     "Get the public keys of all signature devices as a JSON Web Key Set",
+		tag:         "devices",
+		unwrapped:   true,
+		responses: map[int]interface{}{
+			http.StatusOK:                  JWKSet{},
+			http.StatusNotModified:         nil,
+			http.StatusInternalServerError: nil,
+			http.StatusServiceUnavailable:  nil,
+		},
+	},
+	{
+		pattern:     "GET /api/v0/audit",
+		operationID: "getAuditLog",
+		summary:     "Export the hash-chained audit log of administrative actions",
+		tag:         "audit",
+		responses: map[int]interface{}{
+			http.StatusOK:                  audit.Export{},
+			http.StatusInternalServerError: nil,
+		},
+	},
+	{
+		pattern:     "GET /api/v0/backups",
+		operationID: "listBackups",
+		summary:     "List the snapshots of all devices and their transactions, oldest first",
+		tag:         "backups",
+		cbor:        true,
+		responses: map[int]interface{}{
+			http.StatusOK:                  []BackupResponse{},
+			http.StatusInternalServerError: nil,
+		},
+	},
+	{
+		pattern:     "POST /api/v0/backups",
+		operationID: "createBackup",
+		summary:     "Take a snapshot of all devices and their transactions",
+		tag:         "backups",
+		cbor:        true,
+		responses: map[int]interface{}{
+			http.StatusCreated:             BackupResponse{},
+			http.StatusInternalServerError: nil,
+			http.StatusServiceUnavailable:  nil,
+		},
+	},
+	{
+		pattern:      "POST /api/v0/backups/restore",
+		operationID:  "restoreBackup",
+		summary:      "Restore all devices to the latest snapshot taken at or before a point in time",
+		tag:          "backups",
+		cbor:         true,
+		request:      RestoreBackupRequest{},
+		optionalBody: true,
+		responses: map[int]interface{}{
+			http.StatusOK:                    BackupResponse{},
+			http.StatusBadRequest:            nil,
+			http.StatusRequestEntityTooLarge: nil,
+			http.StatusNotFound:              nil,
+			http.StatusUnprocessableEntity:   nil,
+			http.StatusInternalServerError:   nil,
+			http.StatusServiceUnavailable:    nil,
+		},
+	},
+	{
+		pattern:     "GET /api/v0/openapi.json",
+		operationID: "getOpenAPIDocument",
+		summary:     "Get this OpenAPI document",
+		tag:         "meta",
+		responses:   map[int]interface{}{http.StatusOK: nil},
+	},
+	{
+		pattern:     "GET /metrics",
+		operationID: "getMetrics",
+		summary:     "Get Prometheus metrics",
+		tag:         "meta",
+		responses:   map[int]interface{}{http.StatusOK: nil},
+		contentType: "text/plain",
+	},
+}
+
+// OpenAPI writes the OpenAPI document of the service.
+func (s *Server) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(openAPIDocument)
+}
+
+// generateOpenAPIDocument builds the OpenAPI 3.1 document from apiOperations and the Go types they reference.
+func generateOpenAPIDocument() ([]byte, error) {
+	generator := &schemaGenerator{components: map[string]interface{}{}}
+	paths := map[string]map[string]interface{}{}
+
+	for _, op := range apiOperations {
+		method, path, _ := strings.Cut(op.pattern, " ")
+
+		operation := map[string]interface{}{
+			"operationId": op.operationID,
+			"summary":     op.summary,
+			"tags":        []string{op.tag},
+		}
+
+		var parameters []interface{}
+		if strings.Contains(path, "{id}") {
+			parameters = append(parameters, map[string]interface{}{
+				"name":     "id",
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+		for _, query := range op.query {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        query.name,
+				"in":          "query",
+				"required":    false,
+				"description": query.description,
+				"schema":      map[string]interface{}{"type": "string", "enum": query.values},
+			})
+		}
+		if op.idempotent {
+			parameters = append(parameters, map[string]interface{}{
+				"name":        IdempotencyKeyHeader,
+				"in":          "header",
+				"required":    false,
+				"description": "Repeating a request with the same key returns the stored response of the first request.",
+				"schema":      map[string]interface{}{"type": "string", "maxLength": maxIdempotencyKeyLength},
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if op.request != nil {
+			content := jsonContent(generator.schemaFor(reflect.TypeOf(op.request)))
+			if op.cbor {
+				content[CBORContentType] = content["application/json"]
+			}
+			for _, mediaType := range op.requestAlternatives {
+				content[mediaType] = map[string]interface{}{}
+			}
+			operation["requestBody"] = map[string]interface{}{
+				"required": !op.optionalBody,
+				"content":  content,
+			}
+		}
+
+		statuses := op.responses
+		if op.idempotent {
+			statuses = make(map[int]interface{}, len(op.responses)+2)
+			for status, data := range op.responses {
+				statuses[status] = data
+			}
+			statuses[http.StatusConflict] = nil
+			statuses[http.StatusUnprocessableEntity] = nil
+		}
+
+		responses := map[string]interface{}{}
+		for status, data := range statuses {
+			response := map[string]interface{}{"description": http.StatusText(status)}
+			switch {
+			case status >= http.StatusBadRequest:
+				response["content"] = map[string]interface{}{
+					"application/json": map[string]interface{}{"schema": generator.schemaFor(reflect.TypeOf(ErrorResponse{}))},
+					ProblemContentType: map[string]interface{}{"schema": generator.schemaFor(reflect.TypeOf(ProblemDetails{}))},
+				}
+			case status == http.StatusNotModified:
+				// Not Modified responses have no body.
+			case op.contentType != "":
+				response["content"] = map[string]interface{}{op.contentType: map[string]interface{}{}}
+			case op.unwrapped && data != nil:
+				response["content"] = jsonContent(generator.schemaFor(reflect.TypeOf(data)))
+			case data == nil:
+				response["content"] = jsonContent(map[string]interface{}{"type": "object"})
+			default:
+				response["content"] = jsonContent(map[string]interface{}{
+					"type":     "object",
+					"required": []string{"data"},
+					"properties": map[string]interface{}{
+						"data": generator.schemaFor(reflect.TypeOf(data)),
+					},
+				})
+			}
+			if content, ok := response["content"].(map[string]interface{}); ok && status < http.StatusBadRequest {
+				if op.cbor {
+					content[CBORContentType] = content["application/json"]
+				}
+				for _, mediaType := range op.alternatives {
+					content[mediaType] = map[string]interface{}{}
+				}
+			}
+			responses[strconv.Itoa(status)] = response
+		}
+		operation["responses"] = responses
+
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		paths[path][strings.ToLower(method)] = operation
+	}
+
+	document := map[string]interface{}{
+		"openapi": "3.1.0",
+		"info": map[string]interface{}{
+			"title":   "Signature Service API",
+			"version": "v0",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": generator.components,
+		},
+	}
+
+	content, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+	}
+}
+
+// schemaGenerator derives JSON schemas from Go types using their json tags.
+// Named struct types become components and are referenced by $ref.
+type schemaGenerator struct {
+	components map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "contentEncoding": "base64"}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := componentName(t)
+		if _, ok := g.components[name]; !ok {
+			// Register a placeholder first so that recursive types terminate.
+			g.components[name] = nil
+			g.components[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = g.schemaFor(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// componentName names a schema after its type, prefixed with the package name
+// for types declared outside the api package, e.g. "AuditEntry".
+func componentName(t reflect.Type) string {
+	pkg := t.PkgPath()[strings.LastIndex(t.PkgPath(), "/")+1:]
+	if pkg == "api" {
+		return t.Name()
+	}
+	return strings.ToUpper(pkg[:1]) + pkg[1:] + t.Name()
+}
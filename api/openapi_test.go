@@ -0,0 +1,174 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/backup"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/bundle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/timestamp"
+)
+
+var update = flag.Bool("update", false, "regenerate openapi.json")
+
+func TestOpenAPIDocumentIsUpToDate(t *testing.T) {
+	generated, err := generateOpenAPIDocument()
+	if err != nil {
+		t.Fatalf("Failed to generate OpenAPI document: %v", err)
+	}
+
+	if *update {
+		if err := os.WriteFile("openapi.json", generated, 0o644); err != nil {
+			t.Fatalf("Failed to write openapi.json: %v", err)
+		}
+		return
+	}
+
+	if !bytes.Equal(generated, openAPIDocument) {
+		t.Errorf("openapi.json does not match the API types; run `go generate ./api` and review the diff")
+	}
+}
+
+func TestOpenAPIDocumentCoversRoutes(t *testing.T) {
+	key, err := audit.LoadServiceKey("")
+	if err != nil {
+		t.Fatalf("Failed to generate service key: %v", err)
+	}
+	auditLog, err := audit.NewLog(audit.NewMemoryStore(), key, 1)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	server := NewServer(":0", NewDeviceHandler(devices),
+		WithMetrics(metrics.New()),
+		WithAuditHandler(NewAuditHandler(auditLog)),
+		WithBackupHandler(NewBackupHandler(backup.NewManager(devices, backup.NewDirStore(t.TempDir()), nil))),
+	)
+
+	var routed []string
+	for _, pattern := range server.routes().patterns {
+		// Trailing-slash aliases share the documentation of their route.
+		if !strings.HasSuffix(pattern, "/{$}") {
+			routed = append(routed, pattern)
+		}
+	}
+	var documented []string
+	for _, op := range apiOperations {
+		documented = append(documented, op.pattern)
+	}
+	sort.Strings(routed)
+	sort.Strings(documented)
+
+	if strings.Join(routed, "\n") != strings.Join(documented, "\n") {
+		t.Errorf("Routes and OpenAPI operations differ:\nrouted:     %v\ndocumented: %v", routed, documented)
+	}
+}
+
+// TestOpenAPIResponsesMatchHandlers checks that the handlers write the
+// properties documented for their response types.
+func TestOpenAPIResponsesMatchHandlers(t *testing.T) {
+	var document struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(openAPIDocument, &document); err != nil {
+		t.Fatalf("Failed to parse OpenAPI document: %v", err)
+	}
+
+	authority, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	local, err := timestamp.NewLocal(authority)
+	if err != nil {
+		t.Fatalf("Failed to create local TSA: %v", err)
+	}
+	migrationKey, err := bundle.NewKey(bytes.Repeat([]byte("m"), bundle.MinSecretSize))
+	if err != nil {
+		t.Fatalf("Failed to create migration key: %v", err)
+	}
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository(),
+		service.WithCertificateAuthority(authority),
+		service.WithTimestamper(local),
+		service.WithMigrationKey(migrationKey),
+	)
+	backups := backup.NewManager(devices, backup.NewDirStore(t.TempDir()), migrationKey)
+	handler := NewServer(":0", NewDeviceHandler(devices), WithBackupHandler(NewBackupHandler(backups))).Handler()
+	// Bundles are imported by another instance, since the source cannot take them back.
+	target := NewServer(":0", NewDeviceHandler(service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithMigrationKey(migrationKey)))).Handler()
+	callOn := func(handler http.Handler, method, path, body string) map[string]interface{} {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, path, bytes.NewBufferString(body)))
+		var response map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s %s: failed to unmarshal response: %v", method, path, err)
+		}
+		return response
+	}
+	call := func(method, path, body string) map[string]interface{} {
+		t.Helper()
+		return callOn(handler, method, path, body)
+	}
+	assertProperties := func(schema string, object interface{}) {
+		t.Helper()
+		fields, ok := object.(map[string]interface{})
+		if !ok {
+			t.Fatalf("Expected %s to be an object, got %v", schema, object)
+		}
+		documented := document.Components.Schemas[schema].Properties
+		for name := range fields {
+			if _, ok := documented[name]; !ok {
+				t.Errorf("%s: property %q is written by the handler but not documented", schema, name)
+			}
+		}
+		for name := range documented {
+			if _, ok := fields[name]; !ok {
+				t.Errorf("%s: property %q is documented but not written by the handler", schema, name)
+			}
+		}
+	}
+
+	created := call(http.MethodPost, "/api/v0/devices", `{"algorithm": "ECC", "label": "OpenAPI"}`)["data"]
+	assertProperties("CreateDeviceResponse", created)
+	id := created.(map[string]interface{})["id"].(string)
+
+	assertProperties("CreateDeviceResponse", call(http.MethodGet, "/api/v0/devices/"+id, "")["data"])
+	assertProperties("SignTransactionResponse", call(http.MethodPost, "/api/v0/devices/"+id+"/sign?format=jws,cose", `{"data": "test"}`)["data"])
+	assertProperties("TransactionResponse", call(http.MethodGet, "/api/v0/devices/"+id+"/transactions", "")["data"].([]interface{})[0])
+	assertProperties("PublicKeyResponse", call(http.MethodGet, "/api/v0/devices/"+id+"/public-key", "")["data"])
+	assertProperties("CertificateResponse", call(http.MethodGet, "/api/v0/devices/"+id+"/certificate", "")["data"])
+	assertProperties("CSRResponse", call(http.MethodPost, "/api/v0/devices/"+id+"/csr", "")["data"])
+	exported := call(http.MethodPost, "/api/v0/devices/"+id+"/export", "")["data"]
+	assertProperties("DeviceBundleResponse", exported)
+	importRequest, _ := json.Marshal(map[string]interface{}{"bundle": exported.(map[string]interface{})["bundle"]})
+	assertProperties("CreateDeviceResponse", callOn(target, http.MethodPost, "/api/v0/devices/import", string(importRequest))["data"])
+	assertProperties("CounterStatusResponse", call(http.MethodGet, "/api/v0/devices/"+id+"/counter", "")["data"])
+	assertProperties("CounterStatusResponse", call(http.MethodPost, "/api/v0/devices/"+id+"/counter/override", `{"reason": "test"}`)["data"])
+	assertProperties("BackupResponse", call(http.MethodPost, "/api/v0/backups", "")["data"])
+	assertProperties("BackupResponse", call(http.MethodPost, "/api/v0/backups/restore", "")["data"])
+	assertProperties("JWKSet", call(http.MethodGet, "/.well-known/jwks.json", ""))
+	assertProperties("HealthResponse", call(http.MethodGet, "/api/v0/health", "")["data"])
+	assertProperties("ErrorResponse", call(http.MethodGet, "/api/v0/devices/unknown", ""))
+
+	served := call(http.MethodGet, "/api/v0/openapi.json", "")
+	if served["openapi"] != "3.1.0" {
+		t.Errorf("Expected served document to be OpenAPI 3.1.0, got %v", served["openapi"])
+	}
+}
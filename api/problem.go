@@ -0,0 +1,84 @@
+package api
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// ProblemContentType is the media type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// ProblemDetails is the RFC 7807 representation of an error response. The
+// errors and request_id extension members carry the same values as ErrorResponse.
+type ProblemDetails struct {
+	Type      string   `json:"type"`
+	Title     string   `json:"title"`
+	Status    int      `json:"status"`
+	Detail    string   `json:"detail,omitempty"`
+	Instance  string   `json:"instance,omitempty"`
+	Errors    []string `json:"errors"`
+	RequestID string   `json:"request_id,omitempty"`
+}
+
+func newProblemDetails(r *http.Request, code int, errors []string, requestID string) ProblemDetails {
+	problem := ProblemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(code),
+		Status:    code,
+		Detail:    strings.Join(errors, "; "),
+		Errors:    errors,
+		RequestID: requestID,
+	}
+	if r != nil {
+		problem.Instance = r.URL.Path
+	}
+	return problem
+}
+
+type problemDetailsKey struct{}
+
+// negotiateErrorFormat decides once per request whether errors are written as
+// problem details: if the client accepts application/problem+json, or if the
+// server is configured to use them and the client does not insist on plain JSON
+// or CBOR.
+func negotiateErrorFormat(preferProblem bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		problem := acceptsProblemDetails(r, preferProblem)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), problemDetailsKey{}, problem)))
+	})
+}
+
+// wantsProblemDetails reports the error format negotiated for r.
+func wantsProblemDetails(r *http.Request) bool {
+	if r == nil {
+		return false
+	}
+	if problem, ok := r.Context().Value(problemDetailsKey{}).(bool); ok {
+		return problem
+	}
+	return acceptsProblemDetails(r, false)
+}
+
+func acceptsProblemDetails(r *http.Request, preferProblem bool) bool {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return preferProblem
+	}
+
+	acceptsEnvelope := false
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case ProblemContentType:
+			return true
+		case "application/json", CBORContentType:
+			acceptsEnvelope = true
+		}
+	}
+	return preferProblem && !acceptsEnvelope
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+// unavailableRepository simulates a storage backend outage.
+type unavailableRepository struct {
+	persistence.DeviceRepository
+}
+
+func (unavailableRepository) Get(ctx context.Context, id string) (*domain.SignatureDevice, error) {
+	return nil, fmt.Errorf("%w: connection refused", persistence.ErrUnavailable)
+}
+
+func TestProblemDetails(t *testing.T) {
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	envelope := NewServer(":0", NewDeviceHandler(devices)).Handler()
+	problem := NewServer(":0", NewDeviceHandler(devices), WithProblemDetails()).Handler()
+
+	tests := []struct {
+		name        string
+		handler     http.Handler
+		accept      string
+		contentType string
+	}{
+		{"envelope by default", envelope, "", "application/json"},
+		{"negotiated by Accept", envelope, "application/problem+json", ProblemContentType},
+		{"configured", problem, "", ProblemContentType},
+		{"configured, client accepts anything", problem, "*/*", ProblemContentType},
+		{"configured, client insists on JSON", problem, "application/json", "application/json"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/v0/devices/unknown", nil)
+		if test.accept != "" {
+			req.Header.Set("Accept", test.accept)
+		}
+		rr := httptest.NewRecorder()
+		test.handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusNotFound {
+			t.Errorf("%s: expected status 404, got %d", test.name, rr.Code)
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != test.contentType {
+			t.Errorf("%s: expected content type %s, got %s", test.name, test.contentType, contentType)
+		}
+		if test.contentType != ProblemContentType {
+			continue
+		}
+
+		var details ProblemDetails
+		if err := json.Unmarshal(rr.Body.Bytes(), &details); err != nil {
+			t.Fatalf("%s: failed to unmarshal problem details: %v", test.name, err)
+		}
+		if details.Status != http.StatusNotFound || details.Title != "Not Found" || details.Type != "about:blank" {
+			t.Errorf("%s: unexpected problem details %+v", test.name, details)
+		}
+		if details.Instance != "/api/v0/devices/unknown" || len(details.Errors) == 0 {
+			t.Errorf("%s: expected instance and errors, got %+v", test.name, details)
+		}
+		if details.RequestID == "" || details.RequestID != rr.Header().Get(RequestIDHeader) {
+			t.Errorf("%s: expected request ID %q, got %q", test.name, rr.Header().Get(RequestIDHeader), details.RequestID)
+		}
+	}
+}
+
+func TestStorageOutageIsUnavailable(t *testing.T) {
+	handler := NewServer(":0", NewDeviceHandler(service.NewDeviceService(unavailableRepository{}))).Handler()
+
+	for _, path := range []string{"/api/v0/devices/any", "/api/v0/devices/any/transactions"} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s: expected status 503 for a storage outage, got %d", path, rr.Code)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// PublicKeyResponse holds the public key of a device in all text formats.
+type PublicKeyResponse struct {
+	ID        string `json:"id"`
+	Algorithm string `json:"algorithm"`
+	// PublicKey is the standard PKIX PEM encoding of the key.
+	PublicKey string      `json:"public_key"`
+	JWK       *crypto.JWK `json:"jwk"`
+	OpenSSH   string      `json:"openssh"`
+}
+
+// publicKeyContentTypes maps the exported formats to their media types.
+var publicKeyContentTypes = map[domain.PublicKeyFormat]string{
+	domain.PublicKeyPEM:     "application/x-pem-file",
+	domain.PublicKeyDER:     "application/octet-stream",
+	domain.PublicKeyJWK:     "application/jwk+json",
+	domain.PublicKeyOpenSSH: "text/plain; charset=utf-8",
+}
+
+// PublicKey writes the public key of a device. The format is selected by the
+// format query parameter or, without it, by the Accept header; by default the
+// key is returned in all text formats in the usual JSON envelope.
+func (h *DeviceHandler) PublicKey(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	annotateDevice(r, id)
+
+	format, ok := negotiatePublicKeyFormat(r)
+	if !ok {
+		WriteErrorResponse(w, r, http.StatusBadRequest, []string{"Unsupported format. Supported formats: json, pem, der, jwk, openssh"})
+		return
+	}
+
+	device, err := h.devices.GetDevice(r.Context(), id)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+
+	if format != "" {
+		content, err := device.ExportPublicKey(format)
+		if err != nil {
+			writeServiceError(w, r, err)
+			return
+		}
+		w.Header().Set("Content-Type", publicKeyContentTypes[format])
+		w.WriteHeader(http.StatusOK)
+		w.Write(content)
+		return
+	}
+
+	response, err := newPublicKeyResponse(device)
+	if err != nil {
+		writeServiceError(w, r, err)
+		return
+	}
+	writeResponse(w, r, http.StatusOK, response)
+}
+
+func newPublicKeyResponse(device *domain.SignatureDevice) (*PublicKeyResponse, error) {
+	pem, err := device.ExportPublicKey(domain.PublicKeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := device.JWK()
+	if err != nil {
+		return nil, err
+	}
+	openSSH, err := device.ExportPublicKey(domain.PublicKeyOpenSSH)
+	if err != nil {
+		return nil, err
+	}
+	return &PublicKeyResponse{
+		ID:        device.ID,
+		Algorithm: string(device.Algorithm),
+		PublicKey: string(pem),
+		JWK:       jwk,
+		OpenSSH:   strings.TrimSuffix(string(openSSH), "\n"),
+	}, nil
+}
+
+// negotiatePublicKeyFormat returns the raw format requested for r, or "" for
+// the JSON envelope. It reports false for an unknown format parameter.
+func negotiatePublicKeyFormat(r *http.Request) (domain.PublicKeyFormat, bool) {
+	if name := r.URL.Query().Get("format"); name != "" {
+		if strings.EqualFold(name, "json") {
+			return "", true
+		}
+		format, err := domain.ParsePublicKeyFormat(name)
+		return format, !errors.Is(err, domain.ErrUnsupportedFormat)
+	}
+
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+		switch mediaType {
+		case "application/json":
+			return "", true
+		case "application/x-pem-file":
+			return domain.PublicKeyPEM, true
+		case "application/octet-stream", "application/pkix-spki":
+			return domain.PublicKeyDER, true
+		case "application/jwk+json":
+			return domain.PublicKeyJWK, true
+		}
+	}
+	return "", true
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestPublicKey(t *testing.T) {
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	handler := routes(NewDeviceHandler(devices))
+	device, err := devices.CreateDevice(context.Background(), service.CreateDeviceParams{Algorithm: "RSA"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	path := "/api/v0/devices/" + device.ID + "/public-key"
+
+	get := func(query, accept string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path+query, nil)
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := get("", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a JSON response, got %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var response struct {
+		Data PublicKeyResponse `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response: %v", err)
+	}
+	block, _ := pem.Decode([]byte(response.Data.PublicKey))
+	if block == nil || block.Type != "PUBLIC KEY" {
+		t.Fatalf("Expected public_key to be a plain PKIX PEM string, got %q", response.Data.PublicKey)
+	}
+	if _, err := x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		t.Errorf("Failed to parse exported key: %v", err)
+	}
+	if response.Data.JWK == nil || response.Data.JWK.KeyID != device.KeyID() || response.Data.JWK.Algorithm != "RS256" {
+		t.Errorf("Unexpected JWK %+v", response.Data.JWK)
+	}
+	if !strings.HasPrefix(response.Data.OpenSSH, "ssh-rsa ") {
+		t.Errorf("Unexpected OpenSSH key %q", response.Data.OpenSSH)
+	}
+
+	tests := []struct {
+		query       string
+		accept      string
+		contentType string
+	}{
+		{"?format=pem", "", "application/x-pem-file"},
+		{"?format=der", "", "application/octet-stream"},
+		{"?format=jwk", "", "application/jwk+json"},
+		{"?format=openssh", "", "text/plain; charset=utf-8"},
+		{"", "application/x-pem-file", "application/x-pem-file"},
+		{"", "application/jwk+json, application/json;q=0.5", "application/jwk+json"},
+		{"?format=json", "application/x-pem-file", "application/json"},
+	}
+	for _, test := range tests {
+		rr := get(test.query, test.accept)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != test.contentType {
+			t.Errorf("%q (Accept %q): got %d %s want %s", test.query, test.accept, rr.Code, rr.Header().Get("Content-Type"), test.contentType)
+		}
+	}
+
+	if der := get("?format=der", "").Body.Bytes(); string(der) != string(block.Bytes) {
+		t.Errorf("Expected DER to match the PEM contents")
+	}
+	if rr := get("?format=x509", ""); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for an unknown format, got %d", rr.Code)
+	}
+	path = "/api/v0/devices/unknown/public-key"
+	if rr := get("?format=pem", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown device, got %d", rr.Code)
+	}
+}
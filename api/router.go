@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// probeMethods are the methods checked when building the Allow header of a 405 response.
+var probeMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+}
+
+// router dispatches requests with method and pattern routing and answers
+// requests without a matching route with JSON 404 and 405 responses.
+type router struct {
+	mux      *http.ServeMux
+	patterns []string
+}
+
+func newRouter() *router {
+	rt := &router{mux: http.NewServeMux()}
+	rt.mux.HandleFunc("/", rt.unmatched)
+	return rt
+}
+
+// handle registers a handler for a pattern such as "GET /api/v0/devices/{id}".
+func (rt *router) handle(pattern string, handler http.Handler) {
+	rt.mux.Handle(pattern, handler)
+	rt.patterns = append(rt.patterns, pattern)
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// route returns the path template matched by r, for use as a low-cardinality
+// label in logs, metrics and span names.
+func (rt *router) route(r *http.Request) string {
+	_, pattern := rt.mux.Handler(r)
+	if pattern == "" || pattern == "/" {
+		return "unmatched"
+	}
+	if _, path, found := strings.Cut(pattern, " "); found {
+		pattern = path
+	}
+	return strings.TrimSuffix(pattern, "/{$}")
+}
+
+// unmatched responds with 405 and an Allow header if the path exists for other
+// methods, and with 404 otherwise.
+func (rt *router) unmatched(w http.ResponseWriter, r *http.Request) {
+	var allowed []string
+	for _, method := range probeMethods {
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		if _, pattern := rt.mux.Handler(probe); pattern != "" && pattern != "/" {
+			allowed = append(allowed, method)
+		}
+	}
+
+	if len(allowed) == 0 {
+		WriteErrorResponse(w, r, http.StatusNotFound, []string{"Endpoint not found"})
+		return
+	}
+
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	WriteErrorResponse(w, r, http.StatusMethodNotAllowed, []string{http.StatusText(http.StatusMethodNotAllowed)})
+}
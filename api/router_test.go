@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestRoutingTable(t *testing.T) {
+	repo := persistence.NewInMemoryDeviceRepository()
+	// IDs containing "sign" used to be routed by substring matching.
+	for _, id := range []string{"device-1", "sign", "design-sign-device"} {
+		device, err := domain.NewSignatureDevice(id, domain.ECC, "Test Device")
+		if err != nil {
+			t.Fatalf("Failed to create device: %v", err)
+		}
+		if err := repo.Create(context.Background(), device); err != nil {
+			t.Fatalf("Failed to create device in repository: %v", err)
+		}
+	}
+	authority, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	handler := NewServer(":0", NewDeviceHandler(service.NewDeviceService(repo, service.WithCertificateAuthority(authority)))).Handler()
+
+	signBody := `{"data": "test data"}`
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		status int
+		allow  string
+	}{
+		{http.MethodGet, "/api/v0/health", "", http.StatusOK, ""},
+		{http.MethodPost, "/api/v0/health", "", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/api/v0/devices", "", http.StatusOK, ""},
+		{http.MethodPost, "/api/v0/devices", `{"algorithm": "ECC"}`, http.StatusCreated, ""},
+		{http.MethodDelete, "/api/v0/devices", "", http.StatusMethodNotAllowed, "GET, HEAD, POST"},
+		{http.MethodGet, "/api/v0/devices/device-1", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v0/devices/sign", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v0/devices/design-sign-device", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v0/devices/unknown", "", http.StatusNotFound, ""},
+		{http.MethodPut, "/api/v0/devices/device-1", "", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodPost, "/api/v0/devices/device-1/sign", signBody, http.StatusOK, ""},
+		{http.MethodPost, "/api/v0/devices/device-1/sign/", signBody, http.StatusOK, ""},
+		{http.MethodPost, "/api/v0/devices/sign/sign", signBody, http.StatusOK, ""},
+		{http.MethodPost, "/api/v0/devices/design-sign-device/sign", signBody, http.StatusOK, ""},
+		{http.MethodGet, "/api/v0/devices/device-1/sign", "", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPost, "/api/v0/devices/device-1/sign/extra", signBody, http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v0/devices/device-1/sign/extra", "", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v0/devices/device-1/other", "", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v0/devices/device-1/transactions", "", http.StatusOK, ""},
+		{http.MethodPost, "/api/v0/devices/device-1/transactions", "", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/api/v0/devices/unknown/transactions", "", http.StatusNotFound, ""},
+		{http.MethodGet, "/api/v0/devices/device-1/public-key", "", http.StatusOK, ""},
+		{http.MethodPost, "/api/v0/devices/device-1/public-key", "", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/api/v0/devices/device-1/certificate", "", http.StatusOK, ""},
+		{http.MethodDelete, "/api/v0/devices/device-1/certificate", "", http.StatusMethodNotAllowed, "GET, HEAD, PUT"},
+		{http.MethodPost, "/api/v0/devices/device-1/csr", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v0/devices/device-1/csr", "", http.StatusMethodNotAllowed, "POST"},
+		{http.MethodPost, "/api/v0/devices/sign", signBody, http.StatusMethodNotAllowed, "GET, HEAD"},
+		{http.MethodGet, "/.well-known/jwks.json", "", http.StatusOK, ""},
+		{http.MethodGet, "/api/v0/non-existent", "", http.StatusNotFound, ""},
+		{http.MethodGet, "/", "", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, bytes.NewBufferString(test.body))
+		rr := httptest.NewRecorder()
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("%s %s: got status %v want %v", test.method, test.path, rr.Code, test.status)
+		}
+		if allow := rr.Header().Get("Allow"); allow != test.allow {
+			t.Errorf("%s %s: got Allow %q want %q", test.method, test.path, allow, test.allow)
+		}
+		if contentType := rr.Header().Get("Content-Type"); contentType != "application/json" {
+			t.Errorf("%s %s: got Content-Type %q want application/json", test.method, test.path, contentType)
+		}
+		if rr.Code >= http.StatusBadRequest {
+			var errorResponse ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &errorResponse); err != nil || len(errorResponse.Errors) == 0 {
+				t.Errorf("%s %s: expected a JSON error response, got %s", test.method, test.path, rr.Body.String())
+			}
+		}
+	}
+}
+
+func TestRouteTemplates(t *testing.T) {
+	rt := newRouter()
+	noop := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	rt.handle("GET /api/v0/devices/{id}", noop)
+	rt.handle("POST /api/v0/devices/{id}/sign", noop)
+	rt.handle("POST /api/v0/devices/{id}/sign/{$}", noop)
+
+	tests := []struct {
+		method string
+		path   string
+		route  string
+	}{
+		{http.MethodGet, "/api/v0/devices/abc", "/api/v0/devices/{id}"},
+		{http.MethodPost, "/api/v0/devices/abc/sign", "/api/v0/devices/{id}/sign"},
+		{http.MethodPost, "/api/v0/devices/abc/sign/", "/api/v0/devices/{id}/sign"},
+		{http.MethodGet, "/api/v0/devices/abc/sign", "unmatched"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.path, nil)
+		if route := rt.route(req); route != test.route {
+			t.Errorf("%s %s: got route %s want %s", test.method, test.path, route, test.route)
+		}
+	}
+}
@@ -4,11 +4,15 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 )
 
 // Response is the generic API response container.
@@ -18,34 +22,167 @@ type Response struct {
 
 // ErrorResponse is the generic error API response container.
 type ErrorResponse struct {
-	Errors []string `json:"errors"`
+	Errors    []string `json:"errors"`
+	RequestID string   `json:"request_id,omitempty"`
 }
 
 // Server manages HTTP requests and dispatches them to the appropriate services.
 type Server struct {
-	listenAddress string
-	deviceHandler *DeviceHandler
+	listenAddress   string
+	deviceHandler   *DeviceHandler
+	tlsCertFile     string
+	tlsKeyFile      string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
+	metrics         *metrics.Metrics
+	logger          *slog.Logger
+	auditHandler    *AuditHandler
+	backupHandler   *BackupHandler
+	idempotency     *idempotencyCache
+	problemDetails  bool
+}
+
+// ServerOption configures optional Server settings.
+type ServerOption func(*Server)
+
+// WithTLS makes the Server serve HTTPS using the given certificate and key files.
+func WithTLS(certFile, keyFile string) ServerOption {
+	return func(s *Server) {
+		s.tlsCertFile = certFile
+		s.tlsKeyFile = keyFile
+	}
+}
+
+// WithTimeouts sets the read, write and idle timeouts of the underlying http.Server.
+func WithTimeouts(read, write, idle time.Duration) ServerOption {
+	return func(s *Server) {
+		s.readTimeout = read
+		s.writeTimeout = write
+		s.idleTimeout = idle
+	}
+}
+
+// WithShutdownTimeout sets how long the Server waits for in-flight requests on shutdown.
+func WithShutdownTimeout(timeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.shutdownTimeout = timeout
+	}
+}
+
+// WithMetrics instruments all routes and exposes the metrics at /metrics.
+func WithMetrics(m *metrics.Metrics) ServerOption {
+	return func(s *Server) {
+		s.metrics = m
+	}
+}
+
+// WithLogger sets the logger used for access logs.
+func WithLogger(logger *slog.Logger) ServerOption {
+	return func(s *Server) {
+		s.logger = logger
+	}
+}
+
+// WithAuditHandler exposes the audit log at /api/v0/audit.
+func WithAuditHandler(auditHandler *AuditHandler) ServerOption {
+	return func(s *Server) {
+		s.auditHandler = auditHandler
+	}
+}
+
+// WithBackupHandler exposes backups and restores at /api/v0/backups.
+func WithBackupHandler(backupHandler *BackupHandler) ServerOption {
+	return func(s *Server) {
+		s.backupHandler = backupHandler
+	}
+}
+
+// WithProblemDetails makes RFC 7807 problem details the default error format.
+// Clients that explicitly accept only application/json still get ErrorResponse.
+func WithProblemDetails() ServerOption {
+	return func(s *Server) {
+		s.problemDetails = true
+	}
 }
 
 // NewServer is a factory to instantiate a new Server.
-func NewServer(listenAddress string, deviceHandler *DeviceHandler) *Server {
-	return &Server{
-		listenAddress: listenAddress,
-		deviceHandler: deviceHandler,
+func NewServer(listenAddress string, deviceHandler *DeviceHandler, options ...ServerOption) *Server {
+	s := &Server{
+		listenAddress:   listenAddress,
+		deviceHandler:   deviceHandler,
+		shutdownTimeout: 5 * time.Second,
+		logger:          slog.Default(),
+		idempotency:     newIdempotencyCache(),
+	}
+	for _, option := range options {
+		option(s)
 	}
+	return s
+}
+
+// Handler registers all HandlerFuncs for the existing HTTP routes and returns the resulting handler.
+func (s *Server) Handler() http.Handler {
+	rt := s.routes()
+
+	handler := s.metrics.InstrumentHandler(rt.route, negotiateErrorFormat(s.problemDetails, recordClientAddress(rt)))
+	handler = otelhttp.NewHandler(handler, "http.request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return r.Method + " " + rt.route(r)
+		}),
+	)
+	return LogRequests(s.logger, rt.route, handler)
 }
 
-// Run registers all HandlerFuncs for the existing HTTP routes and starts the Server.
-func (s *Server) Run() error {
-	mux := http.NewServeMux()
+// routes builds the routing table. Every route must be documented in apiOperations.
+func (s *Server) routes() *router {
+	rt := newRouter()
 
-	mux.Handle("/api/v0/health", http.HandlerFunc(s.Health))
-	mux.HandleFunc("/api/v0/devices", s.deviceHandler.HandleDeviceRequests)
-	mux.HandleFunc("/api/v0/devices/", s.deviceHandler.HandleDeviceRequests)
+	rt.handle("GET /api/v0/health", http.HandlerFunc(s.Health))
+	rt.handle("GET /api/v0/openapi.json", http.HandlerFunc(s.OpenAPI))
+
+	rt.handle("GET /api/v0/devices", http.HandlerFunc(s.deviceHandler.ListDevices))
+	rt.handle("POST /api/v0/devices", s.idempotency.wrap(http.HandlerFunc(s.deviceHandler.CreateDevice)))
+	rt.handle("GET /api/v0/devices/{id}", http.HandlerFunc(s.deviceHandler.GetDevice))
+	rt.handle("POST /api/v0/devices/{id}/sign", s.idempotency.wrap(http.HandlerFunc(s.deviceHandler.SignTransaction)))
+	rt.handle("POST /api/v0/devices/{id}/sign/{$}", s.idempotency.wrap(http.HandlerFunc(s.deviceHandler.SignTransaction)))
+	rt.handle("GET /api/v0/devices/{id}/transactions", http.HandlerFunc(s.deviceHandler.ListTransactions))
+	rt.handle("GET /api/v0/devices/{id}/public-key", http.HandlerFunc(s.deviceHandler.PublicKey))
+	rt.handle("GET /api/v0/devices/{id}/certificate", http.HandlerFunc(s.deviceHandler.Certificate))
+	rt.handle("PUT /api/v0/devices/{id}/certificate", http.HandlerFunc(s.deviceHandler.ImportCertificate))
+	rt.handle("POST /api/v0/devices/{id}/csr", http.HandlerFunc(s.deviceHandler.CertificateRequest))
+	rt.handle("POST /api/v0/devices/{id}/export", http.HandlerFunc(s.deviceHandler.ExportDevice))
+	rt.handle("POST /api/v0/devices/import", http.HandlerFunc(s.deviceHandler.ImportDevice))
+	rt.handle("GET /api/v0/devices/{id}/counter", http.HandlerFunc(s.deviceHandler.CounterStatus))
+	rt.handle("POST /api/v0/devices/{id}/counter/override", http.HandlerFunc(s.deviceHandler.OverrideHighWaterMark))
+	rt.handle("GET /.well-known/jwks.json", http.HandlerFunc(s.deviceHandler.JWKS))
+
+	if s.auditHandler != nil {
+		rt.handle("GET /api/v0/audit", http.HandlerFunc(s.auditHandler.GetAuditLog))
+	}
+	if s.backupHandler != nil {
+		rt.handle("GET /api/v0/backups", http.HandlerFunc(s.backupHandler.ListBackups))
+		rt.handle("POST /api/v0/backups", http.HandlerFunc(s.backupHandler.CreateBackup))
+		rt.handle("POST /api/v0/backups/restore", http.HandlerFunc(s.backupHandler.RestoreBackup))
+	}
+
+	if s.metrics != nil {
+		rt.handle("GET /metrics", s.metrics.Handler())
+	}
 
+	return rt
+}
+
+// Run starts the Server and blocks until it fails, receives an interrupt
+// signal or ctx is cancelled. The last two shut it down gracefully.
+func (s *Server) Run(ctx context.Context) error {
 	server := &http.Server{
-		Addr:    s.listenAddress,
-		Handler: mux,
+		Addr:         s.listenAddress,
+		Handler:      s.Handler(),
+		ReadTimeout:  s.readTimeout,
+		WriteTimeout: s.writeTimeout,
+		IdleTimeout:  s.idleTimeout,
 	}
 
 	// Channel to listen for errors coming from the listener.
@@ -53,28 +190,35 @@ func (s *Server) Run() error {
 
 	// Start the server in a goroutine so that it doesn't block.
 	go func() {
+		if s.tlsCertFile != "" {
+			serverErrors <- server.ListenAndServeTLS(s.tlsCertFile, s.tlsKeyFile)
+			return
+		}
 		serverErrors <- server.ListenAndServe()
 	}()
 
 	// Listen for an interrupt signal from the OS.
 	osSignals := make(chan os.Signal, 1)
 	signal.Notify(osSignals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(osSignals)
 
-	// Block until we receive a signal or an error from the server.
+	// Block until we receive a signal, a cancellation or an error from the server.
 	select {
 	case err := <-serverErrors:
 		return fmt.Errorf("server error: %w", err)
+	case <-ctx.Done():
 	case <-osSignals:
-		// Create a context with a timeout to allow for graceful shutdown.
-		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-
-		// Attempt to gracefully shut down the server.
-		if err := server.Shutdown(ctx); err != nil {
-			// If shutdown fails, force close.
-			if err := server.Close(); err != nil {
-				return fmt.Errorf("could not stop server gracefully: %w", err)
-			}
+	}
+
+	// Create a context with a timeout to allow for graceful shutdown.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.shutdownTimeout)
+	defer cancel()
+
+	// Attempt to gracefully shut down the server.
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		// If shutdown fails, force close.
+		if err := server.Close(); err != nil {
+			return fmt.Errorf("could not stop server gracefully: %w", err)
 		}
 	}
 
@@ -88,15 +232,28 @@ func WriteInternalError(w http.ResponseWriter) {
 }
 
 // WriteErrorResponse takes an HTTP status code and a slice of errors
-// and writes those as an HTTP error response in a structured format.
-func WriteErrorResponse(w http.ResponseWriter, code int, errors []string) {
-	w.WriteHeader(code)
-
-	errorResponse := ErrorResponse{
-		Errors: errors,
+// and writes those as an HTTP error response in a structured format:
+// RFC 7807 problem details if negotiated for r, ErrorResponse otherwise,
+// encoded as CBOR for clients that prefer it.
+func WriteErrorResponse(w http.ResponseWriter, r *http.Request, code int, errors []string) {
+	var body interface{} = ErrorResponse{
+		Errors:    errors,
+		RequestID: w.Header().Get(RequestIDHeader),
+	}
+	contentType := "application/json"
+	switch {
+	case wantsProblemDetails(r):
+		body = newProblemDetails(r, code, errors, w.Header().Get(RequestIDHeader))
+		contentType = ProblemContentType
+	case acceptsCBOR(r):
+		writeCBOR(w, code, body)
+		return
 	}
 
-	bytes, err := json.Marshal(errorResponse)
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+
+	bytes, err := json.Marshal(body)
 	if err != nil {
 		WriteInternalError(w)
 	}
@@ -107,6 +264,7 @@ func WriteErrorResponse(w http.ResponseWriter, code int, errors []string) {
 // WriteAPIResponse takes an HTTP status code and a generic data struct
 // and writes those as an HTTP response in a structured format.
 func WriteAPIResponse(w http.ResponseWriter, code int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 
 	response := Response{
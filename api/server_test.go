@@ -0,0 +1,68 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestServerTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	repo := persistence.NewInMemoryDeviceRepository()
+	id := uuid.New().String()
+	device, err := domain.NewSignatureDevice(id, domain.ECC, "Test Device")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	if err := repo.Create(context.Background(), device); err != nil {
+		t.Fatalf("Failed to create device in repository: %v", err)
+	}
+
+	server := NewServer(":0", NewDeviceHandler(service.NewDeviceService(repo)))
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodPost, "/api/v0/devices/"+id+"/sign", bytes.NewBufferString(`{"data": "test data"}`))
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+
+	server.Handler().ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Fatalf("Handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+		if got := span.SpanContext().TraceID().String(); got != traceID {
+			t.Errorf("Expected span %s to continue trace %s, got %s", span.Name(), traceID, got)
+		}
+	}
+
+	for _, name := range []string{
+		"POST /api/v0/devices/{id}/sign",
+		"SignatureDevice.SignTransaction",
+		"SignatureDevice.GetSigner",
+		"Signer.Sign",
+	} {
+		if _, ok := spans[name]; !ok {
+			t.Errorf("Expected span %q to be recorded", name)
+		}
+	}
+}
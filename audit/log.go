@@ -0,0 +1,279 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+type Action string
+
+const (
+	ActionCreateDevice Action = "device.create"
+	ActionDeleteDevice Action = "device.delete"
+
+	ActionImportCertificate Action = "device.import_certificate"
+	ActionExportDevice      Action = "device.export"
+	ActionImportDevice      Action = "device.import"
+	ActionRestoreDevice     Action = "device.restore"
+
+	ActionOverrideHighWaterMark Action = "device.override_high_water_mark"
+)
+
+type clientAddressKey struct{}
+
+// WithClientAddress returns a context whose audit entries record address as
+// the origin of the action.
+func WithClientAddress(ctx context.Context, address string) context.Context {
+	return context.WithValue(ctx, clientAddressKey{}, address)
+}
+
+// ClientAddress returns the address stored by WithClientAddress, if any.
+func ClientAddress(ctx context.Context) string {
+	address, _ := ctx.Value(clientAddressKey{}).(string)
+	return address
+}
+
+// GenesisHash is the previous hash of the first entry in a chain.
+var GenesisHash = strings.Repeat("0", sha256.Size*2)
+
+// Entry is a single administrative action. Each entry commits to its
+// predecessor through PreviousHash, so removing or altering any entry breaks
+// the chain. Actor is the name the caller claimed and is not authenticated;
+// ClientAddress is the network address the request came from.
+type Entry struct {
+	Sequence      uint64            `json:"sequence"`
+	Timestamp     time.Time         `json:"timestamp"`
+	Actor         string            `json:"actor"`
+	ClientAddress string            `json:"client_address,omitempty"`
+	Action        Action            `json:"action"`
+	DeviceID      string            `json:"device_id"`
+	Details       map[string]string `json:"details,omitempty"`
+	PreviousHash  string            `json:"previous_hash"`
+	Hash          string            `json:"hash"`
+}
+
+// computeHash returns the hex-encoded SHA-256 of the entry with an empty Hash field.
+func (e Entry) computeHash() (string, error) {
+	e.Hash = ""
+	content, err := json.Marshal(e)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Checkpoint is a service-key signature over the chain up to and including Sequence.
+type Checkpoint struct {
+	Sequence  uint64    `json:"sequence"`
+	Hash      string    `json:"hash"`
+	Timestamp time.Time `json:"timestamp"`
+	Signature string    `json:"signature"`
+}
+
+// signedData returns the bytes covered by the checkpoint signature.
+func (c Checkpoint) signedData() []byte {
+	return []byte(fmt.Sprintf("%d_%s_%s", c.Sequence, c.Hash, c.Timestamp.Format(time.RFC3339Nano)))
+}
+
+// Export is a self-contained copy of the audit log that can be verified offline.
+type Export struct {
+	PublicKey   string       `json:"public_key"`
+	Entries     []Entry      `json:"entries"`
+	Checkpoints []Checkpoint `json:"checkpoints"`
+}
+
+// Log is an append-only, hash-chained audit log. Every CheckpointInterval
+// entries the head of the chain is signed with the service key.
+// A nil *Log is valid and records nothing.
+type Log struct {
+	store              Store
+	signer             crypto.Signer
+	publicKey          []byte
+	checkpointInterval uint64
+	now                func() time.Time
+
+	mu sync.Mutex
+}
+
+// NewLog creates an audit log backed by store. The service key signs the checkpoints
+// and its encoded public key is included in every export.
+func NewLog(store Store, serviceKey *crypto.ECCKeyPair, checkpointInterval uint64) (*Log, error) {
+	publicKey, _, err := crypto.NewECCMarshaler().Marshal(*serviceKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode audit service key: %w", err)
+	}
+	if checkpointInterval == 0 {
+		checkpointInterval = 1
+	}
+
+	return &Log{
+		store:              store,
+		signer:             &crypto.ECCSigner{PrivateKey: serviceKey.Private},
+		publicKey:          publicKey,
+		checkpointInterval: checkpointInterval,
+		now:                func() time.Time { return time.Now().UTC() },
+	}, nil
+}
+
+// Record appends an entry for an administrative action and returns it.
+func (l *Log) Record(ctx context.Context, actor string, action Action, deviceID string, details map[string]string) (*Entry, error) {
+	if l == nil {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	head, err := l.store.Head(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log head: %w", err)
+	}
+
+	entry := Entry{
+		Sequence:      0,
+		Timestamp:     l.now(),
+		Actor:         actor,
+		ClientAddress: ClientAddress(ctx),
+		Action:        action,
+		DeviceID:      deviceID,
+		Details:       details,
+		PreviousHash:  GenesisHash,
+	}
+	if head != nil {
+		entry.Sequence = head.Sequence + 1
+		entry.PreviousHash = head.Hash
+	}
+
+	entry.Hash, err = entry.computeHash()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.store.AppendEntry(ctx, entry); err != nil {
+		return nil, fmt.Errorf("failed to append audit entry: %w", err)
+	}
+
+	if (entry.Sequence+1)%l.checkpointInterval == 0 {
+		if err := l.checkpoint(ctx, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	return &entry, nil
+}
+
+// Checkpoint signs the current head of the chain, unless it is already signed.
+func (l *Log) Checkpoint(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	head, err := l.store.Head(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read audit log head: %w", err)
+	}
+	if head == nil {
+		return nil
+	}
+
+	checkpoints, err := l.store.Checkpoints(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read audit checkpoints: %w", err)
+	}
+	if len(checkpoints) > 0 && checkpoints[len(checkpoints)-1].Sequence == head.Sequence {
+		return nil
+	}
+
+	return l.checkpoint(ctx, *head)
+}
+
+func (l *Log) checkpoint(ctx context.Context, head Entry) error {
+	checkpoint := Checkpoint{
+		Sequence:  head.Sequence,
+		Hash:      head.Hash,
+		Timestamp: l.now(),
+	}
+
+	signature, err := l.signer.Sign(checkpoint.signedData())
+	if err != nil {
+		return fmt.Errorf("failed to sign audit checkpoint: %w", err)
+	}
+	checkpoint.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	if err := l.store.AppendCheckpoint(ctx, checkpoint); err != nil {
+		return fmt.Errorf("failed to append audit checkpoint: %w", err)
+	}
+	return nil
+}
+
+// Export returns the complete chain, its checkpoints and the service public key.
+func (l *Log) Export(ctx context.Context) (*Export, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries, err := l.store.Entries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit entries: %w", err)
+	}
+	checkpoints, err := l.store.Checkpoints(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit checkpoints: %w", err)
+	}
+
+	return &Export{
+		PublicKey:   string(l.publicKey),
+		Entries:     entries,
+		Checkpoints: checkpoints,
+	}, nil
+}
+
+// PeriodicCheckpoints signs the head of the chain every interval until ctx is cancelled,
+// so that entries between two interval-based checkpoints do not stay unsigned for long.
+func (l *Log) PeriodicCheckpoints(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := l.Checkpoint(ctx); err != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+// LoadServiceKey reads a PEM-encoded ECC private key from path. If path is
+// empty, a new key is generated on every start. Checkpoints signed with it
+// can only be verified against the public key of that run, so production
+// deployments must configure a key file.
+func LoadServiceKey(path string) (*crypto.ECCKeyPair, error) {
+	if path == "" {
+		generator := &crypto.ECCGenerator{}
+		return generator.Generate()
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit service key: %w", err)
+	}
+	keyPair, err := crypto.NewECCMarshaler().Unmarshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse audit service key: %w", err)
+	}
+	return keyPair, nil
+}
@@ -0,0 +1,164 @@
+package audit
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+func newTestLog(t *testing.T, checkpointInterval uint64) (*Log, *crypto.ECCKeyPair) {
+	t.Helper()
+	key, err := LoadServiceKey("")
+	if err != nil {
+		t.Fatalf("Failed to generate service key: %v", err)
+	}
+	log, err := NewLog(NewMemoryStore(), key, checkpointInterval)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	return log, key
+}
+
+// roundTrip encodes and decodes the export like an offline verifier would receive it.
+func roundTrip(t *testing.T, export *Export) *Export {
+	t.Helper()
+	content, err := json.Marshal(export)
+	if err != nil {
+		t.Fatalf("Failed to encode export: %v", err)
+	}
+	var decoded Export
+	if err := json.Unmarshal(content, &decoded); err != nil {
+		t.Fatalf("Failed to decode export: %v", err)
+	}
+	return &decoded
+}
+
+func TestRecordAndVerify(t *testing.T) {
+	log, key := newTestLog(t, 2)
+	ctx := context.Background()
+
+	for _, action := range []Action{ActionCreateDevice, ActionExportDevice, ActionDeleteDevice} {
+		if _, err := log.Record(ctx, "operator", action, "device-1", map[string]string{"label": "Test Device"}); err != nil {
+			t.Fatalf("Failed to record audit entry: %v", err)
+		}
+	}
+
+	export, err := log.Export(ctx)
+	if err != nil {
+		t.Fatalf("Failed to export audit log: %v", err)
+	}
+	if len(export.Entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(export.Entries))
+	}
+	if export.Entries[0].PreviousHash != GenesisHash {
+		t.Errorf("Expected first entry to link to the genesis hash")
+	}
+	if export.Entries[1].PreviousHash != export.Entries[0].Hash {
+		t.Errorf("Expected second entry to link to the first")
+	}
+	if len(export.Checkpoints) != 1 || export.Checkpoints[0].Sequence != 1 {
+		t.Fatalf("Expected one checkpoint after the second entry, got %+v", export.Checkpoints)
+	}
+
+	result, err := Verify(roundTrip(t, export), key.Public)
+	if err != nil {
+		t.Fatalf("Failed to verify audit log: %v", err)
+	}
+	if result.Entries != 3 || result.SignedThrough != 1 {
+		t.Errorf("Unexpected verification result: %+v", result)
+	}
+
+	if err := log.Checkpoint(ctx); err != nil {
+		t.Fatalf("Failed to checkpoint audit log: %v", err)
+	}
+	if err := log.Checkpoint(ctx); err != nil {
+		t.Fatalf("Failed to checkpoint audit log: %v", err)
+	}
+	export, _ = log.Export(ctx)
+	if len(export.Checkpoints) != 2 {
+		t.Errorf("Expected an already signed head not to be signed again, got %d checkpoints", len(export.Checkpoints))
+	}
+	result, err = Verify(roundTrip(t, export), key.Public)
+	if err != nil {
+		t.Fatalf("Failed to verify audit log: %v", err)
+	}
+	if result.SignedThrough != 2 {
+		t.Errorf("Expected all entries to be signed, got signed through %d", result.SignedThrough)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	log, key := newTestLog(t, 1)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := log.Record(ctx, "operator", ActionCreateDevice, "device-1", nil); err != nil {
+			t.Fatalf("Failed to record audit entry: %v", err)
+		}
+	}
+	export, err := log.Export(ctx)
+	if err != nil {
+		t.Fatalf("Failed to export audit log: %v", err)
+	}
+
+	altered := roundTrip(t, export)
+	altered.Entries[1].Actor = "someone else"
+	if _, err := Verify(altered, key.Public); err == nil {
+		t.Errorf("Expected error for altered entry")
+	}
+
+	removed := roundTrip(t, export)
+	removed.Entries = append(removed.Entries[:1], removed.Entries[2:]...)
+	if _, err := Verify(removed, key.Public); err == nil {
+		t.Errorf("Expected error for removed entry")
+	}
+
+	// Rewriting the whole chain keeps it internally consistent, but the
+	// checkpoints signed by the service key no longer match.
+	rewritten := roundTrip(t, export)
+	previousHash := GenesisHash
+	for i := range rewritten.Entries {
+		rewritten.Entries[i].Actor = "someone else"
+		rewritten.Entries[i].PreviousHash = previousHash
+		rewritten.Entries[i].Hash, _ = rewritten.Entries[i].computeHash()
+		previousHash = rewritten.Entries[i].Hash
+	}
+	if _, err := Verify(rewritten, key.Public); err == nil {
+		t.Errorf("Expected error for rewritten chain")
+	}
+
+	otherKey, err := (&crypto.ECCGenerator{}).Generate()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	// A rewritten chain with checkpoints re-signed by another key is
+	// consistent with the public key in the export, but not with the trusted
+	// key of the service.
+	resigned := rewritten
+	otherPublicKey, _, err := crypto.NewECCMarshaler().Marshal(*otherKey)
+	if err != nil {
+		t.Fatalf("Failed to encode key: %v", err)
+	}
+	resigned.PublicKey = string(otherPublicKey)
+	otherSigner := &crypto.ECCSigner{PrivateKey: otherKey.Private}
+	for i := range resigned.Checkpoints {
+		resigned.Checkpoints[i].Hash = resigned.Entries[resigned.Checkpoints[i].Sequence].Hash
+		signature, err := otherSigner.Sign(resigned.Checkpoints[i].signedData())
+		if err != nil {
+			t.Fatalf("Failed to sign checkpoint: %v", err)
+		}
+		resigned.Checkpoints[i].Signature = base64.StdEncoding.EncodeToString(signature)
+	}
+	if _, err := Verify(resigned, otherKey.Public); err != nil {
+		t.Fatalf("Expected the re-signed export to verify against the other key: %v", err)
+	}
+	if _, err := Verify(resigned, key.Public); err == nil {
+		t.Errorf("Expected error for checkpoints not signed by the trusted key")
+	}
+	if _, err := Verify(roundTrip(t, export), nil); err == nil {
+		t.Errorf("Expected error without a trusted key")
+	}
+}
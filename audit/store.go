@@ -0,0 +1,220 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// Store persists audit entries and checkpoints. It deliberately offers no way
+// to modify or delete what has been appended.
+type Store interface {
+	AppendEntry(ctx context.Context, entry Entry) error
+	AppendCheckpoint(ctx context.Context, checkpoint Checkpoint) error
+	Head(ctx context.Context) (*Entry, error)
+	Entries(ctx context.Context) ([]Entry, error)
+	Checkpoints(ctx context.Context) ([]Checkpoint, error)
+}
+
+type MemoryStore struct {
+	entries     []Entry
+	checkpoints []Checkpoint
+	mu          sync.RWMutex
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (s *MemoryStore) AppendEntry(ctx context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry.Sequence != uint64(len(s.entries)) {
+		return fmt.Errorf("out of order audit entry: expected sequence %d, got %d", len(s.entries), entry.Sequence)
+	}
+
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *MemoryStore) AppendCheckpoint(ctx context.Context, checkpoint Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoints = append(s.checkpoints, checkpoint)
+	return nil
+}
+
+func (s *MemoryStore) Head(ctx context.Context) (*Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.entries) == 0 {
+		return nil, nil
+	}
+	head := s.entries[len(s.entries)-1]
+	return &head, nil
+}
+
+func (s *MemoryStore) Entries(ctx context.Context) ([]Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make([]Entry, len(s.entries))
+	copy(entries, s.entries)
+	return entries, nil
+}
+
+func (s *MemoryStore) Checkpoints(ctx context.Context) ([]Checkpoint, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	checkpoints := make([]Checkpoint, len(s.checkpoints))
+	copy(checkpoints, s.checkpoints)
+	return checkpoints, nil
+}
+
+// FileStore keeps the audit log in an append-only file with one JSON record
+// per line, so that it survives restarts. The file is read when the store is
+// opened, and reads are served from memory.
+type FileStore struct {
+	memory *MemoryStore
+	file   *os.File
+	mu     sync.Mutex
+}
+
+// fileRecord is one line of a FileStore: either an entry or a checkpoint.
+type fileRecord struct {
+	Entry      *Entry      `json:"entry,omitempty"`
+	Checkpoint *Checkpoint `json:"checkpoint,omitempty"`
+}
+
+// NewFileStore opens the audit log at path, creating it if it does not exist.
+// A final record that was cut off by a crash was never acknowledged, so it is
+// removed; any other record that cannot be read is an error.
+func NewFileStore(path string) (*FileStore, error) {
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	memory := NewMemoryStore()
+	complete := bytes.LastIndexByte(content, '\n') + 1
+	for i, line := range bytes.Split(content[:complete], []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record fileRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return nil, fmt.Errorf("failed to parse audit log: line %d: %w", i+1, err)
+		}
+		switch {
+		case record.Entry != nil:
+			err = memory.AppendEntry(context.Background(), *record.Entry)
+		case record.Checkpoint != nil:
+			err = memory.AppendCheckpoint(context.Background(), *record.Checkpoint)
+		default:
+			err = errors.New("empty record")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse audit log: line %d: %w", i+1, err)
+		}
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	if complete < len(content) {
+		if err := file.Truncate(int64(complete)); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to remove incomplete audit record: %w", err)
+		}
+	}
+	if _, err := file.Seek(int64(complete), io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &FileStore{memory: memory, file: file}, nil
+}
+
+func (s *FileStore) AppendEntry(ctx context.Context, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	head, _ := s.memory.Head(ctx)
+	if expected := headSequence(head); entry.Sequence != expected {
+		return fmt.Errorf("out of order audit entry: expected sequence %d, got %d", expected, entry.Sequence)
+	}
+	if err := s.write(fileRecord{Entry: &entry}); err != nil {
+		return err
+	}
+	return s.memory.AppendEntry(ctx, entry)
+}
+
+func (s *FileStore) AppendCheckpoint(ctx context.Context, checkpoint Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.write(fileRecord{Checkpoint: &checkpoint}); err != nil {
+		return err
+	}
+	return s.memory.AppendCheckpoint(ctx, checkpoint)
+}
+
+func (s *FileStore) Head(ctx context.Context) (*Entry, error) {
+	return s.memory.Head(ctx)
+}
+
+func (s *FileStore) Entries(ctx context.Context) ([]Entry, error) {
+	return s.memory.Entries(ctx)
+}
+
+func (s *FileStore) Checkpoints(ctx context.Context) ([]Checkpoint, error) {
+	return s.memory.Checkpoints(ctx)
+}
+
+// Close closes the file.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// write appends a record and waits until it is on disk.
+func (s *FileStore) write(record fileRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit record: %w", err)
+	}
+	offset, err := s.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	if _, err = s.file.Write(append(line, '\n')); err == nil {
+		err = s.file.Sync()
+	}
+	if err != nil {
+		// Later records must not follow a partial one.
+		s.file.Truncate(offset)
+		s.file.Seek(offset, io.SeekStart)
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+	return nil
+}
+
+// headSequence returns the sequence of the entry that follows head.
+func headSequence(head *Entry) uint64 {
+	if head == nil {
+		return 0
+	}
+	return head.Sequence + 1
+}
@@ -0,0 +1,64 @@
+package audit
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	key, err := LoadServiceKey("")
+	if err != nil {
+		t.Fatalf("Failed to generate service key: %v", err)
+	}
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore failed: %v", err)
+	}
+	log, err := NewLog(store, key, 2)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	for _, action := range []Action{ActionCreateDevice, ActionExportDevice, ActionDeleteDevice} {
+		if _, err := log.Record(ctx, "operator", action, "device-1", nil); err != nil {
+			t.Fatalf("Failed to record audit entry: %v", err)
+		}
+	}
+	store.Close()
+
+	// A record cut off by a crash is dropped, and the log continues after it.
+	file, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o600)
+	file.WriteString(`{"entry": {"sequence": 3, "act`)
+	file.Close()
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen audit log: %v", err)
+	}
+	defer reopened.Close()
+	log, err = NewLog(reopened, key, 2)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	if entry, err := log.Record(ctx, "operator", ActionImportDevice, "device-1", nil); err != nil || entry.Sequence != 3 {
+		t.Fatalf("Expected the log to continue at sequence 3, got %+v, %v", entry, err)
+	}
+	export, err := log.Export(ctx)
+	if err != nil {
+		t.Fatalf("Failed to export audit log: %v", err)
+	}
+	if len(export.Entries) != 4 || len(export.Checkpoints) != 2 {
+		t.Fatalf("Expected 4 entries and 2 checkpoints, got %d and %d", len(export.Entries), len(export.Checkpoints))
+	}
+	if _, err := Verify(roundTrip(t, export), key.Public); err != nil {
+		t.Errorf("Expected the reopened log to verify, got %v", err)
+	}
+
+	os.WriteFile(path, []byte("not json\n{}\n"), 0o600)
+	if _, err := NewFileStore(path); err == nil {
+		t.Errorf("Expected an error for a corrupt record")
+	}
+}
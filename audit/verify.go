@@ -0,0 +1,68 @@
+package audit
+
+import (
+	"crypto/ecdsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+// VerifyResult summarises a successful verification.
+type VerifyResult struct {
+	Entries int
+	// SignedThrough is the sequence of the last checkpointed entry, or -1 if no entry is covered by a checkpoint.
+	SignedThrough int64
+}
+
+// Verify checks that the entries form an unbroken hash chain and that every
+// checkpoint is signed by publicKey and matches the chain. publicKey must come
+// from a trusted source, not from the export: whoever can rewrite the export
+// can also replace the public key in it. Verify runs offline.
+func Verify(export *Export, publicKey *ecdsa.PublicKey) (*VerifyResult, error) {
+	if publicKey == nil {
+		return nil, errors.New("a trusted audit public key is required")
+	}
+	verifier := &crypto.ECCVerifier{PublicKey: publicKey}
+
+	previousHash := GenesisHash
+	for i, entry := range export.Entries {
+		if entry.Sequence != uint64(i) {
+			return nil, fmt.Errorf("entry %d: unexpected sequence %d", i, entry.Sequence)
+		}
+		if entry.PreviousHash != previousHash {
+			return nil, fmt.Errorf("entry %d: previous hash does not match the preceding entry", i)
+		}
+		hash, err := entry.computeHash()
+		if err != nil {
+			return nil, fmt.Errorf("entry %d: %w", i, err)
+		}
+		if entry.Hash != hash {
+			return nil, fmt.Errorf("entry %d: content does not match its hash", i)
+		}
+		previousHash = entry.Hash
+	}
+
+	result := &VerifyResult{Entries: len(export.Entries), SignedThrough: -1}
+	for i, checkpoint := range export.Checkpoints {
+		if checkpoint.Sequence >= uint64(len(export.Entries)) {
+			return nil, fmt.Errorf("checkpoint %d: refers to missing entry %d", i, checkpoint.Sequence)
+		}
+		if export.Entries[checkpoint.Sequence].Hash != checkpoint.Hash {
+			return nil, fmt.Errorf("checkpoint %d: hash does not match entry %d", i, checkpoint.Sequence)
+		}
+		signature, err := base64.StdEncoding.DecodeString(checkpoint.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("checkpoint %d: invalid signature encoding: %w", i, err)
+		}
+		if err := verifier.Verify(checkpoint.signedData(), signature); err != nil {
+			return nil, fmt.Errorf("checkpoint %d: %w", i, err)
+		}
+		if int64(checkpoint.Sequence) > result.SignedThrough {
+			result.SignedThrough = int64(checkpoint.Sequence)
+		}
+	}
+
+	return result, nil
+}
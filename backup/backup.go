@@ -0,0 +1,216 @@
+// Package backup takes snapshots of all signature devices and their
+// transaction journals, writes them to a Store, and restores the devices to
+// the state of the latest snapshot taken at or before a point in time.
+//
+// Every device in a snapshot is sealed as a device bundle with the backup key,
+// so private keys are never stored in the clear. The transactions are stored
+// as they are and must form the signature chain of their device, which is
+// verified before and after a restore.
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/bundle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+// Version is the format version of the snapshots written by Manager.
+const Version = 1
+
+// snapshotPrefix is the prefix of all snapshot names in a Store.
+const snapshotPrefix = "snapshots/"
+
+// nameLayout formats the time of a snapshot in its name. It has a fixed
+// width, so the lexical order of names is their chronological order.
+const nameLayout = "20060102T150405.000000000Z"
+
+var (
+	// ErrNoBackup is returned by Restore when no snapshot was taken at or before the requested time.
+	ErrNoBackup = errors.New("no backup found")
+	// ErrInvalidSnapshot is returned by Restore for snapshots that cannot be read.
+	ErrInvalidSnapshot = errors.New("invalid snapshot")
+)
+
+// Info describes a stored snapshot.
+type Info struct {
+	Name    string
+	TakenAt time.Time
+	// Devices is the number of devices in the snapshot. It is only known for
+	// snapshots that were created or restored, not for listed ones.
+	Devices int
+}
+
+// snapshot is the serialized form of a backup.
+type snapshot struct {
+	Version int       `json:"version"`
+	TakenAt time.Time `json:"taken_at"`
+	Devices []device  `json:"devices"`
+}
+
+type device struct {
+	// Bundle is the device sealed with the backup key.
+	Bundle       []byte                `json:"bundle"`
+	Transactions []*domain.Transaction `json:"transactions"`
+}
+
+// Manager creates snapshots of the devices of a service.DeviceService and restores them.
+type Manager struct {
+	devices *service.DeviceService
+	store   Store
+	key     *bundle.Key
+	now     func() time.Time
+}
+
+func NewManager(devices *service.DeviceService, store Store, key *bundle.Key) *Manager {
+	return &Manager{devices: devices, store: store, key: key, now: time.Now}
+}
+
+// Create takes a snapshot of all devices and writes it to the store. Each
+// device is captured together with its transactions, so its signature counter
+// always matches its journal.
+func (m *Manager) Create(ctx context.Context) (Info, error) {
+	takenAt := m.now().UTC()
+	snapshots, err := m.devices.Snapshot(ctx)
+	if err != nil {
+		return Info{}, err
+	}
+
+	content := snapshot{Version: Version, TakenAt: takenAt, Devices: make([]device, 0, len(snapshots))}
+	for _, snapshot := range snapshots {
+		sealed, err := bundle.Seal(m.key, snapshot.Device, takenAt)
+		if err != nil {
+			return Info{}, fmt.Errorf("device %s: %w", snapshot.Device.ID, err)
+		}
+		content.Devices = append(content.Devices, device{Bundle: sealed, Transactions: snapshot.Transactions})
+	}
+	data, err := json.Marshal(content)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to encode snapshot: %w", err)
+	}
+
+	info := Info{Name: snapshotName(takenAt), TakenAt: takenAt, Devices: len(content.Devices)}
+	if err := m.store.Put(ctx, info.Name, data); err != nil {
+		return Info{}, fmt.Errorf("failed to store snapshot: %w", err)
+	}
+	return info, nil
+}
+
+// List returns the stored snapshots, oldest first.
+func (m *Manager) List(ctx context.Context) ([]Info, error) {
+	names, err := m.store.List(ctx, snapshotPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
+	}
+
+	infos := make([]Info, 0, len(names))
+	for _, name := range names {
+		takenAt, ok := parseSnapshotName(name)
+		if !ok {
+			continue
+		}
+		infos = append(infos, Info{Name: name, TakenAt: takenAt})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].TakenAt.Before(infos[j].TakenAt) })
+	return infos, nil
+}
+
+// Restore replaces all devices with the latest snapshot taken at or before
+// at, or with the latest snapshot if at is zero. Devices created after the
+// snapshot are deleted.
+func (m *Manager) Restore(ctx context.Context, at time.Time, actor string) (Info, error) {
+	infos, err := m.List(ctx)
+	if err != nil {
+		return Info{}, err
+	}
+	var info *Info
+	for i := range infos {
+		if !at.IsZero() && infos[i].TakenAt.After(at) {
+			break
+		}
+		info = &infos[i]
+	}
+	if info == nil {
+		return Info{}, ErrNoBackup
+	}
+
+	data, err := m.store.Get(ctx, info.Name)
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	snapshots, err := m.open(data)
+	if err != nil {
+		return Info{}, fmt.Errorf("%s: %w", info.Name, err)
+	}
+	if err := m.devices.Restore(ctx, snapshots, actor); err != nil {
+		return Info{}, err
+	}
+
+	restored := *info
+	restored.Devices = len(snapshots)
+	return restored, nil
+}
+
+// open decodes a snapshot and unseals its devices.
+func (m *Manager) open(data []byte) ([]service.DeviceSnapshot, error) {
+	var content snapshot
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+	if content.Version != Version {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidSnapshot, content.Version)
+	}
+
+	snapshots := make([]service.DeviceSnapshot, 0, len(content.Devices))
+	for _, entry := range content.Devices {
+		device, _, err := bundle.Open(m.key, entry.Bundle)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+		}
+		snapshots = append(snapshots, service.DeviceSnapshot{Device: device, Transactions: entry.Transactions})
+	}
+	return snapshots, nil
+}
+
+// Periodic creates a snapshot every interval until ctx is cancelled.
+func (m *Manager) Periodic(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := m.Create(ctx); err != nil {
+					onError(err)
+				}
+			}
+		}
+	}()
+}
+
+func snapshotName(takenAt time.Time) string {
+	return snapshotPrefix + takenAt.UTC().Format(nameLayout) + ".json"
+}
+
+func parseSnapshotName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, snapshotPrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, ".json")
+	if !ok {
+		return time.Time{}, false
+	}
+	takenAt, err := time.Parse(nameLayout, stamp)
+	return takenAt, err == nil
+}
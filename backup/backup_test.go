@@ -0,0 +1,170 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/bundle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestPointInTimeRestore(t *testing.T) {
+	ctx := context.Background()
+	key, err := bundle.NewKey(bytes.Repeat([]byte("b"), bundle.MinSecretSize))
+	if err != nil {
+		t.Fatalf("NewKey failed: %v", err)
+	}
+	dir := t.TempDir()
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	manager := NewManager(devices, NewDirStore(dir), key)
+	clock := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	manager.now = func() time.Time { return clock }
+
+	if _, err := manager.Restore(ctx, time.Time{}, "operator"); !errors.Is(err, ErrNoBackup) {
+		t.Errorf("Expected ErrNoBackup without snapshots, got %v", err)
+	}
+
+	device, err := devices.CreateDevice(ctx, service.CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	var infos []Info
+	for i := 0; i < 3; i++ {
+		if _, err := devices.SignTransaction(ctx, device.ID, "receipt"); err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+		info, err := manager.Create(ctx)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		infos = append(infos, info)
+		clock = clock.Add(time.Hour)
+	}
+	if infos[0].Devices != 1 || infos[0].Name != "snapshots/20240101T120000.000000000Z.json" {
+		t.Errorf("Unexpected snapshot %+v", infos[0])
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "snapshots", "20240101T120000.000000000Z.json")); err != nil {
+		t.Errorf("Expected the snapshot in the directory: %v", err)
+	} else if bytes.Contains(data, []byte(base64.StdEncoding.EncodeToString(device.PrivateKey))) {
+		t.Errorf("Expected the private key to be sealed")
+	}
+
+	listed, err := manager.List(ctx)
+	if err != nil || len(listed) != 3 || !listed[2].TakenAt.Equal(infos[2].TakenAt) {
+		t.Fatalf("Unexpected snapshots %+v, %v", listed, err)
+	}
+
+	// Halfway between the second and the third snapshot.
+	restored, err := manager.Restore(ctx, infos[1].TakenAt.Add(30*time.Minute), "operator")
+	if err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if restored.Name != infos[1].Name || restored.Devices != 1 {
+		t.Errorf("Expected the second snapshot, got %+v", restored)
+	}
+	if current, _ := devices.GetDevice(ctx, device.ID); current.SignatureCounter != 2 {
+		t.Errorf("Expected counter 2 after the restore, got %d", current.SignatureCounter)
+	}
+	// The counter is behind the high-water mark of the device until an operator overrides it.
+	if _, err := devices.SignTransaction(ctx, device.ID, "after restore"); !errors.Is(err, service.ErrCounterRollback) {
+		t.Errorf("Expected ErrCounterRollback after the restore, got %v", err)
+	}
+	if _, err := devices.OverrideHighWaterMark(ctx, device.ID, "operator", "third receipt was voided"); err != nil {
+		t.Fatalf("OverrideHighWaterMark failed: %v", err)
+	}
+	if _, err := devices.SignTransaction(ctx, device.ID, "after override"); err != nil {
+		t.Errorf("Signing after the override failed: %v", err)
+	}
+
+	if _, err := manager.Restore(ctx, infos[0].TakenAt.Add(-time.Second), "operator"); !errors.Is(err, ErrNoBackup) {
+		t.Errorf("Expected ErrNoBackup before the first snapshot, got %v", err)
+	}
+	if restored, err := manager.Restore(ctx, time.Time{}, "operator"); err != nil || restored.Name != infos[2].Name {
+		t.Errorf("Expected the latest snapshot, got %+v, %v", restored, err)
+	}
+
+	otherKey, _ := bundle.NewKey(bytes.Repeat([]byte("x"), bundle.MinSecretSize))
+	other := NewManager(devices, NewDirStore(dir), otherKey)
+	if _, err := other.Restore(ctx, time.Time{}, "operator"); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("Expected ErrInvalidSnapshot for another backup key, got %v", err)
+	}
+}
+
+func TestRestoreImportedDevice(t *testing.T) {
+	ctx := context.Background()
+	key, err := bundle.NewKey(bytes.Repeat([]byte("b"), bundle.MinSecretSize))
+	if err != nil {
+		t.Fatalf("NewKey failed: %v", err)
+	}
+	source := service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithMigrationKey(key))
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithMigrationKey(key))
+	manager := NewManager(devices, NewDirStore(t.TempDir()), key)
+
+	device, err := source.CreateDevice(ctx, service.CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := source.SignTransaction(ctx, device.ID, "receipt"); err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+	}
+	_, sealed, err := source.ExportDevice(ctx, device.ID, "operator")
+	if err != nil {
+		t.Fatalf("ExportDevice failed: %v", err)
+	}
+	if _, err := devices.ImportDevice(ctx, sealed, "operator"); err != nil {
+		t.Fatalf("ImportDevice failed: %v", err)
+	}
+	if _, err := devices.SignTransaction(ctx, device.ID, "receipt"); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+
+	// The journal of the imported device starts at the bundle's counter.
+	if _, err := manager.Create(ctx); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if _, err := manager.Restore(ctx, time.Time{}, "operator"); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if current, err := devices.GetDevice(ctx, device.ID); err != nil || current.SignatureCounter != 3 {
+		t.Errorf("Expected counter 3 after the restore, got %+v, %v", current, err)
+	}
+	if _, err := devices.SignTransaction(ctx, device.ID, "after restore"); err != nil {
+		t.Errorf("Signing after the restore failed: %v", err)
+	}
+}
+
+func TestDirStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewDirStore(filepath.Join(t.TempDir(), "backups"))
+
+	if names, err := store.List(ctx, ""); err != nil || len(names) != 0 {
+		t.Errorf("Expected an empty listing for a missing directory, got %v, %v", names, err)
+	}
+	for _, name := range []string{"snapshots/b.json", "snapshots/a.json", "other.json"} {
+		if err := store.Put(ctx, name, []byte(name)); err != nil {
+			t.Fatalf("Put %s failed: %v", name, err)
+		}
+	}
+	names, err := store.List(ctx, "snapshots/")
+	if err != nil || len(names) != 2 || names[0] != "snapshots/a.json" {
+		t.Errorf("Unexpected listing %v, %v", names, err)
+	}
+	if data, err := store.Get(ctx, "snapshots/b.json"); err != nil || string(data) != "snapshots/b.json" {
+		t.Errorf("Unexpected object %q, %v", data, err)
+	}
+	if _, err := store.Get(ctx, "missing.json"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Expected os.ErrNotExist, got %v", err)
+	}
+	if err := store.Put(ctx, "../escape.json", nil); err == nil {
+		t.Errorf("Expected names outside the directory to be refused")
+	}
+}
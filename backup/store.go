@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Store holds snapshots as named objects. Names are slash-separated paths.
+type Store interface {
+	Put(ctx context.Context, name string, data []byte) error
+	// Get returns an error wrapping fs.ErrNotExist if there is no object with the name.
+	Get(ctx context.Context, name string) ([]byte, error)
+	// List returns the names of all objects that start with prefix in lexical order.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// NewStore returns an S3Store for targets of the form s3://bucket/prefix,
+// configured with s3, and a DirStore for all other targets.
+func NewStore(target string, s3 S3Config) (Store, error) {
+	location, ok := strings.CutPrefix(target, "s3://")
+	if !ok {
+		return NewDirStore(target), nil
+	}
+	bucket, prefix, _ := strings.Cut(location, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("missing bucket in backup target %q", target)
+	}
+	s3.Bucket = bucket
+	s3.Prefix = prefix
+	return NewS3Store(s3), nil
+}
+
+// DirStore keeps objects as files below a local directory.
+type DirStore struct {
+	dir string
+}
+
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{dir: dir}
+}
+
+// Put writes data to a temporary file first and renames it, so that an
+// interrupted write never leaves a partial object.
+func (s *DirStore) Put(ctx context.Context, name string, data []byte) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (s *DirStore) Get(ctx context.Context, name string) ([]byte, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(path)
+}
+
+func (s *DirStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if path == s.dir && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".tmp-") {
+			return nil
+		}
+		relative, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(relative); strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// path returns the file of the object name, refusing names outside the directory.
+func (s *DirStore) path(name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(name)), nil
+}
@@ -0,0 +1,265 @@
+// Package bundle exports signature devices as encrypted, signed bundles, so
+// that they can be moved between service instances without breaking their
+// signature counters.
+//
+// A bundle holds the device metadata, its signature counter, last signature
+// and signing time, and its private key wrapped with AES-256-GCM. These
+// contents are encrypted as a whole with AES-256-GCM. Both AES keys are
+// derived from a migration secret that the exporting and the importing
+// instance share. The GCM tag only shows that a bundle was created by some
+// holder of that secret, so keys that move devices between instances also
+// sign the sealed bundle with the ECC signing key of the exporting instance.
+// Opening verifies that signature against the importing instance's own key
+// and the keys of the instances it trusts.
+package bundle
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// Version is the format version of the bundles created by Seal.
+const Version = 1
+
+// MinSecretSize is the minimum length of a migration secret in bytes.
+const MinSecretSize = 32
+
+// ErrInvalidBundle is returned by Open for bundles that cannot be decrypted,
+// are malformed, lack the signature of a trusted instance or hold a private
+// key that does not match the public key.
+var ErrInvalidBundle = errors.New("invalid device bundle")
+
+// Key holds the AES keys derived from a migration secret and, once
+// WithSigningKey is applied, the key pairs that sign and verify bundles.
+type Key struct {
+	encryption []byte
+	wrapping   []byte
+	signer     *crypto.ECCKeyPair
+	trusted    []*ecdsa.PublicKey
+}
+
+// NewKey derives the bundle keys from secret, which must have at least
+// MinSecretSize bytes.
+func NewKey(secret []byte) (*Key, error) {
+	if len(secret) < MinSecretSize {
+		return nil, fmt.Errorf("migration secret must have at least %d bytes, got %d", MinSecretSize, len(secret))
+	}
+	return &Key{
+		encryption: deriveKey(secret, "device bundle encryption"),
+		wrapping:   deriveKey(secret, "device key wrapping"),
+	}, nil
+}
+
+// LoadKey reads a migration secret from path, ignoring surrounding
+// whitespace. A base64 secret such as the output of "openssl rand -base64 32"
+// is used as is.
+func LoadKey(path string) (*Key, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration key: %w", err)
+	}
+	return NewKey(bytes.TrimSpace(content))
+}
+
+// WithSigningKey returns a copy of k that signs the bundles it seals with
+// signer and only opens bundles signed with signer or one of trusted.
+func (k *Key) WithSigningKey(signer *crypto.ECCKeyPair, trusted ...*ecdsa.PublicKey) *Key {
+	signing := *k
+	signing.signer = signer
+	signing.trusted = append([]*ecdsa.PublicKey{signer.Public}, trusted...)
+	return &signing
+}
+
+// LoadSigningKey reads the PEM-encoded ECC private key that signs the bundles
+// of this instance from path.
+func LoadSigningKey(path string) (*crypto.ECCKeyPair, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bundle signing key: %w", err)
+	}
+	keyPair, err := crypto.NewECCMarshaler().Unmarshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse bundle signing key: %w", err)
+	}
+	return keyPair, nil
+}
+
+// LoadTrustedKey reads the PEM-encoded ECC public key of another instance
+// whose bundles are accepted from path.
+func LoadTrustedKey(path string) (*ecdsa.PublicKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read trusted bundle key: %w", err)
+	}
+	publicKey, err := crypto.NewECCMarshaler().UnmarshalPublic(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse trusted bundle key %s: %w", path, err)
+	}
+	return publicKey, nil
+}
+
+func deriveKey(secret []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// envelope is the serialized bundle. Only the version is readable without
+// the key.
+type envelope struct {
+	Version    int    `json:"version"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+	// Signature is the signature of the exporting instance over the other
+	// fields, present if its key signs bundles.
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// signedData returns the bytes covered by the signature of the envelope.
+func (e *envelope) signedData() []byte {
+	data := append(envelopeData(), e.Nonce...)
+	return append(data, e.Ciphertext...)
+}
+
+// contents is the plaintext of an envelope.
+type contents struct {
+	Device     *domain.SignatureDevice `json:"device"`
+	WrappedKey []byte                  `json:"wrapped_key"`
+	ExportedAt time.Time               `json:"exported_at"`
+}
+
+// Seal returns the bundle of device, exported at the given time.
+func Seal(key *Key, device *domain.SignatureDevice, exportedAt time.Time) ([]byte, error) {
+	wrappedKey, err := seal(key.wrapping, device.PrivateKey, wrappingData(device))
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap device key: %w", err)
+	}
+	plaintext, err := json.Marshal(contents{Device: device, WrappedKey: wrappedKey, ExportedAt: exportedAt.UTC()})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode bundle contents: %w", err)
+	}
+
+	sealed, err := seal(key.encryption, plaintext, envelopeData())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt bundle: %w", err)
+	}
+	bundle := envelope{Version: Version, Nonce: sealed[:nonceSize], Ciphertext: sealed[nonceSize:]}
+	if key.signer != nil {
+		signer := &crypto.ECCSigner{PrivateKey: key.signer.Private}
+		if bundle.Signature, err = signer.Sign(bundle.signedData()); err != nil {
+			return nil, fmt.Errorf("failed to sign bundle: %w", err)
+		}
+	}
+	return json.Marshal(bundle)
+}
+
+// Open verifies the signature of bundle if key signs bundles, decrypts and
+// authenticates it, checks its key pair, and returns the device with its
+// private key and the time it was exported.
+func Open(key *Key, bundle []byte) (*domain.SignatureDevice, time.Time, error) {
+	var sealed envelope
+	if err := json.Unmarshal(bundle, &sealed); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if sealed.Version != Version {
+		return nil, time.Time{}, fmt.Errorf("%w: unsupported version %d", ErrInvalidBundle, sealed.Version)
+	}
+	if len(key.trusted) > 0 && !key.signedByTrusted(&sealed) {
+		return nil, time.Time{}, fmt.Errorf("%w: not signed by a trusted instance", ErrInvalidBundle)
+	}
+	plaintext, err := open(key.encryption, append(sealed.Nonce, sealed.Ciphertext...), envelopeData())
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: cannot be decrypted with this migration key", ErrInvalidBundle)
+	}
+
+	var decoded contents
+	if err := json.Unmarshal(plaintext, &decoded); err != nil || decoded.Device == nil {
+		return nil, time.Time{}, fmt.Errorf("%w: malformed contents", ErrInvalidBundle)
+	}
+	device := decoded.Device
+	if err := domain.ValidateID(device.ID); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+
+	device.PrivateKey, err = open(key.wrapping, decoded.WrappedKey, wrappingData(device))
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: cannot unwrap the device key", ErrInvalidBundle)
+	}
+	if err := device.CheckKeyPair(); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	return device, decoded.ExportedAt, nil
+}
+
+// signedByTrusted reports whether sealed carries a valid signature of one of
+// the trusted keys.
+func (k *Key) signedByTrusted(sealed *envelope) bool {
+	for _, publicKey := range k.trusted {
+		verifier := &crypto.ECCVerifier{PublicKey: publicKey}
+		if verifier.Verify(sealed.signedData(), sealed.Signature) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// nonceSize is the size of the AES-GCM nonces that prefix sealed data.
+const nonceSize = 12
+
+// seal encrypts plaintext with AES-256-GCM and returns the nonce followed by
+// the ciphertext.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts data created by seal.
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < nonceSize {
+		return nil, errors.New("sealed data too short")
+	}
+	return aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// envelopeData binds the encrypted bundle to the format version.
+func envelopeData() []byte {
+	return []byte("device-bundle/v" + strconv.Itoa(Version))
+}
+
+// wrappingData binds a wrapped private key to its device and key version.
+func wrappingData(device *domain.SignatureDevice) []byte {
+	return []byte(device.ID + ":v" + strconv.Itoa(device.KeyVersion))
+}
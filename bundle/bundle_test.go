@@ -0,0 +1,196 @@
+package bundle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewKey(bytes.Repeat([]byte("k"), MinSecretSize))
+	if err != nil {
+		t.Fatalf("NewKey failed: %v", err)
+	}
+	exportedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, algorithm := range []domain.SignatureAlgorithm{domain.RSA, domain.ECC} {
+		device, err := domain.NewSignatureDevice(uuid.New().String(), algorithm, "Till 1")
+		if err != nil {
+			t.Fatalf("Failed to create %s device: %v", algorithm, err)
+		}
+		if _, _, err := device.SignTransaction(context.Background(), "receipt"); err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+
+		sealed, err := Seal(key, device, exportedAt)
+		if err != nil {
+			t.Fatalf("%s: Seal failed: %v", algorithm, err)
+		}
+		if bytes.Contains(sealed, []byte("Till 1")) || bytes.Contains(sealed, []byte(device.ID)) {
+			t.Errorf("%s: bundle is not encrypted: %s", algorithm, sealed)
+		}
+
+		opened, openedAt, err := Open(key, sealed)
+		if err != nil {
+			t.Fatalf("%s: Open failed: %v", algorithm, err)
+		}
+		if opened.ID != device.ID || opened.Label != device.Label || opened.SignatureCounter != 1 ||
+			opened.LastSignature != device.LastSignature || !opened.LastSignedAt.Equal(device.LastSignedAt) ||
+			!bytes.Equal(opened.PrivateKey, device.PrivateKey) || !openedAt.Equal(exportedAt) {
+			t.Errorf("%s: opened device %+v does not match %+v", algorithm, opened, device)
+		}
+		// The imported device continues the chain.
+		if _, securedData, err := opened.SignTransaction(context.Background(), "next"); err != nil {
+			t.Errorf("%s: signing with the opened device failed: %v", algorithm, err)
+		} else if counter, _, last, _ := domain.ParseSecuredData(securedData); counter != 1 || last != device.LastSignature {
+			t.Errorf("%s: unexpected secured data %q", algorithm, securedData)
+		}
+	}
+}
+
+func TestOpenRejects(t *testing.T) {
+	key, _ := NewKey(bytes.Repeat([]byte("k"), MinSecretSize))
+	otherKey, _ := NewKey(bytes.Repeat([]byte("o"), MinSecretSize))
+	device, err := domain.NewSignatureDevice(uuid.New().String(), domain.ECC, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(key, device, time.Now())
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+
+	var tampered envelope
+	json.Unmarshal(sealed, &tampered)
+	tampered.Ciphertext[0] ^= 1
+	tamperedBundle, _ := json.Marshal(tampered)
+
+	// A private key that does not belong to the public key fails even if
+	// encrypted correctly.
+	impostor, _ := domain.NewSignatureDevice(device.ID, domain.ECC, "")
+	impostor.PublicKey = device.PublicKey
+	mismatched, err := Seal(key, impostor, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := Open(otherKey, sealed); !errors.Is(err, ErrInvalidBundle) {
+		t.Errorf("Expected ErrInvalidBundle for another migration key, got %v", err)
+	}
+	for name, bundle := range map[string][]byte{
+		"tampered":   tamperedBundle,
+		"mismatched": mismatched,
+		"malformed":  []byte("{"),
+		"version":    []byte(`{"version": 2}`),
+	} {
+		if _, _, err := Open(key, bundle); !errors.Is(err, ErrInvalidBundle) {
+			t.Errorf("%s: expected ErrInvalidBundle, got %v", name, err)
+		}
+	}
+}
+
+func TestSignedBundles(t *testing.T) {
+	key, _ := NewKey(bytes.Repeat([]byte("k"), MinSecretSize))
+	generator := &crypto.ECCGenerator{}
+	sourceKey, err := generator.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetKey, err := generator.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := key.WithSigningKey(sourceKey)
+	target := key.WithSigningKey(targetKey, sourceKey.Public)
+
+	device, err := domain.NewSignatureDevice(uuid.New().String(), domain.ECC, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Seal(source, device, time.Now())
+	if err != nil {
+		t.Fatalf("Seal failed: %v", err)
+	}
+	if _, _, err := Open(target, sealed); err != nil {
+		t.Errorf("Expected a bundle of a trusted instance to open, got %v", err)
+	}
+	if _, _, err := Open(source, sealed); err != nil {
+		t.Errorf("Expected an own bundle to open, got %v", err)
+	}
+
+	unsigned, _ := Seal(key, device, time.Now())
+	var forged envelope
+	json.Unmarshal(sealed, &forged)
+	forged.Signature[len(forged.Signature)-1] ^= 1
+	forgedBundle, _ := json.Marshal(forged)
+	fromTarget, _ := Seal(target, device, time.Now())
+	for name, test := range map[string]struct {
+		key    *Key
+		bundle []byte
+	}{
+		"unsigned":  {target, unsigned},
+		"forged":    {target, forgedBundle},
+		"untrusted": {source, fromTarget},
+	} {
+		if _, _, err := Open(test.key, test.bundle); !errors.Is(err, ErrInvalidBundle) {
+			t.Errorf("%s: expected ErrInvalidBundle, got %v", name, err)
+		}
+	}
+}
+
+func TestLoadSigningKeys(t *testing.T) {
+	dir := t.TempDir()
+	keyPair, err := (&crypto.ECCGenerator{}).Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, private, err := crypto.NewECCMarshaler().Marshal(*keyPair)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privatePath := filepath.Join(dir, "signing.pem")
+	publicPath := filepath.Join(dir, "trusted.pem")
+	os.WriteFile(privatePath, private, 0o600)
+	os.WriteFile(publicPath, public, 0o600)
+
+	signer, err := LoadSigningKey(privatePath)
+	if err != nil {
+		t.Fatalf("LoadSigningKey failed: %v", err)
+	}
+	trusted, err := LoadTrustedKey(publicPath)
+	if err != nil {
+		t.Fatalf("LoadTrustedKey failed: %v", err)
+	}
+	if !signer.Public.Equal(trusted) {
+		t.Errorf("Expected the loaded keys to form a pair")
+	}
+	if _, err := LoadTrustedKey(privatePath); err == nil {
+		t.Errorf("Expected a private key to be rejected as trusted key")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "migration.key")
+	if err := os.WriteFile(path, []byte("c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0LXNlY3JldA==\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKey(path); err != nil {
+		t.Errorf("LoadKey failed: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("short\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadKey(path); err == nil {
+		t.Error("Expected a short secret to be rejected")
+	}
+}
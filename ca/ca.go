@@ -0,0 +1,270 @@
+// Package ca implements the internal certificate authority that certifies
+// the keys of signature devices.
+package ca
+
+import (
+	"bytes"
+	stdcrypto "crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// DeviceIDExtension is the OID of the non-critical certificate extension that
+// holds the device ID as a UTF8String. It lies under the private enterprise
+// number 32473, which RFC 5612 reserves for documentation; deployments that
+// need a stable OID in their own arc should replace it.
+var DeviceIDExtension = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 32473, 1, 1}
+
+var (
+	oidExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37}
+	oidTimeStamping     = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 8}
+)
+
+// selfSignedValidity is the validity of CA certificates generated by NewSelfSigned.
+const selfSignedValidity = 10 * 365 * 24 * time.Hour
+
+// Authority issues device certificates signed by a CA key.
+type Authority struct {
+	certificate *x509.Certificate
+	key         stdcrypto.Signer
+	validity    time.Duration
+	now         func() time.Time
+}
+
+// New returns an Authority that signs with key and certificate and issues
+// certificates valid for validity, but never beyond the CA certificate.
+func New(certificate *x509.Certificate, key stdcrypto.Signer, validity time.Duration) (*Authority, error) {
+	if !certificate.IsCA || certificate.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, errors.New("CA certificate is not allowed to sign certificates")
+	}
+	publicKey, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode CA public key: %w", err)
+	}
+	if !bytes.Equal(publicKey, certificate.RawSubjectPublicKeyInfo) {
+		return nil, errors.New("CA key does not match the CA certificate")
+	}
+	if validity <= 0 {
+		return nil, errors.New("certificate validity must be positive")
+	}
+	return &Authority{certificate: certificate, key: key, validity: validity, now: time.Now}, nil
+}
+
+// NewSelfSigned returns an Authority with a new P-384 key and a self-signed CA
+// certificate. Its certificates cannot be pinned across restarts.
+func NewSelfSigned(validity time.Duration) (*Authority, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate CA key: %w", err)
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "Signing Service Device CA"},
+		NotBefore:             now.Add(-time.Minute),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA certificate: %w", err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+	return New(certificate, key, validity)
+}
+
+// Load reads a PEM-encoded CA certificate and private key. If both paths are
+// empty, a self-signed CA is generated for this run.
+func Load(certFile, keyFile string, validity time.Duration) (*Authority, error) {
+	if certFile == "" && keyFile == "" {
+		return NewSelfSigned(validity)
+	}
+
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("failed to decode CA certificate PEM")
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+	}
+
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA key: %w", err)
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CA key: %w", err)
+	}
+	return New(certificate, key, validity)
+}
+
+// parsePrivateKey accepts PKCS#8, SEC 1 and PKCS#1 PEM private keys.
+func parsePrivateKey(content []byte) (stdcrypto.Signer, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("failed to decode PEM")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(stdcrypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// Certificate returns the CA certificate.
+func (a *Authority) Certificate() *x509.Certificate {
+	return a.certificate
+}
+
+// Issue returns a DER-encoded certificate for the current key of device. The
+// subject common name is the label, or the ID for unlabeled devices, and the
+// subject serial number and the DeviceIDExtension hold the device ID.
+func (a *Authority) Issue(device *domain.SignatureDevice) ([]byte, error) {
+	publicKey, err := crypto.ParsePublicKey(device.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse device public key: %w", err)
+	}
+	extension, err := asn1.MarshalWithParams(device.ID, "utf8")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode device ID extension: %w", err)
+	}
+
+	commonName := device.Label
+	if commonName == "" {
+		commonName = device.ID
+	}
+	der, err := a.issue(publicKey, &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   commonName,
+			SerialNumber: device.ID,
+		},
+		ExtraExtensions: []pkix.Extension{
+			{Id: DeviceIDExtension, Value: extension},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create device certificate: %w", err)
+	}
+	return der, nil
+}
+
+// IssueTimestamping returns a DER-encoded certificate for a time-stamping
+// authority key, with the critical timeStamping extended key usage that
+// RFC 3161 requires.
+func (a *Authority) IssueTimestamping(publicKey stdcrypto.PublicKey, commonName string) ([]byte, error) {
+	// crypto/x509 never marks the extended key usage as critical, so the
+	// extension is set explicitly.
+	extendedKeyUsage, err := asn1.Marshal([]asn1.ObjectIdentifier{oidTimeStamping})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode extended key usage: %w", err)
+	}
+	der, err := a.issue(publicKey, &x509.Certificate{
+		Subject: pkix.Name{CommonName: commonName},
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidExtendedKeyUsage, Critical: true, Value: extendedKeyUsage},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create time-stamping certificate: %w", err)
+	}
+	return der, nil
+}
+
+// issue completes template with the settings shared by all certificates of the
+// CA and signs it.
+func (a *Authority) issue(publicKey stdcrypto.PublicKey, template *x509.Certificate) ([]byte, error) {
+	spki, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	serialNumber, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	now := a.now()
+	notAfter := now.Add(a.validity)
+	if notAfter.After(a.certificate.NotAfter) {
+		notAfter = a.certificate.NotAfter
+	}
+	keyID := sha1.Sum(spki)
+	template.SerialNumber = serialNumber
+	template.NotBefore = now.Add(-time.Minute)
+	template.NotAfter = notAfter
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.BasicConstraintsValid = true
+	template.SubjectKeyId = keyID[:]
+	return x509.CreateCertificate(rand.Reader, template, a.certificate, publicKey, a.key)
+}
+
+// Certifies reports whether the DER-encoded certificate was issued for the
+// current key of device, so that certificates of rotated keys are detected.
+func Certifies(certificate []byte, device *domain.SignatureDevice) bool {
+	parsed, err := x509.ParseCertificate(certificate)
+	return err == nil && device.Certifies(parsed)
+}
+
+// DeviceID returns the device ID stored in the DeviceIDExtension of certificate.
+func DeviceID(certificate *x509.Certificate) (string, bool) {
+	for _, extension := range certificate.Extensions {
+		if extension.Id.Equal(DeviceIDExtension) {
+			var id string
+			if _, err := asn1.UnmarshalWithParams(extension.Value, &id, "utf8"); err != nil {
+				return "", false
+			}
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func newSerialNumber() (*big.Int, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	return serialNumber, nil
+}
@@ -0,0 +1,125 @@
+package ca
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+func TestIssue(t *testing.T) {
+	authority, err := NewSelfSigned(24 * time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.Certificate())
+
+	for _, test := range []struct {
+		algorithm  domain.SignatureAlgorithm
+		label      string
+		commonName string
+	}{
+		{domain.RSA, "Till 1", "Till 1"},
+		{domain.ECC, "", ""},
+	} {
+		device, err := domain.NewSignatureDevice(uuid.New().String(), test.algorithm, test.label)
+		if err != nil {
+			t.Fatalf("Failed to create %s device: %v", test.algorithm, err)
+		}
+		der, err := authority.Issue(device)
+		if err != nil {
+			t.Fatalf("%s: failed to issue certificate: %v", test.algorithm, err)
+		}
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatalf("%s: invalid certificate: %v", test.algorithm, err)
+		}
+
+		if _, err := certificate.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}}); err != nil {
+			t.Errorf("%s: certificate does not chain to the CA: %v", test.algorithm, err)
+		}
+		commonName := test.commonName
+		if commonName == "" {
+			commonName = device.ID
+		}
+		if certificate.Subject.CommonName != commonName || certificate.Subject.SerialNumber != device.ID {
+			t.Errorf("%s: unexpected subject %v", test.algorithm, certificate.Subject)
+		}
+		if id, ok := DeviceID(certificate); !ok || id != device.ID {
+			t.Errorf("%s: expected device ID extension %s, got %q", test.algorithm, device.ID, id)
+		}
+		if certificate.IsCA || certificate.KeyUsage != x509.KeyUsageDigitalSignature {
+			t.Errorf("%s: expected an end-entity signing certificate", test.algorithm)
+		}
+		if !Certifies(der, device) {
+			t.Errorf("%s: expected the certificate to certify the device key", test.algorithm)
+		}
+
+		rotated, err := domain.NewSignatureDevice(device.ID, test.algorithm, test.label)
+		if err != nil {
+			t.Fatalf("Failed to create %s device: %v", test.algorithm, err)
+		}
+		if Certifies(der, rotated) {
+			t.Errorf("%s: expected the certificate not to certify a new key", test.algorithm)
+		}
+	}
+}
+
+func TestIssueValidity(t *testing.T) {
+	authority, err := NewSelfSigned(100 * 365 * 24 * time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	device, err := domain.NewSignatureDevice(uuid.New().String(), domain.ECC, "")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	der, err := authority.Issue(device)
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	if certificate.NotAfter.After(authority.Certificate().NotAfter) {
+		t.Errorf("Expected the certificate to expire with the CA, got %v after %v", certificate.NotAfter, authority.Certificate().NotAfter)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	generated, err := NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(generated.key)
+	if err != nil {
+		t.Fatalf("Failed to encode CA key: %v", err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: generated.Certificate().Raw}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)
+
+	authority, err := Load(certFile, keyFile, time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to load CA: %v", err)
+	}
+	if !authority.Certificate().Equal(generated.Certificate()) {
+		t.Errorf("Expected the loaded CA certificate")
+	}
+
+	other, err := NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	if _, err := New(generated.Certificate(), other.key, time.Hour); err == nil {
+		t.Errorf("Expected a key that does not match the certificate to be rejected")
+	}
+	if _, err := Load(certFile, filepath.Join(dir, "missing.pem"), time.Hour); err == nil {
+		t.Errorf("Expected a missing key file to be rejected")
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// Chain is an offline export of a device and the signatures it created, in signing order.
+type Chain struct {
+	Device     api.CreateDeviceResponse      `json:"device"`
+	Signatures []api.SignTransactionResponse `json:"signatures"`
+}
+
+// VerifyChain checks every signature of chain against the device's public key and
+// checks that the signatures are linked: the counters are consecutive and each
+// signed data ends with the previous signature, or with the base64 encoded
+// device ID for the device's first signature. Signing times, where included,
+// must not decrease. It runs without contacting the service.
+func VerifyChain(chain *Chain) error {
+	var base domain.ChainBase
+	transactions := make([]*domain.Transaction, 0, len(chain.Signatures))
+	for i := range chain.Signatures {
+		signature := &chain.Signatures[i]
+		if err := VerifySignature(&chain.Device, signature); err != nil {
+			return fmt.Errorf("signature %d: %w", i, err)
+		}
+		decoded, err := domain.DecodeSecuredData(signature.SignedData)
+		if err != nil {
+			return fmt.Errorf("signature %d: %w", i, err)
+		}
+		switch {
+		case i == 0 && decoded.Counter == 0:
+			base = domain.GenesisBase(chain.Device.ID)
+		case i == 0:
+			// The export starts in the middle of the chain, so there is nothing to link to.
+			base = domain.ChainBase{Counter: decoded.Counter, LastSignature: decoded.LastSignature}
+		}
+		transactions = append(transactions, &domain.Transaction{
+			DeviceID:   chain.Device.ID,
+			Counter:    decoded.Counter,
+			Signature:  signature.Signature,
+			SignedData: signature.SignedData,
+		})
+	}
+
+	device := &domain.SignatureDevice{
+		ID:        chain.Device.ID,
+		Algorithm: domain.SignatureAlgorithm(chain.Device.Algorithm),
+		PublicKey: []byte(chain.Device.PublicKey),
+	}
+	_, err := domain.VerifyChainFrom(device, base, transactions)
+	return err
+}
@@ -0,0 +1,410 @@
+// Package client is a typed Go client for the signature service API.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/google/uuid"
+)
+
+// Client calls the signature service over HTTP.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	maxRetries int
+	backoff    time.Duration
+}
+
+// Option configures optional Client settings.
+type Option func(*Client)
+
+// WithHTTPClient replaces the default http.Client.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithRetries sets how often a failed request is retried and the initial
+// backoff, which doubles after every attempt.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.backoff = backoff
+	}
+}
+
+// New creates a Client for the service at baseURL, e.g. "http://localhost:8080".
+func New(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		maxRetries: 3,
+		backoff:    100 * time.Millisecond,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// CreateDevice creates a signature device.
+func (c *Client) CreateDevice(ctx context.Context, request api.CreateDeviceRequest) (*api.CreateDeviceResponse, error) {
+	var device api.CreateDeviceResponse
+	if err := c.doIdempotent(ctx, "/api/v0/devices", request, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// GetDevice returns the device with the given ID.
+func (c *Client) GetDevice(ctx context.Context, id string) (*api.CreateDeviceResponse, error) {
+	var device api.CreateDeviceResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v0/devices/"+url.PathEscape(id), nil, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// ListDevices returns all devices.
+func (c *Client) ListDevices(ctx context.Context) ([]api.CreateDeviceResponse, error) {
+	var devices []api.CreateDeviceResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v0/devices", nil, &devices); err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// PublicKey returns the public key of the device with the given ID in the
+// standard PEM, JWK and OpenSSH formats.
+func (c *Client) PublicKey(ctx context.Context, id string) (*api.PublicKeyResponse, error) {
+	var publicKey api.PublicKeyResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v0/devices/"+url.PathEscape(id)+"/public-key", nil, &publicKey); err != nil {
+		return nil, err
+	}
+	return &publicKey, nil
+}
+
+// Certificate returns the X.509 certificate chain of the device with the given ID.
+func (c *Client) Certificate(ctx context.Context, id string) (*api.CertificateResponse, error) {
+	var certificate api.CertificateResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v0/devices/"+url.PathEscape(id)+"/certificate", nil, &certificate); err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
+
+// CertificateRequest returns a PKCS#10 certificate signing request for the key
+// of the device with the given ID, to be certified by an external CA.
+func (c *Client) CertificateRequest(ctx context.Context, id string, request api.CSRRequest) (*api.CSRResponse, error) {
+	var csr api.CSRResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v0/devices/"+url.PathEscape(id)+"/csr", request, &csr); err != nil {
+		return nil, err
+	}
+	return &csr, nil
+}
+
+// ImportCertificate replaces the certificate of the device with the given ID
+// by a PEM certificate issued by an external CA, optionally followed by its chain.
+func (c *Client) ImportCertificate(ctx context.Context, id string, certificate string) (*api.CertificateResponse, error) {
+	var imported api.CertificateResponse
+	path := "/api/v0/devices/" + url.PathEscape(id) + "/certificate"
+	if err := c.do(ctx, http.MethodPut, path, api.ImportCertificateRequest{Certificate: certificate}, &imported); err != nil {
+		return nil, err
+	}
+	return &imported, nil
+}
+
+// ExportDevice returns an encrypted bundle of the device with the given ID for
+// import into an instance that shares the migration key.
+func (c *Client) ExportDevice(ctx context.Context, id string) (*api.DeviceBundleResponse, error) {
+	var exported api.DeviceBundleResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v0/devices/"+url.PathEscape(id)+"/export", nil, &exported); err != nil {
+		return nil, err
+	}
+	return &exported, nil
+}
+
+// ImportDevice restores the device of a bundle returned by ExportDevice. It
+// fails with a conflict if the device exists with a higher signature counter.
+func (c *Client) ImportDevice(ctx context.Context, bundle []byte) (*api.CreateDeviceResponse, error) {
+	var device api.CreateDeviceResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v0/devices/import", api.ImportDeviceRequest{Bundle: bundle}, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// CounterStatus returns the signature counter and high-water mark of the device with the given ID.
+func (c *Client) CounterStatus(ctx context.Context, id string) (*api.CounterStatusResponse, error) {
+	var status api.CounterStatusResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v0/devices/"+url.PathEscape(id)+"/counter", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// OverrideHighWaterMark lets a rolled back device sign again from its current
+// counter. The reason is recorded in the audit log.
+func (c *Client) OverrideHighWaterMark(ctx context.Context, id string, reason string) (*api.CounterStatusResponse, error) {
+	var status api.CounterStatusResponse
+	path := "/api/v0/devices/" + url.PathEscape(id) + "/counter/override"
+	if err := c.do(ctx, http.MethodPost, path, api.OverrideHighWaterMarkRequest{Reason: reason}, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// ListBackups returns the snapshots of all devices, oldest first.
+func (c *Client) ListBackups(ctx context.Context) ([]api.BackupResponse, error) {
+	var backups []api.BackupResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v0/backups", nil, &backups); err != nil {
+		return nil, err
+	}
+	return backups, nil
+}
+
+// CreateBackup takes a snapshot of all devices and their transactions.
+func (c *Client) CreateBackup(ctx context.Context) (*api.BackupResponse, error) {
+	var created api.BackupResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v0/backups", nil, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+// RestoreBackup restores all devices to the latest snapshot taken at or
+// before at, or to the latest snapshot if at is zero.
+func (c *Client) RestoreBackup(ctx context.Context, at time.Time) (*api.BackupResponse, error) {
+	var restored api.BackupResponse
+	if err := c.do(ctx, http.MethodPost, "/api/v0/backups/restore", api.RestoreBackupRequest{At: at}, &restored); err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+// SignTransaction signs data with the device with the given ID. Retries reuse
+// the same idempotency key, so a transaction is signed at most once.
+func (c *Client) SignTransaction(ctx context.Context, id string, data string) (*api.SignTransactionResponse, error) {
+	var signature api.SignTransactionResponse
+	path := "/api/v0/devices/" + url.PathEscape(id) + "/sign"
+	if err := c.doIdempotent(ctx, path, api.SignTransactionRequest{Data: data}, &signature); err != nil {
+		return nil, err
+	}
+	return &signature, nil
+}
+
+// SignBytes signs binary transaction data with the device with the given ID.
+// The data is sent base64-encoded; SignedBytes recovers it from the signature.
+func (c *Client) SignBytes(ctx context.Context, id string, data []byte) (*api.SignTransactionResponse, error) {
+	var signature api.SignTransactionResponse
+	path := "/api/v0/devices/" + url.PathEscape(id) + "/sign"
+	request := api.SignTransactionRequest{Data: base64.StdEncoding.EncodeToString(data), DataEncoding: string(domain.DataBase64)}
+	if err := c.doIdempotent(ctx, path, request, &signature); err != nil {
+		return nil, err
+	}
+	return &signature, nil
+}
+
+// SignDigest signs a precomputed digest of the transaction data with the
+// device with the given ID. The signed data holds "<algorithm>:<hex digest>"
+// in place of the data.
+func (c *Client) SignDigest(ctx context.Context, id string, algorithm domain.HashAlgorithm, digest []byte) (*api.SignTransactionResponse, error) {
+	var signature api.SignTransactionResponse
+	path := "/api/v0/devices/" + url.PathEscape(id) + "/sign"
+	request := api.SignTransactionRequest{Digest: hex.EncodeToString(digest), HashAlgorithm: string(algorithm)}
+	if err := c.doIdempotent(ctx, path, request, &signature); err != nil {
+		return nil, err
+	}
+	return &signature, nil
+}
+
+// SignReader hashes data with algorithm and signs the digest with the device
+// with the given ID, so that large payloads are neither held in memory nor
+// sent to the service. VerifyDigest checks the result.
+func (c *Client) SignReader(ctx context.Context, id string, algorithm domain.HashAlgorithm, data io.Reader) (*api.SignTransactionResponse, error) {
+	hash := algorithm.New()
+	if _, err := io.Copy(hash, data); err != nil {
+		return nil, fmt.Errorf("failed to hash data: %w", err)
+	}
+	return c.SignDigest(ctx, id, algorithm, hash.Sum(nil))
+}
+
+// Verify fetches the public key of the device with the given ID and checks the signature locally.
+func (c *Client) Verify(ctx context.Context, id string, signature *api.SignTransactionResponse) error {
+	device, err := c.GetDevice(ctx, id)
+	if err != nil {
+		return err
+	}
+	return VerifySignature(device, signature)
+}
+
+// VerifySignature checks a signature against the public key of device without contacting the service.
+func VerifySignature(device *api.CreateDeviceResponse, signature *api.SignTransactionResponse) error {
+	verifier, err := domain.NewVerifier(domain.SignatureAlgorithm(device.Algorithm), []byte(device.PublicKey))
+	if err != nil {
+		return err
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %w", err)
+	}
+
+	if err := verifier.Verify([]byte(signature.SignedData), decoded); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// SignedBytes returns the transaction data of a signature, decoded with the
+// data encoding echoed in the response. Signatures of digests have no data.
+func SignedBytes(signature *api.SignTransactionResponse) ([]byte, error) {
+	if signature.DataEncoding == "" {
+		return nil, fmt.Errorf("%w: the signature has no data encoding", domain.ErrInvalidData)
+	}
+	_, signedData, _, err := domain.ParseSecuredData(signature.SignedData)
+	if err != nil {
+		return nil, err
+	}
+	return domain.DecodeData(domain.DataEncoding(signature.DataEncoding), signedData)
+}
+
+// VerifyDigest checks a signature of a digest against the public key of device
+// and checks that the signed digest is the hash of data, without contacting the service.
+func VerifyDigest(device *api.CreateDeviceResponse, signature *api.SignTransactionResponse, data io.Reader) error {
+	if err := VerifySignature(device, signature); err != nil {
+		return err
+	}
+	_, signedData, _, err := domain.ParseSecuredData(signature.SignedData)
+	if err != nil {
+		return err
+	}
+	algorithm, digest, err := domain.ParseDigestData(signedData)
+	if err != nil {
+		return err
+	}
+
+	hash := algorithm.New()
+	if _, err := io.Copy(hash, data); err != nil {
+		return fmt.Errorf("failed to hash data: %w", err)
+	}
+	if !bytes.Equal(hash.Sum(nil), digest) {
+		return fmt.Errorf("%w: the signed %s digest does not match the data", ErrInvalidSignature, algorithm)
+	}
+	return nil
+}
+
+// do sends a request and decodes the data of the response envelope into result.
+// Requests other than POST are retried on network errors, 429 and 502-504
+// responses. POST requests are sent once, since the service could perform
+// them twice; doIdempotent sends those that it deduplicates.
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, result interface{}) error {
+	return c.send(ctx, method, path, body, "", method != http.MethodPost, result)
+}
+
+// doIdempotent sends a POST request with an idempotency key, which the service
+// honours for creating devices and signing. The key stays the same across
+// retries, so the request is retried like do retries requests other than POST.
+func (c *Client) doIdempotent(ctx context.Context, path string, body interface{}, result interface{}) error {
+	return c.send(ctx, http.MethodPost, path, body, uuid.New().String(), true, result)
+}
+
+func (c *Client) send(ctx context.Context, method, path string, body interface{}, idempotencyKey string, retry bool, result interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+	}
+
+	backoff := c.backoff
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, method, path, payload, idempotencyKey, result)
+		if err == nil || !retry || attempt >= c.maxRetries || !retryable(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, path string, payload []byte, idempotencyKey string, result interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set(api.IdempotencyKeyHeader, idempotencyKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return &transportError{err: err}
+	}
+	defer resp.Body.Close()
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &transportError{err: err}
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return newAPIError(resp, content)
+	}
+
+	envelope := api.Response{Data: result}
+	if err := json.Unmarshal(content, &envelope); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func retryable(err error) bool {
+	var transport *transportError
+	if errors.As(err, &transport) {
+		return true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		case http.StatusConflict:
+			// Only the conflict with a first attempt that is still running on
+			// the server carries Retry-After; other conflicts are permanent.
+			return apiErr.RetryAfter != ""
+		}
+	}
+	return false
+}
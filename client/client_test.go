@@ -0,0 +1,386 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func newTestServer(t *testing.T, wrap func(http.Handler) http.Handler) *httptest.Server {
+	t.Helper()
+	handler := api.NewServer(":0", api.NewDeviceHandler(service.NewDeviceService(persistence.NewInMemoryDeviceRepository()))).Handler()
+	if wrap != nil {
+		handler = wrap(handler)
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient(t *testing.T) {
+	server := newTestServer(t, nil)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	for _, algorithm := range []string{"RSA", "ECC"} {
+		device, err := c.CreateDevice(ctx, api.CreateDeviceRequest{Algorithm: algorithm, Label: "Client " + algorithm})
+		if err != nil {
+			t.Fatalf("CreateDevice(%s) failed: %v", algorithm, err)
+		}
+		if device.ID == "" || device.Algorithm != algorithm {
+			t.Fatalf("Unexpected device: %+v", device)
+		}
+
+		fetched, err := c.GetDevice(ctx, device.ID)
+		if err != nil {
+			t.Fatalf("GetDevice failed: %v", err)
+		}
+		if fetched.Label != "Client "+algorithm {
+			t.Errorf("Expected label %q, got %q", "Client "+algorithm, fetched.Label)
+		}
+
+		signature, err := c.SignTransaction(ctx, device.ID, "transaction data")
+		if err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+		if counter, data, _, err := domain.ParseSecuredData(signature.SignedData); err != nil || counter != 0 || data != "transaction data" {
+			t.Errorf("Unexpected signed data %q", signature.SignedData)
+		}
+		if err := c.Verify(ctx, device.ID, signature); err != nil {
+			t.Errorf("Verify failed: %v", err)
+		}
+
+		tampered := *signature
+		tampered.SignedData = strings.Replace(signature.SignedData, "transaction data", "other data", 1)
+		if err := c.Verify(ctx, device.ID, &tampered); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("Expected ErrInvalidSignature for tampered data, got %v", err)
+		}
+	}
+
+	devices, err := c.ListDevices(ctx)
+	if err != nil {
+		t.Fatalf("ListDevices failed: %v", err)
+	}
+	if len(devices) != 2 {
+		t.Errorf("Expected 2 devices, got %d", len(devices))
+	}
+}
+
+func TestClientSignDigest(t *testing.T) {
+	server := newTestServer(t, nil)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	device, err := c.CreateDevice(ctx, api.CreateDeviceRequest{Algorithm: "RSA"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	payload := strings.Repeat("large payload ", 1000)
+	signature, err := c.SignReader(ctx, device.ID, domain.SHA384, strings.NewReader(payload))
+	if err != nil {
+		t.Fatalf("SignReader failed: %v", err)
+	}
+	if _, data, _, _ := domain.ParseSecuredData(signature.SignedData); !strings.HasPrefix(data, "sha384:") {
+		t.Errorf("Expected a SHA-384 digest to be signed, got %q", signature.SignedData)
+	}
+
+	if err := VerifyDigest(device, signature, strings.NewReader(payload)); err != nil {
+		t.Errorf("VerifyDigest failed: %v", err)
+	}
+	if err := VerifyDigest(device, signature, strings.NewReader(payload+"!")); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for other data, got %v", err)
+	}
+
+	plain, err := c.SignTransaction(ctx, device.ID, "not a digest")
+	if err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	if err := VerifyDigest(device, plain, strings.NewReader("not a digest")); !errors.Is(err, domain.ErrInvalidDigest) {
+		t.Errorf("Expected ErrInvalidDigest for a signature of data, got %v", err)
+	}
+}
+
+func TestClientSignBytes(t *testing.T) {
+	server := newTestServer(t, nil)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	device, err := c.CreateDevice(ctx, api.CreateDeviceRequest{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	receipt := []byte{0x1b, 0x40, 0x00, 0xff, '_', 0x0a}
+	signature, err := c.SignBytes(ctx, device.ID, receipt)
+	if err != nil {
+		t.Fatalf("SignBytes failed: %v", err)
+	}
+	if signature.DataEncoding != "base64" {
+		t.Errorf("Expected base64 data encoding, got %q", signature.DataEncoding)
+	}
+	if err := VerifySignature(device, signature); err != nil {
+		t.Errorf("VerifySignature failed: %v", err)
+	}
+	if data, err := SignedBytes(signature); err != nil || !bytes.Equal(data, receipt) {
+		t.Errorf("SignedBytes = %x, %v, want %x", data, err, receipt)
+	}
+
+	digest, err := c.SignDigest(ctx, device.ID, domain.SHA256, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("SignDigest failed: %v", err)
+	}
+	if _, err := SignedBytes(digest); !errors.Is(err, domain.ErrInvalidData) {
+		t.Errorf("Expected ErrInvalidData for a signature of a digest, got %v", err)
+	}
+}
+
+func TestClientErrors(t *testing.T) {
+	server := newTestServer(t, nil)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	_, err := c.GetDevice(ctx, "unknown")
+	if !IsNotFound(err) {
+		t.Fatalf("Expected not found error, got %v", err)
+	}
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || len(apiErr.Errors) == 0 || apiErr.RequestID == "" {
+		t.Errorf("Expected APIError with messages and request ID, got %#v", err)
+	}
+
+	if _, err := c.CreateDevice(ctx, api.CreateDeviceRequest{Algorithm: "DSA"}); !IsUnprocessableEntity(err) {
+		t.Errorf("Expected unprocessable entity error, got %v", err)
+	}
+}
+
+func TestClientRetriesWithIdempotencyKey(t *testing.T) {
+	var mu sync.Mutex
+	failed := false
+	var keys []string
+
+	// The first sign request is processed by the service, but its response is lost.
+	server := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.URL.Path, "/sign") {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			mu.Lock()
+			keys = append(keys, r.Header.Get(api.IdempotencyKeyHeader))
+			fail := !failed
+			failed = true
+			mu.Unlock()
+
+			if fail {
+				next.ServeHTTP(httptest.NewRecorder(), r)
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := New(server.URL, WithRetries(2, time.Millisecond))
+	ctx := context.Background()
+
+	device, err := c.CreateDevice(ctx, api.CreateDeviceRequest{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+
+	signature, err := c.SignTransaction(ctx, device.ID, "retried")
+	if err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	if counter, data, _, err := domain.ParseSecuredData(signature.SignedData); err != nil || counter != 0 || data != "retried" {
+		t.Errorf("Expected the replayed first signature, got %q", signature.SignedData)
+	}
+
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("Expected two attempts with the same idempotency key, got %q", keys)
+	}
+
+	fetched, err := c.GetDevice(ctx, device.ID)
+	if err != nil {
+		t.Fatalf("GetDevice failed: %v", err)
+	}
+	if fetched.SignatureCounter != 1 {
+		t.Errorf("Expected the transaction to be signed once, counter is %d", fetched.SignatureCounter)
+	}
+}
+
+func TestClientDoesNotRetryClientErrors(t *testing.T) {
+	attempts := 0
+	server := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := New(server.URL, WithRetries(3, time.Millisecond))
+
+	if _, err := c.GetDevice(context.Background(), "unknown"); !IsNotFound(err) {
+		t.Fatalf("Expected not found error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected 1 attempt, got %d", attempts)
+	}
+
+	device, err := c.CreateDevice(context.Background(), api.CreateDeviceRequest{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	attempts = 0
+	if _, err := c.CreateDevice(context.Background(), api.CreateDeviceRequest{ID: device.ID, Algorithm: "ECC"}); !IsConflict(err) {
+		t.Fatalf("Expected conflict error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Errorf("Expected a permanent conflict not to be retried, got %d attempts", attempts)
+	}
+}
+
+func TestClientDoesNotRetryRequestsWithoutIdempotency(t *testing.T) {
+	attempts := 0
+	var key string
+	// The service does not deduplicate exports, so a lost response must not
+	// lead to a second export.
+	server := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !strings.HasSuffix(r.URL.Path, "/export") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			attempts++
+			key = r.Header.Get(api.IdempotencyKeyHeader)
+			w.WriteHeader(http.StatusBadGateway)
+		})
+	})
+	c := New(server.URL, WithRetries(3, time.Millisecond))
+
+	if _, err := c.ExportDevice(context.Background(), "device"); err == nil {
+		t.Fatalf("Expected the export to fail")
+	}
+	if attempts != 1 || key != "" {
+		t.Errorf("Expected 1 attempt without an idempotency key, got %d with key %q", attempts, key)
+	}
+}
+
+func TestVerifyChain(t *testing.T) {
+	server := newTestServer(t, nil)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	device, err := c.CreateDevice(ctx, api.CreateDeviceRequest{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	chain := &Chain{Device: *device}
+	for _, data := range []string{"first", "with_underscores", "third"} {
+		signature, err := c.SignTransaction(ctx, device.ID, data)
+		if err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+		chain.Signatures = append(chain.Signatures, *signature)
+	}
+
+	if err := VerifyChain(chain); err != nil {
+		t.Fatalf("Expected valid chain, got %v", err)
+	}
+	if err := VerifyChain(&Chain{Device: *device, Signatures: chain.Signatures[1:]}); err != nil {
+		t.Errorf("Expected a chain starting mid-way to be valid, got %v", err)
+	}
+
+	missing := &Chain{Device: *device, Signatures: []api.SignTransactionResponse{chain.Signatures[0], chain.Signatures[2]}}
+	if err := VerifyChain(missing); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("Expected ErrBrokenChain for a missing signature, got %v", err)
+	}
+
+	forged := &Chain{Device: *device, Signatures: append([]api.SignTransactionResponse(nil), chain.Signatures...)}
+	forged.Signatures[1].Signature = forged.Signatures[0].Signature
+	if err := VerifyChain(forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("Expected ErrInvalidSignature for a forged signature, got %v", err)
+	}
+}
+
+func TestClientCertificates(t *testing.T) {
+	server := newTestServer(t, nil)
+	c := New(server.URL)
+	ctx := context.Background()
+
+	device, err := c.CreateDevice(ctx, api.CreateDeviceRequest{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	csr, err := c.CertificateRequest(ctx, device.ID, api.CSRRequest{Organization: "ACME"})
+	if err != nil {
+		t.Fatalf("CertificateRequest failed: %v", err)
+	}
+	if !strings.HasPrefix(csr.CSR, "-----BEGIN CERTIFICATE REQUEST-----") {
+		t.Errorf("Unexpected certificate request %q", csr.CSR)
+	}
+
+	external, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	issued, err := external.Issue(&domain.SignatureDevice{ID: device.ID, PublicKey: []byte(device.PublicKey)})
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	imported, err := c.ImportCertificate(ctx, device.ID, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issued})))
+	if err != nil {
+		t.Fatalf("ImportCertificate failed: %v", err)
+	}
+
+	fetched, err := c.Certificate(ctx, device.ID)
+	if err != nil {
+		t.Fatalf("Certificate failed: %v", err)
+	}
+	if fetched.SerialNumber != imported.SerialNumber || len(fetched.Chain) != 1 {
+		t.Errorf("Expected the imported certificate, got %+v", fetched)
+	}
+
+	other, err := c.CreateDevice(ctx, api.CreateDeviceRequest{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := c.ImportCertificate(ctx, other.ID, fetched.Certificate); !IsUnprocessableEntity(err) {
+		t.Errorf("Expected a certificate of another key to be rejected, got %v", err)
+	}
+}
+
+func TestClientRetriesRequestInProgress(t *testing.T) {
+	attempts := 0
+	// The first attempt finds the idempotency key still in use by an earlier request.
+	server := newTestServer(t, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			attempts++
+			if attempts == 1 {
+				w.Header().Set("Retry-After", "1")
+				api.WriteErrorResponse(w, r, http.StatusConflict, []string{"A request with this idempotency key is still in progress"})
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	})
+	c := New(server.URL, WithRetries(2, time.Millisecond))
+
+	if _, err := c.CreateDevice(context.Background(), api.CreateDeviceRequest{Algorithm: "ECC"}); err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", attempts)
+	}
+}
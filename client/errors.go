@@ -0,0 +1,108 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// ErrInvalidSignature is returned when a signature does not match the device's public key.
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// ErrBrokenChain is returned when exported signatures are not linked to each
+// other. It is domain.ErrBrokenChain, so errors.Is works across the layers.
+var ErrBrokenChain = domain.ErrBrokenChain
+
+// APIError is returned for responses with a 4xx or 5xx status code.
+type APIError struct {
+	StatusCode int
+	Errors     []string
+	RequestID  string
+	// RetryAfter is the value of the Retry-After header, if any. The service
+	// sets it on responses that may succeed when repeated, such as the 409 of
+	// a request whose idempotency key is still in use by the first attempt.
+	RetryAfter string
+}
+
+func (e *APIError) Error() string {
+	message := http.StatusText(e.StatusCode)
+	if len(e.Errors) > 0 {
+		message = strings.Join(e.Errors, "; ")
+	}
+	if e.RequestID != "" {
+		return fmt.Sprintf("signing service: %d %s (request %s)", e.StatusCode, message, e.RequestID)
+	}
+	return fmt.Sprintf("signing service: %d %s", e.StatusCode, message)
+}
+
+func newAPIError(resp *http.Response, content []byte) *APIError {
+	apiErr := &APIError{
+		StatusCode: resp.StatusCode,
+		RequestID:  resp.Header.Get(api.RequestIDHeader),
+		RetryAfter: resp.Header.Get("Retry-After"),
+	}
+
+	// ErrorResponse and ProblemDetails share the errors and request_id members.
+	var errorResponse api.ErrorResponse
+	if err := json.Unmarshal(content, &errorResponse); err == nil {
+		apiErr.Errors = errorResponse.Errors
+		if errorResponse.RequestID != "" {
+			apiErr.RequestID = errorResponse.RequestID
+		}
+	}
+	return apiErr
+}
+
+// IsNotFound reports whether err is an APIError with status 404.
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsBadRequest reports whether err is an APIError with status 400.
+func IsBadRequest(err error) bool {
+	return hasStatus(err, http.StatusBadRequest)
+}
+
+// IsForbidden reports whether err is an APIError with status 403.
+func IsForbidden(err error) bool {
+	return hasStatus(err, http.StatusForbidden)
+}
+
+// IsConflict reports whether err is an APIError with status 409.
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// IsUnprocessableEntity reports whether err is an APIError with status 422,
+// which the service returns for unsupported algorithms and invalid device IDs.
+func IsUnprocessableEntity(err error) bool {
+	return hasStatus(err, http.StatusUnprocessableEntity)
+}
+
+// IsUnavailable reports whether err is an APIError with status 503.
+func IsUnavailable(err error) bool {
+	return hasStatus(err, http.StatusServiceUnavailable)
+}
+
+func hasStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// transportError marks failures to reach the service, which are retried.
+type transportError struct {
+	err error
+}
+
+func (e *transportError) Error() string {
+	return fmt.Sprintf("signing service unreachable: %v", e.err)
+}
+
+func (e *transportError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,99 @@
+// Command auditctl works with audit log exports of the signing service.
+//
+// Usage:
+//
+//	auditctl verify -public-key <audit.pub> <export.json>
+//
+// The export is the response of GET /api/v0/audit, either the full
+// {"data": ...} envelope or just its data. The checkpoints are verified
+// against the PEM-encoded public key of the audit service key, which must
+// come from a trusted source; the public key inside the export is ignored.
+// Verification runs entirely offline and exits non-zero if the chain or any
+// checkpoint is invalid.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+const usage = "usage: auditctl verify -public-key <audit.pub> <export.json|->"
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "verify" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	publicKeyPath := flags.String("public-key", "", "PEM-encoded public key of the audit service key (required)")
+	flags.Parse(os.Args[2:])
+	if *publicKeyPath == "" || flags.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	content, err := os.ReadFile(*publicKeyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read public key: %v\n", err)
+		os.Exit(1)
+	}
+	publicKey, err := crypto.NewECCMarshaler().UnmarshalPublic(content)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not parse public key: %v\n", err)
+		os.Exit(1)
+	}
+
+	export, err := readExport(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not read audit export: %v\n", err)
+		os.Exit(1)
+	}
+
+	result, err := audit.Verify(export, publicKey)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Audit log verification FAILED: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("Audit log OK: %d entries, hash chain intact\n", result.Entries)
+	switch {
+	case result.SignedThrough < 0:
+		fmt.Println("Warning: no entry is covered by a signed checkpoint yet")
+	case result.SignedThrough < int64(result.Entries)-1:
+		fmt.Printf("Warning: entries after sequence %d are not covered by a signed checkpoint yet\n", result.SignedThrough)
+	default:
+		fmt.Println("All entries are covered by a signed checkpoint")
+	}
+}
+
+func readExport(path string) (*audit.Export, error) {
+	var content []byte
+	var err error
+	if path == "-" {
+		content, err = io.ReadAll(os.Stdin)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var envelope struct {
+		Data *audit.Export `json:"data"`
+	}
+	if err := json.Unmarshal(content, &envelope); err == nil && envelope.Data != nil {
+		return envelope.Data, nil
+	}
+
+	var export audit.Export
+	if err := json.Unmarshal(content, &export); err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
@@ -0,0 +1,670 @@
+// Command signctl operates the signing service over its HTTP API.
+//
+// Usage:
+//
+//	signctl [-server URL] [-output table|json|yaml] <command> [flags] [args]
+//
+// Commands:
+//
+//	create [-id ID] [-algorithm RSA|ECC] [-label LABEL]   create a device
+//	list                                                  list devices
+//	show <device-id>                                      show a device
+//	sign [-file PATH] [-hash H] [-chain PATH] <device-id>  sign data from a file or stdin
+//	public-key [-format F] [-out PATH] <device-id>        export the public key (pem, der, jwk, openssh)
+//	certificate [-out PATH] <device-id>                   export the PEM certificate chain
+//	export [-out PATH] <device-id>                        export an encrypted device bundle
+//	import <bundle|->                                     import a device bundle
+//	backup                                                back up all devices
+//	backups                                               list backups
+//	restore [-at TIME]                                    restore all devices from a backup
+//	counter <device-id>                                   compare the counter with its high-water mark
+//	override-counter -reason TEXT <device-id>             let a rolled back device sign again
+//	verify -public-key PATH -algorithm ALG <sig.json|->   verify a signature offline
+//	verify-chain <chain.json|->                           verify an exported chain offline
+//
+// The server defaults to $SIGNCTL_SERVER or http://localhost:8080. sign
+// appends every signature to the chain file given with -chain, which
+// verify-chain checks without contacting the service. sign -hash signs the
+// sha256, sha384 or sha512 digest of the data instead of the data, and
+// verify -data checks such a signature against the data. Data that is not
+// valid UTF-8 is sent base64-encoded, so binary receipts are signed unchanged.
+// export and import move a device with its counter between instances that
+// share a migration key. restore -at takes an RFC 3339 time and restores the
+// latest backup taken at or before it. A device restored behind its
+// high-water mark refuses to sign until override-counter re-issues its
+// counters, recording the reason in the audit log.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+	"unicode/utf8"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/client"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"gopkg.in/yaml.v3"
+)
+
+const usage = `usage: signctl [-server URL] [-output table|json|yaml] <command> [flags] [args]
+
+commands:
+  create [-id ID] [-algorithm RSA|ECC] [-label LABEL]
+  list
+  show <device-id>
+  sign [-file PATH] [-hash sha256|sha384|sha512] [-chain PATH] <device-id>
+  public-key [-format pem|der|jwk|openssh] [-out PATH] <device-id>
+  certificate [-out PATH] <device-id>
+  export [-out PATH] <device-id>
+  import <bundle|->
+  backup
+  backups
+  restore [-at TIME]
+  counter <device-id>
+  override-counter -reason TEXT <device-id>
+  verify -public-key PATH -algorithm RSA|ECC [-data PATH] <signature.json|->
+  verify-chain <chain.json|->
+`
+
+// usageError is reported with the usage text and exit code 2.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func main() {
+	err := run(context.Background(), os.Args[1:], os.Stdin, os.Stdout)
+	var usageErr *usageError
+	switch {
+	case err == nil:
+	case errors.As(err, &usageErr):
+		fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+		os.Exit(2)
+	default:
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// command holds the global settings shared by all commands.
+type command struct {
+	client *client.Client
+	stdin  io.Reader
+	out    *printer
+}
+
+func run(ctx context.Context, args []string, stdin io.Reader, stdout io.Writer) error {
+	server := os.Getenv("SIGNCTL_SERVER")
+	if server == "" {
+		server = "http://localhost:8080"
+	}
+
+	flags := flag.NewFlagSet("signctl", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&server, "server", server, "base URL of the signing service")
+	format := flags.String("output", "table", "output format: table, json or yaml")
+	if err := flags.Parse(args); err != nil {
+		return &usageError{err.Error()}
+	}
+	if *format != "table" && *format != "json" && *format != "yaml" {
+		return &usageError{fmt.Sprintf("unknown output format %q", *format)}
+	}
+	if flags.NArg() == 0 {
+		return &usageError{"missing command"}
+	}
+
+	cmd := &command{
+		client: client.New(server),
+		stdin:  stdin,
+		out:    &printer{w: stdout, format: *format},
+	}
+	name, args := flags.Arg(0), flags.Args()[1:]
+	switch name {
+	case "create":
+		return cmd.create(ctx, args)
+	case "list":
+		return cmd.list(ctx, args)
+	case "show":
+		return cmd.show(ctx, args)
+	case "sign":
+		return cmd.sign(ctx, args)
+	case "public-key":
+		return cmd.publicKey(ctx, args)
+	case "certificate":
+		return cmd.certificate(ctx, args)
+	case "export":
+		return cmd.exportDevice(ctx, args)
+	case "import":
+		return cmd.importDevice(ctx, args)
+	case "backup":
+		return cmd.backup(ctx, args)
+	case "backups":
+		return cmd.backups(ctx, args)
+	case "restore":
+		return cmd.restore(ctx, args)
+	case "counter":
+		return cmd.counter(ctx, args)
+	case "override-counter":
+		return cmd.overrideCounter(ctx, args)
+	case "verify":
+		return cmd.verify(args)
+	case "verify-chain":
+		return cmd.verifyChain(args)
+	default:
+		return &usageError{fmt.Sprintf("unknown command %q", name)}
+	}
+}
+
+// parse parses the flags of a command and checks the number of positional arguments.
+func parse(flags *flag.FlagSet, args []string, positional int) error {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return &usageError{fmt.Sprintf("%s: %v", flags.Name(), err)}
+	}
+	if flags.NArg() != positional {
+		return &usageError{fmt.Sprintf("%s: expected %d argument(s), got %d", flags.Name(), positional, flags.NArg())}
+	}
+	return nil
+}
+
+func (c *command) create(ctx context.Context, args []string) error {
+	var request api.CreateDeviceRequest
+	flags := flag.NewFlagSet("create", flag.ContinueOnError)
+	flags.StringVar(&request.ID, "id", "", "device ID, generated if empty")
+	flags.StringVar(&request.Algorithm, "algorithm", "", "signature algorithm: RSA or ECC")
+	flags.StringVar(&request.Label, "label", "", "device label")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	device, err := c.client.CreateDevice(ctx, request)
+	if err != nil {
+		return err
+	}
+	return c.out.devices(*device)
+}
+
+func (c *command) list(ctx context.Context, args []string) error {
+	if err := parse(flag.NewFlagSet("list", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+
+	devices, err := c.client.ListDevices(ctx)
+	if err != nil {
+		return err
+	}
+	return c.out.devices(devices...)
+}
+
+func (c *command) show(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("show", flag.ContinueOnError)
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	device, err := c.client.GetDevice(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.out.devices(*device)
+}
+
+func (c *command) sign(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ContinueOnError)
+	file := flags.String("file", "-", "file with the data to sign, - for stdin")
+	hash := flags.String("hash", "", "sign the digest of the data with this hash algorithm instead of the data")
+	chainPath := flags.String("chain", "", "chain file to append the signature to")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	id := flags.Arg(0)
+
+	var signature *api.SignTransactionResponse
+	if *hash != "" {
+		algorithm, err := domain.ParseHashAlgorithm(*hash)
+		if err != nil {
+			return &usageError{err.Error()}
+		}
+		data, err := c.openInput(*file)
+		if err != nil {
+			return err
+		}
+		defer data.Close()
+		if signature, err = c.client.SignReader(ctx, id, algorithm, data); err != nil {
+			return err
+		}
+	} else {
+		data, err := c.readInput(*file)
+		if err != nil {
+			return err
+		}
+		if utf8.Valid(data) {
+			signature, err = c.client.SignTransaction(ctx, id, string(data))
+		} else {
+			signature, err = c.client.SignBytes(ctx, id, data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if *chainPath != "" {
+		if err := appendToChain(ctx, c.client, *chainPath, id, signature); err != nil {
+			return fmt.Errorf("signature created but not added to chain: %w", err)
+		}
+	}
+	return c.out.signature(signature)
+}
+
+// appendToChain adds signature to the chain file at path, creating it with the
+// device's public data if it does not exist yet.
+func appendToChain(ctx context.Context, c *client.Client, path string, id string, signature *api.SignTransactionResponse) error {
+	var chain client.Chain
+	content, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		device, err := c.GetDevice(ctx, id)
+		if err != nil {
+			return err
+		}
+		chain.Device = *device
+	case err != nil:
+		return err
+	default:
+		if err := json.Unmarshal(content, &chain); err != nil {
+			return fmt.Errorf("invalid chain file: %w", err)
+		}
+		if chain.Device.ID != id {
+			return fmt.Errorf("chain file belongs to device %s", chain.Device.ID)
+		}
+	}
+
+	chain.Signatures = append(chain.Signatures, *signature)
+	content, err = json.MarshalIndent(chain, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(content, '\n'), 0o644)
+}
+
+func (c *command) publicKey(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("public-key", flag.ContinueOnError)
+	format := flags.String("format", "pem", "key format: pem, der, jwk or openssh")
+	out := flags.String("out", "", "file to write the encoded key to, stdout if empty")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	publicKey, err := c.client.PublicKey(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+	switch *format {
+	case "pem":
+		content = []byte(publicKey.PublicKey)
+	case "der":
+		block, _ := pem.Decode([]byte(publicKey.PublicKey))
+		if block == nil {
+			return errors.New("invalid PEM public key in response")
+		}
+		content = block.Bytes
+	case "jwk":
+		content, err = json.MarshalIndent(publicKey.JWK, "", "  ")
+		if err != nil {
+			return err
+		}
+		content = append(content, '\n')
+	case "openssh":
+		content = []byte(publicKey.OpenSSH + "\n")
+	default:
+		return &usageError{fmt.Sprintf("public-key: unsupported format %q", *format)}
+	}
+
+	if *out != "" {
+		return os.WriteFile(*out, content, 0o644)
+	}
+	_, err = c.out.w.Write(content)
+	return err
+}
+
+func (c *command) certificate(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("certificate", flag.ContinueOnError)
+	out := flags.String("out", "", "file to write the PEM chain to, stdout if empty")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	certificate, err := c.client.Certificate(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	content := []byte(strings.Join(certificate.Chain, ""))
+	if *out != "" {
+		return os.WriteFile(*out, content, 0o644)
+	}
+	_, err = c.out.w.Write(content)
+	return err
+}
+
+func (c *command) exportDevice(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	out := flags.String("out", "", "file to write the bundle to, stdout if empty")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	exported, err := c.client.ExportDevice(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *out != "" {
+		return os.WriteFile(*out, exported.Bundle, 0o600)
+	}
+	_, err = c.out.w.Write(exported.Bundle)
+	return err
+}
+
+func (c *command) importDevice(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	content, err := c.readInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	device, err := c.client.ImportDevice(ctx, bytes.TrimSpace(content))
+	if err != nil {
+		return err
+	}
+	return c.out.devices(*device)
+}
+
+func (c *command) backup(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	created, err := c.client.CreateBackup(ctx)
+	if err != nil {
+		return err
+	}
+	return c.out.backups(*created)
+}
+
+func (c *command) backups(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("backups", flag.ContinueOnError)
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	backups, err := c.client.ListBackups(ctx)
+	if err != nil {
+		return err
+	}
+	return c.out.backups(backups...)
+}
+
+func (c *command) restore(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	atFlag := flags.String("at", "", "RFC 3339 time to restore to, the latest backup if empty")
+	if err := parse(flags, args, 0); err != nil {
+		return err
+	}
+	var at time.Time
+	if *atFlag != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, *atFlag); err != nil {
+			return &usageError{fmt.Sprintf("restore: invalid time %q", *atFlag)}
+		}
+	}
+
+	restored, err := c.client.RestoreBackup(ctx, at)
+	if err != nil {
+		return err
+	}
+	return c.out.backups(*restored)
+}
+
+func (c *command) counter(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("counter", flag.ContinueOnError)
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	status, err := c.client.CounterStatus(ctx, flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	return c.out.counter(status)
+}
+
+func (c *command) overrideCounter(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("override-counter", flag.ContinueOnError)
+	reason := flags.String("reason", "", "why the counters are re-issued, recorded in the audit log")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	if *reason == "" {
+		return &usageError{"override-counter: -reason is required"}
+	}
+
+	status, err := c.client.OverrideHighWaterMark(ctx, flags.Arg(0), *reason)
+	if err != nil {
+		return err
+	}
+	return c.out.counter(status)
+}
+
+func (c *command) verify(args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	keyPath := flags.String("public-key", "", "PEM encoded public key of the device")
+	algorithm := flags.String("algorithm", "", "signature algorithm of the device: RSA or ECC")
+	dataPath := flags.String("data", "", "data whose digest was signed, checked against the signed digest")
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+	if *keyPath == "" || *algorithm == "" {
+		return &usageError{"verify: -public-key and -algorithm are required"}
+	}
+
+	publicKey, err := os.ReadFile(*keyPath)
+	if err != nil {
+		return err
+	}
+	content, err := c.readInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var signature api.SignTransactionResponse
+	if err := decodeData(content, &signature); err != nil {
+		return fmt.Errorf("invalid signature file: %w", err)
+	}
+
+	device := &api.CreateDeviceResponse{Algorithm: *algorithm, PublicKey: string(publicKey)}
+	if *dataPath != "" {
+		data, err := os.Open(*dataPath)
+		if err != nil {
+			return err
+		}
+		defer data.Close()
+		if err := client.VerifyDigest(device, &signature, data); err != nil {
+			return err
+		}
+	} else if err := client.VerifySignature(device, &signature); err != nil {
+		return err
+	}
+	return c.out.verified(fmt.Sprintf("Signature OK: %s", signature.SignedData), map[string]interface{}{
+		"valid":       true,
+		"signed_data": signature.SignedData,
+	})
+}
+
+func (c *command) verifyChain(args []string) error {
+	flags := flag.NewFlagSet("verify-chain", flag.ContinueOnError)
+	if err := parse(flags, args, 1); err != nil {
+		return err
+	}
+
+	content, err := c.readInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	var chain client.Chain
+	if err := decodeData(content, &chain); err != nil {
+		return fmt.Errorf("invalid chain file: %w", err)
+	}
+
+	if err := client.VerifyChain(&chain); err != nil {
+		return err
+	}
+	return c.out.verified(fmt.Sprintf("Chain OK: %d signatures of device %s", len(chain.Signatures), chain.Device.ID), map[string]interface{}{
+		"valid":      true,
+		"device_id":  chain.Device.ID,
+		"signatures": len(chain.Signatures),
+	})
+}
+
+// openInput opens the file at path, or stdin for "-", for streaming.
+func (c *command) openInput(path string) (io.ReadCloser, error) {
+	if path == "-" {
+		return io.NopCloser(c.stdin), nil
+	}
+	return os.Open(path)
+}
+
+func (c *command) readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(c.stdin)
+	}
+	return os.ReadFile(path)
+}
+
+// decodeData decodes either a {"data": ...} response envelope or the bare data into v.
+func decodeData(content []byte, v interface{}) error {
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(content, &envelope); err == nil && len(envelope.Data) > 0 && envelope.Data[0] == '{' {
+		content = envelope.Data
+	}
+	return json.Unmarshal(content, v)
+}
+
+// printer writes results as a table or as JSON or YAML documents.
+type printer struct {
+	w      io.Writer
+	format string
+}
+
+func (p *printer) devices(devices ...api.CreateDeviceResponse) error {
+	if p.format != "table" {
+		if len(devices) == 1 {
+			return p.document(devices[0])
+		}
+		return p.document(devices)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tLABEL\tALGORITHM\tSIGNATURES")
+	for _, device := range devices {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", device.ID, device.Label, device.Algorithm, strconv.Itoa(device.SignatureCounter))
+	}
+	return tw.Flush()
+}
+
+func (p *printer) backups(backups ...api.BackupResponse) error {
+	if p.format != "table" {
+		if len(backups) == 1 {
+			return p.document(backups[0])
+		}
+		return p.document(backups)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTAKEN AT\tDEVICES")
+	for _, backup := range backups {
+		devices := "-"
+		if backup.Devices > 0 {
+			devices = strconv.Itoa(backup.Devices)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", backup.Name, backup.TakenAt.Format(time.RFC3339Nano), devices)
+	}
+	return tw.Flush()
+}
+
+func (p *printer) counter(status *api.CounterStatusResponse) error {
+	if p.format != "table" {
+		return p.document(status)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSIGNATURES\tHIGH-WATER MARK\tROLLED BACK")
+	fmt.Fprintf(tw, "%s\t%d\t%d\t%t\n", status.ID, status.SignatureCounter, status.HighWaterMark, status.RolledBack)
+	return tw.Flush()
+}
+
+func (p *printer) signature(signature *api.SignTransactionResponse) error {
+	if p.format != "table" {
+		return p.document(signature)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "SIGNATURE\t%s\n", signature.Signature)
+	fmt.Fprintf(tw, "SIGNED DATA\t%s\n", signature.SignedData)
+	return tw.Flush()
+}
+
+func (p *printer) verified(message string, result map[string]interface{}) error {
+	if p.format != "table" {
+		return p.document(result)
+	}
+	_, err := fmt.Fprintln(p.w, message)
+	return err
+}
+
+// document writes v as JSON or YAML. YAML is converted from JSON so that both
+// formats use the field names of the API.
+func (p *printer) document(v interface{}) error {
+	content, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if p.format == "json" {
+		_, err := p.w.Write(append(content, '\n'))
+		return err
+	}
+
+	var generic interface{}
+	if err := json.Unmarshal(content, &generic); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(generic); err != nil {
+		return err
+	}
+	_, err = p.w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/backup"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/bundle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/client"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+)
+
+func TestSignctl(t *testing.T) {
+	authority, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithCertificateAuthority(authority))
+	server := httptest.NewServer(api.NewServer(":0", api.NewDeviceHandler(devices)).Handler())
+	defer server.Close()
+	dir := t.TempDir()
+
+	signctl := func(stdin string, args ...string) string {
+		t.Helper()
+		var stdout bytes.Buffer
+		args = append([]string{"-server", server.URL}, args...)
+		if err := run(context.Background(), args, strings.NewReader(stdin), &stdout); err != nil {
+			t.Fatalf("signctl %s failed: %v", strings.Join(args[2:], " "), err)
+		}
+		return stdout.String()
+	}
+
+	var device api.CreateDeviceResponse
+	if err := json.Unmarshal([]byte(signctl("", "-output", "json", "create", "-algorithm", "ECC", "-label", "Till 1")), &device); err != nil {
+		t.Fatalf("Failed to parse create output: %v", err)
+	}
+
+	if table := signctl("", "list"); !strings.Contains(table, device.ID) || !strings.Contains(table, "Till 1") {
+		t.Errorf("Expected device in list output, got:\n%s", table)
+	}
+	if yaml := signctl("", "-output", "yaml", "show", device.ID); !strings.Contains(yaml, "label: Till 1") {
+		t.Errorf("Expected YAML output, got:\n%s", yaml)
+	}
+
+	chainPath := filepath.Join(dir, "chain.json")
+	signctl("first", "sign", "-chain", chainPath, device.ID)
+	signaturePath := filepath.Join(dir, "signature.json")
+	if err := os.WriteFile(signaturePath, []byte(signctl("second", "-output", "json", "sign", "-chain", chainPath, device.ID)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := filepath.Join(dir, "key.pem")
+	signctl("", "public-key", "-out", keyPath, device.ID)
+	if out := signctl("", "public-key", "-format", "openssh", device.ID); !strings.HasPrefix(out, "ecdsa-sha2-nistp384 ") {
+		t.Errorf("Unexpected OpenSSH public key %q", out)
+	}
+	if out := signctl("", "certificate", device.ID); strings.Count(out, "-----BEGIN CERTIFICATE-----") != 2 {
+		t.Errorf("Expected a PEM chain of two certificates, got %q", out)
+	}
+
+	dataPath := filepath.Join(dir, "receipts.csv")
+	if err := os.WriteFile(dataPath, []byte(strings.Repeat("1,coffee,3.20\n", 100)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	digestPath := filepath.Join(dir, "digest.json")
+	if err := os.WriteFile(digestPath, []byte(signctl("", "-output", "json", "sign", "-file", dataPath, "-hash", "sha256", device.ID)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	binaryPath := filepath.Join(dir, "receipt.bin")
+	if err := os.WriteFile(binaryPath, []byte{0x1b, 0x40, 0xff, 0x00}, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	binarySignaturePath := filepath.Join(dir, "binary.json")
+	if err := os.WriteFile(binarySignaturePath, []byte(signctl("", "-output", "json", "sign", "-file", binaryPath, device.ID)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Offline verification works without the service.
+	server.Close()
+	if out := signctl("", "verify", "-public-key", keyPath, "-algorithm", "ECC", "-data", dataPath, digestPath); !strings.Contains(out, "_sha256:") {
+		t.Errorf("Unexpected verify output for a digest %q", out)
+	}
+	if err := os.WriteFile(dataPath, []byte("1,coffee,0.20\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = run(context.Background(), []string{"verify", "-public-key", keyPath, "-algorithm", "ECC", "-data", dataPath, digestPath}, strings.NewReader(""), &bytes.Buffer{})
+	if !errors.Is(err, client.ErrInvalidSignature) {
+		t.Errorf("Expected changed data to fail verification, got %v", err)
+	}
+	if out := signctl("", "verify", "-public-key", keyPath, "-algorithm", "ECC", signaturePath); !strings.HasPrefix(out, "Signature OK: v2_1_") || !strings.Contains(out, "_second_") {
+		t.Errorf("Unexpected verify output %q", out)
+	}
+	if out := signctl("", "verify", "-public-key", keyPath, "-algorithm", "ECC", binarySignaturePath); !strings.Contains(out, "_base64:G0D/AA==_") {
+		t.Errorf("Expected binary data to be signed base64-encoded, got %q", out)
+	}
+	if out := signctl("", "verify-chain", chainPath); !strings.HasPrefix(out, "Chain OK: 2 signatures") {
+		t.Errorf("Unexpected verify-chain output %q", out)
+	}
+
+	var chain client.Chain
+	content, _ := os.ReadFile(chainPath)
+	json.Unmarshal(content, &chain)
+	chain.Signatures[0].SignedData = strings.Replace(chain.Signatures[0].SignedData, "first", "forged", 1)
+	forged, _ := json.Marshal(chain)
+	err = run(context.Background(), []string{"verify-chain", "-"}, bytes.NewReader(forged), &bytes.Buffer{})
+	if !errors.Is(err, client.ErrInvalidSignature) {
+		t.Errorf("Expected forged chain to fail verification, got %v", err)
+	}
+}
+
+func TestSignctlExportImport(t *testing.T) {
+	key, err := bundle.NewKey(bytes.Repeat([]byte("m"), bundle.MinSecretSize))
+	if err != nil {
+		t.Fatalf("Failed to create migration key: %v", err)
+	}
+	newServer := func() *httptest.Server {
+		devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithMigrationKey(key))
+		server := httptest.NewServer(api.NewServer(":0", api.NewDeviceHandler(devices)).Handler())
+		t.Cleanup(server.Close)
+		return server
+	}
+	source, target := newServer(), newServer()
+	signctl := func(server *httptest.Server, args ...string) string {
+		t.Helper()
+		var stdout bytes.Buffer
+		if err := run(context.Background(), append([]string{"-server", server.URL}, args...), strings.NewReader("receipt"), &stdout); err != nil {
+			t.Fatalf("signctl %s failed: %v", strings.Join(args, " "), err)
+		}
+		return stdout.String()
+	}
+
+	var device api.CreateDeviceResponse
+	if err := json.Unmarshal([]byte(signctl(source, "-output", "json", "create", "-algorithm", "ECC", "-label", "Till 1")), &device); err != nil {
+		t.Fatalf("Failed to parse create output: %v", err)
+	}
+	signctl(source, "sign", device.ID)
+	bundlePath := filepath.Join(t.TempDir(), "device.bundle")
+	signctl(source, "export", "-out", bundlePath, device.ID)
+
+	if out := signctl(target, "-output", "yaml", "import", bundlePath); !strings.Contains(out, "signature_counter: 1") {
+		t.Errorf("Expected the imported device to keep its counter, got:\n%s", out)
+	}
+	signctl(target, "sign", device.ID)
+	err = run(context.Background(), []string{"-server", target.URL, "import", bundlePath}, strings.NewReader(""), &bytes.Buffer{})
+	if !client.IsConflict(err) {
+		t.Errorf("Expected a conflict for an outdated bundle, got %v", err)
+	}
+}
+
+func TestSignctlBackupRestore(t *testing.T) {
+	key, err := bundle.NewKey(bytes.Repeat([]byte("b"), bundle.MinSecretSize))
+	if err != nil {
+		t.Fatalf("Failed to create backup key: %v", err)
+	}
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	backups := backup.NewManager(devices, backup.NewDirStore(t.TempDir()), key)
+	server := httptest.NewServer(api.NewServer(":0", api.NewDeviceHandler(devices), api.WithBackupHandler(api.NewBackupHandler(backups))).Handler())
+	defer server.Close()
+	signctl := func(args ...string) string {
+		t.Helper()
+		var stdout bytes.Buffer
+		if err := run(context.Background(), append([]string{"-server", server.URL}, args...), strings.NewReader("receipt"), &stdout); err != nil {
+			t.Fatalf("signctl %s failed: %v", strings.Join(args, " "), err)
+		}
+		return stdout.String()
+	}
+
+	var device api.CreateDeviceResponse
+	if err := json.Unmarshal([]byte(signctl("-output", "json", "create", "-algorithm", "ECC")), &device); err != nil {
+		t.Fatalf("Failed to parse create output: %v", err)
+	}
+	var created api.BackupResponse
+	if err := json.Unmarshal([]byte(signctl("-output", "json", "backup")), &created); err != nil {
+		t.Fatalf("Failed to parse backup output: %v", err)
+	}
+	signctl("sign", device.ID)
+
+	if out := signctl("backups"); !strings.Contains(out, created.Name) {
+		t.Errorf("Expected the backup in the listing, got:\n%s", out)
+	}
+	if out := signctl("restore", "-at", created.TakenAt.Format(time.RFC3339Nano)); !strings.Contains(out, created.Name) {
+		t.Errorf("Expected the restored backup, got:\n%s", out)
+	}
+	var restored api.CreateDeviceResponse
+	json.Unmarshal([]byte(signctl("-output", "json", "show", device.ID)), &restored)
+	if restored.SignatureCounter != 0 {
+		t.Errorf("Expected the counter of the backup, got %d", restored.SignatureCounter)
+	}
+
+	// The restored device is behind its high-water mark until the override.
+	err = run(context.Background(), []string{"-server", server.URL, "sign", device.ID}, strings.NewReader("receipt"), &bytes.Buffer{})
+	if !client.IsConflict(err) {
+		t.Errorf("Expected a conflict for a rolled back device, got %v", err)
+	}
+	if out := signctl("-output", "yaml", "counter", device.ID); !strings.Contains(out, "rolled_back: true") {
+		t.Errorf("Expected the device to be rolled back, got:\n%s", out)
+	}
+	var usageErr *usageError
+	if err := run(context.Background(), []string{"-server", server.URL, "override-counter", device.ID}, strings.NewReader(""), &bytes.Buffer{}); !errors.As(err, &usageErr) {
+		t.Errorf("Expected a usage error without a reason, got %v", err)
+	}
+	signctl("override-counter", "-reason", "receipt was voided", device.ID)
+	signctl("sign", device.ID)
+	if err := run(context.Background(), []string{"-server", server.URL, "restore", "-at", "yesterday"}, strings.NewReader(""), &bytes.Buffer{}); err == nil {
+		t.Errorf("Expected an error for an invalid time")
+	}
+}
+
+func TestSignctlUsage(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"-output", "xml", "list"},
+		{"show"},
+		{"verify", "signature.json"},
+	} {
+		var usageErr *usageError
+		if err := run(context.Background(), args, strings.NewReader(""), &bytes.Buffer{}); !errors.As(err, &usageErr) {
+			t.Errorf("signctl %v: expected usage error, got %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+const (
+	StorageMemory = "memory"
+
+	TracingNone = "none"
+	TracingOTLP = "otlp"
+	TracingFile = "file"
+
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+
+	ErrorFormatEnvelope = "envelope"
+	ErrorFormatProblem  = "problem"
+
+	TimestampNone   = "none"
+	TimestampLocal  = "local"
+	TimestampRemote = "remote"
+)
+
+// Config is the root configuration of the signing service.
+type Config struct {
+	Server    ServerConfig    `json:"server" yaml:"server"`
+	Storage   StorageConfig   `json:"storage" yaml:"storage"`
+	Keys      KeyConfig       `json:"keys" yaml:"keys"`
+	Limits    LimitsConfig    `json:"limits" yaml:"limits"`
+	Tracing   TracingConfig   `json:"tracing" yaml:"tracing"`
+	Logging   LoggingConfig   `json:"logging" yaml:"logging"`
+	Audit     AuditConfig     `json:"audit" yaml:"audit"`
+	CA        CAConfig        `json:"ca" yaml:"ca"`
+	Timestamp TimestampConfig `json:"timestamp" yaml:"timestamp"`
+	Migration MigrationConfig `json:"migration" yaml:"migration"`
+	Backup    BackupConfig    `json:"backup" yaml:"backup"`
+
+	HighWaterMark HighWaterMarkConfig `json:"high_water_mark" yaml:"high_water_mark"`
+}
+
+// ServerConfig holds the HTTP and gRPC listener settings. An empty
+// GRPCListenAddress disables the gRPC API. ErrorFormat selects the default
+// format of REST error responses. Changing them requires a restart.
+type ServerConfig struct {
+	ListenAddress     string    `json:"listen_address" yaml:"listen_address"`
+	GRPCListenAddress string    `json:"grpc_listen_address" yaml:"grpc_listen_address"`
+	TLS               TLSConfig `json:"tls" yaml:"tls"`
+	ReadTimeout       Duration  `json:"read_timeout" yaml:"read_timeout"`
+	WriteTimeout      Duration  `json:"write_timeout" yaml:"write_timeout"`
+	IdleTimeout       Duration  `json:"idle_timeout" yaml:"idle_timeout"`
+	ShutdownTimeout   Duration  `json:"shutdown_timeout" yaml:"shutdown_timeout"`
+	ErrorFormat       string    `json:"error_format" yaml:"error_format"`
+}
+
+// TLSConfig enables HTTPS when both a certificate and a key file are given.
+type TLSConfig struct {
+	Enabled  bool   `json:"enabled" yaml:"enabled"`
+	CertFile string `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string `json:"key_file" yaml:"key_file"`
+}
+
+// StorageConfig selects the device repository backend. Changing it requires a restart.
+type StorageConfig struct {
+	Backend string `json:"backend" yaml:"backend"`
+}
+
+// KeyConfig holds defaults applied when creating signature devices.
+// It can be reloaded at runtime.
+type KeyConfig struct {
+	DefaultAlgorithm string `json:"default_algorithm" yaml:"default_algorithm"`
+}
+
+// LimitsConfig holds request and resource limits. It can be reloaded at runtime.
+type LimitsConfig struct {
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes" yaml:"max_request_body_bytes"`
+	MaxStreamBytes      int64 `json:"max_stream_bytes" yaml:"max_stream_bytes"`
+	MaxDevices          int   `json:"max_devices" yaml:"max_devices"`
+}
+
+// TracingConfig selects where trace spans are exported. Changing it requires a restart.
+type TracingConfig struct {
+	Exporter    string  `json:"exporter" yaml:"exporter"`
+	Endpoint    string  `json:"endpoint" yaml:"endpoint"`
+	Insecure    bool    `json:"insecure" yaml:"insecure"`
+	FilePath    string  `json:"file_path" yaml:"file_path"`
+	ServiceName string  `json:"service_name" yaml:"service_name"`
+	SampleRatio float64 `json:"sample_ratio" yaml:"sample_ratio"`
+}
+
+// LoggingConfig controls the structured logs. The level can be reloaded at
+// runtime; changing the format requires a restart.
+type LoggingConfig struct {
+	Level  string `json:"level" yaml:"level"`
+	Format string `json:"format" yaml:"format"`
+}
+
+// SlogLevel returns the configured level as a slog.Level.
+func (c LoggingConfig) SlogLevel() slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Level)); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// AuditConfig controls the audit log of administrative actions. Without a
+// file, the log is only kept in memory and starts over on every restart.
+// Changing it requires a restart.
+type AuditConfig struct {
+	File               string   `json:"file" yaml:"file"`
+	SigningKeyFile     string   `json:"signing_key_file" yaml:"signing_key_file"`
+	CheckpointInterval uint64   `json:"checkpoint_interval" yaml:"checkpoint_interval"`
+	CheckpointPeriod   Duration `json:"checkpoint_period" yaml:"checkpoint_period"`
+}
+
+// CAConfig holds the key material of the internal certificate authority that
+// certifies device keys. Without files, a CA is generated on every start.
+// Changing it requires a restart.
+type CAConfig struct {
+	CertFile string   `json:"cert_file" yaml:"cert_file"`
+	KeyFile  string   `json:"key_file" yaml:"key_file"`
+	Validity Duration `json:"validity" yaml:"validity"`
+}
+
+// TimestampConfig selects the RFC 3161 time-stamping authority that
+// timestamps every signature: none, the local TSA certified by the internal
+// CA, or a remote TSA at URL. Changing it requires a restart.
+type TimestampConfig struct {
+	Authority string   `json:"authority" yaml:"authority"`
+	URL       string   `json:"url" yaml:"url"`
+	Timeout   Duration `json:"timeout" yaml:"timeout"`
+}
+
+// MigrationConfig holds the secret shared by instances that exchange device
+// bundles, the ECC key that signs the bundles of this instance, and the public
+// keys of the instances whose bundles it accepts. Without a key file, devices
+// cannot be exported or imported. Changing it requires a restart.
+type MigrationConfig struct {
+	KeyFile         string   `json:"key_file" yaml:"key_file"`
+	SigningKeyFile  string   `json:"signing_key_file" yaml:"signing_key_file"`
+	TrustedKeyFiles []string `json:"trusted_key_files" yaml:"trusted_key_files"`
+}
+
+// BackupConfig selects where snapshots of all devices and their transactions
+// are written: a local directory, or a bucket of an S3-compatible store for
+// targets of the form s3://bucket/prefix. An empty target disables backups.
+// Devices are sealed with the secret in KeyFile. An Interval of zero only
+// takes backups on request. Changing it requires a restart.
+type BackupConfig struct {
+	Target          string   `json:"target" yaml:"target"`
+	KeyFile         string   `json:"key_file" yaml:"key_file"`
+	Interval        Duration `json:"interval" yaml:"interval"`
+	Endpoint        string   `json:"endpoint" yaml:"endpoint"`
+	Region          string   `json:"region" yaml:"region"`
+	AccessKeyID     string   `json:"access_key_id" yaml:"access_key_id"`
+	SecretAccessKey string   `json:"secret_access_key" yaml:"secret_access_key"`
+	Timeout         Duration `json:"timeout" yaml:"timeout"`
+}
+
+// HighWaterMarkConfig holds the file with the highest signature counter of
+// every device. It must be kept apart from the device store and its backups,
+// so that a restore cannot roll it back. Without a file, the marks are only
+// kept in memory, which is refused when backups are enabled. Changing it
+// requires a restart.
+type HighWaterMarkConfig struct {
+	File string `json:"file" yaml:"file"`
+}
+
+// Duration is a time.Duration that is read from strings such as "5s" or "1m30s".
+type Duration time.Duration
+
+// UnmarshalText parses a duration string.
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalText formats the duration as a string.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// Default returns the configuration used when no file or environment overrides are given.
+func Default() *Config {
+	return &Config{
+		Server: ServerConfig{
+			ListenAddress:     ":8080",
+			GRPCListenAddress: ":9090",
+			ReadTimeout:       Duration(10 * time.Second),
+			WriteTimeout:      Duration(10 * time.Second),
+			IdleTimeout:       Duration(60 * time.Second),
+			ShutdownTimeout:   Duration(5 * time.Second),
+			ErrorFormat:       ErrorFormatEnvelope,
+		},
+		Storage: StorageConfig{
+			Backend: StorageMemory,
+		},
+		Limits: LimitsConfig{
+			MaxRequestBodyBytes: 1 << 20,
+			MaxStreamBytes:      1 << 30,
+		},
+		Tracing: TracingConfig{
+			Exporter:    TracingNone,
+			ServiceName: "signing-service",
+			SampleRatio: 1,
+		},
+		Logging: LoggingConfig{
+			Level:  "info",
+			Format: LogFormatJSON,
+		},
+		Audit: AuditConfig{
+			CheckpointInterval: 100,
+			CheckpointPeriod:   Duration(time.Minute),
+		},
+		CA: CAConfig{
+			Validity: Duration(365 * 24 * time.Hour),
+		},
+		Timestamp: TimestampConfig{
+			Authority: TimestampNone,
+			Timeout:   Duration(10 * time.Second),
+		},
+		Backup: BackupConfig{
+			Region:  "us-east-1",
+			Timeout: Duration(time.Minute),
+		},
+	}
+}
+
+// Validate checks the configuration and returns all problems found, joined into a single error.
+func (c *Config) Validate() error {
+	var errs []error
+	invalid := func(field, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if strings.TrimSpace(c.Server.ListenAddress) == "" {
+		invalid("server.listen_address", "must not be empty")
+	}
+	if c.Server.TLS.Enabled {
+		if c.Server.TLS.CertFile == "" {
+			invalid("server.tls.cert_file", "is required when TLS is enabled")
+		}
+		if c.Server.TLS.KeyFile == "" {
+			invalid("server.tls.key_file", "is required when TLS is enabled")
+		}
+	}
+	if c.Server.ReadTimeout < 0 {
+		invalid("server.read_timeout", "must not be negative")
+	}
+	if c.Server.WriteTimeout < 0 {
+		invalid("server.write_timeout", "must not be negative")
+	}
+	if c.Server.IdleTimeout < 0 {
+		invalid("server.idle_timeout", "must not be negative")
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		invalid("server.shutdown_timeout", "must be positive")
+	}
+	switch c.Server.ErrorFormat {
+	case ErrorFormatEnvelope, ErrorFormatProblem:
+	default:
+		invalid("server.error_format", "unsupported format %q (supported: %s, %s)", c.Server.ErrorFormat, ErrorFormatEnvelope, ErrorFormatProblem)
+	}
+
+	switch c.Storage.Backend {
+	case StorageMemory:
+	default:
+		invalid("storage.backend", "unsupported backend %q (supported: %s)", c.Storage.Backend, StorageMemory)
+	}
+
+	switch strings.ToUpper(c.Keys.DefaultAlgorithm) {
+	case "", "RSA", "ECC":
+	default:
+		invalid("keys.default_algorithm", "unsupported algorithm %q (supported: RSA, ECC)", c.Keys.DefaultAlgorithm)
+	}
+
+	if c.Limits.MaxRequestBodyBytes <= 0 {
+		invalid("limits.max_request_body_bytes", "must be positive")
+	}
+	if c.Limits.MaxStreamBytes <= 0 {
+		invalid("limits.max_stream_bytes", "must be positive")
+	}
+	if c.Limits.MaxDevices < 0 {
+		invalid("limits.max_devices", "must not be negative")
+	}
+
+	switch c.Tracing.Exporter {
+	case TracingNone:
+	case TracingOTLP:
+		if c.Tracing.Endpoint == "" {
+			invalid("tracing.endpoint", "is required for the %s exporter", TracingOTLP)
+		}
+	case TracingFile:
+		if c.Tracing.FilePath == "" {
+			invalid("tracing.file_path", "is required for the %s exporter", TracingFile)
+		}
+	default:
+		invalid("tracing.exporter", "unsupported exporter %q (supported: %s, %s, %s)", c.Tracing.Exporter, TracingNone, TracingOTLP, TracingFile)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		invalid("tracing.sample_ratio", "must be between 0 and 1")
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Logging.Level)); err != nil {
+		invalid("logging.level", "unsupported level %q (supported: debug, info, warn, error)", c.Logging.Level)
+	}
+	switch c.Logging.Format {
+	case LogFormatJSON, LogFormatText:
+	default:
+		invalid("logging.format", "unsupported format %q (supported: %s, %s)", c.Logging.Format, LogFormatJSON, LogFormatText)
+	}
+
+	if c.Audit.CheckpointInterval == 0 {
+		invalid("audit.checkpoint_interval", "must be positive")
+	}
+	if c.Audit.CheckpointPeriod <= 0 {
+		invalid("audit.checkpoint_period", "must be positive")
+	}
+
+	if (c.CA.CertFile == "") != (c.CA.KeyFile == "") {
+		invalid("ca", "cert_file and key_file must be given together")
+	}
+	if c.CA.Validity <= 0 {
+		invalid("ca.validity", "must be positive")
+	}
+
+	switch c.Timestamp.Authority {
+	case TimestampNone, TimestampLocal:
+	case TimestampRemote:
+		if c.Timestamp.URL == "" {
+			invalid("timestamp.url", "is required for the %s authority", TimestampRemote)
+		}
+	default:
+		invalid("timestamp.authority", "unsupported authority %q (supported: %s, %s, %s)", c.Timestamp.Authority, TimestampNone, TimestampLocal, TimestampRemote)
+	}
+	if c.Timestamp.Timeout <= 0 {
+		invalid("timestamp.timeout", "must be positive")
+	}
+
+	if c.Migration.KeyFile != "" && c.Migration.SigningKeyFile == "" {
+		invalid("migration.signing_key_file", "is required when a migration key is set")
+	}
+
+	if c.Backup.Target != "" {
+		if c.Backup.KeyFile == "" {
+			invalid("backup.key_file", "is required when a backup target is set")
+		}
+		if strings.HasPrefix(c.Backup.Target, "s3://") && c.Backup.Endpoint == "" {
+			invalid("backup.endpoint", "is required for s3:// targets")
+		}
+		if c.HighWaterMark.File == "" {
+			// Without persisted marks, a restore after a restart could issue counters again.
+			invalid("high_water_mark.file", "is required when a backup target is set")
+		}
+	}
+	if c.Backup.Interval < 0 {
+		invalid("backup.interval", "must not be negative")
+	}
+	if c.Backup.Timeout <= 0 {
+		invalid("backup.timeout", "must be positive")
+	}
+
+	return errors.Join(errs...)
+}
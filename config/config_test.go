@@ -0,0 +1,207 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	return path
+}
+
+func noEnv(string) (string, bool) {
+	return "", false
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load("", noEnv)
+	if err != nil {
+		t.Fatalf("Failed to load default config: %v", err)
+	}
+	if cfg.Server.ListenAddress != ":8080" {
+		t.Errorf("Expected listen address to be ':8080', got %s", cfg.Server.ListenAddress)
+	}
+	if cfg.Storage.Backend != StorageMemory {
+		t.Errorf("Expected storage backend to be %s, got %s", StorageMemory, cfg.Storage.Backend)
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	yamlPath := writeFile(t, "config.yaml", `
+server:
+  listen_address: ":9090"
+  read_timeout: 3s
+keys:
+  default_algorithm: ECC
+limits:
+  max_devices: 10
+`)
+	cfg, err := load(yamlPath, noEnv)
+	if err != nil {
+		t.Fatalf("Failed to load YAML config: %v", err)
+	}
+	if cfg.Server.ListenAddress != ":9090" {
+		t.Errorf("Expected listen address to be ':9090', got %s", cfg.Server.ListenAddress)
+	}
+	if time.Duration(cfg.Server.ReadTimeout) != 3*time.Second {
+		t.Errorf("Expected read timeout to be 3s, got %v", time.Duration(cfg.Server.ReadTimeout))
+	}
+	if cfg.Keys.DefaultAlgorithm != "ECC" {
+		t.Errorf("Expected default algorithm to be ECC, got %s", cfg.Keys.DefaultAlgorithm)
+	}
+	if cfg.Limits.MaxDevices != 10 {
+		t.Errorf("Expected max devices to be 10, got %d", cfg.Limits.MaxDevices)
+	}
+
+	jsonPath := writeFile(t, "config.json", `{"server": {"listen_address": ":7070", "idle_timeout": "2m"}}`)
+	cfg, err = load(jsonPath, noEnv)
+	if err != nil {
+		t.Fatalf("Failed to load JSON config: %v", err)
+	}
+	if cfg.Server.ListenAddress != ":7070" {
+		t.Errorf("Expected listen address to be ':7070', got %s", cfg.Server.ListenAddress)
+	}
+	if time.Duration(cfg.Server.IdleTimeout) != 2*time.Minute {
+		t.Errorf("Expected idle timeout to be 2m, got %v", time.Duration(cfg.Server.IdleTimeout))
+	}
+
+	unknownPath := writeFile(t, "config.yaml", "server:\n  listen_adress: \":1\"\n")
+	if _, err := load(unknownPath, noEnv); err == nil {
+		t.Errorf("Expected error for unknown config field")
+	}
+
+	tomlPath := writeFile(t, "config.toml", "")
+	if _, err := load(tomlPath, noEnv); err == nil {
+		t.Errorf("Expected error for unsupported file extension")
+	}
+}
+
+func TestLoadEnvOverrides(t *testing.T) {
+	path := writeFile(t, "config.yaml", "server:\n  listen_address: \":9090\"\n")
+	env := map[string]string{
+		"SIGNING_LISTEN_ADDRESS":              ":6060",
+		"SIGNING_MAX_DEVICES":                 "5",
+		"SIGNING_WRITE_TIMEOUT":               "1s",
+		"SIGNING_MIGRATION_TRUSTED_KEY_FILES": "staging.pem,production.pem",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	cfg, err := load(path, lookupEnv)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Server.ListenAddress != ":6060" {
+		t.Errorf("Expected environment to override listen address, got %s", cfg.Server.ListenAddress)
+	}
+	if cfg.Limits.MaxDevices != 5 {
+		t.Errorf("Expected max devices to be 5, got %d", cfg.Limits.MaxDevices)
+	}
+	if time.Duration(cfg.Server.WriteTimeout) != time.Second {
+		t.Errorf("Expected write timeout to be 1s, got %v", time.Duration(cfg.Server.WriteTimeout))
+	}
+	if files := cfg.Migration.TrustedKeyFiles; len(files) != 2 || files[0] != "staging.pem" || files[1] != "production.pem" {
+		t.Errorf("Expected two trusted key files, got %q", files)
+	}
+
+	env["SIGNING_MAX_DEVICES"] = "many"
+	if _, err := load(path, lookupEnv); err == nil || !strings.Contains(err.Error(), "SIGNING_MAX_DEVICES") {
+		t.Errorf("Expected error naming SIGNING_MAX_DEVICES, got %v", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Default()
+	cfg.Server.ListenAddress = ""
+	cfg.Server.TLS.Enabled = true
+	cfg.Storage.Backend = "postgres"
+	cfg.Server.ErrorFormat = "xml"
+	cfg.Keys.DefaultAlgorithm = "DSA"
+	cfg.CA.CertFile = "ca.pem"
+	cfg.CA.Validity = 0
+	cfg.Timestamp.Authority = TimestampRemote
+	cfg.Timestamp.Timeout = 0
+	cfg.Limits.MaxStreamBytes = 0
+	cfg.Backup.Target = "s3://backups"
+	cfg.Migration.KeyFile = "migration.key"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatalf("Expected validation error")
+	}
+	for _, field := range []string{
+		"server.listen_address",
+		"server.tls.cert_file",
+		"server.tls.key_file",
+		"server.error_format",
+		"storage.backend",
+		"keys.default_algorithm",
+		"ca: cert_file and key_file",
+		"ca.validity",
+		"timestamp.url",
+		"timestamp.timeout",
+		"limits.max_stream_bytes",
+		"migration.signing_key_file",
+		"backup.key_file",
+		"backup.endpoint",
+		"high_water_mark.file",
+	} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected validation error to mention %s, got %v", field, err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := writeFile(t, "config.yaml", "limits:\n  max_devices: 1\n")
+	initial, err := load(path, noEnv)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	reloader := NewReloader(path, initial)
+	reloader.lookupEnv = noEnv
+
+	var notified *Config
+	reloader.OnReload(func(cfg *Config) {
+		notified = cfg
+	})
+
+	if err := os.WriteFile(path, []byte("server:\n  listen_address: \":9999\"\nlimits:\n  max_devices: 2\n"), 0o600); err != nil {
+		t.Fatalf("Failed to rewrite config file: %v", err)
+	}
+	if err := reloader.Reload(); err != nil {
+		t.Fatalf("Failed to reload config: %v", err)
+	}
+
+	current := reloader.Current()
+	if current.Limits.MaxDevices != 2 {
+		t.Errorf("Expected max devices to be reloaded to 2, got %d", current.Limits.MaxDevices)
+	}
+	if current.Server.ListenAddress != ":8080" {
+		t.Errorf("Expected listen address to keep its startup value, got %s", current.Server.ListenAddress)
+	}
+	if notified != current {
+		t.Errorf("Expected reload listener to receive the current config")
+	}
+
+	if err := os.WriteFile(path, []byte("limits:\n  max_devices: -1\n"), 0o600); err != nil {
+		t.Fatalf("Failed to rewrite config file: %v", err)
+	}
+	if err := reloader.Reload(); err == nil {
+		t.Errorf("Expected error when reloading invalid config")
+	}
+	if reloader.Current().Limits.MaxDevices != 2 {
+		t.Errorf("Expected previous config to stay active after a failed reload")
+	}
+}
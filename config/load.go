@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is prepended to every environment variable that overrides a config value.
+const EnvPrefix = "SIGNING_"
+
+// Load builds the configuration from the defaults, the optional file at path
+// and environment overrides, in that order, and validates the result.
+func Load(path string) (*Config, error) {
+	return load(path, os.LookupEnv)
+}
+
+func load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Default()
+
+	if path != "" {
+		if err := cfg.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(lookupEnv); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) readFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(c); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+	default:
+		return fmt.Errorf("unsupported config file extension %q (supported: .json, .yaml, .yml)", filepath.Ext(path))
+	}
+
+	return nil
+}
+
+// envBinding maps an environment variable (without EnvPrefix) to a config field.
+type envBinding struct {
+	name  string
+	apply func(c *Config, value string) error
+}
+
+var envBindings = []envBinding{
+	{"LISTEN_ADDRESS", func(c *Config, v string) error { c.Server.ListenAddress = v; return nil }},
+	{"GRPC_LISTEN_ADDRESS", func(c *Config, v string) error { c.Server.GRPCListenAddress = v; return nil }},
+	{"TLS_ENABLED", func(c *Config, v string) error { return parseBool(v, &c.Server.TLS.Enabled) }},
+	{"TLS_CERT_FILE", func(c *Config, v string) error { c.Server.TLS.CertFile = v; return nil }},
+	{"TLS_KEY_FILE", func(c *Config, v string) error { c.Server.TLS.KeyFile = v; return nil }},
+	{"READ_TIMEOUT", func(c *Config, v string) error { return c.Server.ReadTimeout.UnmarshalText([]byte(v)) }},
+	{"WRITE_TIMEOUT", func(c *Config, v string) error { return c.Server.WriteTimeout.UnmarshalText([]byte(v)) }},
+	{"IDLE_TIMEOUT", func(c *Config, v string) error { return c.Server.IdleTimeout.UnmarshalText([]byte(v)) }},
+	{"SHUTDOWN_TIMEOUT", func(c *Config, v string) error { return c.Server.ShutdownTimeout.UnmarshalText([]byte(v)) }},
+	{"ERROR_FORMAT", func(c *Config, v string) error { c.Server.ErrorFormat = v; return nil }},
+	{"STORAGE_BACKEND", func(c *Config, v string) error { c.Storage.Backend = v; return nil }},
+	{"DEFAULT_ALGORITHM", func(c *Config, v string) error { c.Keys.DefaultAlgorithm = v; return nil }},
+	{"MAX_REQUEST_BODY_BYTES", func(c *Config, v string) error { return parseInt64(v, &c.Limits.MaxRequestBodyBytes) }},
+	{"MAX_STREAM_BYTES", func(c *Config, v string) error { return parseInt64(v, &c.Limits.MaxStreamBytes) }},
+	{"MAX_DEVICES", func(c *Config, v string) error {
+		var n int64
+		if err := parseInt64(v, &n); err != nil {
+			return err
+		}
+		c.Limits.MaxDevices = int(n)
+		return nil
+	}},
+	{"TRACING_EXPORTER", func(c *Config, v string) error { c.Tracing.Exporter = v; return nil }},
+	{"TRACING_ENDPOINT", func(c *Config, v string) error { c.Tracing.Endpoint = v; return nil }},
+	{"TRACING_INSECURE", func(c *Config, v string) error { return parseBool(v, &c.Tracing.Insecure) }},
+	{"TRACING_FILE_PATH", func(c *Config, v string) error { c.Tracing.FilePath = v; return nil }},
+	{"TRACING_SERVICE_NAME", func(c *Config, v string) error { c.Tracing.ServiceName = v; return nil }},
+	{"TRACING_SAMPLE_RATIO", func(c *Config, v string) error {
+		ratio, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		c.Tracing.SampleRatio = ratio
+		return nil
+	}},
+	{"LOG_LEVEL", func(c *Config, v string) error { c.Logging.Level = v; return nil }},
+	{"LOG_FORMAT", func(c *Config, v string) error { c.Logging.Format = v; return nil }},
+	{"AUDIT_FILE", func(c *Config, v string) error { c.Audit.File = v; return nil }},
+	{"AUDIT_SIGNING_KEY_FILE", func(c *Config, v string) error { c.Audit.SigningKeyFile = v; return nil }},
+	{"AUDIT_CHECKPOINT_INTERVAL", func(c *Config, v string) error {
+		interval, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return err
+		}
+		c.Audit.CheckpointInterval = interval
+		return nil
+	}},
+	{"AUDIT_CHECKPOINT_PERIOD", func(c *Config, v string) error { return c.Audit.CheckpointPeriod.UnmarshalText([]byte(v)) }},
+	{"CA_CERT_FILE", func(c *Config, v string) error { c.CA.CertFile = v; return nil }},
+	{"CA_KEY_FILE", func(c *Config, v string) error { c.CA.KeyFile = v; return nil }},
+	{"CA_VALIDITY", func(c *Config, v string) error { return c.CA.Validity.UnmarshalText([]byte(v)) }},
+	{"TIMESTAMP_AUTHORITY", func(c *Config, v string) error { c.Timestamp.Authority = v; return nil }},
+	{"TIMESTAMP_URL", func(c *Config, v string) error { c.Timestamp.URL = v; return nil }},
+	{"TIMESTAMP_TIMEOUT", func(c *Config, v string) error { return c.Timestamp.Timeout.UnmarshalText([]byte(v)) }},
+	{"MIGRATION_KEY_FILE", func(c *Config, v string) error { c.Migration.KeyFile = v; return nil }},
+	{"MIGRATION_SIGNING_KEY_FILE", func(c *Config, v string) error { c.Migration.SigningKeyFile = v; return nil }},
+	{"MIGRATION_TRUSTED_KEY_FILES", func(c *Config, v string) error { c.Migration.TrustedKeyFiles = strings.Split(v, ","); return nil }},
+	{"BACKUP_TARGET", func(c *Config, v string) error { c.Backup.Target = v; return nil }},
+	{"BACKUP_KEY_FILE", func(c *Config, v string) error { c.Backup.KeyFile = v; return nil }},
+	{"BACKUP_INTERVAL", func(c *Config, v string) error { return c.Backup.Interval.UnmarshalText([]byte(v)) }},
+	{"BACKUP_ENDPOINT", func(c *Config, v string) error { c.Backup.Endpoint = v; return nil }},
+	{"BACKUP_REGION", func(c *Config, v string) error { c.Backup.Region = v; return nil }},
+	{"BACKUP_ACCESS_KEY_ID", func(c *Config, v string) error { c.Backup.AccessKeyID = v; return nil }},
+	{"BACKUP_SECRET_ACCESS_KEY", func(c *Config, v string) error { c.Backup.SecretAccessKey = v; return nil }},
+	{"BACKUP_TIMEOUT", func(c *Config, v string) error { return c.Backup.Timeout.UnmarshalText([]byte(v)) }},
+	{"HIGH_WATER_MARK_FILE", func(c *Config, v string) error { c.HighWaterMark.File = v; return nil }},
+}
+
+func (c *Config) applyEnv(lookupEnv func(string) (string, bool)) error {
+	for _, binding := range envBindings {
+		value, ok := lookupEnv(EnvPrefix + binding.name)
+		if !ok {
+			continue
+		}
+		if err := binding.apply(c, value); err != nil {
+			return fmt.Errorf("invalid value for %s%s: %w", EnvPrefix, binding.name, err)
+		}
+	}
+	return nil
+}
+
+func parseBool(value string, target *bool) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
+
+func parseInt64(value string, target *int64) error {
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Reloader keeps the current configuration and re-reads it on demand.
+// Only the key defaults, limits and log level are applied on reload; all other
+// settings keep their startup values until the process is restarted.
+type Reloader struct {
+	path      string
+	lookupEnv func(string) (string, bool)
+
+	mu        sync.RWMutex
+	current   *Config
+	listeners []func(*Config)
+}
+
+// NewReloader creates a Reloader for the config file at path, starting from initial.
+func NewReloader(path string, initial *Config) *Reloader {
+	return &Reloader{
+		path:      path,
+		lookupEnv: os.LookupEnv,
+		current:   initial,
+	}
+}
+
+// Current returns the active configuration.
+func (r *Reloader) Current() *Config {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.current
+}
+
+// OnReload registers a function that is called with the new configuration after each successful reload.
+func (r *Reloader) OnReload(listener func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.listeners = append(r.listeners, listener)
+}
+
+// Reload re-reads the configuration. If the new configuration is invalid the
+// current one stays active and the error is returned.
+func (r *Reloader) Reload() error {
+	loaded, err := load(r.path, r.lookupEnv)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	next := *r.current
+	next.Keys = loaded.Keys
+	next.Limits = loaded.Limits
+	next.Logging.Level = loaded.Logging.Level
+
+	if loaded.Server != r.current.Server || loaded.Storage != r.current.Storage ||
+		loaded.Tracing != r.current.Tracing || loaded.Logging.Format != r.current.Logging.Format ||
+		loaded.Audit != r.current.Audit {
+		slog.Warn("Ignoring changed settings that require a restart", "reloadable", "keys, limits, logging.level")
+	}
+
+	r.current = &next
+	listeners := make([]func(*Config), len(r.listeners))
+	copy(listeners, r.listeners)
+	r.mu.Unlock()
+
+	for _, listener := range listeners {
+		listener(&next)
+	}
+
+	return nil
+}
+
+// WatchSignals reloads the configuration whenever the process receives SIGHUP,
+// until ctx is cancelled.
+func (r *Reloader) WatchSignals(ctx context.Context) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signals:
+				if err := r.Reload(); err != nil {
+					slog.Error("Could not reload configuration, keeping the previous one", "error", err)
+					continue
+				}
+				slog.Info("Configuration reloaded")
+			}
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package crypto
+
+import (
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE header labels and algorithm identifiers (RFC 9052, RFC 9053, RFC 8812).
+const (
+	COSEHeaderAlgorithm = 1
+	COSEHeaderKeyID     = 4
+
+	COSEAlgorithmES384 = -35
+	COSEAlgorithmRS256 = -257
+)
+
+// coseSign1Tag is the CBOR tag of a COSE_Sign1 structure.
+const coseSign1Tag = 18
+
+// coseEncoding produces deterministic CBOR, so that protected headers are
+// serialized the same way every time.
+var coseEncoding, _ = cbor.CoreDetEncOptions().EncMode()
+
+// SignCOSE1 returns a tagged COSE_Sign1 structure (RFC 9052, section 4.2) over
+// payload with the given protected header, which must contain the algorithm
+// implemented by signer. The payload is embedded and no external data is used.
+func SignCOSE1(signer Signer, protected map[interface{}]interface{}, payload []byte) ([]byte, error) {
+	encodedProtected, err := coseEncoding.Marshal(protected)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode COSE header: %w", err)
+	}
+
+	toBeSigned, err := coseEncoding.Marshal([]interface{}{"Signature1", encodedProtected, []byte{}, payload})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode COSE Sig_structure: %w", err)
+	}
+	signature, err := signer.Sign(toBeSigned)
+	if err != nil {
+		return nil, err
+	}
+
+	return coseEncoding.Marshal(cbor.Tag{
+		Number:  coseSign1Tag,
+		Content: []interface{}{encodedProtected, map[interface{}]interface{}{}, payload, signature},
+	})
+}
@@ -68,6 +68,24 @@ func (m ECCMarshaler) Unmarshal(privateKeyBytes []byte) (*ECCKeyPair, error) {
 	}, nil
 }
 
+// UnmarshalPublic decodes an encoded ECC public key.
+func (m ECCMarshaler) UnmarshalPublic(publicKeyBytes []byte) (*ecdsa.PublicKey, error) {
+	block, _ := pem.Decode(publicKeyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	publicKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key is not an ECC key")
+	}
+
+	return publicKey, nil
+}
+
 type ECDSASignature struct {
 	R, S *big.Int
 }
@@ -95,3 +113,21 @@ func (s *ECCSigner) Sign(dataToBeSigned []byte) ([]byte, error) {
 
 	return signature, nil
 }
+
+type ECCVerifier struct {
+	PublicKey *ecdsa.PublicKey
+}
+
+func (v *ECCVerifier) Verify(signedData []byte, signature []byte) error {
+	if v.PublicKey == nil {
+		return fmt.Errorf("public key is nil")
+	}
+
+	hash := sha256.Sum256(signedData)
+
+	if !ecdsa.VerifyASN1(v.PublicKey, hash[:], signature) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	return nil
+}
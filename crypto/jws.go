@@ -0,0 +1,52 @@
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// ES384Signer signs with ECDSA P-384 and SHA-384 and encodes the signature as
+// the fixed-size R || S octets required by JWS (RFC 7518, section 3.4).
+type ES384Signer struct {
+	PrivateKey *ecdsa.PrivateKey
+}
+
+func (s *ES384Signer) Sign(dataToBeSigned []byte) ([]byte, error) {
+	if s.PrivateKey == nil {
+		return nil, fmt.Errorf("private key is nil")
+	}
+
+	hash := sha512.Sum384(dataToBeSigned)
+
+	r, ss, err := ecdsa.Sign(rand.Reader, s.PrivateKey, hash[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign data: %w", err)
+	}
+
+	size := (s.PrivateKey.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	r.FillBytes(signature[:size])
+	ss.FillBytes(signature[size:])
+	return signature, nil
+}
+
+// SignJWS returns the JWS Compact Serialization (RFC 7515) of payload with the
+// given protected header. The signer must implement the algorithm named by the
+// header's alg member.
+func SignJWS(signer Signer, header interface{}, payload []byte) (string, error) {
+	encodedHeader, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode JWS header: %w", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(encodedHeader) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	signature, err := signer.Sign([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
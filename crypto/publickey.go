@@ -0,0 +1,161 @@
+package crypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+)
+
+// ParsePublicKey decodes a PEM encoded RSA or ECC public key. It accepts the
+// PKCS#1 and PKIX encodings stored for devices as well as standard PKIX PEM.
+func ParsePublicKey(encoded []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing public key")
+	}
+	if key, err := x509.ParsePKIXPublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return key, nil
+}
+
+// MarshalPublicKeyDER encodes key as a DER SubjectPublicKeyInfo (PKIX).
+func MarshalPublicKeyDER(key crypto.PublicKey) ([]byte, error) {
+	return x509.MarshalPKIXPublicKey(key)
+}
+
+// MarshalPublicKeyPEM encodes key as a standard "PUBLIC KEY" PEM block.
+func MarshalPublicKeyPEM(key crypto.PublicKey) ([]byte, error) {
+	der, err := MarshalPublicKeyDER(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// JWK is a JSON Web Key (RFC 7517) holding an RSA or EC public key.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// RSA parameters.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC parameters.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// NewJWK returns the JWK representation of key with the given key ID.
+func NewJWK(key crypto.PublicKey, keyID string) (*JWK, error) {
+	encode := base64.RawURLEncoding.EncodeToString
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			KeyType: "RSA",
+			KeyID:   keyID,
+			N:       encode(key.N.Bytes()),
+			E:       encode(big.NewInt(int64(key.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		curve, err := curveName(key.Curve)
+		if err != nil {
+			return nil, err
+		}
+		point, err := uncompressedPoint(key)
+		if err != nil {
+			return nil, err
+		}
+		size := (len(point) - 1) / 2
+		return &JWK{
+			KeyType: "EC",
+			KeyID:   keyID,
+			Curve:   curve,
+			X:       encode(point[1 : 1+size]),
+			Y:       encode(point[1+size:]),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+}
+
+// MarshalOpenSSHPublicKey encodes key in the authorized_keys format
+// ("ssh-rsa AAAA... comment"), with an optional comment.
+func MarshalOpenSSHPublicKey(key crypto.PublicKey, comment string) ([]byte, error) {
+	var keyType string
+	var blob []byte
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		keyType = "ssh-rsa"
+		blob = appendSSHString(blob, []byte(keyType))
+		blob = appendSSHString(blob, sshMPInt(big.NewInt(int64(key.E))))
+		blob = appendSSHString(blob, sshMPInt(key.N))
+	case *ecdsa.PublicKey:
+		curve, err := curveName(key.Curve)
+		if err != nil {
+			return nil, err
+		}
+		point, err := uncompressedPoint(key)
+		if err != nil {
+			return nil, err
+		}
+		identifier := map[string]string{"P-256": "nistp256", "P-384": "nistp384", "P-521": "nistp521"}[curve]
+		keyType = "ecdsa-sha2-" + identifier
+		blob = appendSSHString(blob, []byte(keyType))
+		blob = appendSSHString(blob, []byte(identifier))
+		blob = appendSSHString(blob, point)
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+
+	line := keyType + " " + base64.StdEncoding.EncodeToString(blob)
+	if comment != "" {
+		line += " " + comment
+	}
+	return []byte(line + "\n"), nil
+}
+
+func curveName(curve elliptic.Curve) (string, error) {
+	switch curve {
+	case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		return curve.Params().Name, nil
+	default:
+		return "", fmt.Errorf("unsupported curve %s", curve.Params().Name)
+	}
+}
+
+// uncompressedPoint returns the SEC 1 encoding 0x04 || X || Y of key.
+func uncompressedPoint(key *ecdsa.PublicKey) ([]byte, error) {
+	ecdhKey, err := key.ECDH()
+	if err != nil {
+		return nil, fmt.Errorf("invalid ECC public key: %w", err)
+	}
+	return ecdhKey.Bytes(), nil
+}
+
+func appendSSHString(b []byte, s []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// sshMPInt encodes a non-negative integer as an SSH mpint (RFC 4251).
+func sshMPInt(n *big.Int) []byte {
+	b := n.Bytes()
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		b = append([]byte{0}, b...)
+	}
+	return b
+}
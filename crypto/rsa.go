@@ -60,6 +60,20 @@ func (m *RSAMarshaler) Unmarshal(privateKeyBytes []byte) (*RSAKeyPair, error) {
 	}, nil
 }
 
+// UnmarshalPublic decodes an encoded RSA public key.
+func (m *RSAMarshaler) UnmarshalPublic(publicKeyBytes []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(publicKeyBytes)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode PEM block containing public key")
+	}
+	publicKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key: %w", err)
+	}
+
+	return publicKey, nil
+}
+
 type RSASigner struct {
 	PrivateKey *rsa.PrivateKey
 }
@@ -78,3 +92,21 @@ func (s *RSASigner) Sign(dataToBeSigned []byte) ([]byte, error) {
 
 	return signature, nil
 }
+
+type RSAVerifier struct {
+	PublicKey *rsa.PublicKey
+}
+
+func (v *RSAVerifier) Verify(signedData []byte, signature []byte) error {
+	if v.PublicKey == nil {
+		return fmt.Errorf("public key is nil")
+	}
+
+	hash := sha256.Sum256(signedData)
+
+	if err := rsa.VerifyPKCS1v15(v.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+
+	return nil
+}
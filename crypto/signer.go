@@ -5,4 +5,9 @@ type Signer interface {
 	Sign(dataToBeSigned []byte) ([]byte, error)
 }
 
+// Verifier defines a contract for checking signatures created by a Signer.
+type Verifier interface {
+	Verify(signedData []byte, signature []byte) error
+}
+
 // TODO: implement RSA and ECDSA signing ...
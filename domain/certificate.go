@@ -0,0 +1,94 @@
+package domain
+
+import (
+	"bytes"
+	stdcrypto "crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+// ErrCertificateMismatch is returned when a certificate does not certify the
+// current key of a device.
+var ErrCertificateMismatch = errors.New("certificate does not match the device key")
+
+// ErrKeyMismatch is returned when the private key of a device does not belong
+// to its public key.
+var ErrKeyMismatch = errors.New("private key does not match the public key")
+
+// CertificateRequest returns a DER-encoded PKCS#10 certificate signing request
+// for the current key of the device, signed with that key. RSA devices sign
+// with SHA-256 and ECC devices with SHA-384.
+func (d *SignatureDevice) CertificateRequest(subject pkix.Name) ([]byte, error) {
+	key, err := d.privateKey()
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: subject}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	return csr, nil
+}
+
+// Certifies reports whether certificate was issued for the current key of the device.
+func (d *SignatureDevice) Certifies(certificate *x509.Certificate) bool {
+	spki, err := d.ExportPublicKey(PublicKeyDER)
+	return err == nil && bytes.Equal(spki, certificate.RawSubjectPublicKeyInfo)
+}
+
+// CheckCertificate returns ErrCertificateMismatch unless certificate certifies
+// the current key of the device.
+func (d *SignatureDevice) CheckCertificate(certificate *x509.Certificate) error {
+	if !d.Certifies(certificate) {
+		return fmt.Errorf("%w: certificate %s is for another key", ErrCertificateMismatch, certificate.SerialNumber.Text(16))
+	}
+	return nil
+}
+
+// equalPublicKey is implemented by the RSA and ECDSA public keys.
+type equalPublicKey interface {
+	Equal(stdcrypto.PublicKey) bool
+}
+
+// CheckKeyPair returns ErrKeyMismatch unless the private key of the device
+// belongs to its public key.
+func (d *SignatureDevice) CheckKeyPair() error {
+	key, err := d.privateKey()
+	if err != nil {
+		return err
+	}
+	publicKey, err := crypto.ParsePublicKey(d.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+	if public, ok := publicKey.(equalPublicKey); !ok || !public.Equal(key.Public()) {
+		return ErrKeyMismatch
+	}
+	return nil
+}
+
+// privateKey returns the private key of the device as a standard library signer.
+func (d *SignatureDevice) privateKey() (stdcrypto.Signer, error) {
+	switch d.Algorithm {
+	case RSA:
+		marshaler := crypto.NewRSAMarshaler()
+		keyPair, err := marshaler.Unmarshal(d.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal RSA key pair: %w", err)
+		}
+		return keyPair.Private, nil
+	case ECC:
+		keyPair, err := crypto.NewECCMarshaler().Unmarshal(d.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ECC key pair: %w", err)
+		}
+		return keyPair.Private, nil
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, d.Algorithm)
+	}
+}
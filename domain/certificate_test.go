@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestCertificateRequest(t *testing.T) {
+	for _, test := range []struct {
+		algorithm SignatureAlgorithm
+		signature x509.SignatureAlgorithm
+	}{
+		{RSA, x509.SHA256WithRSA},
+		{ECC, x509.ECDSAWithSHA384},
+	} {
+		device, err := NewSignatureDevice(uuid.New().String(), test.algorithm, "CSR")
+		if err != nil {
+			t.Fatalf("Failed to create %s device: %v", test.algorithm, err)
+		}
+		der, err := device.CertificateRequest(pkix.Name{CommonName: "CSR"})
+		if err != nil {
+			t.Fatalf("%s: failed to create certificate request: %v", test.algorithm, err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatalf("%s: invalid certificate request: %v", test.algorithm, err)
+		}
+		if err := csr.CheckSignature(); err != nil || csr.SignatureAlgorithm != test.signature {
+			t.Errorf("%s: expected a valid %s signature, got %s: %v", test.algorithm, test.signature, csr.SignatureAlgorithm, err)
+		}
+
+		certificate := &x509.Certificate{RawSubjectPublicKeyInfo: csr.RawSubjectPublicKeyInfo}
+		if err := device.CheckCertificate(certificate); err != nil {
+			t.Errorf("%s: expected the device to match its own key: %v", test.algorithm, err)
+		}
+		other, err := NewSignatureDevice(device.ID, test.algorithm, "CSR")
+		if err != nil {
+			t.Fatalf("Failed to create %s device: %v", test.algorithm, err)
+		}
+		if err := other.CheckCertificate(certificate); !errors.Is(err, ErrCertificateMismatch) {
+			t.Errorf("%s: expected ErrCertificateMismatch for another key, got %v", test.algorithm, err)
+		}
+	}
+}
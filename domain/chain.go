@@ -0,0 +1,99 @@
+package domain
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrBrokenChain is returned by VerifyChain for transaction histories that do
+// not form the signature chain of their device.
+var ErrBrokenChain = errors.New("broken signature chain")
+
+// ChainBase is the point a transaction history continues the signature chain
+// from: the counter of its first transaction and the signature that
+// transaction references.
+type ChainBase struct {
+	Counter       int    `json:"counter"`
+	LastSignature string `json:"last_signature"`
+}
+
+// GenesisBase is the base of the complete history of a device: counter 0,
+// referencing the base64 encoded device ID.
+func GenesisBase(deviceID string) ChainBase {
+	return ChainBase{LastSignature: base64.StdEncoding.EncodeToString([]byte(deviceID))}
+}
+
+// ChainBase returns the base of the transaction journal of the device: its
+// JournalBase, or GenesisBase for a journal that starts at the first signature.
+func (d *SignatureDevice) ChainBase() ChainBase {
+	if d.JournalBase != nil {
+		return *d.JournalBase
+	}
+	return GenesisBase(d.ID)
+}
+
+// VerifyChain checks that transactions are the complete journal of device in
+// signing order: one transaction per counter value from the device's ChainBase
+// on, each signature valid for the device key, each secured data referencing
+// the previous signature, or the base's signature for the first one, and the
+// last signature matching the device's LastSignature.
+func VerifyChain(device *SignatureDevice, transactions []*Transaction) error {
+	base := device.ChainBase()
+	if len(transactions) != device.SignatureCounter-base.Counter {
+		return fmt.Errorf("%w: %d transactions from counter %d for signature counter %d", ErrBrokenChain, len(transactions), base.Counter, device.SignatureCounter)
+	}
+	last, err := VerifyChainFrom(device, base, transactions)
+	if err != nil {
+		return err
+	}
+	if last != device.LastSignature {
+		return fmt.Errorf("%w: the last signature of the device is not in its history", ErrBrokenChain)
+	}
+	return nil
+}
+
+// VerifyChainFrom checks that transactions continue the signature chain of
+// device from base: consecutive counters starting at base.Counter, each
+// signature valid for the device key, each secured data referencing the
+// previous signature, or base.LastSignature for the first one, and signing
+// times that do not decrease. It returns the last signature of the chain,
+// which is base.LastSignature for no transactions.
+func VerifyChainFrom(device *SignatureDevice, base ChainBase, transactions []*Transaction) (string, error) {
+	verifier, err := NewVerifier(device.Algorithm, device.PublicKey)
+	if err != nil {
+		return "", err
+	}
+
+	previous := base.LastSignature
+	var previousSignedAt time.Time
+	for i, transaction := range transactions {
+		decoded, err := DecodeSecuredData(transaction.SignedData)
+		if err != nil {
+			return "", fmt.Errorf("transaction %d: %w", i, err)
+		}
+		counter := base.Counter + i
+		if transaction.Counter != counter || decoded.Counter != counter {
+			return "", fmt.Errorf("%w: transaction %d has counter %d, expected %d", ErrBrokenChain, i, decoded.Counter, counter)
+		}
+		if decoded.LastSignature != previous {
+			return "", fmt.Errorf("%w: transaction %d does not reference the previous signature", ErrBrokenChain, i)
+		}
+		if decoded.SignedAt.Before(previousSignedAt) {
+			return "", fmt.Errorf("%w: transaction %d was signed at %s, before the previous signature", ErrBrokenChain, i, decoded.SignedAt.Format(SigningTimeLayout))
+		}
+		signature, err := base64.StdEncoding.DecodeString(transaction.Signature)
+		if err != nil {
+			return "", fmt.Errorf("%w: transaction %d has a malformed signature", ErrBrokenChain, i)
+		}
+		if err := verifier.Verify([]byte(transaction.SignedData), signature); err != nil {
+			return "", fmt.Errorf("%w: transaction %d: %v", ErrBrokenChain, i, err)
+		}
+		if !decoded.SignedAt.IsZero() {
+			previousSignedAt = decoded.SignedAt
+		}
+		previous = transaction.Signature
+	}
+	return previous, nil
+}
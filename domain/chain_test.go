@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestVerifyChain(t *testing.T) {
+	device, err := NewSignatureDevice(uuid.New().String(), ECC, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var transactions []*Transaction
+	for i, data := range []string{"first", "second", "third"} {
+		signature, signedData, err := device.SignTransaction(context.Background(), data)
+		if err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+		transactions = append(transactions, &Transaction{DeviceID: device.ID, Counter: i, Signature: signature, SignedData: signedData})
+	}
+	if err := VerifyChain(device, transactions); err != nil {
+		t.Fatalf("VerifyChain failed: %v", err)
+	}
+	if err := VerifyChain(device, nil); !errors.Is(err, ErrBrokenChain) {
+		t.Error("Expected an empty history of a used device to fail")
+	}
+
+	base := ChainBase{Counter: 1, LastSignature: transactions[0].Signature}
+	if last, err := VerifyChainFrom(device, base, transactions[1:]); err != nil || last != device.LastSignature {
+		t.Errorf("Expected the history to continue from its base, got %v", err)
+	}
+	if _, err := VerifyChainFrom(device, ChainBase{Counter: 1, LastSignature: transactions[1].Signature}, transactions[1:]); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("Expected ErrBrokenChain for another base, got %v", err)
+	}
+
+	// A journal that starts at its base, as for an imported device.
+	imported := device.Clone()
+	imported.JournalBase = &base
+	if err := VerifyChain(imported, transactions[1:]); err != nil {
+		t.Errorf("Expected the journal to be verified from its base, got %v", err)
+	}
+	if err := VerifyChain(imported, transactions); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("Expected ErrBrokenChain for transactions before the base, got %v", err)
+	}
+
+	reordered := []*Transaction{transactions[0], transactions[2], transactions[1]}
+	forged := *transactions[1]
+	forged.SignedData = forged.SignedData[:len(forged.SignedData)-1]
+	for name, history := range map[string][]*Transaction{
+		"missing":   transactions[:2],
+		"reordered": reordered,
+		"forged":    {transactions[0], &forged, transactions[2]},
+	} {
+		if err := VerifyChain(device, history); !errors.Is(err, ErrBrokenChain) {
+			t.Errorf("%s: expected ErrBrokenChain, got %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+// Protected header labels of COSE signatures that carry the position of the
+// signature in the device's chain.
+const (
+	COSEHeaderCounter               = "counter"
+	COSEHeaderPreviousSignatureHash = "prev_sig_sha256"
+)
+
+// SignCOSE signs securedData, created with the given signature counter and
+// previous signature, as a tagged COSE_Sign1 structure. The protected header
+// carries the algorithm (ES384 or RS256), the KeyID, the counter and the
+// SHA-256 hash of the decoded previous signature.
+func (d *SignatureDevice) SignCOSE(securedData string, counter int, previousSignature string) ([]byte, error) {
+	var algorithm int
+	switch d.Algorithm {
+	case RSA:
+		algorithm = crypto.COSEAlgorithmRS256
+	case ECC:
+		algorithm = crypto.COSEAlgorithmES384
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, d.Algorithm)
+	}
+
+	previous, err := base64.StdEncoding.DecodeString(previousSignature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid previous signature: %w", err)
+	}
+	previousHash := sha256.Sum256(previous)
+
+	signer, err := d.standardSigner()
+	if err != nil {
+		return nil, err
+	}
+
+	protected := map[interface{}]interface{}{
+		crypto.COSEHeaderAlgorithm:      algorithm,
+		crypto.COSEHeaderKeyID:          []byte(d.KeyID()),
+		COSEHeaderCounter:               counter,
+		COSEHeaderPreviousSignatureHash: previousHash[:],
+	}
+	return crypto.SignCOSE1(signer, protected, []byte(securedData))
+}
@@ -0,0 +1,102 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"math/big"
+	"testing"
+
+	signingcrypto "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+)
+
+// TestSignCOSE decodes COSE_Sign1 structures and verifies their signatures
+// over the Sig_structure with the standard library.
+func TestSignCOSE(t *testing.T) {
+	for _, test := range []struct {
+		algorithm SignatureAlgorithm
+		coseAlg   int64
+	}{
+		{RSA, signingcrypto.COSEAlgorithmRS256},
+		{ECC, signingcrypto.COSEAlgorithmES384},
+	} {
+		device, err := NewSignatureDevice(uuid.New().String(), test.algorithm, "COSE")
+		if err != nil {
+			t.Fatalf("Failed to create %s device: %v", test.algorithm, err)
+		}
+		previousSignature := device.LastSignature
+		_, securedData, err := device.SignTransaction(context.Background(), "data")
+		if err != nil {
+			t.Fatalf("%s: failed to sign: %v", test.algorithm, err)
+		}
+
+		encoded, err := device.SignCOSE(securedData, 0, previousSignature)
+		if err != nil {
+			t.Fatalf("%s: failed to sign COSE: %v", test.algorithm, err)
+		}
+
+		var tag cbor.RawTag
+		if err := cbor.Unmarshal(encoded, &tag); err != nil || tag.Number != 18 {
+			t.Fatalf("%s: expected a tagged COSE_Sign1, got tag %d: %v", test.algorithm, tag.Number, err)
+		}
+		var message struct {
+			_           struct{} `cbor:",toarray"`
+			Protected   []byte
+			Unprotected map[interface{}]interface{}
+			Payload     []byte
+			Signature   []byte
+		}
+		if err := cbor.Unmarshal(tag.Content, &message); err != nil {
+			t.Fatalf("%s: invalid COSE_Sign1: %v", test.algorithm, err)
+		}
+		if string(message.Payload) != securedData {
+			t.Errorf("%s: expected the payload to be the signed data, got %q", test.algorithm, message.Payload)
+		}
+
+		var header map[interface{}]interface{}
+		if err := cbor.Unmarshal(message.Protected, &header); err != nil {
+			t.Fatalf("%s: invalid protected header: %v", test.algorithm, err)
+		}
+		previous, _ := base64.StdEncoding.DecodeString(previousSignature)
+		previousHash := sha256.Sum256(previous)
+		if header[uint64(signingcrypto.COSEHeaderKeyID)] == nil ||
+			string(header[uint64(signingcrypto.COSEHeaderKeyID)].([]byte)) != device.KeyID() ||
+			header[uint64(signingcrypto.COSEHeaderAlgorithm)] != test.coseAlg ||
+			header[COSEHeaderCounter] != uint64(0) ||
+			!bytes.Equal(header[COSEHeaderPreviousSignatureHash].([]byte), previousHash[:]) {
+			t.Errorf("%s: unexpected protected header %v", test.algorithm, header)
+		}
+
+		toBeSigned, err := cbor.Marshal([]interface{}{"Signature1", message.Protected, []byte{}, message.Payload})
+		if err != nil {
+			t.Fatalf("%s: failed to encode Sig_structure: %v", test.algorithm, err)
+		}
+		key, err := signingcrypto.ParsePublicKey(device.PublicKey)
+		if err != nil {
+			t.Fatalf("%s: failed to parse public key: %v", test.algorithm, err)
+		}
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			hash := sha256.Sum256(toBeSigned)
+			if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], message.Signature); err != nil {
+				t.Errorf("RS256 signature does not verify: %v", err)
+			}
+		case *ecdsa.PublicKey:
+			hash := sha512.Sum384(toBeSigned)
+			if len(message.Signature) != 96 {
+				t.Fatalf("Expected a 96 byte ES384 signature, got %d bytes", len(message.Signature))
+			}
+			r, s := new(big.Int).SetBytes(message.Signature[:48]), new(big.Int).SetBytes(message.Signature[48:])
+			if !ecdsa.Verify(key, hash[:], r, s) {
+				t.Errorf("ES384 signature does not verify")
+			}
+		}
+	}
+}
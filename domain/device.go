@@ -1,17 +1,28 @@
 package domain
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"log/slog"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/fiskaly/coding-challenges/signing-service-challenge/domain")
+
 type SignatureAlgorithm string
 
 const (
@@ -19,6 +30,37 @@ const (
 	ECC SignatureAlgorithm = "ECC"
 )
 
+// Versions of the secured data that is signed. Version 1 is
+// "<counter>_<data>_<last signature>". Version 2 adds the signing time:
+// "v2_<counter>_<signing time>_<data>_<last signature>", with the time in UTC
+// and millisecond precision as formatted by SigningTimeLayout.
+const (
+	SecuredDataV1 = 1
+	SecuredDataV2 = 2
+
+	SigningTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+)
+
+var (
+	// ErrEmptyID is returned when a device is created without an ID.
+	ErrEmptyID = errors.New("device ID cannot be empty")
+	// ErrInvalidID is returned by ValidateID for IDs that are not UUIDs.
+	ErrInvalidID = errors.New("invalid UUID format")
+	// ErrUnsupportedAlgorithm is returned for algorithms other than RSA and ECC.
+	ErrUnsupportedAlgorithm = errors.New("unsupported algorithm")
+	// ErrInvalidSecuredData is returned by ParseSecuredData for malformed input.
+	ErrInvalidSecuredData = errors.New("invalid secured data format")
+)
+
+// ParseAlgorithm returns the algorithm with the given case-insensitive name.
+func ParseAlgorithm(name string) (SignatureAlgorithm, error) {
+	algorithm := SignatureAlgorithm(strings.ToUpper(name))
+	if algorithm != RSA && algorithm != ECC {
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, name)
+	}
+	return algorithm, nil
+}
+
 type SignatureDevice struct {
 	ID               string             `json:"id"`
 	Label            string             `json:"label"`
@@ -27,16 +69,52 @@ type SignatureDevice struct {
 	LastSignature    string             `json:"last_signature"`
 	PublicKey        []byte             `json:"public_key"`
 	PrivateKey       []byte             `json:"-"`
+	// KeyVersion numbers the key pairs of the device, starting at 1.
+	KeyVersion int `json:"key_version"`
+	// Certificate is the DER-encoded X.509 certificate of the current key, if issued.
+	Certificate []byte `json:"certificate,omitempty"`
+	// CertificateChain holds the DER-encoded issuer certificates of Certificate, up to the root.
+	CertificateChain [][]byte `json:"certificate_chain,omitempty"`
+	// LastSignedAt is the signing time of the last signature. Signing times of
+	// a device never decrease, even if the clock is set back.
+	LastSignedAt time.Time `json:"last_signed_at,omitempty"`
+	// JournalBase is where the transaction journal of the device starts, if
+	// not at its first signature. An imported device arrives without the
+	// transactions it signed before, so its journal continues from the
+	// counter and last signature of the bundle.
+	JournalBase *ChainBase `json:"journal_base,omitempty"`
+	// ExportedAt is set when the device is exported to another instance. An
+	// exported device no longer signs, so that its signature chain cannot fork.
+	ExportedAt time.Time `json:"exported_at,omitempty"`
+	// BundleExportedAt is the export time of the last bundle imported for
+	// the device. Only newer bundles are imported, so that no bundle can be
+	// imported twice.
+	BundleExportedAt time.Time `json:"bundle_exported_at,omitempty"`
 	mu               sync.Mutex
 }
 
+// KeyID identifies the current key pair of the device in JWKs and JWS headers.
+func (d *SignatureDevice) KeyID() string {
+	return fmt.Sprintf("%s:v%d", d.ID, d.KeyVersion)
+}
+
+// LogValue implements slog.LogValuer. It omits the key material and the last signature.
+func (d *SignatureDevice) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", d.ID),
+		slog.String("label", d.Label),
+		slog.String("algorithm", string(d.Algorithm)),
+		slog.Int("signature_counter", d.SignatureCounter),
+	)
+}
+
 func NewSignatureDevice(id string, algorithm SignatureAlgorithm, label string) (*SignatureDevice, error) {
 	if id == "" {
-		return nil, errors.New("device ID cannot be empty")
+		return nil, ErrEmptyID
 	}
 
 	if algorithm != RSA && algorithm != ECC {
-		return nil, fmt.Errorf("unsupported algorithm: %s", algorithm)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
 	}
 
 	var publicKey, privateKey []byte
@@ -78,6 +156,7 @@ func NewSignatureDevice(id string, algorithm SignatureAlgorithm, label string) (
 		LastSignature:    lastSignature,
 		PublicKey:        publicKey,
 		PrivateKey:       privateKey,
+		KeyVersion:       1,
 	}, nil
 }
 
@@ -98,52 +177,158 @@ func (d *SignatureDevice) GetSigner() (crypto.Signer, error) {
 		}
 		return &crypto.ECCSigner{PrivateKey: keyPair.Private}, nil
 	default:
-		return nil, fmt.Errorf("unsupported algorithm: %s", d.Algorithm)
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, d.Algorithm)
+	}
+}
+
+// SignTiming reports how long the phases of a signature operation took.
+type SignTiming struct {
+	KeyParse time.Duration
+	Crypto   time.Duration
+}
+
+// NewVerifier returns a verifier for signatures created by a device with the given
+// algorithm and PEM encoded public key, either as stored for the device or as
+// exported in the standard PKIX format.
+func NewVerifier(algorithm SignatureAlgorithm, publicKey []byte) (crypto.Verifier, error) {
+	if algorithm != RSA && algorithm != ECC {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, algorithm)
+	}
+
+	key, err := crypto.ParsePublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s public key: %w", algorithm, err)
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if algorithm == RSA {
+			return &crypto.RSAVerifier{PublicKey: key}, nil
+		}
+	case *ecdsa.PublicKey:
+		if algorithm == ECC {
+			return &crypto.ECCVerifier{PublicKey: key}, nil
+		}
 	}
+	return nil, fmt.Errorf("public key is not an %s key", algorithm)
+}
+
+// SignTransaction signs data with the current time as signing time.
+func (d *SignatureDevice) SignTransaction(ctx context.Context, data string) (string, string, error) {
+	signature, securedData, _, err := d.SignTransactionTimed(ctx, data, time.Now())
+	return signature, securedData, err
 }
 
-func (d *SignatureDevice) SignTransaction(data string) (string, string, error) {
+// SignTransactionTimed signs like SignTransaction at the signing time now and
+// additionally reports the time spent parsing the private key and computing
+// the signature. If now lies before the last signing time of the device, the
+// last signing time is used instead. The signing time is stored in LastSignedAt.
+func (d *SignatureDevice) SignTransactionTimed(ctx context.Context, data string, now time.Time) (string, string, SignTiming, error) {
+	ctx, span := tracer.Start(ctx, "SignatureDevice.SignTransaction", trace.WithAttributes(
+		attribute.String("device.id", d.ID),
+		attribute.String("device.algorithm", string(d.Algorithm)),
+	))
+	defer span.End()
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	securedData := fmt.Sprintf("%d_%s_%s", d.SignatureCounter, data, d.LastSignature)
+	var timing SignTiming
+
+	span.SetAttributes(attribute.Int("device.signature_counter", d.SignatureCounter))
+	signedAt := now.UTC().Truncate(time.Millisecond)
+	if signedAt.Before(d.LastSignedAt) {
+		signedAt = d.LastSignedAt
+	}
+	securedData := fmt.Sprintf("v%d_%d_%s_%s_%s", SecuredDataV2, d.SignatureCounter, signedAt.Format(SigningTimeLayout), data, d.LastSignature)
 
+	_, keySpan := tracer.Start(ctx, "SignatureDevice.GetSigner")
+	start := time.Now()
 	signer, err := d.GetSigner()
+	timing.KeyParse = time.Since(start)
+	keySpan.End()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to get signer: %w", err)
+		span.SetStatus(codes.Error, "failed to get signer")
+		return "", "", timing, fmt.Errorf("failed to get signer: %w", err)
 	}
 
+	_, cryptoSpan := tracer.Start(ctx, "Signer.Sign")
+	start = time.Now()
 	signature, err := signer.Sign([]byte(securedData))
+	timing.Crypto = time.Since(start)
+	cryptoSpan.End()
 	if err != nil {
-		return "", "", fmt.Errorf("failed to sign data: %w", err)
+		span.SetStatus(codes.Error, "failed to sign data")
+		return "", "", timing, fmt.Errorf("failed to sign data: %w", err)
 	}
 
 	encodedSignature := base64.StdEncoding.EncodeToString(signature)
 
 	d.LastSignature = encodedSignature
+	d.LastSignedAt = signedAt
 	d.SignatureCounter++
 
-	return encodedSignature, securedData, nil
+	return encodedSignature, securedData, timing, nil
 }
 
+// SecuredData is the decoded form of the data signed for a transaction.
+// SignedAt is zero for version 1.
+type SecuredData struct {
+	Version       int
+	Counter       int
+	SignedAt      time.Time
+	Data          string
+	LastSignature string
+}
+
+// ParseSecuredData returns the counter, data and last signature of secured
+// data of any version.
 func ParseSecuredData(securedData string) (int, string, string, error) {
-	parts := strings.SplitN(securedData, "_", 3)
-	if len(parts) != 3 {
-		return 0, "", "", errors.New("invalid secured data format")
+	decoded, err := DecodeSecuredData(securedData)
+	if err != nil {
+		return 0, "", "", err
+	}
+	return decoded.Counter, decoded.Data, decoded.LastSignature, nil
+}
+
+// DecodeSecuredData decodes secured data of any version. The data may contain
+// underscores, but the base64 encoded last signature cannot.
+func DecodeSecuredData(securedData string) (SecuredData, error) {
+	decoded := SecuredData{Version: SecuredDataV1}
+	rest, fields := securedData, 2
+	if after, ok := strings.CutPrefix(securedData, fmt.Sprintf("v%d_", SecuredDataV2)); ok {
+		decoded.Version, rest, fields = SecuredDataV2, after, 3
+	}
+
+	separator := strings.LastIndex(rest, "_")
+	if separator < 0 {
+		return SecuredData{}, ErrInvalidSecuredData
+	}
+	parts := strings.SplitN(rest[:separator], "_", fields)
+	if len(parts) != fields {
+		return SecuredData{}, ErrInvalidSecuredData
 	}
 
 	counter, err := strconv.Atoi(parts[0])
 	if err != nil {
-		return 0, "", "", fmt.Errorf("invalid signature counter: %w", err)
+		return SecuredData{}, fmt.Errorf("%w: invalid signature counter: %v", ErrInvalidSecuredData, err)
 	}
-
-	return counter, parts[1], parts[2], nil
+	if decoded.Version == SecuredDataV2 {
+		decoded.SignedAt, err = time.Parse(SigningTimeLayout, parts[1])
+		if err != nil {
+			return SecuredData{}, fmt.Errorf("%w: invalid signing time: %v", ErrInvalidSecuredData, err)
+		}
+	}
+	decoded.Counter = counter
+	decoded.Data = parts[fields-1]
+	decoded.LastSignature = rest[separator+1:]
+	return decoded, nil
 }
 
 func ValidateID(id string) error {
 	_, err := uuid.Parse(id)
 	if err != nil {
-		return errors.New("invalid UUID format")
+		return ErrInvalidID
 	}
 	return nil
 }
@@ -159,6 +344,15 @@ func (d *SignatureDevice) Clone() *SignatureDevice {
 		Algorithm:        d.Algorithm,
 		SignatureCounter: d.SignatureCounter,
 		LastSignature:    d.LastSignature,
+		KeyVersion:       d.KeyVersion,
+		LastSignedAt:     d.LastSignedAt,
+		ExportedAt:       d.ExportedAt,
+		BundleExportedAt: d.BundleExportedAt,
+	}
+
+	if d.JournalBase != nil {
+		base := *d.JournalBase
+		clone.JournalBase = &base
 	}
 
 	if d.PublicKey != nil {
@@ -171,5 +365,14 @@ func (d *SignatureDevice) Clone() *SignatureDevice {
 		copy(clone.PrivateKey, d.PrivateKey)
 	}
 
+	if d.Certificate != nil {
+		clone.Certificate = make([]byte, len(d.Certificate))
+		copy(clone.Certificate, d.Certificate)
+	}
+
+	for _, certificate := range d.CertificateChain {
+		clone.CertificateChain = append(clone.CertificateChain, append([]byte(nil), certificate...))
+	}
+
 	return clone
 }
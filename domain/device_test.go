@@ -1,9 +1,12 @@
 package domain
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -84,7 +87,7 @@ func TestSignTransaction(t *testing.T) {
 	}
 
 	data := "test data"
-	signature, signedData, err := device.SignTransaction(data)
+	signature, signedData, err := device.SignTransaction(context.Background(), data)
 	if err != nil {
 		t.Fatalf("Failed to sign transaction: %v", err)
 	}
@@ -113,7 +116,7 @@ func TestSignTransaction(t *testing.T) {
 	}
 
 	data2 := "test data 2"
-	signature2, signedData2, err := device.SignTransaction(data2)
+	signature2, signedData2, err := device.SignTransaction(context.Background(), data2)
 	if err != nil {
 		t.Fatalf("Failed to sign transaction: %v", err)
 	}
@@ -142,6 +145,43 @@ func TestSignTransaction(t *testing.T) {
 	}
 }
 
+func TestSignTransactionSigningTime(t *testing.T) {
+	device, err := NewSignatureDevice(uuid.New().String(), ECC, "")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	ctx := context.Background()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 123456789, time.FixedZone("CET", 3600))
+
+	_, securedData, _, err := device.SignTransactionTimed(ctx, "first_item", now)
+	if err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	if expected := "v2_0_2024-03-01T11:00:00.123Z_first_item_"; !strings.HasPrefix(securedData, expected) {
+		t.Errorf("Expected secured data to start with %q, got %q", expected, securedData)
+	}
+	decoded, err := DecodeSecuredData(securedData)
+	if err != nil {
+		t.Fatalf("Failed to decode secured data: %v", err)
+	}
+	if decoded.Version != SecuredDataV2 || decoded.Data != "first_item" || !decoded.SignedAt.Equal(device.LastSignedAt) {
+		t.Errorf("Unexpected decoded secured data %+v", decoded)
+	}
+
+	// The clock was set back: the signing time stays at the last one.
+	_, securedData, _, err = device.SignTransactionTimed(ctx, "second", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	decoded, err = DecodeSecuredData(securedData)
+	if err != nil {
+		t.Fatalf("Failed to decode secured data: %v", err)
+	}
+	if decoded.Counter != 1 || !decoded.SignedAt.Equal(now.Truncate(time.Millisecond)) {
+		t.Errorf("Expected the signing time not to decrease, got %+v", decoded)
+	}
+}
+
 func TestParseSecuredData(t *testing.T) {
 	counter := 1
 	data := "test data"
@@ -174,10 +214,15 @@ func TestParseSecuredData(t *testing.T) {
 		t.Errorf("Expected error for invalid secured data format")
 	}
 
+	invalidSecuredData = "v2_1_yesterday_data_signature"
+	if _, err := DecodeSecuredData(invalidSecuredData); !errors.Is(err, ErrInvalidSecuredData) {
+		t.Errorf("Expected ErrInvalidSecuredData for an invalid signing time, got %v", err)
+	}
+
 	invalidSecuredData = "not-a-number_data_signature"
 	_, _, _, err = ParseSecuredData(invalidSecuredData)
-	if err == nil {
-		t.Errorf("Expected error for invalid counter")
+	if !errors.Is(err, ErrInvalidSecuredData) {
+		t.Errorf("Expected ErrInvalidSecuredData for invalid counter, got %v", err)
 	}
 }
 
@@ -190,7 +235,19 @@ func TestValidateID(t *testing.T) {
 
 	invalidID := "not-a-uuid"
 	err = ValidateID(invalidID)
-	if err == nil {
-		t.Errorf("Expected error for invalid UUID")
+	if !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Expected ErrInvalidID for invalid UUID, got %v", err)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for name, want := range map[string]SignatureAlgorithm{"RSA": RSA, "ecc": ECC} {
+		algorithm, err := ParseAlgorithm(name)
+		if err != nil || algorithm != want {
+			t.Errorf("ParseAlgorithm(%q) = %s, %v; want %s", name, algorithm, err, want)
+		}
+	}
+	if _, err := ParseAlgorithm("DSA"); !errors.Is(err, ErrUnsupportedAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedAlgorithm, got %v", err)
 	}
 }
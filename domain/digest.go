@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// HashAlgorithm is the hash function of a precomputed digest that a device
+// signs in place of the transaction data.
+type HashAlgorithm string
+
+const (
+	SHA256 HashAlgorithm = "sha256"
+	SHA384 HashAlgorithm = "sha384"
+	SHA512 HashAlgorithm = "sha512"
+)
+
+var (
+	// ErrUnsupportedHashAlgorithm is returned for hash algorithms other than SHA-256, SHA-384 and SHA-512.
+	ErrUnsupportedHashAlgorithm = errors.New("unsupported hash algorithm")
+	// ErrInvalidDigest is returned for digests whose length does not match their hash algorithm.
+	ErrInvalidDigest = errors.New("invalid digest")
+)
+
+// ParseHashAlgorithm returns the hash algorithm with the given
+// case-insensitive name, such as "sha256" or "SHA-256".
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	algorithm := HashAlgorithm(strings.ReplaceAll(strings.ToLower(name), "-", ""))
+	switch algorithm {
+	case SHA256, SHA384, SHA512:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("%w: %q (supported: sha256, sha384, sha512)", ErrUnsupportedHashAlgorithm, name)
+	}
+}
+
+// New returns a new hash.Hash computing the algorithm.
+func (h HashAlgorithm) New() hash.Hash {
+	switch h {
+	case SHA384:
+		return sha512.New384()
+	case SHA512:
+		return sha512.New()
+	default:
+		return sha256.New()
+	}
+}
+
+// DigestData returns the representation of digest in the secured data,
+// "<algorithm>:<hex digest>", which takes the place of the transaction data.
+func DigestData(algorithm HashAlgorithm, digest []byte) (string, error) {
+	if _, err := ParseHashAlgorithm(string(algorithm)); err != nil {
+		return "", err
+	}
+	if len(digest) != algorithm.New().Size() {
+		return "", fmt.Errorf("%w: %s digests have %d bytes, got %d", ErrInvalidDigest, algorithm, algorithm.New().Size(), len(digest))
+	}
+	return string(algorithm) + ":" + hex.EncodeToString(digest), nil
+}
+
+// ParseDigestData returns the hash algorithm and digest of data created by
+// DigestData.
+func ParseDigestData(data string) (HashAlgorithm, []byte, error) {
+	name, encoded, ok := strings.Cut(data, ":")
+	if !ok {
+		return "", nil, fmt.Errorf("%w: not a digest", ErrInvalidDigest)
+	}
+	algorithm, err := ParseHashAlgorithm(name)
+	if err != nil {
+		return "", nil, err
+	}
+	digest, err := hex.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: malformed %s digest", ErrInvalidDigest, algorithm)
+	}
+	// Only the canonical form created by DigestData is accepted.
+	if canonical, err := DigestData(algorithm, digest); err != nil || canonical != data {
+		return "", nil, fmt.Errorf("%w: malformed %s digest", ErrInvalidDigest, algorithm)
+	}
+	return algorithm, digest, nil
+}
@@ -0,0 +1,42 @@
+package domain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDigestData(t *testing.T) {
+	for name, expected := range map[string]HashAlgorithm{"sha256": SHA256, "SHA-384": SHA384, "Sha512": SHA512} {
+		if algorithm, err := ParseHashAlgorithm(name); err != nil || algorithm != expected {
+			t.Errorf("ParseHashAlgorithm(%q) = %q, %v, want %q", name, algorithm, err, expected)
+		}
+	}
+	if _, err := ParseHashAlgorithm("md5"); !errors.Is(err, ErrUnsupportedHashAlgorithm) {
+		t.Errorf("Expected ErrUnsupportedHashAlgorithm for md5, got %v", err)
+	}
+
+	digest := sha256.Sum256([]byte("data"))
+	data, err := DigestData(SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("DigestData failed: %v", err)
+	}
+	if !strings.HasPrefix(data, "sha256:") || len(data) != len("sha256:")+2*sha256.Size {
+		t.Errorf("Unexpected digest data %q", data)
+	}
+	algorithm, parsed, err := ParseDigestData(data)
+	if err != nil || algorithm != SHA256 || !bytes.Equal(parsed, digest[:]) {
+		t.Errorf("ParseDigestData(%q) = %q, %x, %v", data, algorithm, parsed, err)
+	}
+
+	if _, err := DigestData(SHA512, digest[:]); !errors.Is(err, ErrInvalidDigest) {
+		t.Errorf("Expected ErrInvalidDigest for a SHA-256 digest declared as SHA-512, got %v", err)
+	}
+	for _, invalid := range []string{"data", "sha256:xyz", strings.ToUpper(data), "sha256:abcd"} {
+		if _, _, err := ParseDigestData(invalid); !errors.Is(err, ErrInvalidDigest) {
+			t.Errorf("Expected ErrInvalidDigest for %q, got %v", invalid, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package domain
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// DataEncoding is the encoding of transaction data in sign requests. Binary
+// data is embedded in the secured data in a canonical form, prefixed with its
+// encoding, so that every byte sequence has exactly one representation.
+type DataEncoding string
+
+const (
+	// DataUTF8 is text, embedded as is.
+	DataUTF8 DataEncoding = "utf8"
+	// DataBase64 is binary data, embedded as "base64:<padded standard base64>".
+	DataBase64 DataEncoding = "base64"
+	// DataHex is binary data, embedded as "hex:<lower-case hex>".
+	DataHex DataEncoding = "hex"
+)
+
+var (
+	// ErrUnsupportedDataEncoding is returned for data encodings other than utf8, base64 and hex.
+	ErrUnsupportedDataEncoding = errors.New("unsupported data encoding")
+	// ErrInvalidData is returned for data that is not valid in its encoding.
+	ErrInvalidData = errors.New("invalid data")
+)
+
+// ParseDataEncoding returns the data encoding with the given case-insensitive
+// name. The empty name selects DataUTF8.
+func ParseDataEncoding(name string) (DataEncoding, error) {
+	encoding := DataEncoding(strings.ReplaceAll(strings.ToLower(name), "-", ""))
+	switch encoding {
+	case "":
+		return DataUTF8, nil
+	case DataUTF8, DataBase64, DataHex:
+		return encoding, nil
+	default:
+		return "", fmt.Errorf("%w: %q (supported: utf8, base64, hex)", ErrUnsupportedDataEncoding, name)
+	}
+}
+
+// Decode returns the bytes of data. Base64 data may use the standard or the
+// URL alphabet, with or without padding, and hex data either case.
+func (e DataEncoding) Decode(data string) ([]byte, error) {
+	switch e {
+	case DataUTF8:
+		if !utf8.ValidString(data) {
+			return nil, fmt.Errorf("%w: not valid UTF-8", ErrInvalidData)
+		}
+		return []byte(data), nil
+	case DataBase64:
+		unpadded := strings.TrimRight(data, "=")
+		decoded, err := base64.RawStdEncoding.DecodeString(unpadded)
+		if err != nil {
+			decoded, err = base64.RawURLEncoding.DecodeString(unpadded)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: not valid base64", ErrInvalidData)
+		}
+		return decoded, nil
+	case DataHex:
+		decoded, err := hex.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("%w: not valid hex", ErrInvalidData)
+		}
+		return decoded, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedDataEncoding, e)
+	}
+}
+
+// EncodeData returns the canonical representation of data in the secured data.
+func EncodeData(encoding DataEncoding, data []byte) (string, error) {
+	switch encoding {
+	case DataUTF8:
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("%w: not valid UTF-8", ErrInvalidData)
+		}
+		return string(data), nil
+	case DataBase64:
+		return string(DataBase64) + ":" + base64.StdEncoding.EncodeToString(data), nil
+	case DataHex:
+		return string(DataHex) + ":" + hex.EncodeToString(data), nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedDataEncoding, encoding)
+	}
+}
+
+// DecodeData returns the bytes of data taken from the secured data, which
+// EncodeData created with encoding.
+func DecodeData(encoding DataEncoding, data string) ([]byte, error) {
+	if encoding == DataUTF8 {
+		return []byte(data), nil
+	}
+	encoded, ok := strings.CutPrefix(data, string(encoding)+":")
+	if !ok {
+		return nil, fmt.Errorf("%w: not %s data", ErrInvalidData, encoding)
+	}
+	decoded, err := encoding.Decode(encoded)
+	if err != nil {
+		return nil, err
+	}
+	// Only the canonical form created by EncodeData is accepted.
+	if canonical, _ := EncodeData(encoding, decoded); canonical != data {
+		return nil, fmt.Errorf("%w: %s data is not canonical", ErrInvalidData, encoding)
+	}
+	return decoded, nil
+}
@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDataEncoding(t *testing.T) {
+	for name, expected := range map[string]DataEncoding{"": DataUTF8, "UTF-8": DataUTF8, "Base64": DataBase64, "hex": DataHex} {
+		if encoding, err := ParseDataEncoding(name); err != nil || encoding != expected {
+			t.Errorf("ParseDataEncoding(%q) = %q, %v, want %q", name, encoding, err, expected)
+		}
+	}
+	if _, err := ParseDataEncoding("ebcdic"); !errors.Is(err, ErrUnsupportedDataEncoding) {
+		t.Errorf("Expected ErrUnsupportedDataEncoding for ebcdic, got %v", err)
+	}
+
+	receipt := []byte{0x00, 0xff, 0xfb, '_', 0x80}
+	for _, tt := range []struct {
+		encoding  DataEncoding
+		inputs    []string
+		canonical string
+	}{
+		{DataBase64, []string{"AP/7X4A=", "AP/7X4A", "AP_7X4A"}, "base64:AP/7X4A="},
+		{DataHex, []string{"00fffb5f80", "00FFFB5F80"}, "hex:00fffb5f80"},
+	} {
+		for _, input := range tt.inputs {
+			if decoded, err := tt.encoding.Decode(input); err != nil || !bytes.Equal(decoded, receipt) {
+				t.Errorf("%s.Decode(%q) = %x, %v", tt.encoding, input, decoded, err)
+			}
+		}
+		data, err := EncodeData(tt.encoding, receipt)
+		if err != nil || data != tt.canonical {
+			t.Errorf("EncodeData(%s) = %q, %v, want %q", tt.encoding, data, err, tt.canonical)
+		}
+		if decoded, err := DecodeData(tt.encoding, data); err != nil || !bytes.Equal(decoded, receipt) {
+			t.Errorf("DecodeData(%s, %q) = %x, %v", tt.encoding, data, decoded, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		encoding DataEncoding
+		data     string
+	}{
+		{DataBase64, "AP/7X4A="},
+		{DataBase64, "base64:AP_7X4A"},
+		{DataHex, "hex:00FFFB5F80"},
+		{DataHex, "base64:AP/7X4A="},
+	} {
+		if _, err := DecodeData(tt.encoding, tt.data); !errors.Is(err, ErrInvalidData) {
+			t.Errorf("Expected ErrInvalidData for %s data %q, got %v", tt.encoding, tt.data, err)
+		}
+	}
+	if _, err := EncodeData(DataUTF8, receipt); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("Expected ErrInvalidData for binary utf8 data, got %v", err)
+	}
+	if _, err := DataHex.Decode("0g"); !errors.Is(err, ErrInvalidData) {
+		t.Errorf("Expected ErrInvalidData for malformed hex, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package domain
+
+import (
+	"fmt"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+// JWSHeader is the protected header of signatures in JWS format. Counter is
+// the signature counter embedded in the secured data payload.
+type JWSHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+	Counter   int    `json:"counter"`
+}
+
+// JWSAlgorithm returns the JOSE algorithm used for JWS signatures of the device.
+func (d *SignatureDevice) JWSAlgorithm() (string, error) {
+	switch d.Algorithm {
+	case RSA:
+		return "RS256", nil
+	case ECC:
+		return "ES384", nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, d.Algorithm)
+	}
+}
+
+// SignJWS signs securedData, created with the given signature counter, as a
+// compact JWS. RSA devices sign with RS256 like their regular signatures; ECC
+// devices sign with ES384, since JOSE has no algorithm for P-384 with SHA-256.
+func (d *SignatureDevice) SignJWS(securedData string, counter int) (string, error) {
+	algorithm, err := d.JWSAlgorithm()
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := d.standardSigner()
+	if err != nil {
+		return "", err
+	}
+
+	header := JWSHeader{
+		Algorithm: algorithm,
+		KeyID:     d.KeyID(),
+		Type:      "JOSE",
+		Counter:   counter,
+	}
+	return crypto.SignJWS(signer, header, []byte(securedData))
+}
+
+// standardSigner returns a signer for the algorithms used in JWS and COSE
+// signatures: RS256 for RSA devices, which is also their regular signature
+// scheme, and ES384 for ECC devices.
+func (d *SignatureDevice) standardSigner() (crypto.Signer, error) {
+	if d.Algorithm != ECC {
+		return d.GetSigner()
+	}
+	keyPair, err := crypto.NewECCMarshaler().Unmarshal(d.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ECC private key: %w", err)
+	}
+	return &crypto.ES384Signer{PrivateKey: keyPair.Private}, nil
+}
@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+
+	signingcrypto "github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/google/uuid"
+)
+
+// TestSignJWS verifies JWS signatures with the standard library the way a
+// JOSE library would, using the exported public key.
+func TestSignJWS(t *testing.T) {
+	for _, algorithm := range []SignatureAlgorithm{RSA, ECC} {
+		device, err := NewSignatureDevice(uuid.New().String(), algorithm, "JWS")
+		if err != nil {
+			t.Fatalf("Failed to create %s device: %v", algorithm, err)
+		}
+		_, securedData, err := device.SignTransaction(context.Background(), "data")
+		if err != nil {
+			t.Fatalf("%s: failed to sign: %v", algorithm, err)
+		}
+
+		jws, err := device.SignJWS(securedData, 0)
+		if err != nil {
+			t.Fatalf("%s: failed to sign JWS: %v", algorithm, err)
+		}
+		parts := strings.Split(jws, ".")
+		if len(parts) != 3 {
+			t.Fatalf("%s: expected a compact JWS, got %q", algorithm, jws)
+		}
+		signingInput := []byte(parts[0] + "." + parts[1])
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			t.Fatalf("%s: invalid signature encoding: %v", algorithm, err)
+		}
+
+		key, err := signingcrypto.ParsePublicKey(device.PublicKey)
+		if err != nil {
+			t.Fatalf("%s: failed to parse public key: %v", algorithm, err)
+		}
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			hash := sha256.Sum256(signingInput)
+			if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+				t.Errorf("RS256 signature does not verify: %v", err)
+			}
+		case *ecdsa.PublicKey:
+			hash := sha512.Sum384(signingInput)
+			if len(signature) != 96 {
+				t.Fatalf("Expected a 96 byte ES384 signature, got %d bytes", len(signature))
+			}
+			r, s := new(big.Int).SetBytes(signature[:48]), new(big.Int).SetBytes(signature[48:])
+			if !ecdsa.Verify(key, hash[:], r, s) {
+				t.Errorf("ES384 signature does not verify")
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+)
+
+// PublicKeyFormat is an encoding in which a device's public key can be exported.
+type PublicKeyFormat string
+
+const (
+	// PublicKeyPEM is a standard "PUBLIC KEY" PEM block (PKIX).
+	PublicKeyPEM PublicKeyFormat = "pem"
+	// PublicKeyDER is the DER encoded SubjectPublicKeyInfo (PKIX).
+	PublicKeyDER PublicKeyFormat = "der"
+	// PublicKeyJWK is a JSON Web Key identified by the device's KeyID.
+	PublicKeyJWK PublicKeyFormat = "jwk"
+	// PublicKeyOpenSSH is a line in the OpenSSH authorized_keys format.
+	PublicKeyOpenSSH PublicKeyFormat = "openssh"
+)
+
+// ErrUnsupportedFormat is returned for unknown public key formats.
+var ErrUnsupportedFormat = errors.New("unsupported public key format")
+
+// ParsePublicKeyFormat returns the format with the given case-insensitive name.
+func ParsePublicKeyFormat(name string) (PublicKeyFormat, error) {
+	format := PublicKeyFormat(strings.ToLower(name))
+	switch format {
+	case PublicKeyPEM, PublicKeyDER, PublicKeyJWK, PublicKeyOpenSSH:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnsupportedFormat, name)
+	}
+}
+
+// ExportPublicKey encodes the public key of the device in format. Devices
+// store RSA keys as PKCS#1 and ECC keys as PKIX with non-standard PEM block
+// types; all exported formats are standard regardless of the algorithm.
+func (d *SignatureDevice) ExportPublicKey(format PublicKeyFormat) ([]byte, error) {
+	key, err := crypto.ParsePublicKey(d.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case PublicKeyPEM:
+		return crypto.MarshalPublicKeyPEM(key)
+	case PublicKeyDER:
+		return crypto.MarshalPublicKeyDER(key)
+	case PublicKeyJWK:
+		jwk, err := d.JWK()
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(jwk)
+	case PublicKeyOpenSSH:
+		return crypto.MarshalOpenSSHPublicKey(key, d.ID)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+	}
+}
+
+// JWK returns the public key of the device as a JSON Web Key for verifying
+// signatures, identified by KeyID.
+func (d *SignatureDevice) JWK() (*crypto.JWK, error) {
+	key, err := crypto.ParsePublicKey(d.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := crypto.NewJWK(key, d.KeyID())
+	if err != nil {
+		return nil, err
+	}
+	jwk.Use = "sig"
+	if d.Algorithm == RSA {
+		// ECC devices sign with P-384 and SHA-256, which has no JOSE algorithm name.
+		jwk.Algorithm = "RS256"
+	}
+	return jwk, nil
+}
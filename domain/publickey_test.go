@@ -0,0 +1,125 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/google/uuid"
+)
+
+func TestExportPublicKey(t *testing.T) {
+	for _, test := range []struct {
+		algorithm SignatureAlgorithm
+		keyType   string
+		sshType   string
+	}{
+		{RSA, "RSA", "ssh-rsa"},
+		{ECC, "EC", "ecdsa-sha2-nistp384"},
+	} {
+		device, err := NewSignatureDevice(uuid.New().String(), test.algorithm, "Export")
+		if err != nil {
+			t.Fatalf("Failed to create %s device: %v", test.algorithm, err)
+		}
+
+		encoded, err := device.ExportPublicKey(PublicKeyPEM)
+		if err != nil {
+			t.Fatalf("%s: failed to export PEM: %v", test.algorithm, err)
+		}
+		block, _ := pem.Decode(encoded)
+		if block == nil || block.Type != "PUBLIC KEY" {
+			t.Fatalf("%s: expected a PUBLIC KEY PEM block, got %q", test.algorithm, encoded)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			t.Fatalf("%s: exported PEM is not PKIX: %v", test.algorithm, err)
+		}
+		switch key.(type) {
+		case *rsa.PublicKey, *ecdsa.PublicKey:
+		default:
+			t.Errorf("%s: unexpected key type %T", test.algorithm, key)
+		}
+
+		der, err := device.ExportPublicKey(PublicKeyDER)
+		if err != nil || !bytes.Equal(der, block.Bytes) {
+			t.Errorf("%s: expected DER to match the PEM contents, got error %v", test.algorithm, err)
+		}
+
+		// The exported PEM verifies signatures just like the stored key.
+		signature, signedData, err := device.SignTransaction(context.Background(), "data")
+		if err != nil {
+			t.Fatalf("%s: failed to sign: %v", test.algorithm, err)
+		}
+		verifier, err := NewVerifier(test.algorithm, encoded)
+		if err != nil {
+			t.Fatalf("%s: failed to create verifier from exported PEM: %v", test.algorithm, err)
+		}
+		decoded, _ := base64.StdEncoding.DecodeString(signature)
+		if err := verifier.Verify([]byte(signedData), decoded); err != nil {
+			t.Errorf("%s: exported key does not verify signature: %v", test.algorithm, err)
+		}
+
+		content, err := device.ExportPublicKey(PublicKeyJWK)
+		if err != nil {
+			t.Fatalf("%s: failed to export JWK: %v", test.algorithm, err)
+		}
+		var jwk crypto.JWK
+		if err := json.Unmarshal(content, &jwk); err != nil {
+			t.Fatalf("%s: invalid JWK: %v", test.algorithm, err)
+		}
+		if jwk.KeyType != test.keyType || jwk.KeyID != device.ID+":v1" || jwk.Use != "sig" {
+			t.Errorf("%s: unexpected JWK %+v", test.algorithm, jwk)
+		}
+
+		openSSH, err := device.ExportPublicKey(PublicKeyOpenSSH)
+		if err != nil {
+			t.Fatalf("%s: failed to export OpenSSH key: %v", test.algorithm, err)
+		}
+		fields := strings.Fields(string(openSSH))
+		if len(fields) != 3 || fields[0] != test.sshType || fields[2] != device.ID {
+			t.Fatalf("%s: unexpected OpenSSH key %q", test.algorithm, openSSH)
+		}
+		blob, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || !bytes.HasPrefix(blob[4:], []byte(test.sshType)) {
+			t.Errorf("%s: OpenSSH key blob does not start with its type", test.algorithm)
+		}
+	}
+}
+
+func TestJWKCoordinates(t *testing.T) {
+	device, err := NewSignatureDevice(uuid.New().String(), ECC, "JWK")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	jwk, err := device.JWK()
+	if err != nil {
+		t.Fatalf("Failed to create JWK: %v", err)
+	}
+	for name, value := range map[string]string{"x": jwk.X, "y": jwk.Y} {
+		// Coordinates are padded to the size of the P-384 field.
+		if decoded, err := base64.RawURLEncoding.DecodeString(value); err != nil || len(decoded) != 48 {
+			t.Errorf("Expected %s to be 48 bytes, got %d (%v)", name, len(decoded), err)
+		}
+	}
+	if jwk.Curve != "P-384" || jwk.Algorithm != "" {
+		t.Errorf("Expected a P-384 JWK without algorithm, got %+v", jwk)
+	}
+}
+
+func TestParsePublicKeyFormat(t *testing.T) {
+	if format, err := ParsePublicKeyFormat("PEM"); err != nil || format != PublicKeyPEM {
+		t.Errorf("ParsePublicKeyFormat(PEM) = %s, %v", format, err)
+	}
+	if _, err := ParsePublicKeyFormat("x509"); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+	}
+}
@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Transaction records a signature created by a device.
+type Transaction struct {
+	DeviceID   string    `json:"device_id"`
+	Counter    int       `json:"counter"`
+	Signature  string    `json:"signature"`
+	SignedData string    `json:"signed_data"`
+	SignedAt   time.Time `json:"signed_at"`
+	// DataEncoding is the encoding of the data in the secured data; it is
+	// empty for signatures of a digest.
+	DataEncoding DataEncoding `json:"data_encoding,omitempty"`
+	// JWS is the secured data as a compact JWS, if requested when signing.
+	JWS string `json:"jws,omitempty"`
+	// COSE is the secured data as a tagged COSE_Sign1 structure, if requested when signing.
+	COSE []byte `json:"cose,omitempty"`
+	// TimestampToken is a DER-encoded RFC 3161 token for the signature, if a
+	// time-stamping authority is configured.
+	TimestampToken []byte `json:"timestamp_token,omitempty"`
+}
+
+// LogValue implements slog.LogValuer. It omits the signed data, which contains the transaction data.
+func (t *Transaction) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("device_id", t.DeviceID),
+		slog.Int("counter", t.Counter),
+	)
+}
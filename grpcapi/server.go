@@ -0,0 +1,256 @@
+// Package grpcapi serves the signing.v0.DeviceService gRPC API. It only covers
+// the core device and signing operations: creating, reading and listing
+// devices, signing and streaming the transaction history. They share the
+// service.DeviceService with the REST API, so they behave the same in both.
+// Everything else, such as signature formats, certificates, migration,
+// counter status and idempotency keys, is only offered over REST.
+package grpcapi
+
+//go:generate protoc -I ../proto --go_out=. --go_opt=module=github.com/fiskaly/coding-challenges/signing-service-challenge/grpcapi --go-grpc_out=. --go-grpc_opt=module=github.com/fiskaly/coding-challenges/signing-service-challenge/grpcapi signing/v0/signing.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/grpcapi/signingpb"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements signingpb.DeviceServiceServer on top of a service.DeviceService.
+type Server struct {
+	signingpb.UnimplementedDeviceServiceServer
+	devices *service.DeviceService
+}
+
+func NewServer(devices *service.DeviceService) *Server {
+	return &Server{devices: devices}
+}
+
+// NewGRPCServer returns a grpc.Server serving devices that logs every call to logger.
+func NewGRPCServer(devices *service.DeviceService, logger *slog.Logger, options ...grpc.ServerOption) *grpc.Server {
+	options = append(options,
+		grpc.ChainUnaryInterceptor(logUnary(logger), recordClientAddress),
+		grpc.ChainStreamInterceptor(logStream(logger)),
+	)
+	server := grpc.NewServer(options...)
+	signingpb.RegisterDeviceServiceServer(server, NewServer(devices))
+	return server
+}
+
+func (s *Server) CreateDevice(ctx context.Context, request *signingpb.CreateDeviceRequest) (*signingpb.Device, error) {
+	device, err := s.devices.CreateDevice(ctx, service.CreateDeviceParams{
+		ID:        request.GetId(),
+		Algorithm: request.GetAlgorithm(),
+		Label:     request.GetLabel(),
+		Actor:     actor(ctx),
+	})
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return newDevice(device)
+}
+
+func (s *Server) GetDevice(ctx context.Context, request *signingpb.GetDeviceRequest) (*signingpb.Device, error) {
+	device, err := s.devices.GetDevice(ctx, request.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return newDevice(device)
+}
+
+func (s *Server) ListDevices(ctx context.Context, request *signingpb.ListDevicesRequest) (*signingpb.ListDevicesResponse, error) {
+	devices, err := s.devices.ListDevices(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	response := &signingpb.ListDevicesResponse{Devices: make([]*signingpb.Device, 0, len(devices))}
+	for _, device := range devices {
+		item, err := newDevice(device)
+		if err != nil {
+			return nil, err
+		}
+		response.Devices = append(response.Devices, item)
+	}
+	return response, nil
+}
+
+func (s *Server) SignTransaction(ctx context.Context, request *signingpb.SignTransactionRequest) (*signingpb.SignTransactionResponse, error) {
+	if request.GetData() != "" && request.GetDigest() != nil {
+		return nil, status.Error(codes.InvalidArgument, "send either data or a digest, not both")
+	}
+	transaction, err := s.sign(ctx, request)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &signingpb.SignTransactionResponse{
+		Signature:      transaction.Signature,
+		SignedData:     transaction.SignedData,
+		TimestampToken: transaction.TimestampToken,
+		SignedAt:       timestamppb.New(transaction.SignedAt),
+		DataEncoding:   string(transaction.DataEncoding),
+	}, nil
+}
+
+// sign signs the data or, if given, the digest of request.
+func (s *Server) sign(ctx context.Context, request *signingpb.SignTransactionRequest) (*domain.Transaction, error) {
+	if request.GetDigest() == nil && request.GetHashAlgorithm() == "" {
+		encoding, err := domain.ParseDataEncoding(request.GetDataEncoding())
+		if err != nil {
+			return nil, err
+		}
+		if encoding == domain.DataUTF8 {
+			return s.devices.SignTransaction(ctx, request.GetDeviceId(), request.GetData())
+		}
+		data, err := encoding.Decode(request.GetData())
+		if err != nil {
+			return nil, err
+		}
+		return s.devices.SignBytes(ctx, request.GetDeviceId(), encoding, data)
+	}
+	if request.GetDataEncoding() != "" {
+		return nil, fmt.Errorf("%w: data_encoding does not apply to digests", service.ErrInvalidDataEncoding)
+	}
+	algorithm, err := domain.ParseHashAlgorithm(request.GetHashAlgorithm())
+	if err != nil {
+		return nil, err
+	}
+	return s.devices.SignDigest(ctx, request.GetDeviceId(), algorithm, request.GetDigest())
+}
+
+func (s *Server) ListTransactions(request *signingpb.ListTransactionsRequest, stream signingpb.DeviceService_ListTransactionsServer) error {
+	transactions, err := s.devices.ListTransactions(stream.Context(), request.GetDeviceId())
+	if err != nil {
+		return toStatus(err)
+	}
+
+	for _, transaction := range transactions {
+		err := stream.Send(&signingpb.Transaction{
+			Counter:        int64(transaction.Counter),
+			Signature:      transaction.Signature,
+			SignedData:     transaction.SignedData,
+			SignedAt:       timestamppb.New(transaction.SignedAt),
+			TimestampToken: transaction.TimestampToken,
+			DataEncoding:   string(transaction.DataEncoding),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newDevice converts a device, with its public key in the standard PKIX PEM
+// encoding like in the REST API.
+func newDevice(device *domain.SignatureDevice) (*signingpb.Device, error) {
+	publicKey, err := device.ExportPublicKey(domain.PublicKeyPEM)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+	return &signingpb.Device{
+		Id:               device.ID,
+		Label:            device.Label,
+		Algorithm:        string(device.Algorithm),
+		SignatureCounter: int64(device.SignatureCounter),
+		PublicKey:        publicKey,
+	}, nil
+}
+
+// toStatus translates errors of the DeviceService to gRPC status codes.
+func toStatus(err error) error {
+	switch {
+	case errors.Is(err, service.ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, service.ErrConflict):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, service.ErrInvalidAlgorithm), errors.Is(err, service.ErrInvalidID),
+		errors.Is(err, service.ErrInvalidHashAlgorithm), errors.Is(err, service.ErrInvalidDigest),
+		errors.Is(err, service.ErrInvalidDataEncoding), errors.Is(err, service.ErrInvalidData):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, service.ErrCounterRollback), errors.Is(err, service.ErrDeviceExported):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.Is(err, service.ErrDeviceLimitReached):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, service.ErrUnavailable), errors.Is(err, service.ErrTimestampUnavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// actor returns the caller recorded in audit entries, taken from the same
+// header as in the REST API. Like there, it is an unauthenticated claim.
+func actor(ctx context.Context) string {
+	if values := metadata.ValueFromIncomingContext(ctx, strings.ToLower(api.ActorHeader)); len(values) > 0 && values[0] != "" {
+		return values[0]
+	}
+	return "anonymous"
+}
+
+// requestID returns the request ID sent by the caller or generates one, and
+// echoes it in the response header.
+func requestID(ctx context.Context) string {
+	id := uuid.New().String()
+	if values := metadata.ValueFromIncomingContext(ctx, strings.ToLower(api.RequestIDHeader)); len(values) > 0 && values[0] != "" && len(values[0]) <= 128 {
+		id = values[0]
+	}
+	grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(api.RequestIDHeader), id))
+	return id
+}
+
+// recordClientAddress makes audit entries of the call record the address of the peer.
+func recordClientAddress(ctx context.Context, request interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		ctx = audit.WithClientAddress(ctx, p.Addr.String())
+	}
+	return handler(ctx, request)
+}
+
+func logUnary(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, request interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		id := requestID(ctx)
+		start := time.Now()
+		response, err := handler(ctx, request)
+		logCall(ctx, logger, id, info.FullMethod, start, err)
+		return response, err
+	}
+}
+
+func logStream(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(server interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id := requestID(stream.Context())
+		start := time.Now()
+		err := handler(server, stream)
+		logCall(stream.Context(), logger, id, info.FullMethod, start, err)
+		return err
+	}
+}
+
+// logCall writes one access log entry per call, like api.LogRequests does for HTTP requests.
+func logCall(ctx context.Context, logger *slog.Logger, id string, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	if code == codes.Internal || code == codes.Unavailable || code == codes.Unknown {
+		level = slog.LevelError
+	}
+	logger.LogAttrs(ctx, level, "gRPC request",
+		slog.String("request_id", id),
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Duration("latency", time.Since(start)),
+	)
+}
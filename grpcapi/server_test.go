@@ -0,0 +1,238 @@
+package grpcapi
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/client"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/grpcapi/signingpb"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestClient serves devices over an in-memory connection.
+func newTestClient(t *testing.T, devices *service.DeviceService) signingpb.DeviceServiceClient {
+	t.Helper()
+	listener := bufconn.Listen(1 << 20)
+	server := NewGRPCServer(devices, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return signingpb.NewDeviceServiceClient(conn)
+}
+
+func TestDeviceService(t *testing.T) {
+	c := newTestClient(t, service.NewDeviceService(persistence.NewInMemoryDeviceRepository()))
+	ctx := context.Background()
+
+	device, err := c.CreateDevice(ctx, &signingpb.CreateDeviceRequest{Algorithm: "ecc", Label: "gRPC Device"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if device.GetId() == "" || device.GetAlgorithm() != "ECC" || len(device.GetPublicKey()) == 0 {
+		t.Fatalf("Unexpected device: %v", device)
+	}
+	if block, _ := pem.Decode(device.GetPublicKey()); block == nil || block.Type != "PUBLIC KEY" {
+		t.Errorf("Expected a standard PEM public key, got %q", device.GetPublicKey())
+	}
+
+	fetched, err := c.GetDevice(ctx, &signingpb.GetDeviceRequest{Id: device.GetId()})
+	if err != nil {
+		t.Fatalf("GetDevice failed: %v", err)
+	}
+	if fetched.GetLabel() != "gRPC Device" {
+		t.Errorf("Expected label 'gRPC Device', got %q", fetched.GetLabel())
+	}
+
+	var signatures []*signingpb.SignTransactionResponse
+	for _, data := range []string{"first", "second", "third"} {
+		signature, err := c.SignTransaction(ctx, &signingpb.SignTransactionRequest{DeviceId: device.GetId(), Data: data})
+		if err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+		signatures = append(signatures, signature)
+	}
+
+	stream, err := c.ListTransactions(ctx, &signingpb.ListTransactionsRequest{DeviceId: device.GetId()})
+	if err != nil {
+		t.Fatalf("ListTransactions failed: %v", err)
+	}
+	for i := 0; ; i++ {
+		transaction, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			if i != len(signatures) {
+				t.Errorf("Expected %d transactions, got %d", len(signatures), i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to receive transaction: %v", err)
+		}
+		if transaction.GetCounter() != int64(i) || transaction.GetSignature() != signatures[i].GetSignature() {
+			t.Errorf("Transaction %d does not match its signature: %v", i, transaction)
+		}
+		if transaction.GetSignedAt().AsTime().IsZero() {
+			t.Errorf("Transaction %d has no signing time", i)
+		}
+	}
+
+	list, err := c.ListDevices(ctx, &signingpb.ListDevicesRequest{})
+	if err != nil {
+		t.Fatalf("ListDevices failed: %v", err)
+	}
+	if len(list.GetDevices()) != 1 || list.GetDevices()[0].GetSignatureCounter() != 3 {
+		t.Errorf("Expected one device with 3 signatures, got %v", list.GetDevices())
+	}
+}
+
+func TestDeviceServiceErrors(t *testing.T) {
+	marks := persistence.NewInMemoryHighWaterMarkRepository()
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithHighWaterMarkRepository(marks))
+	devices.ApplySettings(service.Settings{MaxDevices: 1})
+	c := newTestClient(t, devices)
+	ctx := context.Background()
+
+	device, err := c.CreateDevice(ctx, &signingpb.CreateDeviceRequest{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	// As if the device was restored from an older backup.
+	marks.Raise(ctx, device.GetId(), 5)
+
+	tests := []struct {
+		name string
+		call func() error
+		code codes.Code
+	}{
+		{"unknown device", func() error {
+			_, err := c.GetDevice(ctx, &signingpb.GetDeviceRequest{Id: "unknown"})
+			return err
+		}, codes.NotFound},
+		{"sign with unknown device", func() error {
+			_, err := c.SignTransaction(ctx, &signingpb.SignTransactionRequest{DeviceId: "unknown", Data: "data"})
+			return err
+		}, codes.NotFound},
+		{"history of unknown device", func() error {
+			stream, err := c.ListTransactions(ctx, &signingpb.ListTransactionsRequest{DeviceId: "unknown"})
+			if err != nil {
+				return err
+			}
+			_, err = stream.Recv()
+			return err
+		}, codes.NotFound},
+		{"invalid algorithm", func() error {
+			_, err := c.CreateDevice(ctx, &signingpb.CreateDeviceRequest{Algorithm: "DSA"})
+			return err
+		}, codes.InvalidArgument},
+		{"invalid ID", func() error {
+			_, err := c.CreateDevice(ctx, &signingpb.CreateDeviceRequest{Id: "not-a-uuid", Algorithm: "ECC"})
+			return err
+		}, codes.InvalidArgument},
+		{"sign with rolled back device", func() error {
+			_, err := c.SignTransaction(ctx, &signingpb.SignTransactionRequest{DeviceId: device.GetId(), Data: "data"})
+			return err
+		}, codes.FailedPrecondition},
+		{"device limit", func() error {
+			_, err := c.CreateDevice(ctx, &signingpb.CreateDeviceRequest{Algorithm: "ECC"})
+			return err
+		}, codes.ResourceExhausted},
+	}
+
+	for _, test := range tests {
+		if code := status.Code(test.call()); code != test.code {
+			t.Errorf("%s: got code %v want %v", test.name, code, test.code)
+		}
+	}
+}
+
+// TestRESTAndGRPCShareState checks that both APIs operate on the same devices and counters.
+func TestRESTAndGRPCShareState(t *testing.T) {
+	devices := service.NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	grpcClient := newTestClient(t, devices)
+	httpServer := httptest.NewServer(api.NewServer(":0", api.NewDeviceHandler(devices)).Handler())
+	defer httpServer.Close()
+	restClient := client.New(httpServer.URL)
+	ctx := context.Background()
+
+	device, err := grpcClient.CreateDevice(ctx, &signingpb.CreateDeviceRequest{Algorithm: "RSA"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := restClient.SignTransaction(ctx, device.GetId(), "via REST"); err != nil {
+		t.Fatalf("REST SignTransaction failed: %v", err)
+	}
+	signature, err := grpcClient.SignTransaction(ctx, &signingpb.SignTransactionRequest{DeviceId: device.GetId(), Data: "via gRPC"})
+	if err != nil {
+		t.Fatalf("gRPC SignTransaction failed: %v", err)
+	}
+	if counter, data, _, err := domain.ParseSecuredData(signature.GetSignedData()); err != nil || counter != 1 || data != "via gRPC" {
+		t.Errorf("Expected the second signature of the device, got %q", signature.GetSignedData())
+	}
+
+	response := api.SignTransactionResponse{Signature: signature.GetSignature(), SignedData: signature.GetSignedData()}
+	if err := restClient.Verify(ctx, device.GetId(), &response); err != nil {
+		t.Errorf("Signature created over gRPC does not verify with the REST public key: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v0/devices/"+device.GetId()+"/transactions", nil)
+	rr := httptest.NewRecorder()
+	api.NewServer(":0", api.NewDeviceHandler(devices)).Handler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || strings.Count(rr.Body.String(), `"counter"`) != 2 {
+		t.Errorf("Expected both transactions in the REST history, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAuditEntriesRecordClientAddress(t *testing.T) {
+	key, err := audit.LoadServiceKey("")
+	if err != nil {
+		t.Fatalf("Failed to load audit key: %v", err)
+	}
+	auditLog, err := audit.NewLog(audit.NewMemoryStore(), key, 1)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	c := newTestClient(t, service.NewDeviceService(persistence.NewInMemoryDeviceRepository(), service.WithAuditLog(auditLog)))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-actor-id", "alice")
+	if _, err := c.CreateDevice(ctx, &signingpb.CreateDeviceRequest{Algorithm: "ECC"}); err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+
+	export, err := auditLog.Export(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to export audit log: %v", err)
+	}
+	if len(export.Entries) != 1 {
+		t.Fatalf("Expected 1 audit entry, got %d", len(export.Entries))
+	}
+	if entry := export.Entries[0]; entry.Actor != "alice" || entry.ClientAddress != "bufconn" {
+		t.Errorf("Expected actor alice from bufconn, got %q from %q", entry.Actor, entry.ClientAddress)
+	}
+}
@@ -1,25 +1,296 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"time"
 
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/api"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/backup"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/bundle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/config"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/crypto"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/grpcapi"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
-)
-
-const (
-	ListenAddress = ":8080"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/service"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/timestamp"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func main() {
-	repository := persistence.NewInMemoryDeviceRepository()
+	configPath := flag.String("config", os.Getenv(config.EnvPrefix+"CONFIG"), "path to a YAML or JSON config file")
+	flag.Parse()
+
+	// run returns once its deferred cleanups, such as flushing traces and
+	// stopping the gRPC server, have completed.
+	if err := run(*configPath); err != nil {
+		slog.Error("Service stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run starts the service with the configuration at configPath and blocks
+// until it is shut down.
+func run(configPath string) error {
+	cfg, err := config.Load(configPath)
+	if err != nil {
+		return fmt.Errorf("could not load configuration: %w", err)
+	}
+
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.Logging.SlogLevel())
+	logger := newLogger(cfg.Logging.Format, logLevel)
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		return fmt.Errorf("could not set up tracing: %w", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			slog.Error("Could not flush traces", "error", err)
+		}
+	}()
+
+	var repository persistence.DeviceRepository
+	switch cfg.Storage.Backend {
+	case config.StorageMemory:
+		repository = persistence.NewInMemoryDeviceRepository()
+	}
+
+	serviceMetrics := metrics.New()
+	repository = metrics.NewInstrumentedDeviceRepository(repository, serviceMetrics)
+	repository = tracing.NewTracedDeviceRepository(repository)
+
+	auditKey, err := audit.LoadServiceKey(cfg.Audit.SigningKeyFile)
+	if err != nil {
+		return fmt.Errorf("could not load audit service key: %w", err)
+	}
+	if cfg.Audit.SigningKeyFile == "" {
+		// Exports of this run can only be verified against this public key.
+		publicKey, _, _ := crypto.NewECCMarshaler().Marshal(*auditKey)
+		slog.Error("No audit signing key configured, using a key generated for this run; "+
+			"audit exports cannot be verified after a restart. Set audit.signing_key_file in production",
+			"public_key", string(publicKey))
+	}
+	var auditStore audit.Store = audit.NewMemoryStore()
+	if cfg.Audit.File != "" {
+		fileStore, err := audit.NewFileStore(cfg.Audit.File)
+		if err != nil {
+			return fmt.Errorf("could not open audit log %s: %w", cfg.Audit.File, err)
+		}
+		defer fileStore.Close()
+		auditStore = fileStore
+	} else {
+		slog.Warn("No audit log file configured, the audit log starts over on every restart")
+	}
+	auditLog, err := audit.NewLog(auditStore, auditKey, cfg.Audit.CheckpointInterval)
+	if err != nil {
+		return fmt.Errorf("could not create audit log: %w", err)
+	}
+
+	authority, err := ca.Load(cfg.CA.CertFile, cfg.CA.KeyFile, time.Duration(cfg.CA.Validity))
+	if err != nil {
+		return fmt.Errorf("could not load certificate authority: %w", err)
+	}
+	if cfg.CA.CertFile == "" {
+		slog.Warn("No CA certificate configured, using a CA generated for this run")
+	}
+
+	serviceOptions := []service.Option{
+		service.WithMetrics(serviceMetrics),
+		service.WithAuditLog(auditLog),
+		service.WithCertificateAuthority(authority),
+	}
+	switch cfg.Timestamp.Authority {
+	case config.TimestampLocal:
+		local, err := timestamp.NewLocal(authority)
+		if err != nil {
+			return fmt.Errorf("could not create local timestamp authority: %w", err)
+		}
+		serviceOptions = append(serviceOptions, service.WithTimestamper(local))
+	case config.TimestampRemote:
+		client := timestamp.NewClient(cfg.Timestamp.URL, time.Duration(cfg.Timestamp.Timeout))
+		serviceOptions = append(serviceOptions, service.WithTimestamper(client))
+	}
+
+	if cfg.Migration.KeyFile != "" {
+		migrationKey, err := bundle.LoadKey(cfg.Migration.KeyFile)
+		if err != nil {
+			return fmt.Errorf("could not load migration key: %w", err)
+		}
+		signingKey, err := bundle.LoadSigningKey(cfg.Migration.SigningKeyFile)
+		if err != nil {
+			return fmt.Errorf("could not load migration signing key: %w", err)
+		}
+		var trusted []*ecdsa.PublicKey
+		for _, path := range cfg.Migration.TrustedKeyFiles {
+			publicKey, err := bundle.LoadTrustedKey(path)
+			if err != nil {
+				return fmt.Errorf("could not load trusted migration key: %w", err)
+			}
+			trusted = append(trusted, publicKey)
+		}
+		serviceOptions = append(serviceOptions, service.WithMigrationKey(migrationKey.WithSigningKey(signingKey, trusted...)))
+	}
+
+	if cfg.HighWaterMark.File != "" {
+		marks, err := persistence.NewFileHighWaterMarkRepository(cfg.HighWaterMark.File)
+		if err != nil {
+			return fmt.Errorf("could not load high-water marks from %s: %w", cfg.HighWaterMark.File, err)
+		}
+		defer marks.Close()
+		serviceOptions = append(serviceOptions, service.WithHighWaterMarkRepository(marks))
+	} else {
+		slog.Warn("No high-water mark file configured, counter rollbacks are only detected until a restart")
+	}
 
-	deviceHandler := api.NewDeviceHandler(repository)
-	
-	server := api.NewServer(ListenAddress, deviceHandler)
+	devices := service.NewDeviceService(repository, serviceOptions...)
+	devices.ApplySettings(serviceSettings(cfg))
+	deviceHandler := api.NewDeviceHandler(devices)
+	deviceHandler.ApplySettings(deviceSettings(cfg))
 
-	log.Printf("Starting server on %s", ListenAddress)
-	if err := server.Run(); err != nil {
-		log.Fatalf("Could not start server on %s: %v", ListenAddress, err)
+	reloader := config.NewReloader(configPath, cfg)
+	reloader.OnReload(func(cfg *config.Config) {
+		devices.ApplySettings(serviceSettings(cfg))
+		deviceHandler.ApplySettings(deviceSettings(cfg))
+		logLevel.Set(cfg.Logging.SlogLevel())
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloader.WatchSignals(ctx)
+	auditLog.PeriodicCheckpoints(ctx, time.Duration(cfg.Audit.CheckpointPeriod), func(err error) {
+		slog.Error("Could not checkpoint audit log", "error", err)
+	})
+
+	options := []api.ServerOption{
+		api.WithTimeouts(
+			time.Duration(cfg.Server.ReadTimeout),
+			time.Duration(cfg.Server.WriteTimeout),
+			time.Duration(cfg.Server.IdleTimeout),
+		),
+		api.WithShutdownTimeout(time.Duration(cfg.Server.ShutdownTimeout)),
+		api.WithMetrics(serviceMetrics),
+		api.WithLogger(logger),
+		api.WithAuditHandler(api.NewAuditHandler(auditLog)),
+	}
+	if cfg.Backup.Target != "" {
+		backups, err := newBackupManager(cfg, devices)
+		if err != nil {
+			return fmt.Errorf("could not set up backups at %s: %w", cfg.Backup.Target, err)
+		}
+		options = append(options, api.WithBackupHandler(api.NewBackupHandler(backups)))
+		if cfg.Backup.Interval > 0 {
+			backups.Periodic(ctx, time.Duration(cfg.Backup.Interval), func(err error) {
+				slog.Error("Could not back up devices", "error", err)
+			})
+		}
+	}
+	if cfg.Server.TLS.Enabled {
+		options = append(options, api.WithTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile))
+	}
+	if cfg.Server.ErrorFormat == config.ErrorFormatProblem {
+		options = append(options, api.WithProblemDetails())
+	}
+
+	grpcErrors := make(chan error, 1)
+	if cfg.Server.GRPCListenAddress != "" {
+		grpcServer, err := newGRPCServer(cfg, devices, logger)
+		if err != nil {
+			return fmt.Errorf("could not set up gRPC server: %w", err)
+		}
+		listener, err := net.Listen("tcp", cfg.Server.GRPCListenAddress)
+		if err != nil {
+			return fmt.Errorf("could not listen for gRPC on %s: %w", cfg.Server.GRPCListenAddress, err)
+		}
+		go func() {
+			slog.Info("Starting gRPC server", "address", cfg.Server.GRPCListenAddress)
+			if err := grpcServer.Serve(listener); err != nil {
+				// Shut the HTTP server down as well, so that run returns.
+				grpcErrors <- fmt.Errorf("could not serve gRPC on %s: %w", cfg.Server.GRPCListenAddress, err)
+				cancel()
+			}
+		}()
+		defer grpcServer.GracefulStop()
+	}
+
+	server := api.NewServer(cfg.Server.ListenAddress, deviceHandler, options...)
+
+	slog.Info("Starting server", "address", cfg.Server.ListenAddress)
+	if err := server.Run(ctx); err != nil {
+		return fmt.Errorf("could not start server on %s: %w", cfg.Server.ListenAddress, err)
+	}
+	select {
+	case err := <-grpcErrors:
+		return err
+	default:
+		return nil
+	}
+}
+
+func newLogger(format string, level slog.Leveler) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level}
+	if format == config.LogFormatText {
+		return slog.New(slog.NewTextHandler(os.Stderr, options))
+	}
+	return slog.New(slog.NewJSONHandler(os.Stderr, options))
+}
+
+func serviceSettings(cfg *config.Config) service.Settings {
+	return service.Settings{
+		DefaultAlgorithm: cfg.Keys.DefaultAlgorithm,
+		MaxDevices:       cfg.Limits.MaxDevices,
+	}
+}
+
+func deviceSettings(cfg *config.Config) api.DeviceSettings {
+	return api.DeviceSettings{
+		MaxRequestBodyBytes: cfg.Limits.MaxRequestBodyBytes,
+		MaxStreamBytes:      cfg.Limits.MaxStreamBytes,
+	}
+}
+
+// newGRPCServer serves the gRPC API with the TLS certificate of the HTTP server, if configured.
+func newGRPCServer(cfg *config.Config, devices *service.DeviceService, logger *slog.Logger) (*grpc.Server, error) {
+	var options []grpc.ServerOption
+	if cfg.Server.TLS.Enabled {
+		creds, err := credentials.NewServerTLSFromFile(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	if limit := cfg.Limits.MaxRequestBodyBytes; limit > 0 {
+		options = append(options, grpc.MaxRecvMsgSize(int(limit)))
+	}
+	return grpcapi.NewGRPCServer(devices, logger, options...), nil
+}
+
+// newBackupManager writes backups to the directory or S3 bucket of the backup target.
+func newBackupManager(cfg *config.Config, devices *service.DeviceService) (*backup.Manager, error) {
+	key, err := bundle.LoadKey(cfg.Backup.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	store, err := backup.NewStore(cfg.Backup.Target, backup.S3Config{
+		Endpoint:        cfg.Backup.Endpoint,
+		Region:          cfg.Backup.Region,
+		AccessKeyID:     cfg.Backup.AccessKeyID,
+		SecretAccessKey: cfg.Backup.SecretAccessKey,
+		Timeout:         time.Duration(cfg.Backup.Timeout),
+	})
+	if err != nil {
+		return nil, err
 	}
+	return backup.NewManager(devices, store, key), nil
 }
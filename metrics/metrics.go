@@ -0,0 +1,132 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "signing_service"
+
+// Metrics holds the Prometheus collectors of the service and the registry they are registered with.
+// A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests        *prometheus.CounterVec
+	httpRequestDuration *prometheus.HistogramVec
+	signatures          *prometheus.CounterVec
+	signDuration        *prometheus.HistogramVec
+	repositoryDuration  *prometheus.HistogramVec
+}
+
+// New creates a Metrics instance with its own registry, including the Go runtime and process collectors.
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		signatures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "signatures_total",
+			Help:      "Number of signature operations by algorithm and result.",
+		}, []string{"algorithm", "result"}),
+		signDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "sign_duration_seconds",
+			Help:      "Signature latency by algorithm and phase (key_parse or crypto).",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 14),
+		}, []string{"algorithm", "phase"}),
+		repositoryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "repository_operation_duration_seconds",
+			Help:      "Device repository latency by operation and result.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"operation", "result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.signatures,
+		m.signDuration,
+		m.repositoryDuration,
+	)
+
+	return m
+}
+
+// Handler serves the registered metrics in the Prometheus exposition format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveSignature records the outcome and phase latencies of a signature operation.
+func (m *Metrics) ObserveSignature(algorithm domain.SignatureAlgorithm, timing domain.SignTiming, err error) {
+	if m == nil {
+		return
+	}
+
+	m.signatures.WithLabelValues(string(algorithm), result(err)).Inc()
+	m.signDuration.WithLabelValues(string(algorithm), "key_parse").Observe(timing.KeyParse.Seconds())
+	if err == nil {
+		m.signDuration.WithLabelValues(string(algorithm), "crypto").Observe(timing.Crypto.Seconds())
+	}
+}
+
+// InstrumentHandler wraps next and records request counts and latency.
+// routeOf maps a request to a low-cardinality route label such as "/api/v0/devices/{id}".
+func (m *Metrics) InstrumentHandler(routeOf func(*http.Request) string, next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := routeOf(r)
+		m.httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(recorder.status)).Inc()
+		m.httpRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (m *Metrics) observeRepository(operation string, start time.Time, err error) {
+	m.repositoryDuration.WithLabelValues(operation, result(err)).Observe(time.Since(start).Seconds())
+}
+
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
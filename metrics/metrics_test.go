@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func scrape(t *testing.T, m *Metrics) string {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Metrics handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	return rr.Body.String()
+}
+
+func TestInstrumentHandler(t *testing.T) {
+	m := New()
+	handler := m.InstrumentHandler(
+		func(*http.Request) string { return "/api/v0/devices/{id}" },
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}),
+	)
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v0/devices/abc", nil))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v0/devices/def", nil))
+
+	count := testutil.ToFloat64(m.httpRequests.WithLabelValues(http.MethodGet, "/api/v0/devices/{id}", "404"))
+	if count != 2 {
+		t.Errorf("Expected 2 requests to be counted, got %v", count)
+	}
+}
+
+func TestObserveSignature(t *testing.T) {
+	m := New()
+	m.ObserveSignature(domain.ECC, domain.SignTiming{KeyParse: time.Millisecond, Crypto: 2 * time.Millisecond}, nil)
+	m.ObserveSignature(domain.RSA, domain.SignTiming{KeyParse: time.Millisecond}, errors.New("boom"))
+
+	if count := testutil.ToFloat64(m.signatures.WithLabelValues("ECC", "success")); count != 1 {
+		t.Errorf("Expected 1 successful ECC signature, got %v", count)
+	}
+	if count := testutil.ToFloat64(m.signatures.WithLabelValues("RSA", "error")); count != 1 {
+		t.Errorf("Expected 1 failed RSA signature, got %v", count)
+	}
+
+	body := scrape(t, m)
+	if !strings.Contains(body, `signing_service_sign_duration_seconds_count{algorithm="ECC",phase="crypto"} 1`) {
+		t.Errorf("Expected crypto phase latency to be exposed")
+	}
+
+	var nilMetrics *Metrics
+	nilMetrics.ObserveSignature(domain.ECC, domain.SignTiming{}, nil)
+
+	repo := persistence.NewInMemoryDeviceRepository()
+	if wrapped := NewInstrumentedDeviceRepository(repo, nilMetrics); wrapped != persistence.DeviceRepository(repo) {
+		t.Errorf("Expected the repository to be returned unchanged without metrics")
+	}
+}
+
+func TestInstrumentedDeviceRepository(t *testing.T) {
+	m := New()
+	repo := NewInstrumentedDeviceRepository(persistence.NewInMemoryDeviceRepository(), m)
+	ctx := context.Background()
+
+	device, err := domain.NewSignatureDevice(uuid.New().String(), domain.ECC, "Test Device")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	if err := repo.Create(ctx, device); err != nil {
+		t.Fatalf("Failed to create device in repository: %v", err)
+	}
+	if _, err := repo.Get(ctx, "non-existent-id"); err == nil {
+		t.Errorf("Expected error when getting non-existent device")
+	}
+
+	body := scrape(t, m)
+	for _, expected := range []string{
+		`signing_service_repository_operation_duration_seconds_count{operation="create",result="success"} 1`,
+		`signing_service_repository_operation_duration_seconds_count{operation="get",result="error"} 1`,
+		`signing_service_devices{algorithm="ECC"} 1`,
+		`signing_service_devices{algorithm="RSA"} 0`,
+	} {
+		if !strings.Contains(body, expected) {
+			t.Errorf("Expected metrics to contain %q", expected)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InstrumentedDeviceRepository is a persistence.DeviceRepository decorator
+// that records the latency of every operation.
+type InstrumentedDeviceRepository struct {
+	next    persistence.DeviceRepository
+	metrics *Metrics
+}
+
+// NewInstrumentedDeviceRepository wraps next and registers a collector that
+// reports the number of stored devices by algorithm at scrape time.
+// With nil metrics next is returned unchanged.
+func NewInstrumentedDeviceRepository(next persistence.DeviceRepository, metrics *Metrics) persistence.DeviceRepository {
+	if metrics == nil {
+		return next
+	}
+	metrics.registry.MustRegister(newDeviceCollector(next))
+
+	return &InstrumentedDeviceRepository{
+		next:    next,
+		metrics: metrics,
+	}
+}
+
+func (r *InstrumentedDeviceRepository) Create(ctx context.Context, device *domain.SignatureDevice) error {
+	start := time.Now()
+	err := r.next.Create(ctx, device)
+	r.metrics.observeRepository("create", start, err)
+	return err
+}
+
+func (r *InstrumentedDeviceRepository) Get(ctx context.Context, id string) (*domain.SignatureDevice, error) {
+	start := time.Now()
+	device, err := r.next.Get(ctx, id)
+	r.metrics.observeRepository("get", start, err)
+	return device, err
+}
+
+func (r *InstrumentedDeviceRepository) List(ctx context.Context) ([]*domain.SignatureDevice, error) {
+	start := time.Now()
+	devices, err := r.next.List(ctx)
+	r.metrics.observeRepository("list", start, err)
+	return devices, err
+}
+
+func (r *InstrumentedDeviceRepository) Update(ctx context.Context, device *domain.SignatureDevice) error {
+	start := time.Now()
+	err := r.next.Update(ctx, device)
+	r.metrics.observeRepository("update", start, err)
+	return err
+}
+
+func (r *InstrumentedDeviceRepository) Delete(ctx context.Context, id string) error {
+	start := time.Now()
+	err := r.next.Delete(ctx, id)
+	r.metrics.observeRepository("delete", start, err)
+	return err
+}
+
+// deviceCollector counts the stored devices by algorithm whenever metrics are scraped.
+type deviceCollector struct {
+	repository persistence.DeviceRepository
+	devices    *prometheus.Desc
+}
+
+func newDeviceCollector(repository persistence.DeviceRepository) *deviceCollector {
+	return &deviceCollector{
+		repository: repository,
+		devices: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "devices"),
+			"Number of signature devices by algorithm.",
+			[]string{"algorithm"}, nil,
+		),
+	}
+}
+
+func (c *deviceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.devices
+}
+
+func (c *deviceCollector) Collect(ch chan<- prometheus.Metric) {
+	devices, err := c.repository.List(context.Background())
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.devices, err)
+		return
+	}
+
+	counts := map[domain.SignatureAlgorithm]int{
+		domain.RSA: 0,
+		domain.ECC: 0,
+	}
+	for _, device := range devices {
+		counts[device.Algorithm]++
+	}
+
+	for algorithm, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.devices, prometheus.GaugeValue, float64(count), string(algorithm))
+	}
+}
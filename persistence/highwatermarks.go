@@ -0,0 +1,221 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HighWaterMarkRepository stores the highest signature counter each device
+// has reached. It is kept apart from the DeviceRepository, so that restoring
+// devices from a backup cannot reset it.
+type HighWaterMarkRepository interface {
+	// Get returns the high-water mark of a device, or 0 if none is recorded.
+	Get(ctx context.Context, deviceID string) (int, error)
+	// Raise sets the high-water mark of a device to counter, unless it is already higher.
+	Raise(ctx context.Context, deviceID string, counter int) error
+	// Set replaces the high-water mark of a device, even with a lower counter.
+	Set(ctx context.Context, deviceID string, counter int) error
+}
+
+type InMemoryHighWaterMarkRepository struct {
+	marks map[string]int
+	mu    sync.RWMutex
+}
+
+func NewInMemoryHighWaterMarkRepository() *InMemoryHighWaterMarkRepository {
+	return &InMemoryHighWaterMarkRepository{marks: make(map[string]int)}
+}
+
+func (r *InMemoryHighWaterMarkRepository) Get(ctx context.Context, deviceID string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.marks[deviceID], nil
+}
+
+func (r *InMemoryHighWaterMarkRepository) Raise(ctx context.Context, deviceID string, counter int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if counter > r.marks[deviceID] {
+		r.marks[deviceID] = counter
+	}
+	return nil
+}
+
+func (r *InMemoryHighWaterMarkRepository) Set(ctx context.Context, deviceID string, counter int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.marks[deviceID] = counter
+	return nil
+}
+
+// FileHighWaterMarkRepository keeps the high-water marks in an append-only
+// log with one JSON record per change, so that raising a mark only writes that
+// device's record. The last record of a device is its mark. The log is
+// compacted to one record per device when it is opened and whenever it has
+// grown well beyond that.
+type FileHighWaterMarkRepository struct {
+	path    string
+	file    *os.File
+	marks   map[string]int
+	records int
+	mu      sync.Mutex
+}
+
+// highWaterMarkRecord is one line of the high-water mark log.
+type highWaterMarkRecord struct {
+	DeviceID string `json:"device_id"`
+	Counter  int    `json:"counter"`
+}
+
+// minCompactRecords is the log size below which it is not compacted.
+const minCompactRecords = 1024
+
+// NewFileHighWaterMarkRepository reads the marks stored at path. A missing
+// file holds no marks and is created. An incomplete last record, left by a
+// crash while it was written, is dropped.
+func NewFileHighWaterMarkRepository(path string) (*FileHighWaterMarkRepository, error) {
+	r := &FileHighWaterMarkRepository{path: path, marks: make(map[string]int)}
+
+	content, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read high-water marks: %w", err)
+	}
+	if err := r.replay(content); err != nil {
+		return nil, err
+	}
+	if err := r.compact(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// replay applies the complete records of a log to the marks. Every record
+// ends with a newline, so text after the last one was never fully written.
+func (r *FileHighWaterMarkRepository) replay(content []byte) error {
+	content = content[:bytes.LastIndexByte(content, '\n')+1]
+	for i, line := range bytes.Split(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var record highWaterMarkRecord
+		if err := json.Unmarshal(line, &record); err != nil || record.DeviceID == "" {
+			return fmt.Errorf("failed to parse high-water marks: line %d is not a record", i+1)
+		}
+		r.marks[record.DeviceID] = record.Counter
+	}
+	return nil
+}
+
+func (r *FileHighWaterMarkRepository) Get(ctx context.Context, deviceID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.marks[deviceID], nil
+}
+
+func (r *FileHighWaterMarkRepository) Raise(ctx context.Context, deviceID string, counter int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if counter <= r.marks[deviceID] {
+		return nil
+	}
+	return r.store(deviceID, counter)
+}
+
+func (r *FileHighWaterMarkRepository) Set(ctx context.Context, deviceID string, counter int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.store(deviceID, counter)
+}
+
+// Close closes the log.
+func (r *FileHighWaterMarkRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.file.Close()
+}
+
+// store appends the new counter of a device to the log and only keeps the
+// change in memory once it is on disk.
+func (r *FileHighWaterMarkRepository) store(deviceID string, counter int) error {
+	line, err := json.Marshal(highWaterMarkRecord{DeviceID: deviceID, Counter: counter})
+	if err != nil {
+		return err
+	}
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		// Rewrite the log without the partial record, so that later records
+		// do not follow it on the same line.
+		r.compact()
+		return fmt.Errorf("%w: failed to write high-water mark: %v", ErrUnavailable, err)
+	}
+	if err := r.file.Sync(); err != nil {
+		return fmt.Errorf("%w: failed to write high-water mark: %v", ErrUnavailable, err)
+	}
+	r.marks[deviceID] = counter
+	r.records++
+
+	if r.records > max(minCompactRecords, 2*len(r.marks)) {
+		// The mark is stored; a failed compaction is retried on the next change.
+		r.compact()
+	}
+	return nil
+}
+
+// compact replaces the log with one record per device, through a temporary
+// file and a rename, so that a crash leaves either the old or the new log.
+func (r *FileHighWaterMarkRepository) compact() error {
+	var content []byte
+	for deviceID, counter := range r.marks {
+		line, err := json.Marshal(highWaterMarkRecord{DeviceID: deviceID, Counter: counter})
+		if err != nil {
+			return err
+		}
+		content = append(append(content, line...), '\n')
+	}
+	if err := writeFileAtomic(r.path, content); err != nil {
+		return fmt.Errorf("%w: failed to write high-water marks: %v", ErrUnavailable, err)
+	}
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("%w: failed to open high-water marks: %v", ErrUnavailable, err)
+	}
+	if r.file != nil {
+		r.file.Close()
+	}
+	r.file = file
+	r.records = len(r.marks)
+	return nil
+}
+
+func writeFileAtomic(path string, content []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,88 @@
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileHighWaterMarkRepository(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "high-water-marks.json")
+	repo, err := NewFileHighWaterMarkRepository(path)
+	if err != nil {
+		t.Fatalf("Failed to open repository: %v", err)
+	}
+
+	if mark, err := repo.Get(ctx, "device"); err != nil || mark != 0 {
+		t.Errorf("Expected no mark for an unknown device, got %d, %v", mark, err)
+	}
+	if err := repo.Raise(ctx, "device", 5); err != nil {
+		t.Fatalf("Raise failed: %v", err)
+	}
+	if err := repo.Raise(ctx, "device", 3); err != nil {
+		t.Fatalf("Raise failed: %v", err)
+	}
+	if mark, _ := repo.Get(ctx, "device"); mark != 5 {
+		t.Errorf("Expected a lower counter not to lower the mark, got %d", mark)
+	}
+	// Raising a mark appends a record instead of rewriting the file.
+	before, _ := os.ReadFile(path)
+	if err := repo.Raise(ctx, "other", 1); err != nil {
+		t.Fatalf("Raise failed: %v", err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.HasPrefix(after, before) || bytes.Count(after, []byte("\n")) != 2 {
+		t.Errorf("Expected one more record in the log, got %q", after)
+	}
+	repo.Close()
+
+	reopened, err := NewFileHighWaterMarkRepository(path)
+	if err != nil {
+		t.Fatalf("Failed to reopen repository: %v", err)
+	}
+	if mark, _ := reopened.Get(ctx, "device"); mark != 5 {
+		t.Errorf("Expected the mark to be persisted, got %d", mark)
+	}
+	if err := reopened.Set(ctx, "device", 2); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if mark, _ := reopened.Get(ctx, "device"); mark != 2 {
+		t.Errorf("Expected Set to lower the mark, got %d", mark)
+	}
+	reopened.Close()
+	if compacted, err := NewFileHighWaterMarkRepository(path); err != nil {
+		t.Errorf("Failed to reopen repository: %v", err)
+	} else if mark, _ := compacted.Get(ctx, "device"); mark != 2 {
+		t.Errorf("Expected the last record to win, got %d", mark)
+	} else {
+		compacted.Close()
+	}
+
+	// A crash while a record is written leaves it without its newline.
+	os.WriteFile(path, []byte("{\"device_id\":\"device\",\"counter\":7}\n{\"device_id\":\"device\",\"cou"), 0o600)
+	truncated, err := NewFileHighWaterMarkRepository(path)
+	if err != nil {
+		t.Fatalf("Failed to open a log with a truncated record: %v", err)
+	}
+	if mark, _ := truncated.Get(ctx, "device"); mark != 7 {
+		t.Errorf("Expected the mark of the last complete record, got %d", mark)
+	}
+	if err := truncated.Raise(ctx, "device", 8); err != nil {
+		t.Fatalf("Raise failed: %v", err)
+	}
+	truncated.Close()
+	if repaired, err := NewFileHighWaterMarkRepository(path); err != nil {
+		t.Errorf("Failed to reopen the repaired log: %v", err)
+	} else if mark, _ := repaired.Get(ctx, "device"); mark != 8 {
+		t.Errorf("Expected the record written after the truncated one, got %d", mark)
+	} else {
+		repaired.Close()
+	}
+
+	os.WriteFile(path, []byte("{\"device_id\":\"device\",\"counter\":7}\nnot json\n{\"device_id\":\"device\",\"counter\":8}\n"), 0o600)
+	if _, err := NewFileHighWaterMarkRepository(path); err == nil {
+		t.Errorf("Expected an error for a corrupt record")
+	}
+}
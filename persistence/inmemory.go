@@ -8,6 +8,19 @@ import (
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
 )
 
+var (
+	// ErrNotFound is returned by Get, Update and Delete for unknown device IDs.
+	ErrNotFound = errors.New("device not found")
+	// ErrAlreadyExists is returned by Create for device IDs that are already taken.
+	ErrAlreadyExists = errors.New("device with this ID already exists")
+	// ErrUnavailable is wrapped by backends that cannot reach their storage.
+	// The operation may succeed when retried later.
+	ErrUnavailable = errors.New("storage unavailable")
+)
+
+// DeviceRepository stores signature devices. Implementations return ErrNotFound,
+// ErrAlreadyExists and ErrUnavailable, possibly wrapped, so that callers can tell
+// them apart from other failures.
 type DeviceRepository interface {
 	Create(ctx context.Context, device *domain.SignatureDevice) error
 	Get(ctx context.Context, id string) (*domain.SignatureDevice, error)
@@ -38,7 +51,7 @@ func (r *InMemoryDeviceRepository) Create(ctx context.Context, device *domain.Si
 		return errors.New("device ID cannot be empty")
 	}
 	if _, exists := r.devices[device.ID]; exists {
-		return errors.New("device with this ID already exists")
+		return ErrAlreadyExists
 	}
 
 	r.devices[device.ID] = device.Clone()
@@ -51,7 +64,7 @@ func (r *InMemoryDeviceRepository) Get(ctx context.Context, id string) (*domain.
 
 	device, exists := r.devices[id]
 	if !exists {
-		return nil, errors.New("device not found")
+		return nil, ErrNotFound
 	}
 	return device.Clone(), nil
 }
@@ -78,7 +91,7 @@ func (r *InMemoryDeviceRepository) Update(ctx context.Context, device *domain.Si
 		return errors.New("device ID cannot be empty")
 	}
 	if _, exists := r.devices[device.ID]; !exists {
-		return errors.New("device not found")
+		return ErrNotFound
 	}
 
 	r.devices[device.ID] = device.Clone()
@@ -90,7 +103,7 @@ func (r *InMemoryDeviceRepository) Delete(ctx context.Context, id string) error
 	defer r.mu.Unlock()
 
 	if _, exists := r.devices[id]; !exists {
-		return errors.New("device not found")
+		return ErrNotFound
 	}
 	delete(r.devices, id)
 	return nil
@@ -2,6 +2,7 @@ package persistence
 
 import (
 	"context"
+	"errors"
 	"testing"
 
 	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
@@ -112,13 +113,13 @@ func TestInMemoryDeviceRepositoryErrors(t *testing.T) {
 		t.Fatalf("Failed to create duplicate device: %v", err)
 	}
 	err = repo.Create(ctx, duplicateDevice)
-	if err == nil {
-		t.Errorf("Expected error when creating device with duplicate ID")
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists when creating device with duplicate ID, got %v", err)
 	}
 
 	_, err = repo.Get(ctx, "non-existent-id")
-	if err == nil {
-		t.Errorf("Expected error when getting non-existent device")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound when getting non-existent device, got %v", err)
 	}
 
 	err = repo.Update(ctx, nil)
@@ -138,13 +139,13 @@ func TestInMemoryDeviceRepositoryErrors(t *testing.T) {
 		ID:    "non-existent-id",
 		Label: "Test Device",
 	})
-	if err == nil {
-		t.Errorf("Expected error when updating non-existent device")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound when updating non-existent device, got %v", err)
 	}
 
 	err = repo.Delete(ctx, "non-existent-id")
-	if err == nil {
-		t.Errorf("Expected error when deleting non-existent device")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound when deleting non-existent device, got %v", err)
 	}
 }
 
@@ -193,3 +194,41 @@ func TestInMemoryDeviceRepositoryConcurrency(t *testing.T) {
 		t.Errorf("Expected %d devices, got %d", numDevices, len(devices))
 	}
 }
+
+func TestInMemoryTransactionRepository(t *testing.T) {
+	repo := NewInMemoryTransactionRepository()
+	ctx := context.Background()
+
+	for counter := 0; counter < 3; counter++ {
+		err := repo.Append(ctx, &domain.Transaction{DeviceID: "device-1", Counter: counter, Signature: "signature"})
+		if err != nil {
+			t.Fatalf("Failed to append transaction: %v", err)
+		}
+	}
+	if err := repo.Append(ctx, &domain.Transaction{}); err == nil {
+		t.Errorf("Expected error when appending a transaction without device ID")
+	}
+
+	transactions, err := repo.List(ctx, "device-1")
+	if err != nil {
+		t.Fatalf("Failed to list transactions: %v", err)
+	}
+	if len(transactions) != 3 {
+		t.Fatalf("Expected 3 transactions, got %d", len(transactions))
+	}
+	for i, transaction := range transactions {
+		if transaction.Counter != i {
+			t.Errorf("Expected transaction %d to have counter %d, got %d", i, i, transaction.Counter)
+		}
+	}
+
+	transactions[0].Signature = "modified"
+	stored, _ := repo.List(ctx, "device-1")
+	if stored[0].Signature != "signature" {
+		t.Errorf("Expected listed transactions to be copies")
+	}
+
+	if empty, _ := repo.List(ctx, "device-2"); len(empty) != 0 {
+		t.Errorf("Expected no transactions for another device, got %d", len(empty))
+	}
+}
@@ -0,0 +1,79 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+)
+
+// TransactionRepository stores the history of signed transactions.
+type TransactionRepository interface {
+	Append(ctx context.Context, transaction *domain.Transaction) error
+	// List returns the transactions of a device in signing order.
+	List(ctx context.Context, deviceID string) ([]*domain.Transaction, error)
+	// Replace sets the transactions of a device, for restores from a backup.
+	// An empty list removes its history.
+	Replace(ctx context.Context, deviceID string, transactions []*domain.Transaction) error
+}
+
+type InMemoryTransactionRepository struct {
+	transactions map[string][]*domain.Transaction
+	mu           sync.RWMutex
+}
+
+func NewInMemoryTransactionRepository() *InMemoryTransactionRepository {
+	return &InMemoryTransactionRepository{
+		transactions: make(map[string][]*domain.Transaction),
+	}
+}
+
+func (r *InMemoryTransactionRepository) Append(ctx context.Context, transaction *domain.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if transaction == nil {
+		return errors.New("transaction cannot be nil")
+	}
+	if transaction.DeviceID == "" {
+		return errors.New("device ID cannot be empty")
+	}
+
+	stored := *transaction
+	r.transactions[transaction.DeviceID] = append(r.transactions[transaction.DeviceID], &stored)
+	return nil
+}
+
+func (r *InMemoryTransactionRepository) List(ctx context.Context, deviceID string) ([]*domain.Transaction, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	transactions := make([]*domain.Transaction, 0, len(r.transactions[deviceID]))
+	for _, transaction := range r.transactions[deviceID] {
+		clone := *transaction
+		transactions = append(transactions, &clone)
+	}
+	return transactions, nil
+}
+
+func (r *InMemoryTransactionRepository) Replace(ctx context.Context, deviceID string, transactions []*domain.Transaction) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(transactions) == 0 {
+		delete(r.transactions, deviceID)
+		return nil
+	}
+	stored := make([]*domain.Transaction, 0, len(transactions))
+	for _, transaction := range transactions {
+		if transaction.DeviceID != deviceID {
+			return fmt.Errorf("transaction of device %s cannot be stored for %s", transaction.DeviceID, deviceID)
+		}
+		clone := *transaction
+		stored = append(stored, &clone)
+	}
+	r.transactions[deviceID] = stored
+	return nil
+}
@@ -0,0 +1,1059 @@
+// Package service implements the device and signing use cases shared by the
+// REST and gRPC APIs.
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/bundle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/metrics"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/timestamp"
+	"github.com/google/uuid"
+)
+
+// Errors returned by DeviceService, possibly wrapped. Transports translate them
+// to status codes; any other error is an internal failure.
+var (
+	// ErrNotFound is returned when a device does not exist.
+	ErrNotFound = errors.New("device not found")
+	// ErrConflict is returned when a device with the requested ID already exists.
+	ErrConflict = errors.New("device with this ID already exists")
+	// ErrInvalidAlgorithm is returned for algorithms other than RSA and ECC.
+	ErrInvalidAlgorithm = domain.ErrUnsupportedAlgorithm
+	// ErrInvalidID is returned for device IDs that are not UUIDs.
+	ErrInvalidID = domain.ErrInvalidID
+	// ErrDeviceLimitReached is returned when the configured maximum number of devices exists.
+	ErrDeviceLimitReached = errors.New("device limit reached")
+	// ErrUnavailable is returned when the storage backend cannot be reached.
+	ErrUnavailable = persistence.ErrUnavailable
+	// ErrNoCertificateAuthority is returned when a certificate must be issued but no CA is configured.
+	ErrNoCertificateAuthority = errors.New("no certificate authority configured")
+	// ErrCertificateMismatch is returned when an imported certificate is for another key.
+	ErrCertificateMismatch = domain.ErrCertificateMismatch
+	// ErrInvalidCertificate is returned for imported certificates that are expired or do not form a chain.
+	ErrInvalidCertificate = errors.New("invalid certificate")
+	// ErrInvalidHashAlgorithm is returned for digests of hash algorithms other than SHA-256, SHA-384 and SHA-512.
+	ErrInvalidHashAlgorithm = domain.ErrUnsupportedHashAlgorithm
+	// ErrInvalidDigest is returned for digests whose length does not match their hash algorithm.
+	ErrInvalidDigest = domain.ErrInvalidDigest
+	// ErrInvalidDataEncoding is returned for data encodings other than utf8, base64 and hex.
+	ErrInvalidDataEncoding = domain.ErrUnsupportedDataEncoding
+	// ErrInvalidData is returned for transaction data that is not valid in its encoding.
+	ErrInvalidData = domain.ErrInvalidData
+	// ErrNoMigrationKey is returned when devices are exported or imported but no migration key is configured.
+	ErrNoMigrationKey = errors.New("no migration key configured")
+	// ErrInvalidBundle is returned for device bundles that cannot be decrypted or verified.
+	ErrInvalidBundle = bundle.ErrInvalidBundle
+	// ErrStaleBundle is returned when an imported device already exists with a
+	// higher signature counter, or with another signature at the same counter,
+	// or when the bundle is not newer than the last export or import of the device.
+	ErrStaleBundle = errors.New("bundle would roll back the existing device")
+	// ErrDeviceExported is returned when a device signs after it was exported
+	// to another instance, which would fork its signature chain.
+	ErrDeviceExported = errors.New("device was exported to another instance")
+	// ErrBrokenChain is returned when a restored transaction history does not form the signature chain of its device.
+	ErrBrokenChain = domain.ErrBrokenChain
+	// ErrCounterRollback is returned when a device signs with a signature
+	// counter below its high-water mark, which would re-issue counters, for
+	// example after a restore from a backup.
+	ErrCounterRollback = errors.New("signature counter is behind its high-water mark")
+	// ErrMissingReason is returned when a high-water mark override does not give a reason.
+	ErrMissingReason = errors.New("a reason is required")
+	// ErrTimestampUnavailable is returned when the time-stamping authority fails to timestamp a signature.
+	ErrTimestampUnavailable = errors.New("timestamp authority unavailable")
+)
+
+// Settings holds the DeviceService settings that can be changed at runtime.
+// Zero values disable the respective default or limit.
+type Settings struct {
+	DefaultAlgorithm string
+	MaxDevices       int
+}
+
+// DeviceService creates signature devices and signs transactions with them.
+type DeviceService struct {
+	repository   persistence.DeviceRepository
+	transactions persistence.TransactionRepository
+	marks        persistence.HighWaterMarkRepository
+	settings     atomic.Pointer[Settings]
+	metrics      *metrics.Metrics
+	auditLog     *audit.Log
+	authority    *ca.Authority
+	timestamper  timestamp.Timestamper
+	migrationKey *bundle.Key
+	now          func() time.Time
+
+	// locks serializes signatures per device, so that concurrent requests
+	// cannot sign with the same counter. An entry only exists while a request
+	// holds or waits for it, so unknown IDs do not accumulate.
+	locks   map[string]*deviceLock
+	locksMu sync.Mutex
+	// createMu serializes the device limit check with the creation of a
+	// device, so that concurrent requests cannot exceed the limit.
+	createMu sync.Mutex
+}
+
+// Option configures optional DeviceService dependencies.
+type Option func(*DeviceService)
+
+// WithMetrics makes the DeviceService record signature metrics.
+func WithMetrics(m *metrics.Metrics) Option {
+	return func(s *DeviceService) {
+		s.metrics = m
+	}
+}
+
+// WithAuditLog makes the DeviceService record administrative actions in log.
+func WithAuditLog(log *audit.Log) Option {
+	return func(s *DeviceService) {
+		s.auditLog = log
+	}
+}
+
+// WithTransactionRepository replaces the in-memory transaction history.
+func WithTransactionRepository(transactions persistence.TransactionRepository) Option {
+	return func(s *DeviceService) {
+		s.transactions = transactions
+	}
+}
+
+// WithCertificateAuthority makes the DeviceService issue an X.509 certificate
+// for the key of every device it creates.
+func WithCertificateAuthority(authority *ca.Authority) Option {
+	return func(s *DeviceService) {
+		s.authority = authority
+	}
+}
+
+// WithTimestamper makes the DeviceService obtain an RFC 3161 timestamp token
+// for every signature from timestamper.
+func WithTimestamper(timestamper timestamp.Timestamper) Option {
+	return func(s *DeviceService) {
+		s.timestamper = timestamper
+	}
+}
+
+// WithMigrationKey enables the export and import of device bundles encrypted
+// with key.
+func WithMigrationKey(key *bundle.Key) Option {
+	return func(s *DeviceService) {
+		s.migrationKey = key
+	}
+}
+
+// WithHighWaterMarkRepository replaces the in-memory high-water marks of the
+// signature counters. They must be stored apart from the devices to survive a
+// restore of the device store.
+func WithHighWaterMarkRepository(marks persistence.HighWaterMarkRepository) Option {
+	return func(s *DeviceService) {
+		s.marks = marks
+	}
+}
+
+func NewDeviceService(repository persistence.DeviceRepository, options ...Option) *DeviceService {
+	s := &DeviceService{
+		repository:   repository,
+		transactions: persistence.NewInMemoryTransactionRepository(),
+		marks:        persistence.NewInMemoryHighWaterMarkRepository(),
+		now:          time.Now,
+		locks:        make(map[string]*deviceLock),
+	}
+	s.settings.Store(&Settings{})
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// ApplySettings replaces the runtime settings of the service. It is safe to call concurrently with requests.
+func (s *DeviceService) ApplySettings(settings Settings) {
+	s.settings.Store(&settings)
+}
+
+// CreateDeviceParams describes a device to create. Empty IDs are generated and
+// empty algorithms default to the configured default algorithm.
+type CreateDeviceParams struct {
+	ID        string
+	Algorithm string
+	Label     string
+	// Actor is recorded in the audit log as the creator of the device.
+	Actor string
+}
+
+func (s *DeviceService) CreateDevice(ctx context.Context, params CreateDeviceParams) (*domain.SignatureDevice, error) {
+	settings := s.settings.Load()
+
+	if params.ID == "" {
+		params.ID = uuid.New().String()
+	} else if err := domain.ValidateID(params.ID); err != nil {
+		return nil, err
+	}
+
+	if params.Algorithm == "" {
+		params.Algorithm = settings.DefaultAlgorithm
+	}
+	algorithm, err := domain.ParseAlgorithm(params.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail before generating a key; create checks the limit again.
+	if err := s.checkDeviceLimit(ctx); err != nil {
+		return nil, err
+	}
+
+	device, err := domain.NewSignatureDevice(params.ID, algorithm, params.Label)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signature device: %w", err)
+	}
+	if s.authority != nil {
+		if err := s.issueCertificate(device); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.create(ctx, device); err != nil {
+		return nil, err
+	}
+
+	details := map[string]string{"label": device.Label, "algorithm": string(device.Algorithm)}
+	if _, err := s.auditLog.Record(ctx, params.Actor, audit.ActionCreateDevice, device.ID, details); err != nil {
+		// A device must not exist without its audit trail.
+		s.repository.Delete(ctx, device.ID)
+		return nil, fmt.Errorf("failed to record audit entry: %w", err)
+	}
+
+	return device, nil
+}
+
+// create stores a new device unless the configured maximum number of devices
+// exists. The check and the insert happen under createMu.
+func (s *DeviceService) create(ctx context.Context, device *domain.SignatureDevice) error {
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+
+	if err := s.checkDeviceLimit(ctx); err != nil {
+		return err
+	}
+	if err := s.repository.Create(ctx, device); err != nil {
+		if errors.Is(err, persistence.ErrAlreadyExists) {
+			return fmt.Errorf("%w: %s", ErrConflict, device.ID)
+		}
+		return fmt.Errorf("failed to store signature device: %w", err)
+	}
+	return nil
+}
+
+// checkDeviceLimit returns ErrDeviceLimitReached if the configured maximum
+// number of devices exists.
+func (s *DeviceService) checkDeviceLimit(ctx context.Context) error {
+	settings := s.settings.Load()
+	if settings.MaxDevices <= 0 {
+		return nil
+	}
+	devices, err := s.repository.List(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve devices: %w", err)
+	}
+	if len(devices) >= settings.MaxDevices {
+		return fmt.Errorf("%w: at most %d devices are allowed", ErrDeviceLimitReached, settings.MaxDevices)
+	}
+	return nil
+}
+
+func (s *DeviceService) GetDevice(ctx context.Context, id string) (*domain.SignatureDevice, error) {
+	device, err := s.repository.Get(ctx, id)
+	if errors.Is(err, persistence.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve device: %w", err)
+	}
+	return device, nil
+}
+
+func (s *DeviceService) ListDevices(ctx context.Context) ([]*domain.SignatureDevice, error) {
+	devices, err := s.repository.List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve devices: %w", err)
+	}
+	return devices, nil
+}
+
+// SignOption selects additional representations of a signature.
+type SignOption func(*signOptions)
+
+type signOptions struct {
+	jws  bool
+	cose bool
+}
+
+// WithJWS additionally signs the secured data as a compact JWS, returned in Transaction.JWS.
+func WithJWS() SignOption {
+	return func(o *signOptions) {
+		o.jws = true
+	}
+}
+
+// WithCOSE additionally signs the secured data as a COSE_Sign1 structure, returned in Transaction.COSE.
+func WithCOSE() SignOption {
+	return func(o *signOptions) {
+		o.cose = true
+	}
+}
+
+// SignTransaction signs data with the device with the given ID, stores the
+// increased signature counter and records the transaction in the history.
+func (s *DeviceService) SignTransaction(ctx context.Context, id string, data string, options ...SignOption) (*domain.Transaction, error) {
+	return s.sign(ctx, id, data, domain.DataUTF8, options)
+}
+
+// SignBytes signs binary transaction data. The secured data holds the data in
+// the canonical form of encoding, which is recorded with the transaction so
+// that verifiers can recover the original bytes.
+func (s *DeviceService) SignBytes(ctx context.Context, id string, encoding domain.DataEncoding, data []byte, options ...SignOption) (*domain.Transaction, error) {
+	field, err := domain.EncodeData(encoding, data)
+	if err != nil {
+		return nil, err
+	}
+	return s.sign(ctx, id, field, encoding, options)
+}
+
+// SignDigest signs a precomputed digest of the transaction data instead of the
+// data itself. The secured data holds "<algorithm>:<hex digest>" in place of
+// the data, so large payloads never reach the service.
+func (s *DeviceService) SignDigest(ctx context.Context, id string, algorithm domain.HashAlgorithm, digest []byte, options ...SignOption) (*domain.Transaction, error) {
+	data, err := domain.DigestData(algorithm, digest)
+	if err != nil {
+		return nil, err
+	}
+	return s.sign(ctx, id, data, "", options)
+}
+
+// sign signs the data field of the secured data, which is in the given
+// encoding, or empty for digests.
+func (s *DeviceService) sign(ctx context.Context, id string, data string, encoding domain.DataEncoding, options []SignOption) (*domain.Transaction, error) {
+	var opts signOptions
+	for _, option := range options {
+		option(&opts)
+	}
+
+	defer s.lock(id)()
+
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !device.ExportedAt.IsZero() {
+		return nil, fmt.Errorf("%w: %s was exported at %s", ErrDeviceExported, id, device.ExportedAt.UTC().Format(time.RFC3339))
+	}
+	if err := s.checkHighWaterMark(ctx, device); err != nil {
+		return nil, err
+	}
+
+	previous := device.Clone()
+	counter, previousSignature := device.SignatureCounter, device.LastSignature
+	signature, signedData, timing, err := device.SignTransactionTimed(ctx, data, s.now())
+	s.metrics.ObserveSignature(device.Algorithm, timing, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign transaction: %w", err)
+	}
+
+	// Additional representations and the timestamp are obtained before the
+	// update, so that a failure does not consume the counter.
+	var jws string
+	if opts.jws {
+		jws, err = device.SignJWS(signedData, counter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign JWS: %w", err)
+		}
+	}
+	var cose []byte
+	if opts.cose {
+		cose, err = device.SignCOSE(signedData, counter, previousSignature)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign COSE_Sign1: %w", err)
+		}
+	}
+	var timestampToken []byte
+	if s.timestamper != nil {
+		timestampToken, err = s.timestampSignature(ctx, signature)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repository.Update(ctx, device); err != nil {
+		if errors.Is(err, persistence.ErrNotFound) {
+			// The device was deleted while signing.
+			return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		return nil, fmt.Errorf("failed to update device: %w", err)
+	}
+	// The mark is raised before the signature is returned, so that no issued
+	// counter is above it.
+	if err := s.marks.Raise(ctx, id, device.SignatureCounter); err != nil {
+		s.repository.Update(ctx, previous)
+		return nil, fmt.Errorf("failed to raise high-water mark: %w", err)
+	}
+
+	transaction := &domain.Transaction{
+		DeviceID:       device.ID,
+		Counter:        counter,
+		Signature:      signature,
+		SignedData:     signedData,
+		SignedAt:       device.LastSignedAt,
+		JWS:            jws,
+		COSE:           cose,
+		TimestampToken: timestampToken,
+		DataEncoding:   encoding,
+	}
+	if err := s.transactions.Append(ctx, transaction); err != nil {
+		// The signature is not returned, so the counter is handed out again.
+		// If the mark cannot be lowered, the device refuses to sign until it
+		// is overridden, rather than skipping a counter in its journal.
+		s.repository.Update(ctx, previous)
+		s.marks.Set(ctx, id, previous.SignatureCounter)
+		return nil, fmt.Errorf("failed to record transaction: %w", err)
+	}
+	return transaction, nil
+}
+
+// checkHighWaterMark refuses devices whose signature counter is below the
+// highest counter they ever reached.
+func (s *DeviceService) checkHighWaterMark(ctx context.Context, device *domain.SignatureDevice) error {
+	mark, err := s.marks.Get(ctx, device.ID)
+	if err != nil {
+		return fmt.Errorf("failed to read high-water mark: %w", err)
+	}
+	if device.SignatureCounter < mark {
+		return fmt.Errorf("%w: %s is at counter %d, high-water mark %d", ErrCounterRollback, device.ID, device.SignatureCounter, mark)
+	}
+	return nil
+}
+
+// CounterStatus compares the signature counter of a device with its high-water mark.
+type CounterStatus struct {
+	SignatureCounter int
+	HighWaterMark    int
+}
+
+// RolledBack reports whether the device is refused for signing because its
+// counter is below its high-water mark.
+func (c CounterStatus) RolledBack() bool {
+	return c.SignatureCounter < c.HighWaterMark
+}
+
+// CounterStatus returns the signature counter and high-water mark of the device with the given ID.
+func (s *DeviceService) CounterStatus(ctx context.Context, id string) (CounterStatus, error) {
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return CounterStatus{}, err
+	}
+	mark, err := s.marks.Get(ctx, id)
+	if err != nil {
+		return CounterStatus{}, fmt.Errorf("failed to read high-water mark: %w", err)
+	}
+	return CounterStatus{SignatureCounter: device.SignatureCounter, HighWaterMark: mark}, nil
+}
+
+// OverrideHighWaterMark lowers the high-water mark of a device to its current
+// signature counter, so that a device that was rolled back can sign again.
+// The counters between the two are issued a second time; the override is
+// recorded in the audit log with the actor and reason. Devices that are not
+// rolled back are left unchanged.
+func (s *DeviceService) OverrideHighWaterMark(ctx context.Context, id string, actor string, reason string) (CounterStatus, error) {
+	if strings.TrimSpace(reason) == "" {
+		return CounterStatus{}, ErrMissingReason
+	}
+
+	defer s.lock(id)()
+
+	status, err := s.CounterStatus(ctx, id)
+	if err != nil || !status.RolledBack() {
+		return status, err
+	}
+	if err := s.marks.Set(ctx, id, status.SignatureCounter); err != nil {
+		return CounterStatus{}, fmt.Errorf("failed to set high-water mark: %w", err)
+	}
+
+	details := map[string]string{
+		"signature_counter": strconv.Itoa(status.SignatureCounter),
+		"high_water_mark":   strconv.Itoa(status.HighWaterMark),
+		"reason":            reason,
+	}
+	if _, err := s.auditLog.Record(ctx, actor, audit.ActionOverrideHighWaterMark, id, details); err != nil {
+		return CounterStatus{}, fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return CounterStatus{SignatureCounter: status.SignatureCounter, HighWaterMark: status.SignatureCounter}, nil
+}
+
+// timestampSignature returns a timestamp token for the SHA-256 digest of the
+// decoded signature.
+func (s *DeviceService) timestampSignature(ctx context.Context, signature string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %w", err)
+	}
+	digest := sha256.Sum256(decoded)
+	token, err := s.timestamper.Timestamp(ctx, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTimestampUnavailable, err)
+	}
+	return token, nil
+}
+
+// ListTransactions returns the transactions signed by the device with the given ID in signing order.
+func (s *DeviceService) ListTransactions(ctx context.Context, id string) ([]*domain.Transaction, error) {
+	if _, err := s.GetDevice(ctx, id); err != nil {
+		return nil, err
+	}
+
+	transactions, err := s.transactions.List(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve transactions: %w", err)
+	}
+	return transactions, nil
+}
+
+// deviceLock is the mutex of a device and the number of requests holding or
+// waiting for it.
+type deviceLock struct {
+	sync.Mutex
+	refs int
+}
+
+// lock serializes changes to the device with the given ID and returns the
+// function that releases the lock. The last request to release it removes
+// the entry.
+func (s *DeviceService) lock(id string) func() {
+	s.locksMu.Lock()
+	lock, ok := s.locks[id]
+	if !ok {
+		lock = &deviceLock{}
+		s.locks[id] = lock
+	}
+	lock.refs++
+	s.locksMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+
+		s.locksMu.Lock()
+		defer s.locksMu.Unlock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(s.locks, id)
+		}
+	}
+}
+
+// CertificateChain returns the certificate of the device with the given ID,
+// followed by its issuer certificates. A certificate that does not certify the
+// current key, because the key was rotated or the device predates the CA, is
+// re-issued by the internal CA and stored first.
+func (s *DeviceService) CertificateChain(ctx context.Context, id string) ([]*x509.Certificate, error) {
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if device.Certificate == nil || !ca.Certifies(device.Certificate, device) {
+		if s.authority == nil {
+			return nil, ErrNoCertificateAuthority
+		}
+		device, err = s.reissueCertificate(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	chain := make([]*x509.Certificate, 0, 1+len(device.CertificateChain))
+	for _, der := range append([][]byte{device.Certificate}, device.CertificateChain...) {
+		certificate, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse device certificate: %w", err)
+		}
+		chain = append(chain, certificate)
+	}
+	return chain, nil
+}
+
+func (s *DeviceService) reissueCertificate(ctx context.Context, id string) (*domain.SignatureDevice, error) {
+	defer s.lock(id)()
+
+	// Reload the device, since a signature may have been stored meanwhile.
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if device.Certificate != nil && ca.Certifies(device.Certificate, device) {
+		return device, nil
+	}
+
+	if err := s.issueCertificate(device); err != nil {
+		return nil, err
+	}
+	if err := s.update(ctx, device); err != nil {
+		return nil, err
+	}
+	return device, nil
+}
+
+// issueCertificate certifies the current key of device with the internal CA.
+func (s *DeviceService) issueCertificate(device *domain.SignatureDevice) error {
+	certificate, err := s.authority.Issue(device)
+	if err != nil {
+		return fmt.Errorf("failed to issue device certificate: %w", err)
+	}
+	device.Certificate = certificate
+	device.CertificateChain = [][]byte{s.authority.Certificate().Raw}
+	return nil
+}
+
+// CertificateRequest returns a DER-encoded PKCS#10 certificate signing request
+// for the key of the device with the given ID, for certification by an
+// external CA. An empty subject common name defaults to the label or ID, and
+// an empty subject serial number to the ID.
+func (s *DeviceService) CertificateRequest(ctx context.Context, id string, subject pkix.Name) ([]byte, error) {
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if subject.CommonName == "" {
+		subject.CommonName = device.Label
+		if subject.CommonName == "" {
+			subject.CommonName = device.ID
+		}
+	}
+	if subject.SerialNumber == "" {
+		subject.SerialNumber = device.ID
+	}
+	return device.CertificateRequest(subject)
+}
+
+// ImportCertificate replaces the certificate of the device with the given ID
+// by chain, a certificate issued by an external CA followed by its issuer
+// certificates. The certificate must certify the current device key, be valid
+// now, and each certificate must be signed by the next one in the chain.
+func (s *DeviceService) ImportCertificate(ctx context.Context, id string, chain []*x509.Certificate, actor string) error {
+	if len(chain) == 0 {
+		return fmt.Errorf("%w: no certificate given", ErrInvalidCertificate)
+	}
+	now := s.now()
+	for i, certificate := range chain {
+		if now.Before(certificate.NotBefore) || now.After(certificate.NotAfter) {
+			return fmt.Errorf("%w: certificate %s is not valid at %s", ErrInvalidCertificate, certificate.Subject, now.UTC().Format(time.RFC3339))
+		}
+		if i > 0 {
+			if err := chain[i-1].CheckSignatureFrom(certificate); err != nil {
+				return fmt.Errorf("%w: certificate %s is not issued by %s: %v", ErrInvalidCertificate, chain[i-1].Subject, certificate.Subject, err)
+			}
+		}
+	}
+
+	defer s.lock(id)()
+
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := device.CheckCertificate(chain[0]); err != nil {
+		return err
+	}
+
+	device.Certificate = chain[0].Raw
+	device.CertificateChain = nil
+	for _, issuer := range chain[1:] {
+		device.CertificateChain = append(device.CertificateChain, issuer.Raw)
+	}
+	if err := s.update(ctx, device); err != nil {
+		return err
+	}
+
+	details := map[string]string{"issuer": chain[0].Issuer.String(), "serial_number": chain[0].SerialNumber.Text(16)}
+	if _, err := s.auditLog.Record(ctx, actor, audit.ActionImportCertificate, device.ID, details); err != nil {
+		return fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return nil
+}
+
+// update stores device, which must have been read under its lock.
+func (s *DeviceService) update(ctx context.Context, device *domain.SignatureDevice) error {
+	if err := s.repository.Update(ctx, device); err != nil {
+		if errors.Is(err, persistence.ErrNotFound) {
+			return fmt.Errorf("%w: %s", ErrNotFound, device.ID)
+		}
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+	return nil
+}
+
+// ExportDevice returns the device with the given ID and an encrypted bundle
+// of it, including its signature counter and wrapped private key, for import
+// into another instance with ImportDevice. The device is suspended here: it
+// refuses to sign with ErrDeviceExported, since the chains would otherwise
+// diverge, until a newer bundle of it is imported here again. The export time
+// is later than that of the bundle the device was imported from, even if the
+// clocks of the instances disagree.
+func (s *DeviceService) ExportDevice(ctx context.Context, id string, actor string) (*domain.SignatureDevice, []byte, error) {
+	if s.migrationKey == nil {
+		return nil, nil, ErrNoMigrationKey
+	}
+
+	defer s.lock(id)()
+
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := s.now()
+	if !now.After(device.BundleExportedAt) {
+		now = device.BundleExportedAt.Add(time.Nanosecond)
+	}
+	sealed, err := bundle.Seal(s.migrationKey, device, now)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to export device: %w", err)
+	}
+	// The bundle is only returned once the device is suspended here.
+	device.ExportedAt = now
+	if err := s.update(ctx, device); err != nil {
+		return nil, nil, err
+	}
+
+	// The export entry also records the suspension, which has no action of its own.
+	details := map[string]string{"signature_counter": strconv.Itoa(device.SignatureCounter), "suspended": "true"}
+	if _, err := s.auditLog.Record(ctx, actor, audit.ActionExportDevice, device.ID, details); err != nil {
+		return nil, nil, fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return device, sealed, nil
+}
+
+// ImportDevice validates a bundle created by ExportDevice and stores its
+// device. An existing device with the same ID is replaced, unless it has a
+// higher signature counter, or the same counter with another last signature,
+// which would roll back or fork its signature chain. The bundle must also be
+// newer than the last export of the device here and than the last bundle
+// imported for it, so that neither the source nor the target can take up a
+// bundle again once the device moved on. The bundle does not carry
+// the transactions signed before the export, so the journal of the device
+// starts over at the bundle's counter, unless the device already exists with
+// the same counter and last signature.
+func (s *DeviceService) ImportDevice(ctx context.Context, sealed []byte, actor string) (*domain.SignatureDevice, error) {
+	if s.migrationKey == nil {
+		return nil, ErrNoMigrationKey
+	}
+	device, exportedAt, err := bundle.Open(s.migrationKey, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	defer s.lock(device.ID)()
+
+	// The device signs on this instance from now on.
+	device.ExportedAt = time.Time{}
+	device.BundleExportedAt = exportedAt
+
+	existing, err := s.repository.Get(ctx, device.ID)
+	if errors.Is(err, persistence.ErrNotFound) {
+		existing = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to retrieve device: %w", err)
+	}
+
+	replaceJournal := true
+	switch {
+	case existing != nil && !exportedAt.After(existing.ExportedAt):
+		return nil, fmt.Errorf("%w: %s was exported here at %s, the bundle at %s", ErrStaleBundle, device.ID,
+			existing.ExportedAt.UTC().Format(time.RFC3339Nano), exportedAt.UTC().Format(time.RFC3339Nano))
+	case existing != nil && !exportedAt.After(existing.BundleExportedAt):
+		return nil, fmt.Errorf("%w: %s was imported from a bundle of %s, the bundle is of %s", ErrStaleBundle, device.ID,
+			existing.BundleExportedAt.UTC().Format(time.RFC3339Nano), exportedAt.UTC().Format(time.RFC3339Nano))
+	case existing != nil && existing.SignatureCounter > device.SignatureCounter:
+		return nil, fmt.Errorf("%w: %s has counter %d, the bundle %d", ErrStaleBundle, device.ID, existing.SignatureCounter, device.SignatureCounter)
+	case existing != nil && existing.SignatureCounter == device.SignatureCounter && existing.LastSignature != device.LastSignature:
+		return nil, fmt.Errorf("%w: %s has another signature at counter %d", ErrStaleBundle, device.ID, device.SignatureCounter)
+	case existing != nil && existing.SignatureCounter == device.SignatureCounter:
+		// The device is already at the state of the bundle, for example when
+		// the bundle returns to its source, so its journal still matches.
+		device.JournalBase = existing.JournalBase
+		replaceJournal = false
+	case device.SignatureCounter > 0:
+		device.JournalBase = &domain.ChainBase{Counter: device.SignatureCounter, LastSignature: device.LastSignature}
+	default:
+		device.JournalBase = nil
+	}
+
+	if existing == nil {
+		err = s.create(ctx, device)
+	} else {
+		err = s.update(ctx, device)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if replaceJournal {
+		if err := s.transactions.Replace(ctx, device.ID, nil); err != nil {
+			// A device must not continue a journal that belongs to another state.
+			if existing == nil {
+				s.repository.Delete(ctx, device.ID)
+			} else {
+				s.repository.Update(ctx, existing)
+			}
+			return nil, fmt.Errorf("failed to replace transactions: %w", err)
+		}
+	}
+	if err := s.marks.Raise(ctx, device.ID, device.SignatureCounter); err != nil {
+		return nil, fmt.Errorf("failed to raise high-water mark: %w", err)
+	}
+
+	details := map[string]string{"signature_counter": strconv.Itoa(device.SignatureCounter), "replaced": strconv.FormatBool(existing != nil)}
+	if _, err := s.auditLog.Record(ctx, actor, audit.ActionImportDevice, device.ID, details); err != nil {
+		return nil, fmt.Errorf("failed to record audit entry: %w", err)
+	}
+	return device, nil
+}
+
+// DeviceSnapshot is a device with its transaction history, as captured for a backup.
+type DeviceSnapshot struct {
+	Device       *domain.SignatureDevice
+	Transactions []*domain.Transaction
+}
+
+// Snapshot returns every device with its transactions, ordered by device ID.
+// Each device is read under its lock, so that its signature counter always
+// matches its transaction history.
+func (s *DeviceService) Snapshot(ctx context.Context) ([]DeviceSnapshot, error) {
+	devices, err := s.ListDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+
+	snapshots := make([]DeviceSnapshot, 0, len(devices))
+	for _, device := range devices {
+		snapshot, err := s.snapshotDevice(ctx, device.ID)
+		if errors.Is(err, ErrNotFound) {
+			// The device was deleted after it was listed.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+func (s *DeviceService) snapshotDevice(ctx context.Context, id string) (DeviceSnapshot, error) {
+	defer s.lock(id)()
+
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return DeviceSnapshot{}, err
+	}
+	transactions, err := s.transactions.List(ctx, id)
+	if err != nil {
+		return DeviceSnapshot{}, fmt.Errorf("failed to retrieve transactions: %w", err)
+	}
+	return DeviceSnapshot{Device: device, Transactions: transactions}, nil
+}
+
+// Restore replaces all devices and their transactions with snapshots. Every
+// snapshot must form a valid signature chain before anything is stored, and
+// is verified again after it is stored. Devices that are not in snapshots are
+// deleted. The affected devices are locked for the whole restore, and if any
+// step fails, they go back to the state before it. High-water marks are kept,
+// so restored devices that are behind them refuse to sign. Devices also keep
+// their current export and import times, so that a restore neither resumes
+// signing on an exported device nor accepts a bundle imported before.
+func (s *DeviceService) Restore(ctx context.Context, snapshots []DeviceSnapshot, actor string) error {
+	restored := make(map[string]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		if err := domain.VerifyChain(snapshot.Device, snapshot.Transactions); err != nil {
+			return fmt.Errorf("device %s: %w", snapshot.Device.ID, err)
+		}
+		restored[snapshot.Device.ID] = true
+	}
+
+	devices, err := s.ListDevices(ctx)
+	if err != nil {
+		return err
+	}
+	var removed []string
+	ids := make([]string, 0, len(restored)+len(devices))
+	for id := range restored {
+		ids = append(ids, id)
+	}
+	for _, device := range devices {
+		if !restored[device.ID] {
+			removed = append(removed, device.ID)
+			ids = append(ids, device.ID)
+		}
+	}
+	// Locks are taken in ID order, so that concurrent restores cannot deadlock.
+	sort.Strings(ids)
+	for _, id := range ids {
+		defer s.lock(id)()
+	}
+
+	previous, err := s.capture(ctx, ids)
+	if err != nil {
+		return err
+	}
+	if err := s.applyRestore(ctx, snapshots, removed, previous); err != nil {
+		s.rollbackRestore(ctx, previous)
+		return err
+	}
+
+	for _, snapshot := range snapshots {
+		details := map[string]string{"signature_counter": strconv.Itoa(snapshot.Device.SignatureCounter)}
+		if mark := previous[snapshot.Device.ID].mark; mark > snapshot.Device.SignatureCounter {
+			// The device refuses to sign until the rollback is overridden.
+			details["high_water_mark"] = strconv.Itoa(mark)
+		}
+		if _, err := s.auditLog.Record(ctx, actor, audit.ActionRestoreDevice, snapshot.Device.ID, details); err != nil {
+			return fmt.Errorf("failed to record audit entry: %w", err)
+		}
+	}
+	for _, id := range removed {
+		details := map[string]string{"reason": "not in the restored backup"}
+		if _, err := s.auditLog.Record(ctx, actor, audit.ActionDeleteDevice, id, details); err != nil {
+			return fmt.Errorf("failed to record audit entry: %w", err)
+		}
+	}
+	return nil
+}
+
+// restorePoint is the state of a device before a restore. device is nil for
+// devices that did not exist.
+type restorePoint struct {
+	device       *domain.SignatureDevice
+	transactions []*domain.Transaction
+	mark         int
+}
+
+// capture reads the state of the devices with the given IDs, which the caller
+// has locked.
+func (s *DeviceService) capture(ctx context.Context, ids []string) (map[string]restorePoint, error) {
+	points := make(map[string]restorePoint, len(ids))
+	for _, id := range ids {
+		var point restorePoint
+		device, err := s.repository.Get(ctx, id)
+		if err != nil && !errors.Is(err, persistence.ErrNotFound) {
+			return nil, fmt.Errorf("failed to retrieve device: %w", err)
+		}
+		if err == nil {
+			point.device = device
+			point.transactions, err = s.transactions.List(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to retrieve transactions: %w", err)
+			}
+		}
+		point.mark, err = s.marks.Get(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read high-water mark: %w", err)
+		}
+		points[id] = point
+	}
+	return points, nil
+}
+
+// applyRestore stores the devices and transactions of snapshots with the
+// export and import times of the current devices, verifies the stored chains
+// and deletes the removed devices. The caller holds their locks.
+func (s *DeviceService) applyRestore(ctx context.Context, snapshots []DeviceSnapshot, removed []string, current map[string]restorePoint) error {
+	for _, snapshot := range snapshots {
+		device := snapshot.Device
+		if existing := current[device.ID].device; existing != nil {
+			device = device.Clone()
+			device.ExportedAt = existing.ExportedAt
+			device.BundleExportedAt = existing.BundleExportedAt
+		}
+		if err := s.storeDevice(ctx, device, snapshot.Transactions); err != nil {
+			return fmt.Errorf("device %s: %w", snapshot.Device.ID, err)
+		}
+		if err := s.marks.Raise(ctx, snapshot.Device.ID, snapshot.Device.SignatureCounter); err != nil {
+			return fmt.Errorf("device %s: failed to raise high-water mark: %w", snapshot.Device.ID, err)
+		}
+		if err := s.verifyStored(ctx, snapshot.Device.ID); err != nil {
+			return fmt.Errorf("device %s: %w", snapshot.Device.ID, err)
+		}
+	}
+	for _, id := range removed {
+		if err := s.removeDevice(ctx, id); err != nil {
+			return fmt.Errorf("device %s: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// rollbackRestore puts the devices back to the state captured before a
+// failed restore, on a best-effort basis.
+func (s *DeviceService) rollbackRestore(ctx context.Context, points map[string]restorePoint) {
+	for id, point := range points {
+		if point.device == nil {
+			s.removeDevice(ctx, id)
+		} else {
+			s.storeDevice(ctx, point.device, point.transactions)
+		}
+		s.marks.Set(ctx, id, point.mark)
+	}
+}
+
+// storeDevice creates or replaces a device and its transactions.
+func (s *DeviceService) storeDevice(ctx context.Context, device *domain.SignatureDevice, transactions []*domain.Transaction) error {
+	err := s.repository.Update(ctx, device)
+	if errors.Is(err, persistence.ErrNotFound) {
+		err = s.repository.Create(ctx, device)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store device: %w", err)
+	}
+	if err := s.transactions.Replace(ctx, device.ID, transactions); err != nil {
+		return fmt.Errorf("failed to store transactions: %w", err)
+	}
+	return nil
+}
+
+// verifyStored verifies the chain of a device as it is stored.
+func (s *DeviceService) verifyStored(ctx context.Context, id string) error {
+	device, err := s.GetDevice(ctx, id)
+	if err != nil {
+		return err
+	}
+	transactions, err := s.transactions.List(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve transactions: %w", err)
+	}
+	return domain.VerifyChain(device, transactions)
+}
+
+// removeDevice deletes a device and its transactions.
+func (s *DeviceService) removeDevice(ctx context.Context, id string) error {
+	if err := s.repository.Delete(ctx, id); err != nil && !errors.Is(err, persistence.ErrNotFound) {
+		return fmt.Errorf("failed to delete device: %w", err)
+	}
+	if err := s.transactions.Replace(ctx, id, nil); err != nil {
+		return fmt.Errorf("failed to delete transactions: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,757 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/audit"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/bundle"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/timestamp"
+	"github.com/google/uuid"
+)
+
+// failingRepository simulates a storage backend that is unavailable.
+type failingRepository struct {
+	persistence.DeviceRepository
+}
+
+var errBackend = errors.New("connection refused")
+
+func (failingRepository) Get(ctx context.Context, id string) (*domain.SignatureDevice, error) {
+	return nil, errBackend
+}
+
+func TestCreateDevice(t *testing.T) {
+	key, err := audit.LoadServiceKey("")
+	if err != nil {
+		t.Fatalf("Failed to generate service key: %v", err)
+	}
+	auditLog, err := audit.NewLog(audit.NewMemoryStore(), key, 1)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	devices := NewDeviceService(persistence.NewInMemoryDeviceRepository(), WithAuditLog(auditLog))
+	devices.ApplySettings(Settings{DefaultAlgorithm: "ECC", MaxDevices: 2})
+	ctx := context.Background()
+
+	device, err := devices.CreateDevice(ctx, CreateDeviceParams{Label: "Default", Actor: "operator"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := uuid.Parse(device.ID); err != nil {
+		t.Errorf("Expected a generated UUID, got %q", device.ID)
+	}
+	if device.Algorithm != domain.ECC {
+		t.Errorf("Expected default algorithm ECC, got %s", device.Algorithm)
+	}
+
+	export, err := auditLog.Export(ctx)
+	if err != nil {
+		t.Fatalf("Failed to export audit log: %v", err)
+	}
+	if len(export.Entries) != 1 || export.Entries[0].Actor != "operator" || export.Entries[0].DeviceID != device.ID {
+		t.Errorf("Expected one audit entry by operator for %s, got %+v", device.ID, export.Entries)
+	}
+
+	tests := []struct {
+		name   string
+		params CreateDeviceParams
+		err    error
+	}{
+		{"invalid algorithm", CreateDeviceParams{Algorithm: "DSA"}, ErrInvalidAlgorithm},
+		{"invalid ID", CreateDeviceParams{ID: "not-a-uuid"}, ErrInvalidID},
+		{"duplicate ID", CreateDeviceParams{ID: device.ID, Algorithm: "rsa"}, ErrConflict},
+	}
+	for _, test := range tests {
+		if _, err := devices.CreateDevice(ctx, test.params); !errors.Is(err, test.err) {
+			t.Errorf("%s: got error %v want %v", test.name, err, test.err)
+		}
+	}
+
+	if _, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "rsa"}); err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := devices.CreateDevice(ctx, CreateDeviceParams{}); !errors.Is(err, ErrDeviceLimitReached) {
+		t.Errorf("Expected ErrDeviceLimitReached, got %v", err)
+	}
+}
+
+func TestCreateDeviceLimitConcurrently(t *testing.T) {
+	repo := persistence.NewInMemoryDeviceRepository()
+	devices := NewDeviceService(repo)
+	devices.ApplySettings(Settings{DefaultAlgorithm: "ECC", MaxDevices: 3})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			devices.CreateDevice(ctx, CreateDeviceParams{})
+		}()
+	}
+	wg.Wait()
+
+	if stored, _ := repo.List(ctx); len(stored) != 3 {
+		t.Errorf("Expected exactly 3 devices, got %d", len(stored))
+	}
+}
+
+func TestGetDeviceErrors(t *testing.T) {
+	ctx := context.Background()
+
+	devices := NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	if _, err := devices.GetDevice(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	devices = NewDeviceService(failingRepository{})
+	_, err := devices.GetDevice(ctx, "any")
+	if errors.Is(err, ErrNotFound) || !errors.Is(err, errBackend) {
+		t.Errorf("Expected the backend error and not ErrNotFound, got %v", err)
+	}
+	if _, err := devices.SignTransaction(ctx, "any", "data"); errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected signing to report the backend error, got %v", err)
+	}
+}
+
+func TestSignTransactionConcurrently(t *testing.T) {
+	devices := NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	ctx := context.Background()
+
+	device, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+
+	const signatures = 20
+	var wg sync.WaitGroup
+	for i := 0; i < signatures; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := devices.SignTransaction(ctx, device.ID, "data"); err != nil {
+				t.Errorf("SignTransaction failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	transactions, err := devices.ListTransactions(ctx, device.ID)
+	if err != nil {
+		t.Fatalf("ListTransactions failed: %v", err)
+	}
+	if len(transactions) != signatures {
+		t.Fatalf("Expected %d transactions, got %d", signatures, len(transactions))
+	}
+	for i, transaction := range transactions {
+		if transaction.Counter != i {
+			t.Errorf("Expected transaction %d to have counter %d, got %d", i, i, transaction.Counter)
+		}
+		if i > 0 {
+			_, _, lastSignature, err := domain.ParseSecuredData(transaction.SignedData)
+			if err != nil || lastSignature != transactions[i-1].Signature {
+				t.Errorf("Transaction %d is not chained to its predecessor", i)
+			}
+		}
+	}
+
+	stored, err := devices.GetDevice(ctx, device.ID)
+	if err != nil {
+		t.Fatalf("GetDevice failed: %v", err)
+	}
+	if stored.SignatureCounter != signatures {
+		t.Errorf("Expected signature counter %d, got %d", signatures, stored.SignatureCounter)
+	}
+
+	for i := 0; i < 10; i++ {
+		devices.SignTransaction(ctx, uuid.New().String(), "data")
+	}
+	if len(devices.locks) != 0 {
+		t.Errorf("Expected the device locks to be released, %d remain", len(devices.locks))
+	}
+
+	if _, err := devices.ListTransactions(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown device, got %v", err)
+	}
+}
+
+func TestSignTransactionSigningTime(t *testing.T) {
+	ctx := context.Background()
+	devices := NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	devices.now = func() time.Time { return clock }
+
+	device, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	var signedAt []time.Time
+	for _, step := range []time.Duration{0, time.Second, -time.Hour, time.Minute} {
+		clock = clock.Add(step)
+		transaction, err := devices.SignTransaction(ctx, device.ID, "data")
+		if err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+		decoded, err := domain.DecodeSecuredData(transaction.SignedData)
+		if err != nil || !decoded.SignedAt.Equal(transaction.SignedAt) {
+			t.Errorf("Expected the signed data to include the signing time %v, got %q", transaction.SignedAt, transaction.SignedData)
+		}
+		signedAt = append(signedAt, transaction.SignedAt)
+	}
+
+	expected := []time.Time{
+		time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		time.Date(2024, 3, 1, 12, 0, 1, 0, time.UTC),
+		time.Date(2024, 3, 1, 12, 0, 1, 0, time.UTC), // the clock was set back
+		time.Date(2024, 3, 1, 12, 0, 1, 0, time.UTC),
+	}
+	for i := range expected {
+		if !signedAt[i].Equal(expected[i]) {
+			t.Errorf("Expected signature %d to be signed at %v, got %v", i, expected[i], signedAt[i])
+		}
+	}
+}
+
+// timestamperFunc adapts a function to timestamp.Timestamper.
+type timestamperFunc func(ctx context.Context, digest []byte) ([]byte, error)
+
+func (f timestamperFunc) Timestamp(ctx context.Context, digest []byte) ([]byte, error) {
+	return f(ctx, digest)
+}
+
+func TestSignTransactionTimestamp(t *testing.T) {
+	ctx := context.Background()
+	authority, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	local, err := timestamp.NewLocal(authority)
+	if err != nil {
+		t.Fatalf("Failed to create local TSA: %v", err)
+	}
+	available := true
+	devices := NewDeviceService(persistence.NewInMemoryDeviceRepository(), WithTimestamper(timestamperFunc(
+		func(ctx context.Context, digest []byte) ([]byte, error) {
+			if !available {
+				return nil, errBackend
+			}
+			return local.Timestamp(ctx, digest)
+		},
+	)))
+
+	device, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	transaction, err := devices.SignTransaction(ctx, device.ID, "data")
+	if err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	token, err := timestamp.Parse(transaction.TimestampToken)
+	if err != nil {
+		t.Fatalf("Failed to parse timestamp token: %v", err)
+	}
+	signature, _ := base64.StdEncoding.DecodeString(transaction.Signature)
+	digest := sha256.Sum256(signature)
+	if err := token.Verify(digest[:], nil); err != nil {
+		t.Errorf("Expected the token to timestamp the signature: %v", err)
+	}
+
+	available = false
+	if _, err := devices.SignTransaction(ctx, device.ID, "data"); !errors.Is(err, ErrTimestampUnavailable) {
+		t.Errorf("Expected ErrTimestampUnavailable, got %v", err)
+	}
+	stored, err := devices.GetDevice(ctx, device.ID)
+	if err != nil {
+		t.Fatalf("GetDevice failed: %v", err)
+	}
+	if stored.SignatureCounter != 1 {
+		t.Errorf("Expected a failed timestamp not to consume the counter, got %d", stored.SignatureCounter)
+	}
+}
+
+// failingMarks and failingTransactions simulate stores that refuse writes.
+type failingMarks struct {
+	persistence.HighWaterMarkRepository
+}
+
+func (failingMarks) Raise(ctx context.Context, deviceID string, counter int) error {
+	return errBackend
+}
+
+type failingTransactions struct {
+	persistence.TransactionRepository
+}
+
+func (failingTransactions) Append(ctx context.Context, transaction *domain.Transaction) error {
+	return errBackend
+}
+
+func TestSignTransactionRollsBack(t *testing.T) {
+	ctx := context.Background()
+	repository := persistence.NewInMemoryDeviceRepository()
+	marks := persistence.NewInMemoryHighWaterMarkRepository()
+	transactions := persistence.NewInMemoryTransactionRepository()
+	devices := NewDeviceService(repository, WithHighWaterMarkRepository(marks), WithTransactionRepository(transactions))
+
+	device, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := devices.SignTransaction(ctx, device.ID, "first"); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+
+	for name, failing := range map[string]*DeviceService{
+		"mark":        NewDeviceService(repository, WithHighWaterMarkRepository(failingMarks{marks}), WithTransactionRepository(transactions)),
+		"transaction": NewDeviceService(repository, WithHighWaterMarkRepository(marks), WithTransactionRepository(failingTransactions{transactions})),
+	} {
+		if _, err := failing.SignTransaction(ctx, device.ID, "lost"); !errors.Is(err, errBackend) {
+			t.Fatalf("%s: expected the store error, got %v", name, err)
+		}
+		status, err := devices.CounterStatus(ctx, device.ID)
+		if err != nil || status.SignatureCounter != 1 || status.HighWaterMark != 1 {
+			t.Errorf("%s: expected the device and its mark back at counter 1, got %+v, %v", name, status, err)
+		}
+	}
+
+	// The journal continues without a gap.
+	if _, err := devices.SignTransaction(ctx, device.ID, "second"); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	current, _ := devices.GetDevice(ctx, device.ID)
+	history, _ := devices.ListTransactions(ctx, device.ID)
+	if err := domain.VerifyChain(current, history); err != nil {
+		t.Errorf("Expected an intact chain, got %v", err)
+	}
+}
+
+func TestCertificateChain(t *testing.T) {
+	ctx := context.Background()
+	repository := persistence.NewInMemoryDeviceRepository()
+
+	uncertified, err := NewDeviceService(repository).CreateDevice(ctx, CreateDeviceParams{Algorithm: "RSA"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := NewDeviceService(repository).CertificateChain(ctx, uncertified.ID); !errors.Is(err, ErrNoCertificateAuthority) {
+		t.Errorf("Expected ErrNoCertificateAuthority without a CA, got %v", err)
+	}
+
+	authority, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	devices := NewDeviceService(repository, WithCertificateAuthority(authority))
+	device, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC", Label: "Till"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if device.Certificate == nil {
+		t.Fatalf("Expected a certificate to be issued at creation")
+	}
+
+	chain, err := devices.CertificateChain(ctx, device.ID)
+	if err != nil {
+		t.Fatalf("CertificateChain failed: %v", err)
+	}
+	if len(chain) != 2 || !bytes.Equal(chain[0].Raw, device.Certificate) || !chain[1].Equal(authority.Certificate()) {
+		t.Fatalf("Expected the issued certificate followed by the CA certificate")
+	}
+
+	// Replace the key, as a key rotation would.
+	rotated, err := domain.NewSignatureDevice(device.ID, domain.ECC, device.Label)
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	rotated.KeyVersion = 2
+	rotated.Certificate = device.Certificate
+	if err := repository.Update(ctx, rotated); err != nil {
+		t.Fatalf("Failed to update device: %v", err)
+	}
+
+	chain, err = devices.CertificateChain(ctx, device.ID)
+	if err != nil {
+		t.Fatalf("CertificateChain failed: %v", err)
+	}
+	if bytes.Equal(chain[0].Raw, device.Certificate) || !ca.Certifies(chain[0].Raw, rotated) {
+		t.Errorf("Expected a certificate for the rotated key to be issued")
+	}
+	if stored, _ := devices.GetDevice(ctx, device.ID); !bytes.Equal(stored.Certificate, chain[0].Raw) {
+		t.Errorf("Expected the re-issued certificate to be stored")
+	}
+
+	// Devices created before the CA was configured are certified on request.
+	if chain, err := devices.CertificateChain(ctx, uncertified.ID); err != nil || !ca.Certifies(chain[0].Raw, uncertified) {
+		t.Errorf("Expected a certificate for a device created without a CA, got %v", err)
+	}
+
+	if _, err := devices.CertificateChain(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown device, got %v", err)
+	}
+}
+
+func TestImportCertificate(t *testing.T) {
+	ctx := context.Background()
+	key, err := audit.LoadServiceKey("")
+	if err != nil {
+		t.Fatalf("Failed to generate service key: %v", err)
+	}
+	auditLog, err := audit.NewLog(audit.NewMemoryStore(), key, 1)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	devices := NewDeviceService(persistence.NewInMemoryDeviceRepository(), WithAuditLog(auditLog))
+	device, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+
+	external, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	der, err := external.Issue(device)
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	chain := []*x509.Certificate{certificate, external.Certificate()}
+
+	devices.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if err := devices.ImportCertificate(ctx, device.ID, chain, "operator"); !errors.Is(err, ErrInvalidCertificate) {
+		t.Errorf("Expected ErrInvalidCertificate for an expired certificate, got %v", err)
+	}
+	devices.now = time.Now
+
+	if err := devices.ImportCertificate(ctx, device.ID, chain, "operator"); err != nil {
+		t.Fatalf("ImportCertificate failed: %v", err)
+	}
+	export, err := auditLog.Export(ctx)
+	if err != nil {
+		t.Fatalf("Failed to export audit log: %v", err)
+	}
+	last := export.Entries[len(export.Entries)-1]
+	if last.Action != audit.ActionImportCertificate || last.Actor != "operator" || last.Details["serial_number"] != certificate.SerialNumber.Text(16) {
+		t.Errorf("Unexpected audit entry %+v", last)
+	}
+}
+
+func TestExportImportDevice(t *testing.T) {
+	ctx := context.Background()
+	key, err := bundle.NewKey(bytes.Repeat([]byte("m"), bundle.MinSecretSize))
+	if err != nil {
+		t.Fatalf("Failed to create migration key: %v", err)
+	}
+	source := NewDeviceService(persistence.NewInMemoryDeviceRepository(), WithMigrationKey(key))
+	target := NewDeviceService(persistence.NewInMemoryDeviceRepository(), WithMigrationKey(key))
+
+	device, err := source.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC", Label: "Till 1"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := source.SignTransaction(ctx, device.ID, "first"); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	_, old, err := source.ExportDevice(ctx, device.ID, "operator")
+	if err != nil {
+		t.Fatalf("ExportDevice failed: %v", err)
+	}
+	// The exported device is suspended on the source, so that its chain cannot fork.
+	if _, err := source.SignTransaction(ctx, device.ID, "second"); !errors.Is(err, ErrDeviceExported) {
+		t.Errorf("Expected ErrDeviceExported after the export, got %v", err)
+	}
+	// The source cannot take its own bundle back, since the target may have imported it.
+	if _, err := source.ImportDevice(ctx, old, "operator"); !errors.Is(err, ErrStaleBundle) {
+		t.Errorf("Expected ErrStaleBundle for the own bundle, got %v", err)
+	}
+
+	imported, err := target.ImportDevice(ctx, old, "operator")
+	if err != nil {
+		t.Fatalf("ImportDevice failed: %v", err)
+	}
+	if imported.ID != device.ID || imported.Label != "Till 1" || imported.SignatureCounter != 1 {
+		t.Errorf("Unexpected imported device %+v", imported)
+	}
+	last, err := target.SignTransaction(ctx, device.ID, "second")
+	if err != nil {
+		t.Fatalf("SignTransaction after import failed: %v", err)
+	}
+	if counter, _, previous, _ := domain.ParseSecuredData(last.SignedData); counter != 1 || previous == "" {
+		t.Errorf("Expected the chain to continue after import, got %q", last.SignedData)
+	}
+	// A bundle is imported only once.
+	if _, err := target.ImportDevice(ctx, old, "operator"); !errors.Is(err, ErrStaleBundle) {
+		t.Errorf("Expected ErrStaleBundle for a bundle imported before, got %v", err)
+	}
+
+	otherKey, _ := bundle.NewKey(bytes.Repeat([]byte("x"), bundle.MinSecretSize))
+	other := NewDeviceService(persistence.NewInMemoryDeviceRepository(), WithMigrationKey(otherKey))
+	if _, err := other.ImportDevice(ctx, old, "operator"); !errors.Is(err, ErrInvalidBundle) {
+		t.Errorf("Expected ErrInvalidBundle for another migration key, got %v", err)
+	}
+	disabled := NewDeviceService(persistence.NewInMemoryDeviceRepository())
+	if _, _, err := disabled.ExportDevice(ctx, device.ID, "operator"); !errors.Is(err, ErrNoMigrationKey) {
+		t.Errorf("Expected ErrNoMigrationKey, got %v", err)
+	}
+
+	// Back on the source with a higher counter, the journal of the device
+	// starts over at the bundle's counter instead of keeping the old entries.
+	_, back, err := target.ExportDevice(ctx, device.ID, "operator")
+	if err != nil {
+		t.Fatalf("ExportDevice failed: %v", err)
+	}
+	if _, err := target.SignTransaction(ctx, device.ID, "third"); !errors.Is(err, ErrDeviceExported) {
+		t.Errorf("Expected ErrDeviceExported after the export, got %v", err)
+	}
+	returned, err := source.ImportDevice(ctx, back, "operator")
+	if err != nil {
+		t.Fatalf("ImportDevice failed: %v", err)
+	}
+	if base := returned.ChainBase(); base.Counter != 2 || base.LastSignature != last.Signature {
+		t.Errorf("Expected the journal to start at the bundle's counter, got %+v", base)
+	}
+	if transactions, err := source.ListTransactions(ctx, device.ID); err != nil || len(transactions) != 0 {
+		t.Errorf("Expected the old journal to be replaced, got %d transactions, %v", len(transactions), err)
+	}
+	transaction, err := source.SignTransaction(ctx, device.ID, "third")
+	if err != nil {
+		t.Fatalf("SignTransaction after import failed: %v", err)
+	}
+	if counter, _, previous, _ := domain.ParseSecuredData(transaction.SignedData); counter != 2 || previous != last.Signature {
+		t.Errorf("Expected the chain to continue after import, got %q", transaction.SignedData)
+	}
+	if _, err := source.ImportDevice(ctx, back, "operator"); !errors.Is(err, ErrStaleBundle) {
+		t.Errorf("Expected ErrStaleBundle for a bundle imported before, got %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx := context.Background()
+	devices := NewDeviceService(persistence.NewInMemoryDeviceRepository())
+
+	kept, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC", Label: "Till 1"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := devices.SignTransaction(ctx, kept.ID, "first"); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	snapshots, err := devices.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	if len(snapshots) != 1 || snapshots[0].Device.SignatureCounter != 1 || len(snapshots[0].Transactions) != 1 {
+		t.Fatalf("Unexpected snapshots %+v", snapshots)
+	}
+
+	// Changes after the snapshot are undone by the restore.
+	if _, err := devices.SignTransaction(ctx, kept.ID, "second"); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	added, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "RSA"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if err := devices.Restore(ctx, snapshots, "operator"); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	restored, err := devices.GetDevice(ctx, kept.ID)
+	if err != nil || restored.SignatureCounter != 1 {
+		t.Errorf("Expected the device at counter 1, got %+v, %v", restored, err)
+	}
+	if transactions, _ := devices.ListTransactions(ctx, kept.ID); len(transactions) != 1 {
+		t.Errorf("Expected one restored transaction, got %d", len(transactions))
+	}
+	if _, err := devices.GetDevice(ctx, added.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected the device created after the snapshot to be deleted, got %v", err)
+	}
+
+	broken := []DeviceSnapshot{{Device: snapshots[0].Device, Transactions: nil}}
+	if err := devices.Restore(ctx, broken, "operator"); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("Expected ErrBrokenChain for a snapshot without its history, got %v", err)
+	}
+}
+
+// replaceFailingTransactions refuses to store the journal of one device.
+type replaceFailingTransactions struct {
+	persistence.TransactionRepository
+	deviceID string
+}
+
+func (r replaceFailingTransactions) Replace(ctx context.Context, deviceID string, transactions []*domain.Transaction) error {
+	if deviceID == r.deviceID && len(transactions) > 0 {
+		return errBackend
+	}
+	return r.TransactionRepository.Replace(ctx, deviceID, transactions)
+}
+
+func TestRestoreKeepsExportState(t *testing.T) {
+	ctx := context.Background()
+	key, err := bundle.NewKey(bytes.Repeat([]byte("m"), bundle.MinSecretSize))
+	if err != nil {
+		t.Fatalf("Failed to create migration key: %v", err)
+	}
+	devices := NewDeviceService(persistence.NewInMemoryDeviceRepository(), WithMigrationKey(key))
+
+	device, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	snapshots, err := devices.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	exported, sealed, err := devices.ExportDevice(ctx, device.ID, "operator")
+	if err != nil {
+		t.Fatalf("ExportDevice failed: %v", err)
+	}
+
+	// The snapshot predates the export, but the device stays suspended.
+	if err := devices.Restore(ctx, snapshots, "operator"); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	restored, err := devices.GetDevice(ctx, device.ID)
+	if err != nil {
+		t.Fatalf("GetDevice failed: %v", err)
+	}
+	if !restored.ExportedAt.Equal(exported.ExportedAt) {
+		t.Errorf("Expected the export time %s to be kept, got %s", exported.ExportedAt, restored.ExportedAt)
+	}
+	if _, err := devices.SignTransaction(ctx, device.ID, "fork"); !errors.Is(err, ErrDeviceExported) {
+		t.Errorf("Expected ErrDeviceExported after the restore, got %v", err)
+	}
+	if _, err := devices.ImportDevice(ctx, sealed, "operator"); !errors.Is(err, ErrStaleBundle) {
+		t.Errorf("Expected ErrStaleBundle for the own bundle after the restore, got %v", err)
+	}
+	if !snapshots[0].Device.ExportedAt.IsZero() {
+		t.Errorf("Expected the snapshot not to be modified, got %+v", snapshots[0].Device)
+	}
+}
+
+func TestRestoreRollsBack(t *testing.T) {
+	ctx := context.Background()
+	repository := persistence.NewInMemoryDeviceRepository()
+	transactions := persistence.NewInMemoryTransactionRepository()
+	devices := NewDeviceService(repository, WithTransactionRepository(transactions))
+
+	var ids []string
+	for i := 0; i < 2; i++ {
+		device, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC"})
+		if err != nil {
+			t.Fatalf("CreateDevice failed: %v", err)
+		}
+		if _, err := devices.SignTransaction(ctx, device.ID, "first"); err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+		ids = append(ids, device.ID)
+	}
+	snapshots, err := devices.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	for _, id := range ids {
+		if _, err := devices.SignTransaction(ctx, id, "second"); err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+	}
+	added, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+
+	// The journal of the last snapshot cannot be stored, after the first one was.
+	last := snapshots[len(snapshots)-1].Device.ID
+	failing := NewDeviceService(repository, WithTransactionRepository(replaceFailingTransactions{transactions, last}))
+	if err := failing.Restore(ctx, snapshots, "operator"); !errors.Is(err, errBackend) {
+		t.Fatalf("Expected the store error, got %v", err)
+	}
+	for _, id := range ids {
+		device, err := devices.GetDevice(ctx, id)
+		if err != nil || device.SignatureCounter != 2 {
+			t.Errorf("Expected %s back at counter 2, got %+v, %v", id, device, err)
+			continue
+		}
+		history, _ := devices.ListTransactions(ctx, id)
+		if err := domain.VerifyChain(device, history); err != nil {
+			t.Errorf("Expected the journal of %s back, got %v", id, err)
+		}
+	}
+	if _, err := devices.GetDevice(ctx, added.ID); err != nil {
+		t.Errorf("Expected the device created after the snapshot to be kept, got %v", err)
+	}
+}
+
+func TestHighWaterMark(t *testing.T) {
+	ctx := context.Background()
+	key, err := audit.LoadServiceKey("")
+	if err != nil {
+		t.Fatalf("Failed to generate service key: %v", err)
+	}
+	auditLog, err := audit.NewLog(audit.NewMemoryStore(), key, 1)
+	if err != nil {
+		t.Fatalf("Failed to create audit log: %v", err)
+	}
+	repository := persistence.NewInMemoryDeviceRepository()
+	marks := persistence.NewInMemoryHighWaterMarkRepository()
+	devices := NewDeviceService(repository, WithHighWaterMarkRepository(marks), WithAuditLog(auditLog))
+
+	device, err := devices.CreateDevice(ctx, CreateDeviceParams{Algorithm: "ECC"})
+	if err != nil {
+		t.Fatalf("CreateDevice failed: %v", err)
+	}
+	if _, err := devices.SignTransaction(ctx, device.ID, "first"); err != nil {
+		t.Fatalf("SignTransaction failed: %v", err)
+	}
+	backup, _ := repository.Get(ctx, device.ID)
+	for _, data := range []string{"second", "third"} {
+		if _, err := devices.SignTransaction(ctx, device.ID, data); err != nil {
+			t.Fatalf("SignTransaction failed: %v", err)
+		}
+	}
+
+	// A device store restored from a backup, with the marks kept apart.
+	if err := repository.Update(ctx, backup); err != nil {
+		t.Fatalf("Failed to roll back the device: %v", err)
+	}
+	restarted := NewDeviceService(repository, WithHighWaterMarkRepository(marks), WithAuditLog(auditLog))
+	if _, err := restarted.SignTransaction(ctx, device.ID, "reissued"); !errors.Is(err, ErrCounterRollback) {
+		t.Fatalf("Expected ErrCounterRollback, got %v", err)
+	}
+	status, err := restarted.CounterStatus(ctx, device.ID)
+	if err != nil || status.SignatureCounter != 1 || status.HighWaterMark != 3 || !status.RolledBack() {
+		t.Errorf("Unexpected counter status %+v, %v", status, err)
+	}
+
+	if _, err := restarted.OverrideHighWaterMark(ctx, device.ID, "operator", " "); !errors.Is(err, ErrMissingReason) {
+		t.Errorf("Expected ErrMissingReason, got %v", err)
+	}
+	status, err = restarted.OverrideHighWaterMark(ctx, device.ID, "operator", "receipts 2 and 3 were voided")
+	if err != nil || status.RolledBack() || status.HighWaterMark != 1 {
+		t.Fatalf("Unexpected override result %+v, %v", status, err)
+	}
+	if _, err := restarted.SignTransaction(ctx, device.ID, "after override"); err != nil {
+		t.Errorf("SignTransaction after the override failed: %v", err)
+	}
+
+	export, err := auditLog.Export(ctx)
+	if err != nil {
+		t.Fatalf("Failed to export audit log: %v", err)
+	}
+	last := export.Entries[len(export.Entries)-1]
+	if last.Action != audit.ActionOverrideHighWaterMark || last.Actor != "operator" || last.Details["high_water_mark"] != "3" ||
+		last.Details["reason"] != "receipts 2 and 3 were voided" {
+		t.Errorf("Unexpected audit entry %+v", last)
+	}
+}
@@ -0,0 +1,82 @@
+package timestamp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// maxReplyBytes limits the size of time-stamp responses read by Client.
+const maxReplyBytes = 1 << 20
+
+// Client obtains tokens from a remote TSA with the HTTP transport of RFC 3161.
+// Every request carries a random nonce and asks for the TSA certificate, and
+// every token is verified before it is returned.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient returns a Client for the TSA at url. Requests time out after timeout.
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{url: url, httpClient: &http.Client{Timeout: timeout}}
+}
+
+// Timestamp requests a token for digest from the TSA.
+func (c *Client) Timestamp(ctx context.Context, digest []byte) ([]byte, error) {
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	query, err := asn1.Marshal(timeStampReq{
+		Version:        1,
+		MessageImprint: messageImprint{HashAlgorithm: sha256Algorithm, HashedMessage: digest},
+		Nonce:          nonce,
+		CertReq:        true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode time-stamp request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create time-stamp request: %w", err)
+	}
+	req.Header.Set("Content-Type", QueryContentType)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach TSA: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("TSA responded with status %d", resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxReplyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TSA response: %w", err)
+	}
+
+	var reply timeStampResp
+	if rest, err := asn1.Unmarshal(content, &reply); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("malformed TSA response")
+	}
+	if status := reply.Status.Status; status != statusGranted && status != statusGrantedWithMods {
+		return nil, fmt.Errorf("TSA rejected the request with status %d: %s", status, reply.Status.text())
+	}
+
+	token, err := Parse(reply.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	if err := token.Verify(digest, nonce); err != nil {
+		return nil, err
+	}
+	return reply.TimeStampToken.FullBytes, nil
+}
@@ -0,0 +1,215 @@
+package timestamp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+)
+
+// LocalPolicy is the TSA policy of tokens issued by Local. Like
+// ca.DeviceIDExtension it lies under the enterprise number that RFC 5612
+// reserves for documentation.
+var LocalPolicy = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 32473, 2, 1}
+
+// maxQueryBytes limits the size of time-stamp requests served by Local.
+const maxQueryBytes = 64 << 10
+
+// Local is a time-stamping authority that runs inside the service, for tests
+// and air-gapped setups without access to a public TSA. It signs with a P-384
+// key generated on start, certified by the internal CA.
+type Local struct {
+	key         *ecdsa.PrivateKey
+	certificate *x509.Certificate
+	chain       []*x509.Certificate
+	now         func() time.Time
+}
+
+// NewLocal returns a Local TSA whose certificate is issued by authority.
+func NewLocal(authority *ca.Authority) (*Local, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate TSA key: %w", err)
+	}
+	der, err := authority.IssueTimestamping(key.Public(), "Signing Service Local TSA")
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse TSA certificate: %w", err)
+	}
+	return &Local{
+		key:         key,
+		certificate: certificate,
+		chain:       []*x509.Certificate{authority.Certificate()},
+		now:         time.Now,
+	}, nil
+}
+
+// Certificate returns the certificate that signs the tokens of the TSA.
+func (l *Local) Certificate() *x509.Certificate {
+	return l.certificate
+}
+
+// Timestamp returns a token for digest that includes the TSA certificate chain.
+func (l *Local) Timestamp(ctx context.Context, digest []byte) ([]byte, error) {
+	return l.issue(digest, nil, true)
+}
+
+// ServeHTTP answers RFC 3161 time-stamp queries sent with the HTTP transport
+// of RFC 3161, section 3.4, so that Local can also serve other systems.
+func (l *Local) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	content, err := io.ReadAll(io.LimitReader(r.Body, maxQueryBytes))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	response := l.respond(content)
+	encoded, err := asn1.Marshal(response)
+	if err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ReplyContentType)
+	w.Write(encoded)
+}
+
+// respond answers a DER-encoded TimeStampReq.
+func (l *Local) respond(query []byte) timeStampResp {
+	var request timeStampReq
+	if rest, err := asn1.Unmarshal(query, &request); err != nil || len(rest) > 0 || request.Version != 1 {
+		return rejection(failureBadRequest, "malformed request")
+	}
+	imprint := request.MessageImprint
+	if !imprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || len(imprint.HashedMessage) != sha256.Size {
+		return rejection(failureBadAlg, "only SHA-256 message imprints are supported")
+	}
+	if len(request.ReqPolicy) > 0 && !request.ReqPolicy.Equal(LocalPolicy) {
+		return rejection(failureUnacceptedPolicy, "unsupported policy")
+	}
+
+	token, err := l.issue(imprint.HashedMessage, request.Nonce, request.CertReq)
+	if err != nil {
+		return rejection(failureSystemFailure, "failed to create token")
+	}
+	return timeStampResp{
+		Status:         pkiStatusInfo{Status: statusGranted},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	}
+}
+
+func rejection(failure int, reason string) timeStampResp {
+	failInfo := make([]byte, failure/8+1)
+	failInfo[failure/8] |= 0x80 >> (failure % 8)
+	return timeStampResp{Status: pkiStatusInfo{
+		Status:       statusRejection,
+		StatusString: []asn1.RawValue{{Tag: asn1.TagUTF8String, Bytes: []byte(reason)}},
+		FailInfo:     asn1.BitString{Bytes: failInfo, BitLength: failure + 1},
+	}}
+}
+
+// issue creates a TimeStampToken for digest, signed with the TSA key.
+func (l *Local) issue(digest []byte, nonce *big.Int, includeCertificates bool) ([]byte, error) {
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate serial number: %w", err)
+	}
+	content, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         LocalPolicy,
+		MessageImprint: messageImprint{HashAlgorithm: sha256Algorithm, HashedMessage: digest},
+		SerialNumber:   serialNumber,
+		GenTime:        l.now().UTC().Truncate(time.Second),
+		Accuracy:       accuracy{Seconds: 1},
+		Nonce:          nonce,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode TSTInfo: %w", err)
+	}
+
+	contentDigest := sha512.Sum384(content)
+	certificateHash := sha256.Sum256(l.certificate.Raw)
+	attributes := make([]attribute, 0, 3)
+	for _, attr := range []struct {
+		id    asn1.ObjectIdentifier
+		value interface{}
+	}{
+		{oidContentType, oidTSTInfo},
+		{oidMessageDigest, contentDigest[:]},
+		{oidSigningCertificateV2, signingCertificateV2{Certs: []essCertIDv2{{CertHash: certificateHash[:]}}}},
+	} {
+		value, err := asn1.Marshal(attr.value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode signed attribute: %w", err)
+		}
+		attributes = append(attributes, attribute{
+			Type:   attr.id,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: value},
+		})
+	}
+	// The signature covers the attributes as a DER SET OF; in the SignerInfo
+	// they are encoded with the implicit tag [0] instead.
+	signedAttrs, err := asn1.MarshalWithParams(attributes, "set")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode signed attributes: %w", err)
+	}
+	var attributeSet asn1.RawValue
+	if _, err := asn1.Unmarshal(signedAttrs, &attributeSet); err != nil {
+		return nil, fmt.Errorf("failed to encode signed attributes: %w", err)
+	}
+	attributesDigest := sha512.Sum384(signedAttrs)
+	signature, err := ecdsa.SignASN1(rand.Reader, l.key, attributesDigest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	sha384Algorithm := pkix.AlgorithmIdentifier{Algorithm: oidSHA384}
+	signed := signedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha384Algorithm},
+		EncapContentInfo: encapsulatedContentInfo{EContentType: oidTSTInfo, EContent: content},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                issuerAndSerialNumber{Issuer: asn1.RawValue{FullBytes: l.certificate.RawIssuer}, SerialNumber: l.certificate.SerialNumber},
+			DigestAlgorithm:    sha384Algorithm,
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attributeSet.Bytes},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA384},
+			Signature:          signature,
+		}},
+	}
+	if includeCertificates {
+		var certificates []byte
+		for _, certificate := range append([]*x509.Certificate{l.certificate}, l.chain...) {
+			certificates = append(certificates, certificate.Raw...)
+		}
+		signed.Certificates = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certificates}
+	}
+	encoded, err := asn1.Marshal(signed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode SignedData: %w", err)
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: encoded},
+	})
+}
@@ -0,0 +1,368 @@
+// Package timestamp obtains and verifies RFC 3161 time-stamp tokens, which
+// prove that a signature existed at a given time.
+package timestamp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// Timestamper obtains time-stamp tokens from a time-stamping authority (TSA).
+type Timestamper interface {
+	// Timestamp returns the DER-encoded RFC 3161 TimeStampToken for the
+	// SHA-256 digest of some data.
+	Timestamp(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// ErrInvalidToken is returned for tokens that cannot be parsed or whose
+// signature, digest or nonce do not match.
+var ErrInvalidToken = errors.New("invalid time-stamp token")
+
+// Object identifiers of RFC 3161, RFC 5652, RFC 5035 and the algorithms used in tokens.
+var (
+	oidSignedData           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo              = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidContentType          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningCertificateV2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 2, 47}
+	oidSHA256               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512               = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidRSAEncryption        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512      = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// sha256Algorithm identifies SHA-256 in message imprints.
+var sha256Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional,default:false"`
+	Extensions     []pkix.Extension      `asn1:"tag:0,optional"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []asn1.RawValue `asn1:"optional"` // PKIFreeText, a SEQUENCE OF UTF8String
+	FailInfo     asn1.BitString  `asn1:"optional"`
+}
+
+// text returns the status strings of s.
+func (s pkiStatusInfo) text() string {
+	texts := make([]string, len(s.StatusString))
+	for i, value := range s.StatusString {
+		texts[i] = string(value.Bytes)
+	}
+	return strings.Join(texts, "; ")
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+// PKIStatus values and PKIFailureInfo bits of time-stamp responses.
+const (
+	statusGranted         = 0
+	statusGrantedWithMods = 1
+	statusRejection       = 2
+
+	failureBadAlg           = 0
+	failureBadRequest       = 2
+	failureUnacceptedPolicy = 15
+	failureSystemFailure    = 25
+)
+
+// Media types of the RFC 3161 HTTP transport.
+const (
+	QueryContentType = "application/timestamp-query"
+	ReplyContentType = "application/timestamp-reply"
+)
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"tag:0,optional"`
+	Micros  int `asn1:"tag:1,optional"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       accuracy         `asn1:"optional"`
+	Ordering       bool             `asn1:"optional,default:false"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"tag:0,optional"`
+	Extensions     []pkix.Extension `asn1:"tag:1,optional"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,tag:0"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type essCertIDv2 struct {
+	HashAlgorithm pkix.AlgorithmIdentifier `asn1:"optional"`
+	CertHash      []byte
+}
+
+type signingCertificateV2 struct {
+	Certs []essCertIDv2
+}
+
+// Token is a parsed time-stamp token whose signature has been verified.
+type Token struct {
+	// Time is the time at which the TSA created the token.
+	Time         time.Time
+	SerialNumber *big.Int
+	Policy       asn1.ObjectIdentifier
+	// Digest is the SHA-256 digest that was timestamped.
+	Digest []byte
+	Nonce  *big.Int
+	// Certificate is the TSA certificate that signed the token.
+	Certificate *x509.Certificate
+	// Certificates holds all certificates included in the token.
+	Certificates []*x509.Certificate
+}
+
+// Parse parses a DER-encoded TimeStampToken and verifies its signature with
+// the TSA certificate included in it. Whether that certificate is trusted is
+// left to the caller, for example with VerifyChain.
+func Parse(token []byte) (*Token, error) {
+	var info contentInfo
+	if rest, err := asn1.Unmarshal(token, &info); err != nil || len(rest) > 0 {
+		return nil, fmt.Errorf("%w: malformed ContentInfo", ErrInvalidToken)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("%w: content type %s is not SignedData", ErrInvalidToken, info.ContentType)
+	}
+	var signed signedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return nil, fmt.Errorf("%w: malformed SignedData: %v", ErrInvalidToken, err)
+	}
+	if !signed.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, fmt.Errorf("%w: encapsulated content is not a TSTInfo", ErrInvalidToken)
+	}
+	if len(signed.SignerInfos) != 1 {
+		return nil, fmt.Errorf("%w: expected one signer, got %d", ErrInvalidToken, len(signed.SignerInfos))
+	}
+
+	var tst tstInfo
+	if _, err := asn1.Unmarshal(signed.EncapContentInfo.EContent, &tst); err != nil {
+		return nil, fmt.Errorf("%w: malformed TSTInfo: %v", ErrInvalidToken, err)
+	}
+	if !tst.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) {
+		return nil, fmt.Errorf("%w: message imprint is not SHA-256", ErrInvalidToken)
+	}
+
+	certificates, err := x509.ParseCertificates(signed.Certificates.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed certificates: %v", ErrInvalidToken, err)
+	}
+	signer := signed.SignerInfos[0]
+	var certificate *x509.Certificate
+	for _, candidate := range certificates {
+		if bytes.Equal(candidate.RawIssuer, signer.SID.Issuer.FullBytes) && candidate.SerialNumber.Cmp(signer.SID.SerialNumber) == 0 {
+			certificate = candidate
+		}
+	}
+	if certificate == nil {
+		return nil, fmt.Errorf("%w: the TSA certificate is not included", ErrInvalidToken)
+	}
+	if err := verifySignerInfo(signer, certificate, signed.EncapContentInfo.EContent); err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		Time:         tst.GenTime,
+		SerialNumber: tst.SerialNumber,
+		Policy:       tst.Policy,
+		Digest:       tst.MessageImprint.HashedMessage,
+		Nonce:        tst.Nonce,
+		Certificate:  certificate,
+		Certificates: certificates,
+	}, nil
+}
+
+// verifySignerInfo checks the signed attributes of signer and its signature.
+func verifySignerInfo(signer signerInfo, certificate *x509.Certificate, content []byte) error {
+	if len(signer.SignedAttrs.Bytes) == 0 {
+		return fmt.Errorf("%w: signed attributes are missing", ErrInvalidToken)
+	}
+	newHash, err := hashFor(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	algorithm, err := signatureAlgorithm(signer.SignatureAlgorithm.Algorithm, signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	var attributes []attribute
+	if _, err := asn1.UnmarshalWithParams(signer.SignedAttrs.FullBytes, &attributes, "set,tag:0"); err != nil {
+		return fmt.Errorf("%w: malformed signed attributes: %v", ErrInvalidToken, err)
+	}
+	var contentTypeFound, digestFound bool
+	for _, attr := range attributes {
+		switch {
+		case attr.Type.Equal(oidContentType):
+			var contentType asn1.ObjectIdentifier
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &contentType); err != nil || !contentType.Equal(oidTSTInfo) {
+				return fmt.Errorf("%w: content type attribute does not match", ErrInvalidToken)
+			}
+			contentTypeFound = true
+		case attr.Type.Equal(oidMessageDigest):
+			var digest []byte
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &digest); err != nil {
+				return fmt.Errorf("%w: malformed message digest attribute", ErrInvalidToken)
+			}
+			h := newHash()
+			h.Write(content)
+			if !bytes.Equal(digest, h.Sum(nil)) {
+				return fmt.Errorf("%w: message digest does not match the TSTInfo", ErrInvalidToken)
+			}
+			digestFound = true
+		case attr.Type.Equal(oidSigningCertificateV2):
+			var signingCertificate signingCertificateV2
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &signingCertificate); err != nil || len(signingCertificate.Certs) == 0 {
+				return fmt.Errorf("%w: malformed signing certificate attribute", ErrInvalidToken)
+			}
+			hash := sha256.Sum256(certificate.Raw)
+			if id := signingCertificate.Certs[0]; (len(id.HashAlgorithm.Algorithm) == 0 || id.HashAlgorithm.Algorithm.Equal(oidSHA256)) && !bytes.Equal(id.CertHash, hash[:]) {
+				return fmt.Errorf("%w: signing certificate attribute does not match the TSA certificate", ErrInvalidToken)
+			}
+		}
+	}
+	if !contentTypeFound || !digestFound {
+		return fmt.Errorf("%w: content type or message digest attribute is missing", ErrInvalidToken)
+	}
+
+	// The signature covers the DER encoding of the attributes as a SET OF,
+	// not with the implicit [0] tag they are transmitted with.
+	signedAttrs := append([]byte{0x31}, signer.SignedAttrs.FullBytes[1:]...)
+	if err := certificate.CheckSignature(algorithm, signedAttrs, signer.Signature); err != nil {
+		return fmt.Errorf("%w: signature does not verify: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
+
+// Verify checks that the token timestamps digest and, if nonce is not nil,
+// that it echoes nonce.
+func (t *Token) Verify(digest []byte, nonce *big.Int) error {
+	if !bytes.Equal(t.Digest, digest) {
+		return fmt.Errorf("%w: the token is for another digest", ErrInvalidToken)
+	}
+	if nonce != nil && (t.Nonce == nil || t.Nonce.Cmp(nonce) != 0) {
+		return fmt.Errorf("%w: nonce does not match the request", ErrInvalidToken)
+	}
+	return nil
+}
+
+// VerifyChain checks that the TSA certificate chains to roots and is
+// authorized for time stamping at the time of the token.
+func (t *Token) VerifyChain(roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, certificate := range t.Certificates {
+		intermediates.AddCert(certificate)
+	}
+	_, err := t.Certificate.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   t.Time,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+	})
+	return err
+}
+
+func hashFor(algorithm asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case algorithm.Equal(oidSHA256):
+		return sha256.New, nil
+	case algorithm.Equal(oidSHA384):
+		return sha512.New384, nil
+	case algorithm.Equal(oidSHA512):
+		return sha512.New, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported digest algorithm %s", ErrInvalidToken, algorithm)
+	}
+}
+
+// signatureAlgorithm maps the CMS signature and digest algorithms to crypto/x509.
+func signatureAlgorithm(signature, digest asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
+	switch {
+	case signature.Equal(oidECDSAWithSHA256):
+		return x509.ECDSAWithSHA256, nil
+	case signature.Equal(oidECDSAWithSHA384):
+		return x509.ECDSAWithSHA384, nil
+	case signature.Equal(oidECDSAWithSHA512):
+		return x509.ECDSAWithSHA512, nil
+	case signature.Equal(oidSHA256WithRSA), signature.Equal(oidRSAEncryption) && digest.Equal(oidSHA256):
+		return x509.SHA256WithRSA, nil
+	case signature.Equal(oidSHA384WithRSA), signature.Equal(oidRSAEncryption) && digest.Equal(oidSHA384):
+		return x509.SHA384WithRSA, nil
+	case signature.Equal(oidSHA512WithRSA), signature.Equal(oidRSAEncryption) && digest.Equal(oidSHA512):
+		return x509.SHA512WithRSA, nil
+	default:
+		return 0, fmt.Errorf("%w: unsupported signature algorithm %s", ErrInvalidToken, signature)
+	}
+}
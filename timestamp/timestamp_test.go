@@ -0,0 +1,111 @@
+package timestamp
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/ca"
+)
+
+func newLocal(t *testing.T) (*Local, *ca.Authority) {
+	t.Helper()
+	authority, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	local, err := NewLocal(authority)
+	if err != nil {
+		t.Fatalf("Failed to create local TSA: %v", err)
+	}
+	return local, authority
+}
+
+func TestLocal(t *testing.T) {
+	local, authority := newLocal(t)
+	digest := sha256.Sum256([]byte("signature"))
+
+	before := time.Now().Add(-time.Second)
+	encoded, err := local.Timestamp(context.Background(), digest[:])
+	if err != nil {
+		t.Fatalf("Timestamp failed: %v", err)
+	}
+	token, err := Parse(encoded)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if err := token.Verify(digest[:], nil); err != nil {
+		t.Errorf("Verify failed: %v", err)
+	}
+	if token.Time.Before(before.Truncate(time.Second)) || token.Time.After(time.Now()) || !token.Policy.Equal(LocalPolicy) {
+		t.Errorf("Unexpected token time %v or policy %v", token.Time, token.Policy)
+	}
+	if !token.Certificate.Equal(local.Certificate()) {
+		t.Errorf("Expected the token to be signed by the TSA certificate")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(authority.Certificate())
+	if err := token.VerifyChain(roots); err != nil {
+		t.Errorf("Expected the TSA certificate to chain to the CA: %v", err)
+	}
+	other, err := ca.NewSelfSigned(time.Hour)
+	if err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+	untrusted := x509.NewCertPool()
+	untrusted.AddCert(other.Certificate())
+	if err := token.VerifyChain(untrusted); err == nil {
+		t.Errorf("Expected the TSA certificate not to chain to another CA")
+	}
+
+	otherDigest := sha256.Sum256([]byte("other"))
+	if err := token.Verify(otherDigest[:], nil); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for another digest, got %v", err)
+	}
+
+	// Flipping a byte of the TSTInfo breaks the message digest.
+	index := strings.Index(string(encoded), string(digest[:]))
+	tampered := append([]byte(nil), encoded...)
+	tampered[index] ^= 0xff
+	if _, err := Parse(tampered); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Expected ErrInvalidToken for a tampered token, got %v", err)
+	}
+}
+
+func TestClient(t *testing.T) {
+	local, _ := newLocal(t)
+	server := httptest.NewServer(local)
+	defer server.Close()
+
+	digest := sha256.Sum256([]byte("signature"))
+	encoded, err := NewClient(server.URL, time.Second).Timestamp(context.Background(), digest[:])
+	if err != nil {
+		t.Fatalf("Timestamp failed: %v", err)
+	}
+	token, err := Parse(encoded)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if token.Nonce == nil {
+		t.Errorf("Expected the token to echo the request nonce")
+	}
+
+	if _, err := NewClient(server.URL, time.Second).Timestamp(context.Background(), digest[:8]); err == nil || !strings.Contains(err.Error(), "rejected") {
+		t.Errorf("Expected a rejection for a truncated digest, got %v", err)
+	}
+
+	response := local.respond([]byte("not a request"))
+	if response.Status.Status != statusRejection || response.Status.FailInfo.At(failureBadRequest) != 1 {
+		t.Errorf("Expected a badRequest rejection, got %+v", response.Status)
+	}
+	if _, err := asn1.Marshal(response); err != nil {
+		t.Errorf("Failed to encode rejection: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+
+// TracedDeviceRepository is a persistence.DeviceRepository decorator that
+// records a span for every operation.
+type TracedDeviceRepository struct {
+	next persistence.DeviceRepository
+}
+
+// NewTracedDeviceRepository wraps next with tracing.
+func NewTracedDeviceRepository(next persistence.DeviceRepository) *TracedDeviceRepository {
+	return &TracedDeviceRepository{next: next}
+}
+
+func (r *TracedDeviceRepository) Create(ctx context.Context, device *domain.SignatureDevice) error {
+	ctx, span := startSpan(ctx, "DeviceRepository.Create", attribute.String("device.id", deviceID(device)))
+	defer span.End()
+
+	return recordError(span, r.next.Create(ctx, device))
+}
+
+func (r *TracedDeviceRepository) Get(ctx context.Context, id string) (*domain.SignatureDevice, error) {
+	ctx, span := startSpan(ctx, "DeviceRepository.Get", attribute.String("device.id", id))
+	defer span.End()
+
+	device, err := r.next.Get(ctx, id)
+	return device, recordError(span, err)
+}
+
+func (r *TracedDeviceRepository) List(ctx context.Context) ([]*domain.SignatureDevice, error) {
+	ctx, span := startSpan(ctx, "DeviceRepository.List")
+	defer span.End()
+
+	devices, err := r.next.List(ctx)
+	span.SetAttributes(attribute.Int("device.count", len(devices)))
+	return devices, recordError(span, err)
+}
+
+func (r *TracedDeviceRepository) Update(ctx context.Context, device *domain.SignatureDevice) error {
+	ctx, span := startSpan(ctx, "DeviceRepository.Update", attribute.String("device.id", deviceID(device)))
+	defer span.End()
+
+	return recordError(span, r.next.Update(ctx, device))
+}
+
+func (r *TracedDeviceRepository) Delete(ctx context.Context, id string) error {
+	ctx, span := startSpan(ctx, "DeviceRepository.Delete", attribute.String("device.id", id))
+	defer span.End()
+
+	return recordError(span, r.next.Delete(ctx, id))
+}
+
+func deviceID(device *domain.SignatureDevice) string {
+	if device == nil {
+		return ""
+	}
+	return device.ID
+}
+
+func startSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+func recordError(span trace.Span, err error) error {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return err
+}
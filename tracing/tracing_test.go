@@ -0,0 +1,63 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/config"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/domain"
+	"github.com/fiskaly/coding-challenges/signing-service-challenge/persistence"
+	"github.com/google/uuid"
+)
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(context.Background(), config.TracingConfig{
+		Exporter:    config.TracingFile,
+		FilePath:    path,
+		ServiceName: "signing-service-test",
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatalf("Failed to set up tracing: %v", err)
+	}
+
+	repo := NewTracedDeviceRepository(persistence.NewInMemoryDeviceRepository())
+	ctx := context.Background()
+
+	device, err := domain.NewSignatureDevice(uuid.New().String(), domain.ECC, "Test Device")
+	if err != nil {
+		t.Fatalf("Failed to create device: %v", err)
+	}
+	if err := repo.Create(ctx, device); err != nil {
+		t.Fatalf("Failed to create device in repository: %v", err)
+	}
+	if _, _, err := device.SignTransaction(ctx, "test data"); err != nil {
+		t.Fatalf("Failed to sign transaction: %v", err)
+	}
+	if _, err := repo.Get(ctx, "non-existent-id"); err == nil {
+		t.Errorf("Expected error when getting non-existent device")
+	}
+
+	if err := shutdown(ctx); err != nil {
+		t.Fatalf("Failed to shut down tracing: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read trace file: %v", err)
+	}
+	for _, expected := range []string{
+		`"Name":"DeviceRepository.Create"`,
+		`"Name":"DeviceRepository.Get"`,
+		`"Name":"SignatureDevice.SignTransaction"`,
+		`"Value":"signing-service-test"`,
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Expected trace file to contain %s", expected)
+		}
+	}
+}